@@ -21,6 +21,33 @@ const (
 	// Required fields: Entity
 	OpPromulgate   Operation = "promulgate"
 	OpUnpromulgate Operation = "unpromulgate"
+
+	// OpPublish represents the publishing of an entity to channels.
+	// Required fields: Entity, Channels
+	OpPublish Operation = "publish"
+
+	// OpTrash, OpRestore represent the moving of an entity into
+	// and out of the trash.
+	// Required fields: Entity
+	OpTrash   Operation = "trash"
+	OpRestore Operation = "restore"
+
+	// OpPurge represents the permanent removal of a trashed
+	// entity once its grace period has expired. Purges are
+	// made by the charm store itself, so User is empty.
+	// Required fields: Entity
+	OpPurge Operation = "purge"
+
+	// OpPrune represents the moving of an entity to the trash
+	// because it is not kept by the revision retention policy.
+	// Pruning is done by the charm store itself, so User is empty.
+	// Required fields: Entity
+	OpPrune Operation = "prune"
+
+	// OpTransfer represents the transfer of a base entity
+	// and all its revisions to another user.
+	// Required fields: Entity, Owner
+	OpTransfer Operation = "transfer"
 )
 
 // ACL represents an access control list.
@@ -36,4 +63,7 @@ type Entry struct {
 	Op     Operation        `json:"op"`
 	Entity *charm.Reference `json:"entity,omitempty"`
 	ACL    *ACL             `json:"acl,omitempty"`
+
+	Channels []string `json:"channels,omitempty"`
+	Owner    string   `json:"owner,omitempty"`
 }
@@ -0,0 +1,90 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// This command finds archive blobs that are no longer referenced
+// by any entity or resource and removes them from the blob store.
+
+package main // import "gopkg.in/juju/charmstore.v5-unstable/cmd/blobgc"
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+
+	"gopkg.in/juju/charmstore.v5-unstable/config"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+)
+
+var (
+	logger        = loggo.GetLogger("blobgc")
+	loggingConfig = flag.String("logging-config", "INFO", "specify log levels for modules e.g. <root>=TRACE")
+	dryRun        = flag.Bool("dry-run", false, "report orphaned blobs without removing them")
+	minAge        = flag.Duration("min-age", charmstore.DefaultBlobGCMinAge, "ignore blobs created more recently than this")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] <config path>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+	}
+	if *loggingConfig != "" {
+		if err := loggo.ConfigureLoggers(*loggingConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot configure loggers: %v", err)
+			os.Exit(1)
+		}
+	}
+	if err := run(flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(confPath string) error {
+	logger.Infof("reading configuration")
+	conf, err := config.Read(confPath)
+	if err != nil {
+		return errgo.Notef(err, "cannot read config file %q", confPath)
+	}
+
+	logger.Infof("connecting to mongo")
+	session, err := mgo.Dial(conf.MongoURL)
+	if err != nil {
+		return errgo.Notef(err, "cannot dial mongo at %q", conf.MongoURL)
+	}
+	defer session.Close()
+	db := session.DB("juju")
+
+	logger.Infof("instantiating the store")
+	pool, err := charmstore.NewPool(db, nil, nil, charmstore.ServerParams{})
+	if err != nil {
+		return errgo.Notef(err, "cannot create a new store")
+	}
+	defer pool.Close()
+	store := pool.Store()
+	defer store.Close()
+
+	logger.Infof("collecting orphaned blobs")
+	orphans, err := store.CollectBlobs(*minAge, *dryRun)
+	for _, name := range orphans {
+		fmt.Println(name)
+	}
+	if err != nil {
+		return errgo.Notef(err, "cannot collect blobs")
+	}
+	if *dryRun {
+		logger.Infof("found %d orphaned blobs", len(orphans))
+	} else {
+		logger.Infof("removed %d orphaned blobs", len(orphans))
+	}
+	return nil
+}
@@ -83,6 +83,15 @@ func serve(confPath string) error {
 		MaxMgoSessions:          conf.MaxMgoSessions,
 		HTTPRequestWaitDuration: conf.RequestTimeout.Duration,
 		SearchCacheMaxAge:       conf.SearchCacheMaxAge.Duration,
+		TrashGracePeriod:        conf.TrashGracePeriod.Duration,
+		BlobGCInterval:          conf.BlobGCInterval.Duration,
+		RetentionKeepRevisions:  conf.RetentionKeepRevisions,
+		RetentionKeepDuration:   conf.RetentionKeepDuration.Duration,
+		PruneInterval:           conf.PruneInterval.Duration,
+		QuotaMaxBytes:           conf.QuotaMaxBytes,
+		QuotaMaxArchiveSize:     conf.QuotaMaxArchiveSize,
+		QuotaMaxRevisions:       conf.QuotaMaxRevisions,
+		MongoSearch:             conf.MongoSearch,
 	}
 
 	if conf.AuditLogFile != "" {
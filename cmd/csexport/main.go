@@ -0,0 +1,89 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// This command writes the contents of a charm store, including
+// its blobs, to a portable archive that can be restored with
+// csimport.
+
+package main // import "gopkg.in/juju/charmstore.v5-unstable/cmd/csexport"
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+
+	"gopkg.in/juju/charmstore.v5-unstable/config"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+)
+
+var (
+	logger        = loggo.GetLogger("csexport")
+	loggingConfig = flag.String("logging-config", "INFO", "specify log levels for modules e.g. <root>=TRACE")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] <config path> <archive path>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+	}
+	if *loggingConfig != "" {
+		if err := loggo.ConfigureLoggers(*loggingConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot configure loggers: %v", err)
+			os.Exit(1)
+		}
+	}
+	if err := run(flag.Arg(0), flag.Arg(1)); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(confPath, archivePath string) error {
+	logger.Infof("reading configuration")
+	conf, err := config.Read(confPath)
+	if err != nil {
+		return errgo.Notef(err, "cannot read config file %q", confPath)
+	}
+
+	logger.Infof("connecting to mongo")
+	session, err := mgo.Dial(conf.MongoURL)
+	if err != nil {
+		return errgo.Notef(err, "cannot dial mongo at %q", conf.MongoURL)
+	}
+	defer session.Close()
+	db := session.DB("juju")
+
+	logger.Infof("instantiating the store")
+	pool, err := charmstore.NewPool(db, nil, nil, charmstore.ServerParams{})
+	if err != nil {
+		return errgo.Notef(err, "cannot create a new store")
+	}
+	defer pool.Close()
+	store := pool.Store()
+	defer store.Close()
+
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	logger.Infof("exporting to %s", archivePath)
+	if err := store.Export(f); err != nil {
+		f.Close()
+		return errgo.Notef(err, "cannot export store")
+	}
+	if err := f.Close(); err != nil {
+		return errgo.Mask(err)
+	}
+	logger.Infof("export complete")
+	return nil
+}
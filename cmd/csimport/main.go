@@ -0,0 +1,97 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// This command restores an archive written by csexport into
+// an empty charm store, and rebuilds the search index if
+// elasticsearch is configured.
+
+package main // import "gopkg.in/juju/charmstore.v5-unstable/cmd/csimport"
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+
+	"gopkg.in/juju/charmstore.v5-unstable/config"
+	"gopkg.in/juju/charmstore.v5-unstable/elasticsearch"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+)
+
+var (
+	logger        = loggo.GetLogger("csimport")
+	loggingConfig = flag.String("logging-config", "INFO", "specify log levels for modules e.g. <root>=TRACE")
+	index         = flag.String("index", "cs", "Name of the search index to rebuild.")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] <config path> <archive path>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+	}
+	if *loggingConfig != "" {
+		if err := loggo.ConfigureLoggers(*loggingConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot configure loggers: %v", err)
+			os.Exit(1)
+		}
+	}
+	if err := run(flag.Arg(0), flag.Arg(1)); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(confPath, archivePath string) error {
+	logger.Infof("reading configuration")
+	conf, err := config.Read(confPath)
+	if err != nil {
+		return errgo.Notef(err, "cannot read config file %q", confPath)
+	}
+	var si *charmstore.SearchIndex
+	if conf.ESAddr != "" {
+		si = &charmstore.SearchIndex{
+			Database: &elasticsearch.Database{
+				conf.ESAddr,
+			},
+			Index: *index,
+		}
+	}
+
+	logger.Infof("connecting to mongo")
+	session, err := mgo.Dial(conf.MongoURL)
+	if err != nil {
+		return errgo.Notef(err, "cannot dial mongo at %q", conf.MongoURL)
+	}
+	defer session.Close()
+	db := session.DB("juju")
+
+	logger.Infof("instantiating the store")
+	pool, err := charmstore.NewPool(db, si, nil, charmstore.ServerParams{})
+	if err != nil {
+		return errgo.Notef(err, "cannot create a new store")
+	}
+	defer pool.Close()
+	store := pool.Store()
+	defer store.Close()
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer f.Close()
+	logger.Infof("importing from %s", archivePath)
+	if err := store.Import(f); err != nil {
+		return errgo.Notef(err, "cannot import store")
+	}
+	logger.Infof("import complete")
+	return nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// This command checks that the Elastic Search index is consistent
+// with the charms and bundles in the charm store, optionally
+// repairing any problems found.
+
+package main // import "gopkg.in/juju/charmstore.v5-unstable/cmd/escheck"
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/loggo"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+
+	"gopkg.in/juju/charmstore.v5-unstable/config"
+	"gopkg.in/juju/charmstore.v5-unstable/elasticsearch"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+)
+
+var (
+	logger        = loggo.GetLogger("escheck")
+	index         = flag.String("index", "cs", "Name of index to check.")
+	loggingConfig = flag.String("logging-config", "INFO", "specify log levels for modules e.g. <root>=TRACE")
+	repair        = flag.Bool("repair", false, "repair any problems found")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] <config path>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+	}
+	if *loggingConfig != "" {
+		if err := loggo.ConfigureLoggers(*loggingConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot configure loggers: %v", err)
+			os.Exit(1)
+		}
+	}
+	ok, err := run(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// run checks the search index and reports whether
+// it was found to be consistent or has been repaired.
+func run(confPath string) (bool, error) {
+	logger.Infof("reading configuration")
+	conf, err := config.Read(confPath)
+	if err != nil {
+		return false, errgo.Notef(err, "cannot read config file %q", confPath)
+	}
+	if conf.ESAddr == "" {
+		return false, errgo.Newf("no elasticsearch-addr specified in config file %q", confPath)
+	}
+
+	logger.Infof("connecting to mongo")
+	session, err := mgo.Dial(conf.MongoURL)
+	if err != nil {
+		return false, errgo.Notef(err, "cannot dial mongo at %q", conf.MongoURL)
+	}
+	defer session.Close()
+	db := session.DB("juju")
+
+	logger.Infof("instantiating the store")
+	pool, err := charmstore.NewPool(db, nil, nil, charmstore.ServerParams{})
+	if err != nil {
+		return false, errgo.Notef(err, "cannot create a new store")
+	}
+	defer pool.Close()
+	store := pool.Store()
+	defer store.Close()
+	// The search index is set on the store rather than given to the
+	// pool so that the index being checked is never replaced.
+	store.ES = &charmstore.SearchIndex{
+		Database: &elasticsearch.Database{
+			conf.ESAddr,
+		},
+		Index: *index,
+	}
+
+	logger.Infof("checking search index")
+	result, err := store.CheckSearch(*repair)
+	if err != nil {
+		return false, errgo.Mask(err)
+	}
+	for _, url := range result.Missing {
+		fmt.Printf("missing %s\n", url)
+	}
+	for _, stale := range result.Stale {
+		fmt.Printf("stale %s (indexed %s): %s\n", stale.URL, stale.IndexedURL, strings.Join(stale.Fields, ", "))
+	}
+	for _, url := range result.Extra {
+		fmt.Printf("extra %s\n", url)
+	}
+	fmt.Printf("checked %d search documents: %d missing, %d stale, %d extra\n", result.Checked, len(result.Missing), len(result.Stale), len(result.Extra))
+	if result.Repaired {
+		fmt.Println("all problems repaired")
+	}
+	return result.OK() || result.Repaired, nil
+}
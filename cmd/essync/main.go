@@ -25,6 +25,8 @@ var (
 	loggingConfig = flag.String("logging-config", "", "specify log levels for modules e.g. <root>=TRACE")
 	mapping       = flag.String("mapping", "", "No longer used.")
 	settings      = flag.String("settings", "", "No longer used.")
+	resume        = flag.Bool("resume", false, "Resume an interrupted synchronisation rather than starting afresh.")
+	batchSize     = flag.Int("batch-size", 500, "Number of search documents to index in each bulk request.")
 )
 
 func main() {
@@ -71,14 +73,33 @@ func populate(confPath string) error {
 	defer session.Close()
 	db := session.DB("juju")
 
-	pool, err := charmstore.NewPool(db, si, nil, charmstore.ServerParams{})
+	// The search index is not given to the pool because that would
+	// replace the current index with an empty one if its settings are
+	// out of date, leaving search unavailable until it is repopulated.
+	pool, err := charmstore.NewPool(db, nil, nil, charmstore.ServerParams{})
 	if err != nil {
 		return errgo.Notef(err, "cannot create a new store")
 	}
 	store := pool.Store()
 	defer store.Close()
-	if err := store.SynchroniseElasticsearch(); err != nil {
+	store.ES = si
+	err = store.ReindexElasticsearch(charmstore.ReindexParams{
+		BatchSize: *batchSize,
+		Resume:    *resume,
+		Progress:  printProgress,
+	})
+	if err != nil {
 		return errgo.Notef(err, "cannot synchronise elasticsearch")
 	}
+	fmt.Println("synchronisation complete")
 	return nil
 }
+
+// printProgress prints the progress of the synchronisation.
+func printProgress(p charmstore.ReindexProgress) {
+	percent := 100
+	if p.TotalBaseEntities > 0 {
+		percent = p.BaseEntities * 100 / p.TotalBaseEntities
+	}
+	fmt.Printf("%s: %d/%d base entities (%d%%), %d documents\n", p.Index, p.BaseEntities, p.TotalBaseEntities, percent, p.Documents)
+}
@@ -30,13 +30,22 @@ type Config struct {
 	IdentityPublicKey *bakery.PublicKey `yaml:"identity-public-key"`
 	IdentityLocation  string            `yaml:"identity-location"`
 	// The identity API is optional
-	IdentityAPIURL    string          `yaml:"identity-api-url"`
-	AgentUsername     string          `yaml:"agent-username"`
-	AgentKey          *bakery.KeyPair `yaml:"agent-key"`
-	MaxMgoSessions    int             `yaml:"max-mgo-sessions"`
-	RequestTimeout    DurationString  `yaml:"request-timeout"`
-	StatsCacheMaxAge  DurationString  `yaml:"stats-cache-max-age"`
-	SearchCacheMaxAge DurationString  `yaml:"search-cache-max-age"`
+	IdentityAPIURL         string          `yaml:"identity-api-url"`
+	AgentUsername          string          `yaml:"agent-username"`
+	AgentKey               *bakery.KeyPair `yaml:"agent-key"`
+	MaxMgoSessions         int             `yaml:"max-mgo-sessions"`
+	RequestTimeout         DurationString  `yaml:"request-timeout"`
+	StatsCacheMaxAge       DurationString  `yaml:"stats-cache-max-age"`
+	SearchCacheMaxAge      DurationString  `yaml:"search-cache-max-age"`
+	TrashGracePeriod       DurationString  `yaml:"trash-grace-period"`
+	BlobGCInterval         DurationString  `yaml:"blob-gc-interval"`
+	RetentionKeepRevisions int             `yaml:"retention-keep-revisions"`
+	RetentionKeepDuration  DurationString  `yaml:"retention-keep-duration"`
+	PruneInterval          DurationString  `yaml:"prune-interval"`
+	QuotaMaxBytes          int64           `yaml:"quota-max-bytes"`
+	QuotaMaxArchiveSize    int64           `yaml:"quota-max-archive-size"`
+	QuotaMaxRevisions      int             `yaml:"quota-max-revisions"`
+	MongoSearch            bool            `yaml:"mongo-search"`
 }
 
 func (c *Config) validate() error {
@@ -48,6 +48,15 @@ stats-cache-max-age: 1h
 search-cache-max-age: 15m
 request-timeout: 500ms
 max-mgo-sessions: 10
+trash-grace-period: 72h
+blob-gc-interval: 24h
+retention-keep-revisions: 20
+retention-keep-duration: 720h
+prune-interval: 6h
+quota-max-bytes: 1000000000
+quota-max-archive-size: 10000000
+quota-max-revisions: 100
+mongo-search: true
 `
 
 func (s *ConfigSuite) readConfig(c *gc.C, content string) (*config.Config, error) {
@@ -85,10 +94,19 @@ func (s *ConfigSuite) TestRead(c *gc.C) {
 				mustParseKey("lsvcDkapKoFxIyjX9/eQgb3s41KVwPMISFwAJdVCZ70="),
 			},
 		},
-		StatsCacheMaxAge:  config.DurationString{time.Hour},
-		RequestTimeout:    config.DurationString{500 * time.Millisecond},
-		MaxMgoSessions:    10,
-		SearchCacheMaxAge: config.DurationString{15 * time.Minute},
+		StatsCacheMaxAge:       config.DurationString{time.Hour},
+		RequestTimeout:         config.DurationString{500 * time.Millisecond},
+		MaxMgoSessions:         10,
+		SearchCacheMaxAge:      config.DurationString{15 * time.Minute},
+		TrashGracePeriod:       config.DurationString{72 * time.Hour},
+		BlobGCInterval:         config.DurationString{24 * time.Hour},
+		RetentionKeepRevisions: 20,
+		RetentionKeepDuration:  config.DurationString{720 * time.Hour},
+		PruneInterval:          config.DurationString{6 * time.Hour},
+		QuotaMaxBytes:          1000000000,
+		QuotaMaxArchiveSize:    10000000,
+		QuotaMaxRevisions:      100,
+		MongoSearch:            true,
 	})
 }
 
@@ -20,6 +20,7 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/juju/loggo"
 	"gopkg.in/errgo.v1"
@@ -37,6 +38,10 @@ const (
 	// ExternalGTE provides elasticsearche's "external_gte" versioning system, as described in
 	// http://www.elasticsearch.org/guide/en/elasticsearch/reference/current/docs-index_.html#_version_types
 	ExternalGTE = "external_gte"
+
+	// Force provides elasticsearche's "force" versioning system, as described in
+	// http://www.elasticsearch.org/guide/en/elasticsearch/reference/current/docs-index_.html#_version_types
+	Force = "force"
 )
 
 var log = loggo.GetLogger("charmstore.elasticsearch")
@@ -121,6 +126,126 @@ func (db *Database) Alias(i, a string) error {
 	return nil
 }
 
+// BulkDocument holds a document to be indexed by Bulk.
+type BulkDocument struct {
+	// Index, Type and ID specify where the document is stored.
+	Index string
+	Type  string
+	ID    string
+
+	// Version and VersionType, if VersionType is not empty,
+	// specify the versioning used when storing the document,
+	// as for PutDocumentVersionWithType.
+	Version     int64
+	VersionType string
+
+	// Doc holds the document itself. It will be marshaled as JSON.
+	Doc interface{}
+}
+
+// Bulk indexes all the given documents in a single request to the
+// elasticsearch _bulk endpoint. Documents that cannot be stored
+// because of a version conflict are silently skipped; if any other
+// document fails to be stored an error is returned describing the
+// first failure.
+// See http://www.elasticsearch.org/guide/en/elasticsearch/reference/current/docs-bulk.html
+// for further details.
+func (db *Database) Bulk(docs []BulkDocument) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, d := range docs {
+		var action struct {
+			Index bulkAction `json:"index"`
+		}
+		action.Index = bulkAction{
+			Index: d.Index,
+			Type:  d.Type,
+			ID:    d.ID,
+		}
+		if d.VersionType != "" {
+			// The version must be sent even when it is zero,
+			// as elasticsearch rejects versioned actions
+			// without one.
+			version := d.Version
+			action.Index.Version = &version
+			action.Index.VersionType = d.VersionType
+		}
+		// Encode writes a trailing newline after each
+		// value, as required by the bulk API.
+		if err := enc.Encode(action); err != nil {
+			return errgo.Notef(err, "cannot marshal bulk action")
+		}
+		if err := enc.Encode(d.Doc); err != nil {
+			return errgo.Notef(err, "cannot marshal document %s", d.ID)
+		}
+	}
+	var resp struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string          `json:"_id"`
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := db.doBytes("POST", db.url("_bulk"), buf.Bytes(), &resp); err != nil {
+		return getError(err)
+	}
+	if !resp.Errors {
+		return nil
+	}
+	for _, item := range resp.Items {
+		for _, result := range item {
+			if result.Status < 300 || result.Status == http.StatusConflict {
+				continue
+			}
+			return errgo.Newf("cannot index document %s: %s", result.ID, result.Error)
+		}
+	}
+	return nil
+}
+
+// Count returns the number of documents of the given type_ stored in the
+// given index. If type_ is empty, documents of all types are counted.
+// See http://www.elasticsearch.org/guide/en/elasticsearch/reference/current/search-count.html
+// for further details.
+func (db *Database) Count(index, type_ string) (int, error) {
+	var resp struct {
+		Count int `json:"count"`
+	}
+	if err := db.get(db.url(index, type_, "_count"), nil, &resp); err != nil {
+		return 0, getError(err)
+	}
+	return resp.Count, nil
+}
+
+// MultiGet retrieves the documents of the given type_ with the given ids
+// from the given index in a single request. The returned documents are
+// in the same order as ids; the Found field of each document reports
+// whether it exists.
+// See http://www.elasticsearch.org/guide/en/elasticsearch/reference/current/docs-multi-get.html
+// for further details.
+func (db *Database) MultiGet(index, type_ string, ids []string) ([]Document, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	req := struct {
+		IDs []string `json:"ids"`
+	}{ids}
+	var resp struct {
+		Docs []Document `json:"docs"`
+	}
+	if err := db.get(db.url(index, type_, "_mget"), req, &resp); err != nil {
+		return nil, getError(err)
+	}
+	if len(resp.Docs) != len(ids) {
+		return nil, errgo.Newf("unexpected document count; got %d want %d", len(resp.Docs), len(ids))
+	}
+	return resp.Docs, nil
+}
+
 // Create document attempts to create a new document at index/type_/id with the
 // contents in doc. If the document already exists then CreateDocument will return
 // ErrConflict and return a non-nil error if any other error occurs.
@@ -280,7 +405,7 @@ func (db *Database) PutDocumentVersion(index, type_, id string, version int64, d
 // ErrConflict if the data cannot be stored due to a version mismatch, and a non-nil error if
 // any other error occurs.
 //
-// The constants Internal, External and ExternalGTE represent some of the available
+// The constants Internal, External, ExternalGTE and Force represent some of the available
 // version types. Other version types may also be available, plese check the elasticsearch
 // documentation.
 //
@@ -333,19 +458,83 @@ func (db *Database) Search(index, type_ string, q QueryDSL) (SearchResult, error
 	return sr, nil
 }
 
+// Scroll starts a scrolled search performing the query specified in q on
+// the values in index/type_. The returned SearchResult holds the first
+// page of hits and the ScrollID used to retrieve the following pages
+// with ScrollNext. The search context is kept alive for keepAlive
+// between requests; it should be released with ClearScroll when it
+// is no longer needed.
+// See http://www.elasticsearch.org/guide/en/elasticsearch/reference/current/search-request-scroll.html
+// for further details.
+func (db *Database) Scroll(index, type_ string, q QueryDSL, keepAlive time.Duration) (SearchResult, error) {
+	var sr SearchResult
+	u := db.url(index, type_, "_search") + "?" + url.Values{
+		"scroll": {durationString(keepAlive)},
+	}.Encode()
+	if err := db.get(u, q, &sr); err != nil {
+		return SearchResult{}, errgo.Notef(getError(err), "search failed")
+	}
+	return sr, nil
+}
+
+// ScrollNext returns the next page of hits of the scrolled search with
+// the given scroll id, as returned in the ScrollID field of the result
+// of Scroll or ScrollNext. There are no more hits when the returned
+// page is empty.
+func (db *Database) ScrollNext(scrollID string, keepAlive time.Duration) (SearchResult, error) {
+	var sr SearchResult
+	u := db.url("_search", "scroll") + "?" + url.Values{
+		"scroll":    {durationString(keepAlive)},
+		"scroll_id": {scrollID},
+	}.Encode()
+	if err := db.get(u, nil, &sr); err != nil {
+		return SearchResult{}, errgo.Notef(getError(err), "search failed")
+	}
+	return sr, nil
+}
+
+// ClearScroll releases the search context of the
+// scrolled search with the given scroll id.
+func (db *Database) ClearScroll(scrollID string) error {
+	u := db.url("_search", "scroll") + "?" + url.Values{
+		"scroll_id": {scrollID},
+	}.Encode()
+	if err := db.delete(u, nil, nil); err != nil {
+		return getError(err)
+	}
+	return nil
+}
+
+// durationString returns d in the form used for
+// elasticsearch time values, rounded up to seconds.
+func durationString(d time.Duration) string {
+	return fmt.Sprintf("%ds", (d+time.Second-1)/time.Second)
+}
+
 // do performs a request on the elasticsearch server. If body is not nil it will be
 // marsheled as a json object and sent with the request. If v is non nil the response
 // body will be unmarshalled into the value it points to.
 func (db *Database) do(method, url string, body, v interface{}) error {
-	log.Debugf(">>> %s %s", method, url)
-	var r io.Reader
+	var b []byte
 	if body != nil {
-		b, err := json.Marshal(body)
+		var err error
+		b, err = json.Marshal(body)
 		if err != nil {
 			return errgo.Notef(err, "can not marshaling body")
 		}
-		log.Debugf(">>> %s", b)
-		r = bytes.NewReader(b)
+	}
+	return db.doBytes(method, url, b, v)
+}
+
+// doBytes performs a request on the elasticsearch server. If body is not nil
+// it will be sent as the body of the request. If v is non nil the response
+// body will be unmarshalled into the value it points to.
+func (db *Database) doBytes(method, url string, body []byte, v interface{}) error {
+	log.Debugf(">>> %s %s", method, url)
+	var r io.Reader
+	if body != nil {
+		log.Debugf(">>> %s", body)
+		r = bytes.NewReader(body)
 	}
 	req, err := http.NewRequest(method, url, r)
 	if err != nil {
@@ -432,8 +621,49 @@ type SearchResult struct {
 		MaxScore float64 `json:"max_score"`
 		Hits     []Hit   `json:"hits"`
 	} `json:"hits"`
-	Took     int  `json:"took"`
-	TimedOut bool `json:"timed_out"`
+	Took         int                          `json:"took"`
+	TimedOut     bool                         `json:"timed_out"`
+	Aggregations map[string]AggregationResult `json:"aggregations"`
+	Suggest      map[string][]SuggestResult   `json:"suggest"`
+
+	// ScrollID holds the id used to retrieve the next page
+	// of a scrolled search started with Scroll.
+	ScrollID string `json:"_scroll_id"`
+}
+
+// AggregationResult holds the result of an aggregation
+// requested in QueryDSL.Aggregations.
+type AggregationResult struct {
+	// DocCount holds the number of documents counted
+	// by a single bucket aggregation, such as FilterAggregation.
+	DocCount int `json:"doc_count"`
+
+	// Buckets holds the buckets of a multiple bucket
+	// aggregation, such as TermsAggregation.
+	Buckets []Bucket `json:"buckets"`
+}
+
+// Bucket represents a bucket in the result of
+// a multiple bucket aggregation.
+type Bucket struct {
+	Key      string `json:"key"`
+	DocCount int    `json:"doc_count"`
+}
+
+// SuggestResult holds the suggestions for a
+// single term of the text given to a suggester.
+type SuggestResult struct {
+	Text    string          `json:"text"`
+	Offset  int             `json:"offset"`
+	Length  int             `json:"length"`
+	Options []SuggestOption `json:"options"`
+}
+
+// SuggestOption represents a suggested replacement for a term.
+type SuggestOption struct {
+	Text  string  `json:"text"`
+	Score float64 `json:"score"`
+	Freq  int     `json:"freq"`
 }
 
 // Hit represents an individual search hit returned from elasticsearch
@@ -444,6 +674,9 @@ type Hit struct {
 	Score  float64         `json:"_score"`
 	Source json.RawMessage `json:"_source"`
 	Fields Fields          `json:"fields"`
+	// Highlight holds the highlighted snippets requested
+	// with QueryDSL.Highlight, keyed by field name.
+	Highlight map[string][]string `json:"highlight"`
 }
 
 type Fields map[string][]interface{}
@@ -502,6 +735,15 @@ type alias struct {
 	Alias string `json:"alias"`
 }
 
+// bulkAction holds the metadata of a document sent to the _bulk endpoint.
+type bulkAction struct {
+	Index       string `json:"_index"`
+	Type        string `json:"_type"`
+	ID          string `json:"_id"`
+	Version     *int64 `json:"_version,omitempty"`
+	VersionType string `json:"_version_type,omitempty"`
+}
+
 // action is an action that can be performed on an alias
 type action struct {
 	Remove *alias `json:"remove,omitempty"`
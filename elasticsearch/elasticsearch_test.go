@@ -374,6 +374,146 @@ func (s *Suite) TestEscapeRegexp(c *gc.C) {
 	}
 }
 
+func (s *Suite) TestBulk(c *gc.C) {
+	err := s.ES.PutDocumentVersionWithType(s.TestIndex, "testtype", "b", 5, es.External, map[string]string{"foo": "old"})
+	c.Assert(err, gc.IsNil)
+	err = s.ES.Bulk([]es.BulkDocument{{
+		Index: s.TestIndex,
+		Type:  "testtype",
+		ID:    "a",
+		Doc:   map[string]string{"foo": "bar"},
+	}, {
+		Index:       s.TestIndex,
+		Type:        "testtype",
+		ID:          "b",
+		Version:     4,
+		VersionType: es.ExternalGTE,
+		Doc:         map[string]string{"foo": "conflict"},
+	}, {
+		Index:       s.TestIndex,
+		Type:        "testtype",
+		ID:          "c",
+		Version:     2,
+		VersionType: es.ExternalGTE,
+		Doc:         map[string]string{"foo": "baz"},
+	}, {
+		Index:       s.TestIndex,
+		Type:        "testtype",
+		ID:          "d",
+		Version:     0,
+		VersionType: es.ExternalGTE,
+		Doc:         map[string]string{"foo": "zero"},
+	}})
+	c.Assert(err, gc.IsNil)
+	var result map[string]string
+	err = s.ES.GetDocument(s.TestIndex, "testtype", "a", &result)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result["foo"], gc.Equals, "bar")
+	err = s.ES.GetDocument(s.TestIndex, "testtype", "b", &result)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result["foo"], gc.Equals, "old")
+	d, err := s.ES.GetESDocument(s.TestIndex, "testtype", "c")
+	c.Assert(err, gc.IsNil)
+	c.Assert(d.Found, gc.Equals, true)
+	c.Assert(d.Version, gc.Equals, int64(2))
+	d, err = s.ES.GetESDocument(s.TestIndex, "testtype", "d")
+	c.Assert(err, gc.IsNil)
+	c.Assert(d.Found, gc.Equals, true)
+	c.Assert(d.Version, gc.Equals, int64(0))
+}
+
+func (s *Suite) TestBulkNoDocuments(c *gc.C) {
+	err := s.ES.Bulk(nil)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *Suite) TestBulkError(c *gc.C) {
+	err := s.ES.Bulk([]es.BulkDocument{{
+		Index:       s.TestIndex,
+		Type:        "testtype",
+		ID:          "a",
+		Version:     1,
+		VersionType: "no-such-version-type",
+		Doc:         map[string]string{"foo": "bar"},
+	}})
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *Suite) TestCount(c *gc.C) {
+	// The test index starts with a single document.
+	n, err := s.ES.Count(s.TestIndex, "testtype")
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 1)
+	_, err = s.ES.PostDocument(s.TestIndex, "testtype", map[string]string{"foo": "bar"})
+	c.Assert(err, gc.IsNil)
+	_, err = s.ES.PostDocument(s.TestIndex, "othertype", map[string]string{"foo": "bar"})
+	c.Assert(err, gc.IsNil)
+	err = s.ES.RefreshIndex(s.TestIndex)
+	c.Assert(err, gc.IsNil)
+	n, err = s.ES.Count(s.TestIndex, "testtype")
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 2)
+	n, err = s.ES.Count(s.TestIndex, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 3)
+}
+
+func (s *Suite) TestMultiGet(c *gc.C) {
+	err := s.ES.PutDocument(s.TestIndex, "testtype", "a", map[string]string{"foo": "bar"})
+	c.Assert(err, gc.IsNil)
+	docs, err := s.ES.MultiGet(s.TestIndex, "testtype", []string{"a", "b", s.TestIndex})
+	c.Assert(err, gc.IsNil)
+	c.Assert(docs, gc.HasLen, 3)
+	c.Assert(docs[0].Found, gc.Equals, true)
+	c.Assert(docs[0].Id, gc.Equals, "a")
+	var result map[string]string
+	err = json.Unmarshal(docs[0].Source, &result)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result, gc.DeepEquals, map[string]string{"foo": "bar"})
+	c.Assert(docs[1].Found, gc.Equals, false)
+	c.Assert(docs[1].Id, gc.Equals, "b")
+	c.Assert(docs[2].Found, gc.Equals, true)
+}
+
+func (s *Suite) TestMultiGetNoIds(c *gc.C) {
+	docs, err := s.ES.MultiGet(s.TestIndex, "testtype", nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(docs, gc.HasLen, 0)
+}
+
+func (s *Suite) TestScroll(c *gc.C) {
+	// The test index starts with a single document.
+	expect := map[string]bool{s.TestIndex: true}
+	for i := 0; i < 4; i++ {
+		id, err := s.ES.PostDocument(s.TestIndex, "testtype", map[string]string{"foo": "bar"})
+		c.Assert(err, gc.IsNil)
+		expect[id] = true
+	}
+	err := s.ES.RefreshIndex(s.TestIndex)
+	c.Assert(err, gc.IsNil)
+	sr, err := s.ES.Scroll(s.TestIndex, "testtype", es.QueryDSL{
+		Size:  2,
+		Query: es.MatchAllQuery{},
+	}, time.Minute)
+	c.Assert(err, gc.IsNil)
+	c.Assert(sr.Hits.Total, gc.Equals, 5)
+	found := make(map[string]bool)
+	pages := 0
+	for len(sr.Hits.Hits) > 0 {
+		pages++
+		for _, h := range sr.Hits.Hits {
+			found[h.ID] = true
+		}
+		c.Assert(sr.ScrollID, gc.Not(gc.Equals), "")
+		sr, err = s.ES.ScrollNext(sr.ScrollID, time.Minute)
+		c.Assert(err, gc.IsNil)
+	}
+	c.Assert(pages, gc.Equals, 3)
+	c.Assert(found, gc.DeepEquals, expect)
+	err = s.ES.ClearScroll(sr.ScrollID)
+	c.Assert(err, gc.IsNil)
+}
+
 func (s *Suite) TestAlias(c *gc.C) {
 	uuid, err := utils.NewUUID()
 	c.Assert(err, gc.Equals, nil)
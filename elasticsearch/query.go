@@ -203,6 +203,34 @@ func (t TermFilter) MarshalJSON() ([]byte, error) {
 	return marshalNamedObject("term", map[string]string{t.Field: t.Value})
 }
 
+// RangeFilter provides a filter that requires the value of a field
+// to be within a range. GT, GTE, LT and LTE hold the bounds of the
+// range. A nil bound is not checked.
+type RangeFilter struct {
+	Field string
+	GT    interface{}
+	GTE   interface{}
+	LT    interface{}
+	LTE   interface{}
+}
+
+func (r RangeFilter) MarshalJSON() ([]byte, error) {
+	bounds := make(map[string]interface{})
+	if r.GT != nil {
+		bounds["gt"] = r.GT
+	}
+	if r.GTE != nil {
+		bounds["gte"] = r.GTE
+	}
+	if r.LT != nil {
+		bounds["lt"] = r.LT
+	}
+	if r.LTE != nil {
+		bounds["lte"] = r.LTE
+	}
+	return marshalNamedObject("range", map[string]interface{}{r.Field: bounds})
+}
+
 // ExistsFilter provides a filter that requres a field to be present.
 type ExistsFilter string
 
@@ -210,14 +238,104 @@ func (f ExistsFilter) MarshalJSON() ([]byte, error) {
 	return marshalNamedObject("exists", map[string]string{"field": string(f)})
 }
 
+// Aggregation represents an aggregation in the elasticsearch DSL.
+type Aggregation interface {
+	json.Marshaler
+}
+
+// TermsAggregation provides an aggregation that counts the
+// documents holding each value of a field. If Size is not zero,
+// only the Size most common values are counted.
+type TermsAggregation struct {
+	Field string
+	Size  int
+}
+
+func (t TermsAggregation) MarshalJSON() ([]byte, error) {
+	params := map[string]interface{}{"field": t.Field}
+	if t.Size != 0 {
+		params["size"] = t.Size
+	}
+	return marshalNamedObject("terms", params)
+}
+
+// FilterAggregation provides an aggregation that counts the
+// documents matching a filter.
+type FilterAggregation struct {
+	Filter Filter
+}
+
+func (f FilterAggregation) MarshalJSON() ([]byte, error) {
+	return marshalNamedObject("filter", f.Filter)
+}
+
+// Suggester represents a suggester in the elasticsearch DSL.
+type Suggester interface {
+	json.Marshaler
+}
+
+// TermSuggester provides a suggester that suggests terms of a field
+// within a small edit distance of each term in Text. If Analyzer is
+// not empty, it is used to split Text into terms. If Size is not zero,
+// at most Size suggestions are returned for each term.
+type TermSuggester struct {
+	Text     string
+	Field    string
+	Analyzer string
+	Size     int
+}
+
+func (t TermSuggester) MarshalJSON() ([]byte, error) {
+	params := map[string]interface{}{"field": t.Field}
+	if t.Analyzer != "" {
+		params["analyzer"] = t.Analyzer
+	}
+	if t.Size != 0 {
+		params["size"] = t.Size
+	}
+	return json.Marshal(map[string]interface{}{
+		"text": t.Text,
+		"term": params,
+	})
+}
+
+// Highlight requests snippets of the given fields, with the terms
+// matched by the query highlighted, to be returned for each hit.
+// If FragmentSize is not zero, it holds the approximate length of
+// each snippet in characters. If NumberOfFragments is not zero, at
+// most NumberOfFragments snippets are returned for each field.
+type Highlight struct {
+	Fields            []string
+	FragmentSize      int
+	NumberOfFragments int
+}
+
+func (h Highlight) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]interface{}, len(h.Fields))
+	for _, f := range h.Fields {
+		fields[f] = struct{}{}
+	}
+	params := map[string]interface{}{"fields": fields}
+	if h.FragmentSize != 0 {
+		params["fragment_size"] = h.FragmentSize
+	}
+	if h.NumberOfFragments != 0 {
+		params["number_of_fragments"] = h.NumberOfFragments
+	}
+	return json.Marshal(params)
+}
+
 // QueryDSL provides a structure to put together a query using the
 // elasticsearch DSL.
 type QueryDSL struct {
-	Fields []string `json:"fields"`
-	From   int      `json:"from,omitempty"`
-	Size   int      `json:"size,omitempty"`
-	Query  Query    `json:"query,omitempty"`
-	Sort   []Sort   `json:"sort,omitempty"`
+	Fields       []string               `json:"fields"`
+	From         int                    `json:"from,omitempty"`
+	Size         int                    `json:"size,omitempty"`
+	Query        Query                  `json:"query,omitempty"`
+	Sort         []Sort                 `json:"sort,omitempty"`
+	Aggregations map[string]Aggregation `json:"aggregations,omitempty"`
+	Suggest      map[string]Suggester   `json:"suggest,omitempty"`
+	Highlight    *Highlight             `json:"highlight,omitempty"`
 }
 
 type Sort struct {
@@ -4,6 +4,8 @@
 package elasticsearch_test // import "gopkg.in/juju/charmstore.v5-unstable/elasticsearch"
 
 import (
+	"time"
+
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
 
@@ -139,6 +141,74 @@ func (s *QuerySuite) TestJSONEncodings(c *gc.C) {
 			Modifier: "bar",
 		},
 		json: `{"field_value_factor": {"field": "foo", "factor": 1.2, "modifier": "bar"}}`,
+	}, {
+		about: "terms aggregation",
+		query: TermsAggregation{Field: "foo"},
+		json:  `{"terms": {"field": "foo"}}`,
+	}, {
+		about: "terms aggregation with size",
+		query: TermsAggregation{Field: "foo", Size: 5},
+		json:  `{"terms": {"field": "foo", "size": 5}}`,
+	}, {
+		about: "filter aggregation",
+		query: FilterAggregation{Filter: TermFilter{Field: "foo", Value: "bar"}},
+		json:  `{"filter": {"term": {"foo": "bar"}}}`,
+	}, {
+		about: "query dsl with aggregations",
+		query: QueryDSL{
+			Fields: []string{"foo"},
+			Query:  MatchAllQuery{},
+			Aggregations: map[string]Aggregation{
+				"bar": TermsAggregation{Field: "bar"},
+			},
+		},
+		json: `{"fields": ["foo"], "query": {"match_all": {}}, "aggregations": {"bar": {"terms": {"field": "bar"}}}}`,
+	}, {
+		about: "range filter",
+		query: RangeFilter{Field: "foo", GTE: 1, LT: 10},
+		json:  `{"range": {"foo": {"gte": 1, "lt": 10}}}`,
+	}, {
+		about: "range filter with all bounds",
+		query: RangeFilter{Field: "foo", GT: "a", GTE: "b", LT: "c", LTE: "d"},
+		json:  `{"range": {"foo": {"gt": "a", "gte": "b", "lt": "c", "lte": "d"}}}`,
+	}, {
+		about: "range filter with time bound",
+		query: RangeFilter{Field: "foo", GTE: time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)},
+		json:  `{"range": {"foo": {"gte": "2015-06-01T00:00:00Z"}}}`,
+	}, {
+		about: "term suggester",
+		query: TermSuggester{Text: "foo", Field: "bar"},
+		json:  `{"text": "foo", "term": {"field": "bar"}}`,
+	}, {
+		about: "term suggester with analyzer and size",
+		query: TermSuggester{Text: "foo", Field: "bar", Analyzer: "standard", Size: 5},
+		json:  `{"text": "foo", "term": {"field": "bar", "analyzer": "standard", "size": 5}}`,
+	}, {
+		about: "query dsl with suggesters",
+		query: QueryDSL{
+			Fields: []string{"foo"},
+			Query:  MatchAllQuery{},
+			Suggest: map[string]Suggester{
+				"bar": TermSuggester{Text: "baz", Field: "bar"},
+			},
+		},
+		json: `{"fields": ["foo"], "query": {"match_all": {}}, "suggest": {"bar": {"text": "baz", "term": {"field": "bar"}}}}`,
+	}, {
+		about: "highlight",
+		query: Highlight{Fields: []string{"foo", "bar"}},
+		json:  `{"fields": {"foo": {}, "bar": {}}}`,
+	}, {
+		about: "highlight with fragment size and number of fragments",
+		query: Highlight{Fields: []string{"foo"}, FragmentSize: 50, NumberOfFragments: 2},
+		json:  `{"fields": {"foo": {}}, "fragment_size": 50, "number_of_fragments": 2}`,
+	}, {
+		about: "query dsl with highlight",
+		query: QueryDSL{
+			Fields:    []string{"foo"},
+			Query:     MatchAllQuery{},
+			Highlight: &Highlight{Fields: []string{"bar"}},
+		},
+		json: `{"fields": ["foo"], "query": {"match_all": {}}, "highlight": {"fields": {"bar": {}}}}`,
 	}}
 	for i, test := range tests {
 		c.Logf("%d: %s", i, test.about)
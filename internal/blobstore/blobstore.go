@@ -9,11 +9,13 @@ import (
 	"hash"
 	"io"
 	"strconv"
+	"strings"
 
 	"github.com/juju/blobstore"
 	"github.com/juju/errors"
 	"gopkg.in/errgo.v1"
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
 type ReadSeekCloser interface {
@@ -80,6 +82,8 @@ func NewContentChallengeResponse(chal *ContentChallenge, r io.ReadSeeker) (*Cont
 // blob hash.
 type Store struct {
 	mstore blobstore.ManagedStorage
+	db     *mgo.Database
+	prefix string
 }
 
 // New returns a new blob store that writes to the given database,
@@ -88,9 +92,75 @@ func New(db *mgo.Database, prefix string) *Store {
 	rs := blobstore.NewGridFS(db.Name, prefix, db.Session)
 	return &Store{
 		mstore: blobstore.NewManagedStorage(db, rs),
+		db:     db,
+		prefix: prefix,
 	}
 }
 
+// managedResourceC holds the name of the collection that the
+// managed storage uses to record the path of each stored blob.
+const managedResourceC = "managedStoredResources"
+
+// resourceCatalogC holds the name of the collection that the
+// managed storage uses to record where the content of each
+// stored blob is held in GridFS.
+const resourceCatalogC = "storedResources"
+
+// globalPathPrefix holds the prefix that the managed storage
+// adds to the path of blobs that are not stored for
+// a specific environment.
+const globalPathPrefix = "global/"
+
+// Names returns the names of all the blobs in the store. The blob
+// paths and the resource catalog are shared by all the stores in the
+// same database, so only the blobs whose content is held in the
+// GridFS files with this store's collection prefix are included.
+func (s *Store) Names() ([]string, error) {
+	var file struct {
+		Filename string `bson:"filename"`
+	}
+	files := make(map[string]bool)
+	iter := s.db.C(s.prefix + ".files").Find(nil).Select(bson.D{{"filename", 1}}).Iter()
+	for iter.Next(&file) {
+		files[file.Filename] = true
+	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Notef(err, "cannot list blob files")
+	}
+
+	var resource struct {
+		Id   string `bson:"_id"`
+		Path string `bson:"path"`
+	}
+	resourceIds := make(map[string]bool)
+	iter = s.db.C(resourceCatalogC).Find(nil).Select(bson.D{{"_id", 1}, {"path", 1}}).Iter()
+	for iter.Next(&resource) {
+		if files[resource.Path] {
+			resourceIds[resource.Id] = true
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Notef(err, "cannot list blob resources")
+	}
+
+	var doc struct {
+		Path       string `bson:"path"`
+		ResourceId string `bson:"resourceid"`
+	}
+	var names []string
+	iter = s.db.C(managedResourceC).Find(nil).Select(bson.D{{"path", 1}, {"resourceid", 1}}).Iter()
+	for iter.Next(&doc) {
+		if !strings.HasPrefix(doc.Path, globalPathPrefix) || !resourceIds[doc.ResourceId] {
+			continue
+		}
+		names = append(names, strings.TrimPrefix(doc.Path, globalPathPrefix))
+	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Notef(err, "cannot list blobs")
+	}
+	return names, nil
+}
+
 func (s *Store) challengeResponse(resp *ContentChallengeResponse) error {
 	id, err := strconv.ParseInt(resp.RequestId, 10, 64)
 	if err != nil {
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -117,6 +118,48 @@ func (s *BlobStoreSuite) TestRemove(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, `resource at path "[^"]+" not found`)
 }
 
+func (s *BlobStoreSuite) TestNames(c *gc.C) {
+	store := blobstore.New(s.Session.DB("db"), "blobstore")
+	names, err := store.Names()
+	c.Assert(err, gc.IsNil)
+	c.Assert(names, gc.HasLen, 0)
+
+	for _, name := range []string{"x", "y", "z"} {
+		content := "data for " + name
+		err := store.PutUnchallenged(strings.NewReader(content), name, int64(len(content)), hashOf(content))
+		c.Assert(err, gc.IsNil)
+	}
+	err = store.Remove("y")
+	c.Assert(err, gc.IsNil)
+
+	names, err = store.Names()
+	c.Assert(err, gc.IsNil)
+	sort.Strings(names)
+	c.Assert(names, gc.DeepEquals, []string{"x", "z"})
+}
+
+func (s *BlobStoreSuite) TestNamesIgnoresOtherPrefixes(c *gc.C) {
+	store := blobstore.New(s.Session.DB("db"), "blobstore")
+	other := blobstore.New(s.Session.DB("db"), "otherstore")
+	for _, name := range []string{"x", "y"} {
+		content := "data for " + name
+		err := store.PutUnchallenged(strings.NewReader(content), name, int64(len(content)), hashOf(content))
+		c.Assert(err, gc.IsNil)
+	}
+	content := "other data"
+	err := other.PutUnchallenged(strings.NewReader(content), "z", int64(len(content)), hashOf(content))
+	c.Assert(err, gc.IsNil)
+
+	names, err := store.Names()
+	c.Assert(err, gc.IsNil)
+	sort.Strings(names)
+	c.Assert(names, gc.DeepEquals, []string{"x", "y"})
+
+	names, err = other.Names()
+	c.Assert(err, gc.IsNil)
+	c.Assert(names, gc.DeepEquals, []string{"z"})
+}
+
 func (s *BlobStoreSuite) TestLarge(c *gc.C) {
 	store := blobstore.New(s.Session.DB("db"), "blobstore")
 	size := int64(20 * 1024 * 1024)
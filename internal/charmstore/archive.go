@@ -4,9 +4,13 @@
 package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
 
 import (
+	"archive/zip"
 	"bytes"
 	"io"
 	"os"
+	"path"
+	"sort"
+	"strings"
 
 	"gopkg.in/errgo.v1"
 	"gopkg.in/juju/charm.v6-unstable"
@@ -14,6 +18,34 @@ import (
 	"gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
 )
 
+// ArchiveHooks returns the sorted names of the hooks in the charm
+// archive with the given size that is read from r. Hidden files in
+// the hooks directory are ignored.
+func ArchiveHooks(r io.ReaderAt, size int64) ([]string, error) {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot read archive")
+	}
+	var hooks []string
+	for _, f := range zipReader.File {
+		if name, ok := hookName(f); ok {
+			hooks = append(hooks, name)
+		}
+	}
+	sort.Strings(hooks)
+	return hooks, nil
+}
+
+// hookName returns the name of the hook held in the given archive
+// file, and reports whether the file holds a hook.
+func hookName(f *zip.File) (string, bool) {
+	name := path.Clean(f.Name)
+	if path.Dir(name) != "hooks" || strings.HasPrefix(path.Base(name), ".") || f.Mode().IsDir() {
+		return "", false
+	}
+	return path.Base(name), true
+}
+
 type archiverTo interface {
 	ArchiveTo(io.Writer) error
 }
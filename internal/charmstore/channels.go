@@ -0,0 +1,149 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"regexp"
+	"sort"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+var validChannel = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// IsValidChannel reports whether the given name is
+// a valid channel name.
+func IsValidChannel(channel string) bool {
+	return validChannel.MatchString(channel)
+}
+
+// Publish publishes the entity with the given id to each of the given
+// channels, replacing any entity with the same series that was
+// previously published to those channels. A multi-series charm
+// is published for each of its supported series.
+func (s *Store) Publish(url *router.ResolvedURL, channels ...string) error {
+	if len(channels) == 0 {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "no channels specified")
+	}
+	for _, channel := range channels {
+		if !IsValidChannel(channel) {
+			return errgo.WithCausef(nil, params.ErrBadRequest, "invalid channel %q", channel)
+		}
+	}
+	entity, err := s.FindEntity(url, "_id", "supportedseries")
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	series := entity.SupportedSeries
+	if url.URL.Series != "" {
+		series = []string{url.URL.Series}
+	}
+	var set bson.D
+	for _, channel := range channels {
+		for _, s := range series {
+			set = append(set, bson.DocElem{"channels." + channel + "." + s, &url.URL})
+		}
+	}
+	if err := s.UpdateBaseEntity(url, bson.D{{"$set", set}}); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if err := s.UpdateSearch(url); err != nil {
+		return errgo.Notef(err, "cannot update search entities for %q", url)
+	}
+	return nil
+}
+
+// findBestEntityInChannel is like FindBestEntity except that only
+// entities published to the given channel are considered.
+func (s *Store) findBestEntityInChannel(url *charm.Reference, channel string, fields ...string) (*mongodoc.Entity, error) {
+	baseEntity, err := s.FindBaseEntity(url, "channels")
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	var best *charm.Reference
+	for series, id := range baseEntity.Channels[channel] {
+		if url.Series != "" && series != url.Series {
+			continue
+		}
+		if best == nil || seriesScore[series] > seriesScore[best.Series] ||
+			seriesScore[series] == seriesScore[best.Series] && series > best.Series {
+			best = id
+		}
+	}
+	if best == nil {
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "entity not found in channel %q", channel)
+	}
+	if len(fields) > 0 {
+		fields = append(fields, "_id", "promulgated-url", "promulgated-revision")
+	}
+	entity, err := s.FindEntity(&router.ResolvedURL{URL: *best, PromulgatedRevision: -1}, fields...)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if url.User == "" && entity.PromulgatedURL == nil {
+		// A promulgated URL was asked for, but the
+		// published entity does not have one.
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "entity not found in channel %q", channel)
+	}
+	return entity, nil
+}
+
+// ChannelEntityURL returns the URL of the entity with the same
+// base URL and series as the given id that is published to the
+// given channel. When the id refers to a multi-series charm, the
+// latest multi-series charm published to the channel is returned.
+// It returns a params.ErrNotFound error if no such entity has
+// been published.
+func (s *Store) ChannelEntityURL(id *router.ResolvedURL, channel string) (*charm.Reference, error) {
+	var baseEntity mongodoc.BaseEntity
+	err := s.DB.BaseEntities().FindId(baseURL(&id.URL)).Select(bson.D{{"channels", 1}}).One(&baseEntity)
+	if err == mgo.ErrNotFound {
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "base entity not found")
+	}
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot find base entity of %v", id)
+	}
+	var url *charm.Reference
+	if id.URL.Series != "" {
+		url = baseEntity.Channels[channel][id.URL.Series]
+	} else {
+		for _, u := range baseEntity.Channels[channel] {
+			if u.Series == "" && (url == nil || u.Revision > url.Revision) {
+				url = u
+			}
+		}
+	}
+	if url == nil {
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "%v not published to channel %q", baseURL(&id.URL), channel)
+	}
+	return url, nil
+}
+
+// entityChannels returns the names of all the channels that an
+// entity with the same base URL and series as e is published to.
+// For a multi-series charm, any of its supported series will do.
+func entityChannels(e *mongodoc.Entity, be *mongodoc.BaseEntity) []string {
+	series := e.SupportedSeries
+	if e.URL.Series != "" {
+		series = []string{e.URL.Series}
+	}
+	var channels []string
+	for channel, urls := range be.Channels {
+		for _, s := range series {
+			if urls[s] != nil {
+				channels = append(channels, channel)
+				break
+			}
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
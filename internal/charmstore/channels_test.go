@@ -0,0 +1,171 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+var findBestEntityInChannelTests = []struct {
+	url       string
+	channel   string
+	expectURL string
+	expectErr string
+}{{
+	url:       "~charmers/wordpress",
+	channel:   "stable",
+	expectURL: "~charmers/trusty/wordpress-1",
+}, {
+	url:       "~charmers/precise/wordpress",
+	channel:   "stable",
+	expectURL: "~charmers/precise/wordpress-0",
+}, {
+	url:       "~charmers/trusty/wordpress",
+	channel:   "development",
+	expectURL: "~charmers/trusty/wordpress-2",
+}, {
+	url:       "~charmers/trusty/wordpress",
+	channel:   "",
+	expectURL: "~charmers/trusty/wordpress-3",
+}, {
+	url:       "~charmers/trusty/wordpress-0",
+	channel:   "stable",
+	expectURL: "~charmers/trusty/wordpress-0",
+}, {
+	url:       "trusty/wordpress",
+	channel:   "stable",
+	expectURL: "~charmers/trusty/wordpress-1",
+}, {
+	url:       "~charmers/precise/wordpress",
+	channel:   "development",
+	expectErr: `entity not found in channel "development"`,
+}, {
+	url:       "~charmers/wordpress",
+	channel:   "beta",
+	expectErr: `entity not found in channel "beta"`,
+}}
+
+func (s *StoreSuite) TestFindBestEntityInChannel(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	for _, id := range []string{
+		"~charmers/precise/wordpress-0",
+		"~charmers/trusty/wordpress-0",
+		"~charmers/trusty/wordpress-1",
+		"~charmers/trusty/wordpress-2",
+		"~charmers/trusty/wordpress-3",
+	} {
+		rurl := MustParseResolvedURL(id)
+		rurl.PromulgatedRevision = rurl.URL.Revision
+		err := store.AddCharmWithArchive(rurl, storetesting.Charms.CharmDir("wordpress"))
+		c.Assert(err, gc.IsNil)
+	}
+	err := store.Publish(MustParseResolvedURL("~charmers/precise/wordpress-0"), "stable")
+	c.Assert(err, gc.IsNil)
+	err = store.Publish(MustParseResolvedURL("~charmers/trusty/wordpress-1"), "stable")
+	c.Assert(err, gc.IsNil)
+	err = store.Publish(MustParseResolvedURL("~charmers/trusty/wordpress-2"), "development", "stable")
+	c.Assert(err, gc.IsNil)
+	// Publishing again moves the channel pointer.
+	err = store.Publish(MustParseResolvedURL("~charmers/trusty/wordpress-1"), "stable")
+	c.Assert(err, gc.IsNil)
+
+	for i, test := range findBestEntityInChannelTests {
+		c.Logf("test %d: %s in %q", i, test.url, test.channel)
+		entity, err := store.FindBestEntity(charm.MustParseReference(test.url), test.channel)
+		if test.expectErr != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectErr)
+			c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(entity.URL.String(), gc.Equals, charm.MustParseReference(test.expectURL).String())
+	}
+}
+
+func (s *StoreSuite) TestPublishErrors(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	url := MustParseResolvedURL("~charmers/trusty/wordpress-0")
+	err := store.AddCharmWithArchive(url, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+
+	err = store.Publish(url)
+	c.Assert(err, gc.ErrorMatches, "no channels specified")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+
+	err = store.Publish(url, "Bad.Channel")
+	c.Assert(err, gc.ErrorMatches, `invalid channel "Bad.Channel"`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+
+	err = store.Publish(MustParseResolvedURL("~charmers/trusty/wordpress-1"), "stable")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
+
+func (s *StoreSuite) TestChannelEntityURL(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	url := MustParseResolvedURL("~charmers/trusty/wordpress-0")
+	err := store.AddCharmWithArchive(url, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	err = store.Publish(url, "stable")
+	c.Assert(err, gc.IsNil)
+
+	published, err := store.ChannelEntityURL(MustParseResolvedURL("~charmers/trusty/wordpress-5"), "stable")
+	c.Assert(err, gc.IsNil)
+	c.Assert(published, gc.DeepEquals, &url.URL)
+
+	_, err = store.ChannelEntityURL(MustParseResolvedURL("~charmers/precise/wordpress-0"), "stable")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
+
+func (s *StoreSuite) TestPublishMultiSeries(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	for _, id := range []string{"~charmers/trusty/multi-series-0", "~charmers/multi-series-1"} {
+		err := store.AddCharmWithArchive(MustParseResolvedURL(id), storetesting.Charms.CharmDir("multi-series"))
+		c.Assert(err, gc.IsNil)
+	}
+	err := store.Publish(MustParseResolvedURL("~charmers/trusty/multi-series-0"), "stable")
+	c.Assert(err, gc.IsNil)
+	url := MustParseResolvedURL("~charmers/multi-series-1")
+	err = store.Publish(url, "development")
+	c.Assert(err, gc.IsNil)
+
+	// The multi-series charm is published for each of its series.
+	baseEntity, err := store.FindBaseEntity(&url.URL)
+	c.Assert(err, gc.IsNil)
+	c.Assert(baseEntity.Channels["development"], gc.DeepEquals, map[string]*charm.Reference{
+		"precise": &url.URL,
+		"trusty":  &url.URL,
+		"utopic":  &url.URL,
+	})
+	for _, series := range []string{"precise", "trusty", "utopic"} {
+		entity, err := store.FindBestEntity(charm.MustParseReference("~charmers/"+series+"/multi-series"), "development")
+		c.Assert(err, gc.IsNil)
+		c.Assert(entity.URL, gc.DeepEquals, &url.URL)
+	}
+	entity, err := store.FindBestEntity(charm.MustParseReference("~charmers/multi-series"), "stable")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.URL.String(), gc.Equals, "cs:~charmers/trusty/multi-series-0")
+
+	// Publishing a single-series revision only replaces its own series.
+	err = store.Publish(MustParseResolvedURL("~charmers/trusty/multi-series-0"), "development")
+	c.Assert(err, gc.IsNil)
+	entity, err = store.FindBestEntity(charm.MustParseReference("~charmers/trusty/multi-series"), "development")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.URL.String(), gc.Equals, "cs:~charmers/trusty/multi-series-0")
+	entity, err = store.FindBestEntity(charm.MustParseReference("~charmers/precise/multi-series"), "development")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.URL, gc.DeepEquals, &url.URL)
+}
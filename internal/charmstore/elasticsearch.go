@@ -10,7 +10,7 @@ var (
 	esMapping = mustParseJSON(esMappingJSON)
 )
 
-const esSettingsVersion = 7
+const esSettingsVersion = 12
 
 func mustParseJSON(s string) interface{} {
 	var j json.RawMessage
@@ -110,9 +110,18 @@ const esMappingJSON = `
           }
         }
       },
+      "SupportedSeries" : {
+        "type" : "string",
+        "index" : "not_analyzed",
+        "omit_norms" : true,
+        "index_options" : "docs"
+      },
       "TotalDownloads": {
         "type": "long"
       },
+      "RecentDownloads": {
+        "type": "long"
+      },
       "BlobHash" : {
         "type" : "string",
         "index" : "not_analyzed",
@@ -275,6 +284,24 @@ const esMappingJSON = `
         "omit_norms" : true,
         "index_options" : "docs"
       },
+      "CharmHooks" : {
+        "type" : "string",
+        "index" : "not_analyzed",
+        "omit_norms" : true,
+        "index_options" : "docs"
+      },
+      "ConfigOptions" : {
+        "type" : "string",
+        "index" : "not_analyzed",
+        "omit_norms" : true,
+        "index_options" : "docs"
+      },
+      "ActionNames" : {
+        "type" : "string",
+        "index" : "not_analyzed",
+        "omit_norms" : true,
+        "index_options" : "docs"
+      },
 
 
       "BundleData" : {
@@ -344,6 +371,18 @@ const esMappingJSON = `
         "index": "not_analyzed",
         "omit_norms" : true,
         "index_options" : "docs"
+      },
+      "Channels" : {
+        "type" : "string",
+        "index": "not_analyzed",
+        "omit_norms" : true,
+        "index_options" : "docs"
+      },
+      "Tags" : {
+        "type" : "string",
+        "index": "not_analyzed",
+        "omit_norms" : true,
+        "index_options" : "docs"
       }
     }
   }
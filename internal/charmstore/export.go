@@ -0,0 +1,290 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+)
+
+// The export archive is a gzipped tar file holding an entry for
+// each collection, containing its documents as concatenated BSON,
+// and an entry for each blob.
+const (
+	exportCollectionsDir = "collections/"
+	exportBlobsDir       = "blobs/"
+)
+
+// importBatchSize holds the number of documents
+// inserted at a time by Import.
+const importBatchSize = 1000
+
+// Export writes the contents of the charm store to w in a portable
+// archive that can be restored with Import. The archive holds the
+// documents of all the collections used by the charm store (see
+// StoreDatabase.Collections) and the contents of all the blobs.
+// Macaroons and the internal blob store collections are not
+// included.
+func (s *Store) Export(w io.Writer) error {
+	gzw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gzw)
+	for _, coll := range s.DB.Collections() {
+		if err := exportCollection(tw, coll); err != nil {
+			return errgo.Notef(err, "cannot export %s", coll.Name)
+		}
+	}
+	names, err := s.BlobStore.Names()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := s.exportBlob(tw, name); err != nil {
+			return errgo.Notef(err, "cannot export blob %s", name)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := gzw.Close(); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// exportCollection writes all the documents in the given
+// collection to a single archive entry.
+func exportCollection(tw *tar.Writer, coll *mgo.Collection) error {
+	// The size of an archive entry must be known before it is
+	// written, so the documents are gathered in a temporary file.
+	f, err := ioutil.TempFile("", "charmstore-export")
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	var doc bson.Raw
+	iter := coll.Find(nil).Sort("_id").Iter()
+	for iter.Next(&doc) {
+		if _, err := f.Write(doc.Data); err != nil {
+			iter.Close()
+			return errgo.Mask(err)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return errgo.Notef(err, "cannot read documents")
+	}
+	size, err := f.Seek(0, 1)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return errgo.Mask(err)
+	}
+	return writeArchiveEntry(tw, exportCollectionsDir+coll.Name+".bson", f, size)
+}
+
+// exportBlob writes the blob with the given name to
+// an archive entry.
+func (s *Store) exportBlob(tw *tar.Writer, name string) error {
+	r, size, err := s.BlobStore.Open(name)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer r.Close()
+	return writeArchiveEntry(tw, exportBlobsDir+name, r, size)
+}
+
+func writeArchiveEntry(tw *tar.Writer, name string, r io.Reader, size int64) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if _, err := io.Copy(tw, r); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// Import restores the contents of an archive written by Export.
+// The store must be empty: apart from the migrations document that
+// is created with the store, none of its collections may hold any
+// documents and its blob store may not hold any blobs. If the store
+// has a search index, it is rebuilt once all the contents have been
+// restored.
+func (s *Store) Import(r io.Reader) error {
+	if err := s.checkEmpty(); err != nil {
+		return errgo.Mask(err)
+	}
+	collections := make(map[string]*mgo.Collection)
+	for _, coll := range s.DB.Collections() {
+		collections[coll.Name] = coll
+	}
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return errgo.Notef(err, "cannot read archive")
+	}
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errgo.Notef(err, "cannot read archive")
+		}
+		switch {
+		case strings.HasPrefix(hdr.Name, exportCollectionsDir):
+			name := strings.TrimSuffix(strings.TrimPrefix(hdr.Name, exportCollectionsDir), ".bson")
+			coll, ok := collections[name]
+			if !ok {
+				return errgo.Newf("unknown collection %q in archive", name)
+			}
+			if coll.Name == s.DB.Migrations().Name {
+				// The migrations document is created with the
+				// store, so replace it with the imported one.
+				if _, err := coll.RemoveAll(nil); err != nil {
+					return errgo.Notef(err, "cannot remove migrations")
+				}
+			}
+			if err := importCollection(coll, tr); err != nil {
+				return errgo.Notef(err, "cannot import %s", name)
+			}
+		case strings.HasPrefix(hdr.Name, exportBlobsDir):
+			name := strings.TrimPrefix(hdr.Name, exportBlobsDir)
+			if err := s.importBlob(name, tr, hdr.Size); err != nil {
+				return errgo.Notef(err, "cannot import blob %s", name)
+			}
+		default:
+			return errgo.Newf("unexpected file %q in archive", hdr.Name)
+		}
+	}
+	if s.searchBackend() == nil {
+		return nil
+	}
+	if err := s.SynchroniseElasticsearch(); err != nil {
+		return errgo.Notef(err, "cannot synchronise elasticsearch")
+	}
+	return nil
+}
+
+// checkEmpty returns an error if the store holds any
+// content that could conflict with an import.
+func (s *Store) checkEmpty() error {
+	for _, coll := range s.DB.Collections() {
+		if coll.Name == s.DB.Migrations().Name {
+			continue
+		}
+		n, err := coll.Count()
+		if err != nil {
+			return errgo.Notef(err, "cannot count documents in %s", coll.Name)
+		}
+		if n > 0 {
+			return errgo.Newf("cannot import into non-empty store: %s has %d documents", coll.Name, n)
+		}
+	}
+	names, err := s.BlobStore.Names()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if len(names) > 0 {
+		return errgo.Newf("cannot import into non-empty store: blob store has %d blobs", len(names))
+	}
+	return nil
+}
+
+// importCollection inserts the concatenated BSON
+// documents read from r into the given collection.
+func importCollection(coll *mgo.Collection, r io.Reader) error {
+	docs := make([]interface{}, 0, importBatchSize)
+	for {
+		doc, err := readBSONDocument(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		docs = append(docs, doc)
+		if len(docs) == importBatchSize {
+			if err := coll.Insert(docs...); err != nil {
+				return errgo.Mask(err)
+			}
+			docs = docs[:0]
+		}
+	}
+	if len(docs) > 0 {
+		if err := coll.Insert(docs...); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+// readBSONDocument reads a single BSON document from r.
+// It returns io.EOF if there are no more documents.
+func readBSONDocument(r io.Reader) (bson.Raw, error) {
+	var sizeBuf [4]byte
+	if _, err := io.ReadFull(r, sizeBuf[:]); err != nil {
+		if err == io.EOF {
+			return bson.Raw{}, io.EOF
+		}
+		return bson.Raw{}, errgo.Notef(err, "cannot read document")
+	}
+	// The size includes the size field itself and
+	// the terminating null byte.
+	size := binary.LittleEndian.Uint32(sizeBuf[:])
+	if size < 5 {
+		return bson.Raw{}, errgo.Newf("invalid document size %d", size)
+	}
+	data := make([]byte, size)
+	copy(data, sizeBuf[:])
+	if _, err := io.ReadFull(r, data[4:]); err != nil {
+		return bson.Raw{}, errgo.Notef(err, "cannot read document")
+	}
+	return bson.Raw{Kind: 3, Data: data}, nil
+}
+
+// importBlob adds the blob with the given name and
+// size, read from r, to the blob store.
+func (s *Store) importBlob(name string, r io.Reader, size int64) error {
+	// The blob store needs the hash of the content before
+	// it is stored, so read it into a temporary file first.
+	f, err := ioutil.TempFile("", "charmstore-import")
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	hash := blobstore.NewHash()
+	if _, err := io.Copy(io.MultiWriter(f, hash), r); err != nil {
+		return errgo.Mask(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := s.BlobStore.PutUnchallenged(f, name, size, fmt.Sprintf("%x", hash.Sum(nil))); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/macaroon-bakery.v1/bakery"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+func (s *StoreSuite) TestExportImport(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	for _, id := range []string{"0 ~charmers/trusty/wordpress-0", "~bob/precise/mysql-1"} {
+		err := store.AddCharmWithArchive(MustParseResolvedURL(id), storetesting.Charms.CharmDir("wordpress"))
+		c.Assert(err, gc.IsNil)
+	}
+	err := store.AddBundleWithArchive(MustParseResolvedURL("~charmers/bundle/wordpress-simple-0"), storetesting.Charms.BundleDir("wordpress-simple"))
+	c.Assert(err, gc.IsNil)
+	statsKey := EntityStatsKey(charm.MustParseReference("~charmers/trusty/wordpress-0"), params.StatsArchiveDownload)
+	err = store.IncCounter(statsKey)
+	c.Assert(err, gc.IsNil)
+
+	var buf bytes.Buffer
+	err = store.Export(&buf)
+	c.Assert(err, gc.IsNil)
+
+	p, err := NewPool(s.Session.DB("juju_test_import"), nil, &bakery.NewServiceParams{}, ServerParams{})
+	c.Assert(err, gc.IsNil)
+	defer p.Close()
+	imported := p.Store()
+	defer imported.Close()
+	err = imported.Import(bytes.NewReader(buf.Bytes()))
+	c.Assert(err, gc.IsNil)
+
+	// The documents and blobs are the same in both stores.
+	var entities, importedEntities []*mongodoc.Entity
+	err = store.DB.Entities().Find(nil).Sort("_id").All(&entities)
+	c.Assert(err, gc.IsNil)
+	err = imported.DB.Entities().Find(nil).Sort("_id").All(&importedEntities)
+	c.Assert(err, gc.IsNil)
+	c.Assert(importedEntities, jc.DeepEquals, entities)
+	var baseEntities, importedBaseEntities []*mongodoc.BaseEntity
+	err = store.DB.BaseEntities().Find(nil).Sort("_id").All(&baseEntities)
+	c.Assert(err, gc.IsNil)
+	err = imported.DB.BaseEntities().Find(nil).Sort("_id").All(&importedBaseEntities)
+	c.Assert(err, gc.IsNil)
+	c.Assert(importedBaseEntities, jc.DeepEquals, baseEntities)
+	for _, e := range entities {
+		r, _, err := store.BlobStore.Open(e.BlobName)
+		c.Assert(err, gc.IsNil)
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		c.Assert(err, gc.IsNil)
+		r, _, err = imported.BlobStore.Open(e.BlobName)
+		c.Assert(err, gc.IsNil)
+		importedData, err := ioutil.ReadAll(r)
+		r.Close()
+		c.Assert(err, gc.IsNil)
+		c.Assert(importedData, gc.DeepEquals, data)
+	}
+	counters, err := imported.Counters(&CounterRequest{Key: statsKey})
+	c.Assert(err, gc.IsNil)
+	c.Assert(counters[0].Count, gc.Equals, int64(1))
+
+	// The imported store can be used as usual.
+	entity, err := imported.FindBestEntity(charm.MustParseReference("wordpress"), "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.URL.String(), gc.Equals, "cs:~charmers/trusty/wordpress-0")
+
+	// Importing into a store that is not empty fails.
+	err = imported.Import(bytes.NewReader(buf.Bytes()))
+	c.Assert(err, gc.ErrorMatches, `cannot import into non-empty store: juju.stat.counters has [0-9]+ documents`)
+}
+
+func (s *StoreSuite) TestImportIntoStoreWithoutEntities(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	var buf bytes.Buffer
+	err := store.Export(&buf)
+	c.Assert(err, gc.IsNil)
+
+	// A store holding no entities but some other
+	// documents cannot be imported into.
+	p, err := NewPool(s.Session.DB("juju_test_import"), nil, &bakery.NewServiceParams{}, ServerParams{})
+	c.Assert(err, gc.IsNil)
+	defer p.Close()
+	imported := p.Store()
+	defer imported.Close()
+	err = imported.DB.Featured().Insert(bson.D{{"_id", "cs:wordpress"}})
+	c.Assert(err, gc.IsNil)
+	err = imported.Import(bytes.NewReader(buf.Bytes()))
+	c.Assert(err, gc.ErrorMatches, `cannot import into non-empty store: featured has 1 documents`)
+
+	// Nor can a store holding only blobs.
+	p1, err := NewPool(s.Session.DB("juju_test_import1"), nil, &bakery.NewServiceParams{}, ServerParams{})
+	c.Assert(err, gc.IsNil)
+	defer p1.Close()
+	imported1 := p1.Store()
+	defer imported1.Close()
+	content := "some data"
+	hash := blobstore.NewHash()
+	hash.Write([]byte(content))
+	err = imported1.BlobStore.PutUnchallenged(strings.NewReader(content), "x", int64(len(content)), fmt.Sprintf("%x", hash.Sum(nil)))
+	c.Assert(err, gc.IsNil)
+	err = imported1.Import(bytes.NewReader(buf.Bytes()))
+	c.Assert(err, gc.ErrorMatches, `cannot import into non-empty store: blob store has 1 blobs`)
+
+	// An empty store can be imported into.
+	p2, err := NewPool(s.Session.DB("juju_test_import2"), nil, &bakery.NewServiceParams{}, ServerParams{})
+	c.Assert(err, gc.IsNil)
+	defer p2.Close()
+	imported2 := p2.Store()
+	defer imported2.Close()
+	err = imported2.Import(bytes.NewReader(buf.Bytes()))
+	c.Assert(err, gc.IsNil)
+}
@@ -0,0 +1,114 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"sort"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+// DefaultBlobGCMinAge holds the minimum age of an unreferenced
+// blob before it is removed by the background garbage collector.
+// Younger blobs may belong to uploads that are still in progress.
+const DefaultBlobGCMinAge = time.Hour
+
+// CollectBlobs finds the blobs in the blob store that are not
+// referenced by any entity, including entities in the trash, by
+// any resource or by any upload session that has not expired, and
+// returns their names in sorted order. Cached zip
+// file entries (see mongodoc.Entity.Contents) refer to offsets within
+// the entity's archive blob, so they are covered by its BlobName.
+//
+// Blobs created less than minAge ago are never reported, because
+// they may belong to an upload that has not yet recorded its
+// reference. If dryRun is false, the orphaned blobs are removed.
+func (s *Store) CollectBlobs(minAge time.Duration, dryRun bool) ([]string, error) {
+	names, err := s.BlobStore.Names()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	sort.Strings(names)
+	referenced, err := s.referencedBlobs()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	cutoff := time.Now().Add(-minAge)
+	var orphans []string
+	for _, name := range names {
+		if referenced[name] {
+			continue
+		}
+		// All blob names are created as object ids, so we
+		// can tell when the blob was added. Names that are
+		// not object ids cannot belong to a current upload.
+		if bson.IsObjectIdHex(name) && bson.ObjectIdHex(name).Time().After(cutoff) {
+			continue
+		}
+		if !dryRun {
+			if err := s.BlobStore.Remove(name); err != nil {
+				return orphans, errgo.Notef(err, "cannot remove blob %s", name)
+			}
+		}
+		orphans = append(orphans, name)
+	}
+	return orphans, nil
+}
+
+// referencedBlobs returns the set of the names of all the
+// blobs referred to by entities, resources and upload
+// sessions that have not expired.
+func (s *Store) referencedBlobs() (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	var entity mongodoc.Entity
+	iter := s.DB.Entities().Find(nil).Select(bson.D{{"blobname", 1}}).Iter()
+	for iter.Next(&entity) {
+		referenced[entity.BlobName] = true
+	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Notef(err, "cannot read entity blob names")
+	}
+	var resource mongodoc.Resource
+	iter = s.DB.Resources().Find(nil).Select(bson.D{{"blobs", 1}}).Iter()
+	for iter.Next(&resource) {
+		for _, blob := range resource.Blobs {
+			referenced[blob.BlobName] = true
+		}
+		resource.Blobs = nil
+	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Notef(err, "cannot read resource blob names")
+	}
+	var session mongodoc.UploadSession
+	iter = s.DB.UploadSessions().Find(bson.D{{"expires", bson.D{{"$gt", time.Now()}}}}).Select(bson.D{{"chunks", 1}}).Iter()
+	for iter.Next(&session) {
+		for _, chunk := range session.Chunks {
+			referenced[chunk.BlobName] = true
+		}
+		session.Chunks = nil
+	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Notef(err, "cannot read upload session blob names")
+	}
+	return referenced, nil
+}
+
+// collectBlobs removes expired upload sessions and orphaned blobs
+// from the blob store. It is intended to be run as a periodic job.
+func collectBlobs(store *Store) {
+	if err := store.removeExpiredUploadSessions(); err != nil {
+		logger.Errorf("%v", err)
+	}
+	removed, err := store.CollectBlobs(DefaultBlobGCMinAge, false)
+	for _, name := range removed {
+		logger.Infof("removed orphaned blob %s", name)
+	}
+	if err != nil {
+		logger.Errorf("cannot collect orphaned blobs: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+func (s *StoreSuite) TestCollectBlobs(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	// Add a charm, a trashed charm and a resource, all of
+	// which refer to blobs that must be kept.
+	for _, id := range []string{"~charmers/trusty/wordpress-0", "~charmers/trusty/wordpress-1"} {
+		err := store.AddCharmWithArchive(MustParseResolvedURL(id), storetesting.Charms.CharmDir("wordpress"))
+		c.Assert(err, gc.IsNil)
+	}
+	err := store.TrashEntity(MustParseResolvedURL("~charmers/trusty/wordpress-1"))
+	c.Assert(err, gc.IsNil)
+	url := charm.MustParseReference("cs:~charmers/trusty/wordpress-0")
+	_, err = store.AddResourceRevision(url, "data.default")
+	c.Assert(err, gc.IsNil)
+	err = store.PutResource(url, "data.default", 0, "amd64", strings.NewReader("hello"), hashOf256("hello"))
+	c.Assert(err, gc.IsNil)
+
+	// Add some orphaned blobs, one of which is too recent to collect.
+	oldName1 := bson.NewObjectIdWithTime(time.Now().Add(-2 * time.Hour)).Hex()
+	oldName2 := bson.NewObjectIdWithTime(time.Now().Add(-3 * time.Hour)).Hex()
+	newName := bson.NewObjectId().Hex()
+	for _, name := range []string{oldName1, oldName2, newName, "not-an-object-id"} {
+		err := store.BlobStore.PutUnchallenged(strings.NewReader("fake content"), name, fakeBlobSize, fakeBlobHash)
+		c.Assert(err, gc.IsNil)
+	}
+	expect := []string{oldName1, oldName2, "not-an-object-id"}
+	sort.Strings(expect)
+
+	// A dry run reports the orphans without removing them.
+	orphans, err := store.CollectBlobs(time.Hour, true)
+	c.Assert(err, gc.IsNil)
+	c.Assert(orphans, gc.DeepEquals, expect)
+	names, err := store.BlobStore.Names()
+	c.Assert(err, gc.IsNil)
+	c.Assert(names, gc.HasLen, 7)
+
+	orphans, err = store.CollectBlobs(time.Hour, false)
+	c.Assert(err, gc.IsNil)
+	c.Assert(orphans, gc.DeepEquals, expect)
+	names, err = store.BlobStore.Names()
+	c.Assert(err, gc.IsNil)
+	c.Assert(names, gc.HasLen, 4)
+	for _, name := range expect {
+		_, _, err := store.BlobStore.Open(name)
+		c.Assert(err, gc.ErrorMatches, "resource.*not found")
+	}
+	r, _, err := store.BlobStore.Open(newName)
+	c.Assert(err, gc.IsNil)
+	r.Close()
+
+	// Nothing more is found on a second run.
+	orphans, err = store.CollectBlobs(time.Hour, false)
+	c.Assert(err, gc.IsNil)
+	c.Assert(orphans, gc.HasLen, 0)
+}
@@ -0,0 +1,312 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"sort"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+// DefaultInterestingLimit holds the maximum number of entities
+// returned by Interesting when no limit is specified.
+const DefaultInterestingLimit = 20
+
+// InterestingPopularPeriod holds how recently an entity must
+// have been uploaded to be considered as recently published.
+const InterestingPopularPeriod = 30 * 24 * time.Hour
+
+// maxInterestingCandidates holds the maximum number of recently
+// uploaded entities considered for each of the computed rankings.
+const maxInterestingCandidates = 100
+
+// interestingFields holds the entity fields needed
+// to rank the interesting entities.
+var interestingFields = bson.D{
+	{"_id", 1},
+	{"promulgated-url", 1},
+	{"baseurl", 1},
+}
+
+// Featured returns the Mongo collection where the
+// curated featured entities are stored.
+func (s StoreDatabase) Featured() *mgo.Collection {
+	return s.C("featured")
+}
+
+// FeaturedEntities returns the ids of the featured entities,
+// in the order they were set by SetFeaturedEntities.
+func (s *Store) FeaturedEntities() ([]*charm.Reference, error) {
+	var docs []mongodoc.FeaturedEntity
+	if err := s.DB.Featured().Find(nil).Sort("position").All(&docs); err != nil {
+		return nil, errgo.Notef(err, "cannot get featured entities")
+	}
+	ids := make([]*charm.Reference, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.URL
+	}
+	return ids, nil
+}
+
+// SetFeaturedEntities replaces the featured entities with the given
+// ids, in order. It returns an error with a params.ErrBadRequest
+// cause if an id is specified more than once.
+func (s *Store) SetFeaturedEntities(ids []*charm.Reference) error {
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if seen[id.String()] {
+			return errgo.WithCausef(nil, params.ErrBadRequest, "duplicate featured entity %q", id)
+		}
+		seen[id.String()] = true
+	}
+	if _, err := s.DB.Featured().RemoveAll(nil); err != nil {
+		return errgo.Notef(err, "cannot remove featured entities")
+	}
+	for i, id := range ids {
+		err := s.DB.Featured().Insert(&mongodoc.FeaturedEntity{
+			URL:      id,
+			Position: i,
+		})
+		if err != nil {
+			return errgo.Notef(err, "cannot add featured entity %q", id)
+		}
+	}
+	return nil
+}
+
+// InterestingParams holds the parameters for Store.Interesting.
+type InterestingParams struct {
+	// Limit holds the maximum number of entities to return.
+	// If it is zero, DefaultInterestingLimit is used.
+	Limit int
+
+	// Groups holds the ACL values, user names or group names,
+	// that may read the returned entities in addition to everyone.
+	Groups []string
+
+	// Admin specifies that entities are returned
+	// regardless of their ACLs.
+	Admin bool
+}
+
+// Interesting returns entities of interest to users browsing the
+// charm store, as selected by SelectInteresting from the candidates
+// returned by InterestingCandidates.
+func (s *Store) Interesting(p InterestingParams) ([]*router.ResolvedURL, error) {
+	candidates, err := s.InterestingCandidates()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return s.SelectInteresting(candidates, p)
+}
+
+// InterestingCandidates returns the entities that may be of interest
+// to users browsing the charm store, best first. The featured entities
+// come first, followed by recently published entities ordered by their
+// downloads in the last week, interleaved with the most recently
+// uploaded promulgated charms. At most one entity is returned for each
+// base entity.
+//
+// The candidates do not depend on the ACLs of the entities, so they
+// may be shared between users: SelectInteresting is used to choose
+// the candidates readable by a given user.
+func (s *Store) InterestingCandidates() ([]*mongodoc.Entity, error) {
+	featured, err := s.featuredCandidates()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	popular, err := s.popularCandidates(time.Now().Add(-InterestingPopularPeriod))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	promulgated, err := s.newPromulgatedCandidates()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var candidates []*mongodoc.Entity
+	seen := make(map[string]bool)
+	add := func(e *mongodoc.Entity) {
+		key := e.BaseURL.String()
+		if !seen[key] {
+			seen[key] = true
+			candidates = append(candidates, e)
+		}
+	}
+	for _, e := range featured {
+		add(e)
+	}
+	for i := 0; i < len(popular) || i < len(promulgated); i++ {
+		if i < len(popular) {
+			add(popular[i])
+		}
+		if i < len(promulgated) {
+			add(promulgated[i])
+		}
+	}
+	return candidates, nil
+}
+
+// SelectInteresting returns the ids of the first p.Limit entities
+// in candidates, as returned by InterestingCandidates, that are
+// readable as specified by p.
+func (s *Store) SelectInteresting(candidates []*mongodoc.Entity, p InterestingParams) ([]*router.ResolvedURL, error) {
+	if p.Limit <= 0 {
+		p.Limit = DefaultInterestingLimit
+	}
+	var results []*router.ResolvedURL
+	for _, e := range candidates {
+		if len(results) >= p.Limit {
+			break
+		}
+		ok, err := s.canReadInteresting(e.BaseURL, p)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if ok {
+			results = append(results, EntityResolvedURL(e))
+		}
+	}
+	return results, nil
+}
+
+// featuredCandidates returns the best entity matching each
+// featured id. Featured ids that do not match any entity
+// are ignored.
+func (s *Store) featuredCandidates() ([]*mongodoc.Entity, error) {
+	ids, err := s.FeaturedEntities()
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	entities := make([]*mongodoc.Entity, 0, len(ids))
+	for _, id := range ids {
+		entity, err := s.FindBestEntity(id, "", "_id", "promulgated-url", "baseurl")
+		if errgo.Cause(err) == params.ErrNotFound {
+			logger.Infof("ignoring featured entity %q: %v", id, err)
+			continue
+		}
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		entities = append(entities, entity)
+	}
+	return entities, nil
+}
+
+// popularCandidates returns the latest revisions of the entities
+// uploaded since the given time that have been downloaded in the
+// last week, the most downloaded first.
+func (s *Store) popularCandidates(since time.Time) ([]*mongodoc.Entity, error) {
+	entities, err := s.recentEntities(bson.D{
+		{"uploadtime", bson.D{{"$gte", since}}},
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	downloads := make(map[*mongodoc.Entity]int64)
+	popular := entities[:0]
+	for _, e := range entities {
+		_, counts, err := s.ArchiveDownloadCounts(e.URL, false)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if counts.LastWeek == 0 {
+			continue
+		}
+		downloads[e] = counts.LastWeek
+		popular = append(popular, e)
+	}
+	sort.Stable(entitiesByDownloads{popular, downloads})
+	return popular, nil
+}
+
+// newPromulgatedCandidates returns the latest revisions of
+// the most recently uploaded promulgated charms.
+func (s *Store) newPromulgatedCandidates() ([]*mongodoc.Entity, error) {
+	entities, err := s.recentEntities(bson.D{
+		{"promulgated-url", bson.D{{"$exists", true}}},
+		{"series", bson.D{{"$ne", "bundle"}}},
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return entities, nil
+}
+
+// recentEntities returns the most recently uploaded entities
+// matching the given query that are not in the trash, most recent
+// first, keeping only the latest upload of each base entity.
+func (s *Store) recentEntities(query bson.D) ([]*mongodoc.Entity, error) {
+	query = append(query, bson.DocElem{"trashtime", bson.D{{"$exists", false}}})
+	var entities []*mongodoc.Entity
+	err := s.DB.Entities().
+		Find(query).
+		Sort("-uploadtime").
+		Limit(maxInterestingCandidates).
+		Select(interestingFields).
+		All(&entities)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get recent entities")
+	}
+	seen := make(map[string]bool)
+	latest := entities[:0]
+	for _, e := range entities {
+		if seen[e.BaseURL.String()] {
+			continue
+		}
+		seen[e.BaseURL.String()] = true
+		latest = append(latest, e)
+	}
+	return latest, nil
+}
+
+// canReadInteresting reports whether the given base
+// entity is readable as specified by p.
+func (s *Store) canReadInteresting(baseURL *charm.Reference, p InterestingParams) (bool, error) {
+	if p.Admin {
+		return true, nil
+	}
+	baseEntity, err := s.FindBaseEntity(baseURL, "acls")
+	if errgo.Cause(err) == params.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, errgo.Mask(err)
+	}
+	for _, name := range baseEntity.ACLs.Read {
+		if name == params.Everyone {
+			return true, nil
+		}
+		for _, group := range p.Groups {
+			if name == group {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+type entitiesByDownloads struct {
+	entities  []*mongodoc.Entity
+	downloads map[*mongodoc.Entity]int64
+}
+
+func (s entitiesByDownloads) Len() int {
+	return len(s.entities)
+}
+
+func (s entitiesByDownloads) Swap(i, j int) {
+	s.entities[i], s.entities[j] = s.entities[j], s.entities[i]
+}
+
+func (s entitiesByDownloads) Less(i, j int) bool {
+	return s.downloads[s.entities[i]] > s.downloads[s.entities[j]]
+}
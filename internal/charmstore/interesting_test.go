@@ -0,0 +1,156 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"time"
+
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+func (s *StoreSuite) TestFeaturedEntities(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	ids, err := store.FeaturedEntities()
+	c.Assert(err, gc.IsNil)
+	c.Assert(ids, gc.HasLen, 0)
+
+	featured := []*charm.Reference{
+		charm.MustParseReference("cs:wordpress"),
+		charm.MustParseReference("cs:~bob/trusty/mysql"),
+	}
+	err = store.SetFeaturedEntities(featured)
+	c.Assert(err, gc.IsNil)
+	ids, err = store.FeaturedEntities()
+	c.Assert(err, gc.IsNil)
+	c.Assert(ids, gc.DeepEquals, featured)
+
+	// Setting the featured entities replaces the previous ones.
+	featured = featured[1:]
+	err = store.SetFeaturedEntities(featured)
+	c.Assert(err, gc.IsNil)
+	ids, err = store.FeaturedEntities()
+	c.Assert(err, gc.IsNil)
+	c.Assert(ids, gc.DeepEquals, featured)
+
+	err = store.SetFeaturedEntities([]*charm.Reference{featured[0], featured[0]})
+	c.Assert(err, gc.ErrorMatches, `duplicate featured entity "cs:~bob/trusty/mysql"`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+}
+
+// addInterestingCharm adds the charm with the given name to the store
+// at the given URL, with the given upload time, read ACL and number of
+// downloads.
+func addInterestingCharm(c *gc.C, store *Store, url, name string, uploadTime time.Time, downloads int, readACL ...string) {
+	rurl := MustParseResolvedURL(url)
+	err := store.AddCharmWithArchive(rurl, storetesting.Charms.CharmDir(name))
+	c.Assert(err, gc.IsNil)
+	err = store.UpdateEntity(rurl, bson.D{{"$set", bson.D{{"uploadtime", uploadTime}}}})
+	c.Assert(err, gc.IsNil)
+	err = store.SetPerms(&rurl.URL, "read", readACL...)
+	c.Assert(err, gc.IsNil)
+	for i := 0; i < downloads; i++ {
+		err := store.IncrementDownloadCounts(rurl)
+		c.Assert(err, gc.IsNil)
+	}
+}
+
+var interestingTests = []struct {
+	about  string
+	params InterestingParams
+	expect []string
+}{{
+	about: "anonymous",
+	expect: []string{
+		"cs:~bob/trusty/dummy-0",
+		"0 cs:~charmers/trusty/logging-0",
+		"cs:~bob/trusty/varnish-0",
+		"0 cs:~charmers/precise/wordpress-0",
+		"cs:~bob/trusty/mysql-0",
+	},
+}, {
+	about: "with groups",
+	params: InterestingParams{
+		Groups: []string{"alice"},
+	},
+	expect: []string{
+		"cs:~bob/trusty/dummy-0",
+		"cs:~alice/trusty/riak-0",
+		"0 cs:~charmers/trusty/logging-0",
+		"cs:~bob/trusty/varnish-0",
+		"0 cs:~charmers/precise/wordpress-0",
+		"cs:~bob/trusty/mysql-0",
+	},
+}, {
+	about: "admin",
+	params: InterestingParams{
+		Admin: true,
+	},
+	expect: []string{
+		"cs:~bob/trusty/dummy-0",
+		"cs:~alice/trusty/riak-0",
+		"0 cs:~charmers/trusty/logging-0",
+		"cs:~bob/trusty/varnish-0",
+		"0 cs:~charmers/precise/wordpress-0",
+		"cs:~bob/trusty/mysql-0",
+	},
+}, {
+	about: "with limit",
+	params: InterestingParams{
+		Limit: 3,
+	},
+	expect: []string{
+		"cs:~bob/trusty/dummy-0",
+		"0 cs:~charmers/trusty/logging-0",
+		"cs:~bob/trusty/varnish-0",
+	},
+}}
+
+func (s *StoreSuite) TestInteresting(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	now := time.Now()
+	old := now.Add(-2 * InterestingPopularPeriod)
+	addInterestingCharm(c, store, "0 ~charmers/precise/wordpress-0", "wordpress", old, 20, params.Everyone)
+	addInterestingCharm(c, store, "0 ~charmers/trusty/logging-0", "logging", now.Add(-time.Hour), 0, params.Everyone)
+	addInterestingCharm(c, store, "~bob/trusty/mysql-0", "mysql", now, 2, params.Everyone)
+	addInterestingCharm(c, store, "~bob/trusty/varnish-0", "varnish", now, 5, params.Everyone)
+	addInterestingCharm(c, store, "~alice/trusty/riak-0", "riak", now, 10, "alice")
+	addInterestingCharm(c, store, "~bob/trusty/dummy-0", "dummy", old, 0, params.Everyone)
+	err := store.SetFeaturedEntities([]*charm.Reference{
+		charm.MustParseReference("cs:~bob/trusty/dummy"),
+		charm.MustParseReference("cs:~bob/trusty/no-such-charm"),
+	})
+	c.Assert(err, gc.IsNil)
+
+	for i, test := range interestingTests {
+		c.Logf("test %d: %s", i, test.about)
+		results, err := store.Interesting(test.params)
+		c.Assert(err, gc.IsNil)
+		c.Assert(results, gc.DeepEquals, MustParseResolvedURLs(test.expect))
+	}
+}
+
+func (s *StoreSuite) TestInterestingOneRevisionPerBaseEntity(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	now := time.Now()
+	addInterestingCharm(c, store, "~bob/trusty/mysql-0", "mysql", now.Add(-time.Hour), 1, params.Everyone)
+	addInterestingCharm(c, store, "~bob/trusty/mysql-1", "mysql", now, 1, params.Everyone)
+	results, err := store.Interesting(InterestingParams{})
+	c.Assert(err, gc.IsNil)
+	c.Assert(results, gc.DeepEquals, []*router.ResolvedURL{
+		MustParseResolvedURL("~bob/trusty/mysql-1"),
+	})
+}
@@ -0,0 +1,46 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"time"
+)
+
+// periodicJob runs a maintenance task at regular
+// intervals until it is closed.
+type periodicJob struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startPeriodicJob calls f every interval with a Store taken from
+// the given pool. The returned job must be stopped with the Close
+// method.
+func startPeriodicJob(pool *Pool, interval time.Duration, f func(*Store)) *periodicJob {
+	j := &periodicJob{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(j.done)
+		for {
+			select {
+			case <-j.stop:
+				return
+			case <-time.After(interval):
+			}
+			store := pool.Store()
+			f(store)
+			store.Close()
+		}
+	}()
+	return j
+}
+
+// Close stops the job and waits for any
+// current run to finish.
+func (j *periodicJob) Close() {
+	close(j.stop)
+	<-j.done
+}
@@ -0,0 +1,209 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"archive/zip"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+// Lint problem severities. A problem with LintError severity
+// causes the upload to be rejected; problems with LintWarning
+// severity are stored on the entity.
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// LintArchive holds an uploaded archive to be checked by a Linter.
+type LintArchive struct {
+	// URL holds the id the archive is being uploaded to.
+	URL *router.ResolvedURL
+
+	// Charm holds the uploaded charm. It is nil
+	// if the archive holds a bundle.
+	Charm charm.Charm
+
+	// Bundle holds the uploaded bundle. It is nil
+	// if the archive holds a charm.
+	Bundle charm.Bundle
+
+	// Files holds the files in the archive.
+	Files []*zip.File
+}
+
+// Linter checks an uploaded archive and returns any problems found.
+// The Linter field of the returned problems need not be set.
+type Linter func(s *Store, a *LintArchive) ([]mongodoc.LintProblem, error)
+
+// linters holds the registered linters, keyed by name.
+var linters = map[string]Linter{
+	"config":     lintConfig,
+	"hooks":      lintHooks,
+	"icon":       lintIcon,
+	"interfaces": lintInterfaces,
+	"readme":     lintReadme,
+}
+
+// RegisterLinter registers a linter to be run on every uploaded
+// archive. It panics if a linter with the given name is already
+// registered. It is not safe to call RegisterLinter concurrently
+// with Lint.
+func RegisterLinter(name string, l Linter) {
+	if _, ok := linters[name]; ok {
+		panic(fmt.Sprintf("linter %q already registered", name))
+	}
+	linters[name] = l
+}
+
+// Lint runs all the registered linters on the given archive,
+// in name order, and returns the problems they found.
+func (s *Store) Lint(a *LintArchive) ([]mongodoc.LintProblem, error) {
+	names := make([]string, 0, len(linters))
+	for name := range linters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var problems []mongodoc.LintProblem
+	for _, name := range names {
+		ps, err := linters[name](s, a)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot run %s linter", name)
+		}
+		for _, p := range ps {
+			p.Linter = name
+			problems = append(problems, p)
+		}
+	}
+	return problems, nil
+}
+
+func lintWarningf(f string, a ...interface{}) mongodoc.LintProblem {
+	return mongodoc.LintProblem{
+		Severity: LintWarning,
+		Message:  fmt.Sprintf(f, a...),
+	}
+}
+
+func lintErrorf(f string, a ...interface{}) mongodoc.LintProblem {
+	return mongodoc.LintProblem{
+		Severity: LintError,
+		Message:  fmt.Sprintf(f, a...),
+	}
+}
+
+// lintIcon checks that a charm has an icon.
+func lintIcon(s *Store, a *LintArchive) ([]mongodoc.LintProblem, error) {
+	if a.Charm == nil {
+		return nil, nil
+	}
+	for _, f := range a.Files {
+		if path.Clean(f.Name) == "icon.svg" {
+			return nil, nil
+		}
+	}
+	return []mongodoc.LintProblem{lintWarningf("icon.svg not found")}, nil
+}
+
+// lintReadme checks that the archive has a README file.
+func lintReadme(s *Store, a *LintArchive) ([]mongodoc.LintProblem, error) {
+	for _, f := range a.Files {
+		name := path.Clean(f.Name)
+		if !strings.Contains(name, "/") && strings.HasPrefix(strings.ToLower(name), "readme") {
+			return nil, nil
+		}
+	}
+	return []mongodoc.LintProblem{lintWarningf("README not found")}, nil
+}
+
+// lintHooks checks that all the hooks of a charm are executable.
+// Hidden files in the hooks directory are ignored.
+func lintHooks(s *Store, a *LintArchive) ([]mongodoc.LintProblem, error) {
+	if a.Charm == nil {
+		return nil, nil
+	}
+	var problems []mongodoc.LintProblem
+	for _, f := range a.Files {
+		name, ok := hookName(f)
+		if !ok {
+			continue
+		}
+		if f.Mode()&0111 == 0 {
+			problems = append(problems, lintWarningf("hook %q is not executable", name))
+		}
+	}
+	return problems, nil
+}
+
+// lintConfig checks that each charm configuration option is
+// described. Invalid default values need not be checked because
+// charms with such values are rejected when they are read.
+func lintConfig(s *Store, a *LintArchive) ([]mongodoc.LintProblem, error) {
+	if a.Charm == nil || a.Charm.Config() == nil {
+		return nil, nil
+	}
+	config := a.Charm.Config()
+	names := make([]string, 0, len(config.Options))
+	for name := range config.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var problems []mongodoc.LintProblem
+	for _, name := range names {
+		opt := config.Options[name]
+		if opt.Description == "" {
+			problems = append(problems, lintWarningf("config option %q has no description", name))
+		}
+	}
+	return problems, nil
+}
+
+// lintInterfaces checks that the interfaces provided and required
+// by a charm are used by at least one other charm in the store.
+func lintInterfaces(s *Store, a *LintArchive) ([]mongodoc.LintProblem, error) {
+	if a.Charm == nil {
+		return nil, nil
+	}
+	meta := a.Charm.Meta()
+	interfaces := make(map[string]bool)
+	for _, rels := range []map[string]charm.Relation{meta.Provides, meta.Requires} {
+		for _, rel := range rels {
+			if rel.Interface != "juju-info" {
+				interfaces[rel.Interface] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(interfaces))
+	for name := range interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var problems []mongodoc.LintProblem
+	for _, name := range names {
+		n, err := s.DB.Entities().Find(bson.D{
+			{"baseurl", bson.D{{"$ne", baseURL(&a.URL.URL)}}},
+			{"$or", []bson.D{
+				{{"charmprovidedinterfaces", name}},
+				{{"charmrequiredinterfaces", name}},
+			}},
+		}).Count()
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot count charms using interface %q", name)
+		}
+		if n == 0 {
+			problems = append(problems, lintWarningf("interface %q is not used by any other charm", name))
+		}
+	}
+	return problems, nil
+}
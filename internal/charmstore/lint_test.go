@@ -0,0 +1,91 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"archive/zip"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+func (s *StoreSuite) TestLint(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	// The mysql charm provides the mysql interface.
+	err := store.AddCharmWithArchive(MustParseResolvedURL("~charmers/precise/mysql-0"), storetesting.Charms.CharmDir("mysql"))
+	c.Assert(err, gc.IsNil)
+
+	ch := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	zr, err := zip.OpenReader(ch.Path)
+	c.Assert(err, gc.IsNil)
+	defer zr.Close()
+	problems, err := store.Lint(&LintArchive{
+		URL:   MustParseResolvedURL("~charmers/precise/wordpress-0"),
+		Charm: ch,
+		Files: zr.File,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(problems, jc.DeepEquals, []mongodoc.LintProblem{{
+		Linter:   "icon",
+		Severity: LintWarning,
+		Message:  "icon.svg not found",
+	}, {
+		Linter:   "interfaces",
+		Severity: LintWarning,
+		Message:  `interface "http" is not used by any other charm`,
+	}, {
+		Linter:   "interfaces",
+		Severity: LintWarning,
+		Message:  `interface "logging" is not used by any other charm`,
+	}, {
+		Linter:   "interfaces",
+		Severity: LintWarning,
+		Message:  `interface "monitoring" is not used by any other charm`,
+	}, {
+		Linter:   "interfaces",
+		Severity: LintWarning,
+		Message:  `interface "varnish" is not used by any other charm`,
+	}, {
+		Linter:   "readme",
+		Severity: LintWarning,
+		Message:  "README not found",
+	}})
+}
+
+func (s *StoreSuite) TestRegisterLinter(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	s.PatchValue(&linters, map[string]Linter{})
+	RegisterLinter("always", func(s *Store, a *LintArchive) ([]mongodoc.LintProblem, error) {
+		return []mongodoc.LintProblem{lintErrorf("always fails for %s", &a.URL.URL)}, nil
+	})
+	c.Assert(func() {
+		RegisterLinter("always", nil)
+	}, gc.PanicMatches, `linter "always" already registered`)
+
+	problems, err := store.Lint(&LintArchive{
+		URL: MustParseResolvedURL("~charmers/precise/wordpress-0"),
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(problems, jc.DeepEquals, []mongodoc.LintProblem{{
+		Linter:   "always",
+		Severity: LintError,
+		Message:  "always fails for cs:~charmers/precise/wordpress-0",
+	}})
+
+	RegisterLinter("broken", func(s *Store, a *LintArchive) ([]mongodoc.LintProblem, error) {
+		return nil, errgo.New("oops")
+	})
+	_, err = store.Lint(&LintArchive{
+		URL: MustParseResolvedURL("~charmers/precise/wordpress-0"),
+	})
+	c.Assert(err, gc.ErrorMatches, "cannot run broken linter: oops")
+}
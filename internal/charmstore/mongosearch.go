@@ -0,0 +1,816 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"bytes"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+// SearchDocs returns the Mongo collection where the search
+// documents are stored when searching with MongoDB.
+func (s StoreDatabase) SearchDocs() *mgo.Collection {
+	return s.C("searchdocs")
+}
+
+// mongoSearchIndex is a search backend that stores the search
+// documents in MongoDB, for deployments without elasticsearch.
+// Filters and ACLs are applied by MongoDB, using the indexes
+// created by Store.ensureIndexes, but text matching, scoring,
+// sorting and pagination are done in process, so it is only
+// suitable for stores holding a modest number of entities.
+type mongoSearchIndex struct {
+	db StoreDatabase
+}
+
+var _ searchBackend = (*mongoSearchIndex)(nil)
+
+// mongoSearchDoc holds the fields of a SearchDoc
+// that are used when searching with MongoDB.
+type mongoSearchDoc struct {
+	// Id holds the entity URL without a revision.
+	Id                 string `bson:"_id"`
+	URL                *charm.Reference
+	PromulgatedURL     *charm.Reference `bson:",omitempty"`
+	Revision           int
+	User               string
+	Name               string
+	Series             string
+	SupportedSeries    []string
+	Summary            string
+	Description        string
+	ReadMe             string
+	Tags               []string
+	ProvidedInterfaces []string
+	RequiredInterfaces []string
+	ConfigOptions      []string
+	ActionNames        []string
+	Hooks              []string
+	ReadACLs           []string
+	Channels           []string
+	TotalDownloads     int64
+	RecentDownloads    int64
+	UploadTime         time.Time
+	UnitCount          *int
+	MachineCount       *int
+}
+
+// newMongoSearchDoc returns the mongoSearchDoc for the given SearchDoc.
+func newMongoSearchDoc(doc *SearchDoc) *mongoSearchDoc {
+	mdoc := &mongoSearchDoc{
+		Id:                 mongoSearchDocId(doc.URL),
+		URL:                doc.URL,
+		PromulgatedURL:     doc.PromulgatedURL,
+		Revision:           doc.URL.Revision,
+		User:               doc.User,
+		Name:               doc.Name,
+		Series:             doc.Series,
+		SupportedSeries:    doc.SupportedSeries,
+		ReadMe:             doc.BundleReadMe,
+		Tags:               doc.Tags,
+		ProvidedInterfaces: doc.CharmProvidedInterfaces,
+		RequiredInterfaces: doc.CharmRequiredInterfaces,
+		ConfigOptions:      doc.ConfigOptions,
+		ActionNames:        doc.ActionNames,
+		Hooks:              doc.CharmHooks,
+		ReadACLs:           doc.ReadACLs,
+		Channels:           doc.Channels,
+		TotalDownloads:     doc.TotalDownloads,
+		RecentDownloads:    doc.RecentDownloads,
+		UploadTime:         doc.UploadTime,
+		UnitCount:          doc.BundleUnitCount,
+		MachineCount:       doc.BundleMachineCount,
+	}
+	if doc.CharmMeta != nil {
+		mdoc.Summary = doc.CharmMeta.Summary
+		mdoc.Description = doc.CharmMeta.Description
+	}
+	return mdoc
+}
+
+// mongoSearchDocId returns the id of the search
+// document for the entity with the given URL.
+func mongoSearchDocId(url *charm.Reference) string {
+	ref := *url
+	ref.Revision = -1
+	return ref.String()
+}
+
+// ensureMongoSearch populates the MongoDB search documents if MongoDB
+// is used for search and there are none, which is the case when
+// MongoDB search is first enabled.
+func (s *Store) ensureMongoSearch() error {
+	if _, ok := s.searchBackend().(*mongoSearchIndex); !ok {
+		return nil
+	}
+	n, err := s.DB.SearchDocs().Count()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if n > 0 {
+		return nil
+	}
+	if err := s.syncSearch(); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// update implements searchBackend.update.
+func (si *mongoSearchIndex) update(doc *SearchDoc) error {
+	mdoc := newMongoSearchDoc(doc)
+	_, err := si.db.SearchDocs().Upsert(bson.D{
+		{"_id", mdoc.Id},
+		{"revision", bson.D{{"$lte", mdoc.Revision}}},
+	}, mdoc)
+	if mgo.IsDup(err) {
+		// The index already holds a later revision.
+		return nil
+	}
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// put implements searchBackend.put.
+func (si *mongoSearchIndex) put(doc *SearchDoc) error {
+	mdoc := newMongoSearchDoc(doc)
+	if _, err := si.db.SearchDocs().UpsertId(mdoc.Id, mdoc); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// remove implements searchBackend.remove.
+func (si *mongoSearchIndex) remove(id *charm.Reference) error {
+	err := si.db.SearchDocs().RemoveId(mongoSearchDocId(id))
+	if err != nil && err != mgo.ErrNotFound {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// recentDownloads implements searchBackend.recentDownloads.
+func (si *mongoSearchIndex) recentDownloads(id *charm.Reference) (int64, error) {
+	var mdoc mongoSearchDoc
+	err := si.db.SearchDocs().FindId(mongoSearchDocId(id)).Select(bson.D{{"recentdownloads", 1}}).One(&mdoc)
+	if err == mgo.ErrNotFound {
+		return 0, errgo.WithCausef(nil, params.ErrNotFound, "no search document for %s", id)
+	}
+	if err != nil {
+		return 0, errgo.Mask(err)
+	}
+	return mdoc.RecentDownloads, nil
+}
+
+// ensureIndexes implements searchBackend.ensureIndexes.
+// The search documents are removed if force is true,
+// so that they can be populated from scratch.
+func (si *mongoSearchIndex) ensureIndexes(force bool) error {
+	if !force {
+		return nil
+	}
+	if _, err := si.db.SearchDocs().RemoveAll(nil); err != nil {
+		return errgo.Notef(err, "cannot remove search documents")
+	}
+	return nil
+}
+
+// search implements searchBackend.search.
+func (si *mongoSearchIndex) search(sp SearchParams) (SearchResult, error) {
+	start := time.Now()
+	terms := strings.Fields(strings.ToLower(sp.Text))
+	// All the matching documents are scored, so avoid loading
+	// the text fields that are not needed for scoring. The
+	// summaries are only loaded for the returned page when
+	// highlighting.
+	omit := bson.D{{"summary", 0}}
+	if len(terms) == 0 {
+		omit = append(omit, bson.DocElem{"description", 0}, bson.DocElem{"readme", 0})
+	}
+	var docs []*mongoSearchDoc
+	if err := si.db.SearchDocs().Find(mongoSearchQuery(sp)).Select(omit).All(&docs); err != nil {
+		return SearchResult{}, errgo.Notef(err, "cannot search")
+	}
+	scores := make(map[*mongoSearchDoc]float64)
+	matches := docs[:0]
+	for _, doc := range docs {
+		score := 1.0
+		if len(terms) > 0 {
+			score = doc.textScore(terms, sp.AutoComplete)
+			if score == 0 {
+				continue
+			}
+		}
+		scores[doc] = score * doc.boost()
+		matches = append(matches, doc)
+	}
+	sort.Sort(mongoSearchDocsByOrder{
+		docs:   matches,
+		scores: scores,
+		sort:   sp.sort,
+	})
+	r := SearchResult{
+		Total: len(matches),
+	}
+	for _, name := range sp.Facets {
+		if r.Facets == nil {
+			r.Facets = make(map[string][]FacetCount)
+		}
+		r.Facets[name] = mongoFacetCounts(name, matches)
+	}
+	if len(terms) > 0 && r.Total < fewResults {
+		var err error
+		r.Suggestions, err = si.suggestions(sp)
+		if err != nil {
+			return SearchResult{}, errgo.Mask(err)
+		}
+	}
+	limit := sp.Limit
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+	if sp.Skip < len(matches) {
+		matches = matches[sp.Skip:]
+	} else {
+		matches = nil
+	}
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	r.Results = make([]*router.ResolvedURL, len(matches))
+	for i, doc := range matches {
+		id := &router.ResolvedURL{
+			URL:                 *doc.URL,
+			PromulgatedRevision: -1,
+		}
+		if doc.PromulgatedURL != nil {
+			id.PromulgatedRevision = doc.PromulgatedURL.Revision
+		}
+		r.Results[i] = id
+	}
+	if sp.Highlight && len(terms) > 0 {
+		if err := si.loadSummaries(matches); err != nil {
+			return SearchResult{}, errgo.Mask(err)
+		}
+		r.Highlights = make([]map[string][]string, len(matches))
+		for i, doc := range matches {
+			r.Highlights[i] = doc.highlights(terms)
+		}
+	}
+	r.SearchTime = time.Since(start)
+	return r, nil
+}
+
+// loadSummaries sets the summaries of the given
+// documents, which are not loaded when searching.
+func (si *mongoSearchIndex) loadSummaries(docs []*mongoSearchDoc) error {
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.Id
+	}
+	var summaries []*mongoSearchDoc
+	err := si.db.SearchDocs().
+		Find(bson.D{{"_id", bson.D{{"$in", ids}}}}).
+		Select(bson.D{{"summary", 1}}).
+		All(&summaries)
+	if err != nil {
+		return errgo.Notef(err, "cannot get summaries")
+	}
+	summaryById := make(map[string]string)
+	for _, doc := range summaries {
+		summaryById[doc.Id] = doc.Summary
+	}
+	for _, doc := range docs {
+		doc.Summary = summaryById[doc.Id]
+	}
+	return nil
+}
+
+// Suggestions are made for words of at least suggestMinWordLength
+// characters that are within suggestMaxEdits edits of a name or tag,
+// which are the defaults of the elasticsearch term suggester.
+const (
+	suggestMinWordLength = 4
+	suggestMaxEdits      = 2
+)
+
+// suggestions returns the suggested search text for a search with the
+// given parameters. In the same way as the elasticsearch term
+// suggester, each word of the text that is not a name or tag of an
+// entity the user is allowed to read may be replaced by the names and
+// tags that start with the same letter and are a few edits away.
+func (si *mongoSearchIndex) suggestions(sp SearchParams) ([]string, error) {
+	var docs []*mongoSearchDoc
+	q := mongoSearchQuery(SearchParams{
+		Admin:   sp.Admin,
+		Groups:  sp.Groups,
+		Channel: sp.Channel,
+	})
+	if err := si.db.SearchDocs().Find(q).Select(bson.D{{"name", 1}, {"tags", 1}}).All(&docs); err != nil {
+		return nil, errgo.Notef(err, "cannot get suggestion terms")
+	}
+	terms := make(map[string]bool)
+	for _, doc := range docs {
+		terms[doc.Name] = true
+		for _, t := range doc.Tags {
+			terms[t] = true
+		}
+	}
+	words := strings.Fields(sp.Text)
+	var candidates []suggestion
+	for i, w := range words {
+		w = strings.ToLower(w)
+		if terms[w] || len(w) < suggestMinWordLength {
+			continue
+		}
+		for t := range terms {
+			if t == "" || strings.ToLower(t[:1]) != w[:1] {
+				continue
+			}
+			d := editDistance(w, strings.ToLower(t))
+			if d == 0 || d > suggestMaxEdits {
+				continue
+			}
+			text := make([]string, len(words))
+			copy(text, words)
+			text[i] = t
+			candidates = append(candidates, suggestion{
+				text:  strings.Join(text, " "),
+				term:  t,
+				score: 1 - float64(d)/float64(len(w)),
+			})
+		}
+	}
+	return sortedSuggestions(candidates), nil
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	r0, r1 := []rune(a), []rune(b)
+	prev := make([]int, len(r1)+1)
+	cur := make([]int, len(r1)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := range r0 {
+		cur[0] = i + 1
+		for j := range r1 {
+			cost := 1
+			if r0[i] == r1[j] {
+				cost = 0
+			}
+			cur[j+1] = minInt(minInt(prev[j+1]+1, cur[j]+1), prev[j]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(r1)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// mongoSearchQuery returns the MongoDB query that selects the
+// search documents matching the filters, ACLs and channel in sp.
+// See createFilters for the semantics of the filters.
+func mongoSearchQuery(sp SearchParams) bson.D {
+	var and []bson.D
+	for k, vals := range sp.Filters {
+		name := strings.TrimPrefix(k, "-")
+		filter, ok := mongoFilters[name]
+		if !ok {
+			continue
+		}
+		or := make([]bson.D, len(vals))
+		for i, v := range vals {
+			or[i] = filter(v)
+		}
+		if name != k {
+			and = append(and, bson.D{{"$nor", or}})
+			continue
+		}
+		and = append(and, bson.D{{"$or", or}})
+	}
+	if !sp.Admin {
+		and = append(and, bson.D{{
+			"readacls", bson.D{{"$in", append([]string{params.Everyone}, sp.Groups...)}},
+		}})
+	}
+	if sp.Channel != "" {
+		and = append(and, bson.D{{"channels", sp.Channel}})
+	}
+	if len(and) == 0 {
+		return nil
+	}
+	return bson.D{{"$and", and}}
+}
+
+// mongoFilters contains a mapping from a filter parameter in the API
+// to a function that will generate a MongoDB query for the given
+// value. It holds the same filters as filters.
+var mongoFilters = map[string]func(string) bson.D{
+	"action":         termsMongoFilter("actionnames"),
+	"config-option":  termsMongoFilter("configoptions"),
+	"description":    phraseMongoFilter("description"),
+	"downloads-min":  rangeMongoFilter("downloads-min", "totaldownloads", "$gte"),
+	"hook":           termsMongoFilter("hooks"),
+	"machines-max":   rangeMongoFilter("machines-max", "machinecount", "$lte"),
+	"name":           fieldMongoFilter("name"),
+	"owner":          ownerMongoFilter,
+	"promulgated":    promulgatedMongoFilter,
+	"provides":       termsMongoFilter("providedinterfaces"),
+	"requires":       termsMongoFilter("requiredinterfaces"),
+	"series":         seriesMongoFilter,
+	"summary":        phraseMongoFilter("summary"),
+	"tags":           termsMongoFilter("tags"),
+	"type":           typeMongoFilter,
+	"units-max":      rangeMongoFilter("units-max", "unitcount", "$lte"),
+	"uploaded-since": rangeMongoFilter("uploaded-since", "uploadtime", "$gte"),
+}
+
+// fieldMongoFilter returns a function that generates a
+// query matching the given value of the given field.
+func fieldMongoFilter(field string) func(string) bson.D {
+	return func(value string) bson.D {
+		return bson.D{{field, value}}
+	}
+}
+
+// rangeMongoFilter returns a function that generates a query
+// comparing the given field with the value of the named range
+// filter using the given operator, such as "$gte".
+func rangeMongoFilter(name, field, op string) func(string) bson.D {
+	return func(value string) bson.D {
+		return bson.D{{field, bson.D{{op, rangeFilterValue(name, value)}}}}
+	}
+}
+
+// phraseMongoFilter returns a function that generates a query
+// matching the given phrase anywhere in the given field,
+// regardless of case.
+func phraseMongoFilter(field string) func(string) bson.D {
+	return func(value string) bson.D {
+		return bson.D{{field, bson.RegEx{
+			Pattern: regexp.QuoteMeta(value),
+			Options: "i",
+		}}}
+	}
+}
+
+// termsMongoFilter returns a function that generates a query
+// matching documents holding all of the space separated terms
+// of the given value in the given field.
+func termsMongoFilter(field string) func(string) bson.D {
+	return func(value string) bson.D {
+		terms := strings.Fields(value)
+		if len(terms) == 0 {
+			return bson.D{}
+		}
+		return bson.D{{field, bson.D{{"$all", terms}}}}
+	}
+}
+
+// ownerMongoFilter generates a query matching the owner of the
+// entity. An empty owner matches promulgated entities.
+func ownerMongoFilter(value string) bson.D {
+	if value == "" {
+		return promulgatedMongoFilter("1")
+	}
+	return bson.D{{"user", value}}
+}
+
+// promulgatedMongoFilter generates a query matching promulgated
+// entities if value is "1", or non-promulgated entities otherwise.
+func promulgatedMongoFilter(value string) bson.D {
+	return bson.D{{"promulgatedurl", bson.D{{"$exists", value == "1"}}}}
+}
+
+// seriesMongoFilter generates a query matching the series of the
+// entity, or any of the supported series of a multi-series charm.
+func seriesMongoFilter(value string) bson.D {
+	return bson.D{{"$or", []bson.D{
+		{{"series", value}},
+		{{"supportedseries", value}},
+	}}}
+}
+
+// typeMongoFilter generates a query matching either
+// only bundles or only charms.
+func typeMongoFilter(value string) bson.D {
+	if value == "bundle" {
+		return bson.D{{"series", "bundle"}}
+	}
+	return bson.D{{"series", bson.D{{"$ne", "bundle"}}}}
+}
+
+// textScore returns the score of the document for a text search
+// of the given lower case terms, or zero if no term matches. The
+// fields and weights are the same as those used by queryFields.
+func (doc *mongoSearchDoc) textScore(terms []string, autoComplete bool) float64 {
+	url := strings.ToLower(doc.URL.String())
+	description := textWords(doc.Description)
+	readMe := textWords(doc.ReadMe)
+	var score float64
+	for _, t := range terms {
+		if autoComplete && strings.HasPrefix(doc.Name, t) || !autoComplete && doc.Name == t {
+			score += 10
+		}
+		if ngramMatch(url, t) {
+			score += 8
+		}
+		if containsFold(doc.Tags, t) {
+			score += 5
+		}
+		if ngramMatch(doc.Series, t) {
+			score += 5
+		}
+		if containsFold(doc.ProvidedInterfaces, t) {
+			score += 3
+		}
+		if containsFold(doc.RequiredInterfaces, t) {
+			score += 3
+		}
+		if containsFold(doc.ConfigOptions, t) {
+			score += 2
+		}
+		if containsFold(doc.ActionNames, t) {
+			score += 2
+		}
+		if containsFold(doc.Hooks, t) {
+			score += 1
+		}
+		if description[t] {
+			score += 1
+		}
+		if readMe[t] {
+			score += 1
+		}
+	}
+	return score
+}
+
+// boost returns the factor by which the score of the document is
+// multiplied, which is computed in the same way as the boosting
+// functions in createSearchDSL.
+func (doc *mongoSearchDoc) boost() float64 {
+	boost := math.Log(2 + 0.000001*float64(doc.TotalDownloads))
+	boost *= math.Log(2 + 0.0001*float64(doc.RecentDownloads))
+	if doc.PromulgatedURL != nil {
+		boost *= 1.25
+	}
+	for series, b := range seriesBoost {
+		if doc.Series == series || containsFold(doc.SupportedSeries, series) {
+			boost *= b
+		}
+	}
+	return boost
+}
+
+// highlights returns the highlighted snippets of the document for a
+// text search of the given lower case terms, keyed by the names in
+// highlightFields. Unlike elasticsearch, at most one snippet is
+// returned for the summary and the description.
+func (doc *mongoSearchDoc) highlights(terms []string) map[string][]string {
+	var highlights map[string][]string
+	add := func(name, snippet string) {
+		if highlights == nil {
+			highlights = make(map[string][]string)
+		}
+		highlights[name] = append(highlights[name], snippet)
+	}
+	if snippet := highlightText(doc.Summary, terms); snippet != "" {
+		add("summary", snippet)
+	}
+	if snippet := highlightText(doc.Description, terms); snippet != "" {
+		add("description", snippet)
+	}
+	for _, tag := range doc.Tags {
+		if containsFold(terms, tag) {
+			add("tags", "<em>"+tag+"</em>")
+		}
+	}
+	return highlights
+}
+
+// highlightText returns a snippet of s of about highlightFragmentSize
+// characters in which the words matching any of the given lower case
+// terms are enclosed in <em> tags, in the same way as the default
+// elasticsearch highlighter. The snippet starts shortly before the
+// first matching word. If no word matches, highlightText returns
+// the empty string.
+func highlightText(s string, terms []string) string {
+	type word struct {
+		start, end int
+		match      bool
+	}
+	var words []word
+	start := -1
+	for i, r := range s + " " {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case isWord && start == -1:
+			start = i
+		case !isWord && start != -1:
+			words = append(words, word{
+				start: start,
+				end:   i,
+				match: containsFold(terms, s[start:i]),
+			})
+			start = -1
+		}
+	}
+	first := -1
+	for i, w := range words {
+		if w.match {
+			first = i
+			break
+		}
+	}
+	if first == -1 {
+		return ""
+	}
+	// Include some context before the first match.
+	from := first
+	for from > 0 && words[first].end-words[from-1].start <= highlightFragmentSize/4 {
+		from--
+	}
+	to := first + 1
+	for to < len(words) && words[to].end-words[from].start <= highlightFragmentSize {
+		to++
+	}
+	if from == 0 {
+		words[from].start = 0
+	}
+	end := words[to-1].end
+	if to == len(words) {
+		end = len(s)
+	}
+	var buf bytes.Buffer
+	pos := words[from].start
+	for _, w := range words[from:to] {
+		if !w.match {
+			continue
+		}
+		buf.WriteString(s[pos:w.start])
+		buf.WriteString("<em>")
+		buf.WriteString(s[w.start:w.end])
+		buf.WriteString("</em>")
+		pos = w.end
+	}
+	buf.WriteString(s[pos:end])
+	return strings.TrimSpace(buf.String())
+}
+
+// ngramMatch reports whether term matches s in the same way as
+// a field analyzed with the n3_20grams analyzer would.
+func ngramMatch(s, term string) bool {
+	return len(term) >= 3 && strings.Contains(s, term)
+}
+
+// containsFold reports whether ss contains s, regardless of case.
+func containsFold(ss []string, s string) bool {
+	for _, v := range ss {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// textWords returns the set of lower case words in s.
+func textWords(s string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words[strings.ToLower(w)] = true
+	}
+	return words
+}
+
+// mongoFacetCounts returns the counts for the facet
+// with the given name over the given documents.
+func mongoFacetCounts(name string, docs []*mongoSearchDoc) []FacetCount {
+	counts := make(map[string]int)
+	for _, doc := range docs {
+		var values []string
+		switch name {
+		case "owner":
+			values = []string{doc.User}
+		case "series":
+			values = doc.SupportedSeries
+			if doc.Series != "" {
+				values = []string{doc.Series}
+			}
+		case "tags":
+			values = doc.Tags
+		case "type":
+			values = []string{"charm"}
+			if doc.Series == "bundle" {
+				values = []string{"bundle"}
+			}
+		}
+		seen := make(map[string]bool)
+		for _, v := range values {
+			if !seen[v] {
+				counts[v]++
+				seen[v] = true
+			}
+		}
+	}
+	return sortedFacetCounts(counts)
+}
+
+// mongoSearchDocsByOrder sorts search documents by the given sort
+// fields, or by descending score if there are none. Documents that
+// compare equal are ordered by URL so that the order is stable.
+type mongoSearchDocsByOrder struct {
+	docs   []*mongoSearchDoc
+	scores map[*mongoSearchDoc]float64
+	sort   []sortParam
+}
+
+func (s mongoSearchDocsByOrder) Len() int {
+	return len(s.docs)
+}
+
+func (s mongoSearchDocsByOrder) Swap(i, j int) {
+	s.docs[i], s.docs[j] = s.docs[j], s.docs[i]
+}
+
+func (s mongoSearchDocsByOrder) Less(i, j int) bool {
+	d0, d1 := s.docs[i], s.docs[j]
+	for _, sp := range s.sort {
+		c := compareSearchDocField(d0, d1, sp.Field)
+		if c == 0 {
+			continue
+		}
+		if sp.Order == sortDescending {
+			return c > 0
+		}
+		return c < 0
+	}
+	if len(s.sort) == 0 && s.scores[d0] != s.scores[d1] {
+		return s.scores[d0] > s.scores[d1]
+	}
+	return d0.URL.String() < d1.URL.String()
+}
+
+// compareSearchDocField compares the given sort field of the two
+// documents, returning -1, 0 or 1 if the value for d0 is less than,
+// equal to or greater than the value for d1. The field is one of the
+// values in sortFields.
+func compareSearchDocField(d0, d1 *mongoSearchDoc, field string) int {
+	switch field {
+	case "TotalDownloads":
+		return compareInt64(d0.TotalDownloads, d1.TotalDownloads)
+	case "RecentDownloads":
+		return compareInt64(d0.RecentDownloads, d1.RecentDownloads)
+	case "Name":
+		return compareString(d0.Name, d1.Name)
+	case "User":
+		return compareString(d0.User, d1.User)
+	case "Series":
+		return compareString(d0.Series, d1.Series)
+	}
+	return 0
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareString(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,434 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+type MongoSearchSuite struct {
+	storetesting.IsolatedMgoSuite
+	pool  *Pool
+	store *Store
+}
+
+var _ = gc.Suite(&MongoSearchSuite{})
+
+func (s *MongoSearchSuite) SetUpTest(c *gc.C) {
+	s.IsolatedMgoSuite.SetUpTest(c)
+
+	// See StoreSearchSuite.SetUpTest.
+	original := LegacyDownloadCountsEnabled
+	LegacyDownloadCountsEnabled = false
+	s.AddCleanup(func(*gc.C) {
+		LegacyDownloadCountsEnabled = original
+	})
+
+	pool, err := NewPool(s.Session.DB("foo"), nil, nil, ServerParams{
+		MongoSearch: true,
+	})
+	c.Assert(err, gc.IsNil)
+	s.pool = pool
+	s.store = pool.Store()
+	addSearchTestEntities(c, s.store)
+}
+
+func (s *MongoSearchSuite) TearDownTest(c *gc.C) {
+	s.store.Close()
+	s.pool.Close()
+	s.IsolatedMgoSuite.TearDownTest(c)
+}
+
+func (s *MongoSearchSuite) TestSearches(c *gc.C) {
+	for i, test := range searchTests {
+		c.Logf("test %d: %s", i, test.about)
+		res, err := s.store.Search(test.sp)
+		c.Assert(err, gc.IsNil)
+		sort.Sort(resolvedURLsByString(res.Results))
+		sort.Sort(resolvedURLsByString(test.results))
+		c.Assert(res.Results, jc.DeepEquals, test.results)
+		c.Assert(res.Total, gc.Equals, len(test.results)+test.totalDiff)
+	}
+}
+
+func (s *MongoSearchSuite) TestSearchChannelOmitsStaleResults(c *gc.C) {
+	// Make the search index claim that every entity is
+	// published to the development channel, and then
+	// publish only mysql and varnish.
+	_, err := s.store.DB.SearchDocs().UpdateAll(nil, bson.D{{"$set", bson.D{{"channels", []string{"development"}}}}})
+	c.Assert(err, gc.IsNil)
+	err = s.store.Publish(exportTestCharms["mysql"], "development")
+	c.Assert(err, gc.IsNil)
+	err = s.store.Publish(exportTestCharms["varnish"], "development")
+	c.Assert(err, gc.IsNil)
+
+	sp := SearchParams{
+		Admin:   true,
+		Channel: "development",
+		Limit:   2,
+	}
+	err = sp.ParseSortFields("name")
+	c.Assert(err, gc.IsNil)
+	res, err := s.store.Search(sp)
+	c.Assert(err, gc.IsNil)
+	// The riak result is omitted, leaving a short page.
+	c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{
+		exportTestCharms["mysql"],
+	})
+	c.Assert(res.Total, gc.Equals, 4)
+
+	// The next page starts after the last result of the
+	// previous one, so no result is repeated or skipped.
+	sp.Skip = 2
+	res, err = s.store.Search(sp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{
+		exportTestCharms["varnish"],
+	})
+}
+
+func (s *MongoSearchSuite) TestSearchChannelHighlights(c *gc.C) {
+	// Make the search index claim that every entity is
+	// published to the development channel, and then
+	// publish only the wordpress-simple bundle.
+	_, err := s.store.DB.SearchDocs().UpdateAll(nil, bson.D{{"$set", bson.D{{"channels", []string{"development"}}}}})
+	c.Assert(err, gc.IsNil)
+	err = s.store.Publish(exportTestBundles["wordpress-simple"], "development")
+	c.Assert(err, gc.IsNil)
+
+	res, err := s.store.Search(SearchParams{
+		Text:      "wordpress",
+		Admin:     true,
+		Channel:   "development",
+		Highlight: true,
+	})
+	c.Assert(err, gc.IsNil)
+	// The highlights of the omitted wordpress
+	// charm are omitted too.
+	c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{
+		exportTestBundles["wordpress-simple"],
+	})
+	c.Assert(resultHighlights(c, res), jc.DeepEquals, map[string]map[string][]string{
+		"cs:~charmers/bundle/wordpress-simple-4": {
+			"tags": {"<em>wordpress</em>"},
+		},
+	})
+}
+
+func (s *MongoSearchSuite) TestSearchFacets(c *gc.C) {
+	for i, test := range searchFacetsTests {
+		c.Logf("test %d: %s", i, test.about)
+		res, err := s.store.Search(test.sp)
+		c.Assert(err, gc.IsNil)
+		c.Assert(res.Facets, jc.DeepEquals, test.expectFacets)
+	}
+}
+
+func (s *MongoSearchSuite) TestSearchSuggestions(c *gc.C) {
+	for i, test := range searchSuggestionsTests {
+		c.Logf("test %d: %s", i, test.about)
+		res, err := s.store.Search(test.sp)
+		c.Assert(err, gc.IsNil)
+		c.Assert(res.Suggestions, jc.DeepEquals, test.expectSuggestions)
+	}
+}
+
+func (s *MongoSearchSuite) TestSearchConfigAndActions(c *gc.C) {
+	url := addCharmWithConfigAndActions(c, s.store)
+	for i, test := range searchConfigAndActionsTests {
+		c.Logf("test %d: %s", i, test.about)
+		res, err := s.store.Search(test.sp)
+		c.Assert(err, gc.IsNil)
+		if test.expectResults {
+			c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{url})
+		} else {
+			c.Assert(res.Results, gc.HasLen, 0)
+		}
+	}
+}
+
+func (s *MongoSearchSuite) TestSearchHighlights(c *gc.C) {
+	for i, test := range searchHighlightTests {
+		c.Logf("test %d: %s", i, test.about)
+		res, err := s.store.Search(test.sp)
+		c.Assert(err, gc.IsNil)
+		c.Assert(resultHighlights(c, res), jc.DeepEquals, test.expectHighlights)
+	}
+}
+
+func (s *MongoSearchSuite) TestHighlightText(c *gc.C) {
+	long := strings.Repeat("lorem ipsum ", 20)
+	for _, test := range []struct {
+		text   string
+		terms  []string
+		expect string
+	}{
+		{"Blog engine", []string{"blog"}, "<em>Blog</em> engine"},
+		{"A pretty popular blog engine.", []string{"blog", "engine"}, "A pretty popular <em>blog</em> <em>engine</em>."},
+		{"A blogging engine", []string{"blog"}, ""},
+		{"", []string{"blog"}, ""},
+		{long + "a blog engine " + long, []string{"blog"}, "ipsum lorem ipsum a <em>blog</em> engine lorem ipsum lorem ipsum lorem ipsum lorem ipsum lorem ipsum lorem"},
+	} {
+		c.Assert(highlightText(test.text, test.terms), gc.Equals, test.expect, gc.Commentf("%q %q", test.text, test.terms))
+	}
+}
+
+func (s *MongoSearchSuite) TestEditDistance(c *gc.C) {
+	for _, test := range []struct {
+		a, b   string
+		expect int
+	}{
+		{"", "", 0},
+		{"mysql", "mysql", 0},
+		{"mysq", "mysql", 1},
+		{"wordpres", "wordpress", 1},
+		{"riek", "riak", 1},
+		{"kitten", "sitting", 3},
+		{"", "abc", 3},
+	} {
+		c.Assert(editDistance(test.a, test.b), gc.Equals, test.expect, gc.Commentf("%q %q", test.a, test.b))
+	}
+}
+
+func (s *MongoSearchSuite) TestSearchRanking(c *gc.C) {
+	// The charm named by the search text is ranked
+	// above the bundle that only contains it in its URL.
+	res, err := s.store.Search(SearchParams{
+		Text: "wordpress",
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{
+		exportTestCharms["wordpress"],
+		exportTestBundles["wordpress-simple"],
+	})
+}
+
+func (s *MongoSearchSuite) TestSorting(c *gc.C) {
+	res, err := s.store.Search(SearchParams{
+		sort: []sortParam{{Field: "TotalDownloads", Order: sortDescending}},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{
+		exportTestCharms["varnish"],
+		exportTestCharms["mysql"],
+		exportTestBundles["wordpress-simple"],
+		exportTestCharms["wordpress"],
+	})
+
+	res, err = s.store.Search(SearchParams{
+		sort: []sortParam{{Field: "Name", Order: sortAscending}},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{
+		exportTestCharms["mysql"],
+		exportTestCharms["varnish"],
+		exportTestCharms["wordpress"],
+		exportTestBundles["wordpress-simple"],
+	})
+}
+
+func (s *MongoSearchSuite) TestTrendingSort(c *gc.C) {
+	addOldDownloads(c, s.store, exportTestCharms["wordpress"], 10)
+	checkTrendingSort(c, s.store)
+}
+
+func (s *MongoSearchSuite) TestRefreshTrending(c *gc.C) {
+	// Record a download without updating the search
+	// document, and make the stored recent downloads of
+	// another entity stale.
+	varnish := exportTestCharms["varnish"]
+	err := s.store.IncCounter(EntityStatsKey(&varnish.URL, params.StatsArchiveDownload))
+	c.Assert(err, gc.IsNil)
+	err = s.store.DB.SearchDocs().UpdateId("cs:~openstack-charmers/trusty/mysql", bson.D{{
+		"$set", bson.D{{"recentdownloads", 100}},
+	}})
+	c.Assert(err, gc.IsNil)
+
+	n, err := s.store.RefreshTrending(time.Now().Add(-time.Hour))
+	c.Assert(err, gc.IsNil)
+	// The wordpress charm has never been downloaded and the
+	// search document of the wordpress-simple bundle is already
+	// up to date.
+	c.Assert(n, gc.Equals, 2)
+	for id, expect := range map[string]int64{
+		"cs:~foo/trusty/varnish":              6,
+		"cs:~openstack-charmers/trusty/mysql": 3,
+		"cs:~charmers/precise/wordpress":      0,
+	} {
+		var doc mongoSearchDoc
+		err := s.store.DB.SearchDocs().FindId(id).One(&doc)
+		c.Assert(err, gc.IsNil)
+		c.Assert(doc.RecentDownloads, gc.Equals, expect, gc.Commentf("%s", id))
+	}
+}
+
+func (s *MongoSearchSuite) TestRefreshTrendingNoDownloads(c *gc.C) {
+	pool, err := NewPool(s.Session.DB("bar"), nil, nil, ServerParams{
+		MongoSearch: true,
+	})
+	c.Assert(err, gc.IsNil)
+	defer pool.Close()
+	store := pool.Store()
+	defer store.Close()
+	n, err := store.RefreshTrending(time.Now().Add(-time.Hour))
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
+
+func (s *MongoSearchSuite) TestPaginatedSearch(c *gc.C) {
+	sp := SearchParams{
+		sort: []sortParam{{Field: "Name", Order: sortAscending}},
+		Skip: 1,
+	}
+	res, err := s.store.Search(sp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{
+		exportTestCharms["varnish"],
+		exportTestCharms["wordpress"],
+		exportTestBundles["wordpress-simple"],
+	})
+	c.Assert(res.Total, gc.Equals, 4)
+
+	sp.Limit = 1
+	res, err = s.store.Search(sp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{
+		exportTestCharms["varnish"],
+	})
+	c.Assert(res.Total, gc.Equals, 4)
+
+	sp.Skip = 10
+	res, err = s.store.Search(sp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, gc.HasLen, 0)
+	c.Assert(res.Total, gc.Equals, 4)
+}
+
+func (s *MongoSearchSuite) TestOnlyLatestRevision(c *gc.C) {
+	url := newResolvedURL("cs:~charmers/precise/wordpress-24", -1)
+	err := s.store.AddCharmWithArchive(url, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	err = s.store.SetPerms(&url.URL, "read", params.Everyone, url.URL.User)
+	c.Assert(err, gc.IsNil)
+
+	// Updating the search document for an earlier
+	// revision does not replace the later one.
+	err = s.store.UpdateSearch(exportTestCharms["wordpress"])
+	c.Assert(err, gc.IsNil)
+	res, err := s.store.Search(SearchParams{
+		Filters: map[string][]string{
+			"name": {"wordpress"},
+		},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{url})
+}
+
+func (s *MongoSearchSuite) TestSearchAfterTrash(c *gc.C) {
+	url := newResolvedURL("cs:~charmers/precise/wordpress-24", -1)
+	err := s.store.AddCharmWithArchive(url, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	nameFilter := SearchParams{
+		Filters: map[string][]string{
+			"name": {"wordpress"},
+		},
+	}
+
+	// Trashing the latest revision makes the previous one searchable.
+	err = s.store.TrashEntity(url)
+	c.Assert(err, gc.IsNil)
+	res, err := s.store.Search(nameFilter)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{
+		exportTestCharms["wordpress"],
+	})
+
+	// Trashing all revisions removes the document.
+	err = s.store.TrashEntity(exportTestCharms["wordpress"])
+	c.Assert(err, gc.IsNil)
+	res, err = s.store.Search(nameFilter)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, gc.HasLen, 0)
+	n, err := s.store.DB.SearchDocs().FindId("cs:~charmers/precise/wordpress").Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
+
+func (s *MongoSearchSuite) TestSearchMultiSeries(c *gc.C) {
+	url := newResolvedURL("cs:~charmers/multi-series-0", -1)
+	err := s.store.AddCharmWithArchive(url, storetesting.Charms.CharmDir("multi-series"))
+	c.Assert(err, gc.IsNil)
+	err = s.store.SetPerms(&url.URL, "read", params.Everyone, url.URL.User)
+	c.Assert(err, gc.IsNil)
+	err = s.store.UpdateSearch(url)
+	c.Assert(err, gc.IsNil)
+
+	for _, series := range []string{"precise", "trusty", "utopic"} {
+		c.Logf("series %s", series)
+		res, err := s.store.Search(SearchParams{
+			Filters: map[string][]string{
+				"name":   {"multi-series"},
+				"series": {series},
+			},
+		})
+		c.Assert(err, gc.IsNil)
+		c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{url})
+	}
+}
+
+func (s *MongoSearchSuite) TestEnsureMongoSearch(c *gc.C) {
+	_, err := s.store.DB.SearchDocs().RemoveAll(nil)
+	c.Assert(err, gc.IsNil)
+
+	// Creating a new pool populates the empty search documents.
+	pool, err := NewPool(s.Session.DB("foo"), nil, nil, ServerParams{
+		MongoSearch: true,
+	})
+	c.Assert(err, gc.IsNil)
+	defer pool.Close()
+	n, err := s.store.DB.SearchDocs().Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 5)
+}
+
+func (s *MongoSearchSuite) TestSynchroniseElasticsearch(c *gc.C) {
+	_, err := s.store.DB.SearchDocs().UpsertId("cs:~bob/trusty/stale", &mongoSearchDoc{
+		Id:  "cs:~bob/trusty/stale",
+		URL: charm.MustParseReference("cs:~bob/trusty/stale-0"),
+	})
+	c.Assert(err, gc.IsNil)
+
+	// Synchronising removes documents for entities that no longer exist.
+	err = s.store.SynchroniseElasticsearch()
+	c.Assert(err, gc.IsNil)
+	n, err := s.store.DB.SearchDocs().Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 5)
+}
+
+func (s *MongoSearchSuite) TestSearchNotConfigured(c *gc.C) {
+	pool, err := NewPool(s.Session.DB("bar"), nil, nil, ServerParams{})
+	c.Assert(err, gc.IsNil)
+	defer pool.Close()
+	store := pool.Store()
+	defer store.Close()
+	addSearchTestEntities(c, store)
+	res, err := store.Search(SearchParams{})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, gc.HasLen, 0)
+	n, err := store.DB.SearchDocs().Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
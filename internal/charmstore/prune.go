@@ -0,0 +1,145 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/audit"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+// PruneRevisions moves to the trash all the revisions that are not
+// kept by the retention policy of their base entity or, when the base
+// entity has no policy of its own, by the store-wide policy in the
+// server configuration. Pruned revisions are purged along with any
+// other trashed entities once the trash grace period has expired.
+// It returns the ids of the pruned revisions.
+func (s *Store) PruneRevisions(now time.Time) ([]*router.ResolvedURL, error) {
+	defaultPolicy := mongodoc.RetentionPolicy{
+		KeepRevisions: s.pool.config.RetentionKeepRevisions,
+		KeepDuration:  s.pool.config.RetentionKeepDuration,
+	}
+	var query bson.D
+	if isNoRetentionPolicy(&defaultPolicy) {
+		// Only base entities with their own policy can be pruned.
+		query = bson.D{{"retention", bson.D{{"$exists", true}}}}
+	}
+	iter := s.DB.BaseEntities().
+		Find(query).
+		Select(bson.D{{"_id", 1}, {"channels", 1}, {"retention", 1}}).
+		Sort("_id").
+		Iter()
+	var pruned []*router.ResolvedURL
+	var baseEntity mongodoc.BaseEntity
+	for iter.Next(&baseEntity) {
+		policy := baseEntity.Retention
+		if policy == nil {
+			policy = &defaultPolicy
+		}
+		ids, err := s.pruneBaseEntity(&baseEntity, policy, now)
+		pruned = append(pruned, ids...)
+		if err != nil {
+			iter.Close()
+			return pruned, errgo.Mask(err)
+		}
+		baseEntity = mongodoc.BaseEntity{}
+	}
+	if err := iter.Close(); err != nil {
+		return pruned, errgo.Notef(err, "cannot iterate base entities")
+	}
+	return pruned, nil
+}
+
+// pruneBaseEntity moves to the trash the revisions of the given base
+// entity that are not kept by the given policy.
+func (s *Store) pruneBaseEntity(baseEntity *mongodoc.BaseEntity, policy *mongodoc.RetentionPolicy, now time.Time) ([]*router.ResolvedURL, error) {
+	if isNoRetentionPolicy(policy) {
+		return nil, nil
+	}
+	var entities []*mongodoc.Entity
+	err := s.DB.Entities().
+		Find(bson.D{{"baseurl", baseEntity.URL}, {"trashtime", bson.D{{"$exists", false}}}}).
+		Select(bson.D{{"_id", 1}, {"series", 1}, {"supportedseries", 1}, {"promulgated-url", 1}, {"promulgated-revision", 1}, {"uploadtime", 1}}).
+		Sort("series", "-revision").
+		All(&entities)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot find revisions of %s", baseEntity.URL)
+	}
+	published := make(map[charm.Reference]bool)
+	for _, urls := range baseEntity.Channels {
+		for _, url := range urls {
+			published[*url] = true
+		}
+	}
+	var pruned []*router.ResolvedURL
+	n := 0
+	for i, e := range entities {
+		if i > 0 && e.Series != entities[i-1].Series {
+			n = 0
+		}
+		n++
+		if isRetained(policy, n, e.UploadTime, now) || e.PromulgatedURL != nil || published[*e.URL] {
+			continue
+		}
+		referenced, err := s.isReferencedByBundle(e)
+		if err != nil {
+			return pruned, errgo.Mask(err)
+		}
+		if referenced {
+			continue
+		}
+		id := EntityResolvedURL(e)
+		if err := s.TrashEntity(id); err != nil {
+			if errgo.Cause(err) == params.ErrNotFound {
+				// The entity has been trashed concurrently.
+				continue
+			}
+			return pruned, errgo.Mask(err)
+		}
+		s.AddAudit(audit.Entry{
+			Op:     audit.OpPrune,
+			Entity: e.URL,
+		})
+		pruned = append(pruned, id)
+	}
+	return pruned, nil
+}
+
+// isNoRetentionPolicy reports whether the policy
+// keeps all revisions.
+func isNoRetentionPolicy(p *mongodoc.RetentionPolicy) bool {
+	return p.KeepRevisions <= 0 && p.KeepDuration <= 0
+}
+
+// isRetained reports whether the policy keeps the nth most
+// recent revision in a series, uploaded at the given time.
+func isRetained(p *mongodoc.RetentionPolicy, n int, uploadTime, now time.Time) bool {
+	if p.KeepRevisions > 0 && n <= p.KeepRevisions {
+		return true
+	}
+	if p.KeepDuration > 0 && now.Sub(uploadTime) < p.KeepDuration {
+		return true
+	}
+	return false
+}
+
+// pruneRevisions prunes old revisions according to the
+// retention policies. It is intended to be run as a
+// periodic job.
+func pruneRevisions(store *Store) {
+	pruned, err := store.PruneRevisions(time.Now())
+	for _, id := range pruned {
+		logger.Infof("pruned %s", id)
+	}
+	if err != nil {
+		logger.Errorf("cannot prune revisions: %v", err)
+	}
+}
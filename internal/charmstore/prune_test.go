@@ -0,0 +1,97 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"time"
+
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+func (s *StoreSuite) TestPruneRevisions(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	for _, id := range []string{
+		"~charmers/trusty/wordpress-0",
+		"~charmers/trusty/wordpress-1",
+		"~charmers/trusty/wordpress-2",
+		"~charmers/trusty/wordpress-3",
+		"~charmers/trusty/wordpress-4",
+		"~charmers/precise/wordpress-0",
+		"~charmers/precise/wordpress-1",
+		"0 ~charmers/trusty/mysql-0",
+		"~charmers/trusty/mysql-1",
+		"~charmers/trusty/mysql-2",
+	} {
+		err := store.AddCharmWithArchive(MustParseResolvedURL(id), storetesting.Charms.CharmDir("wordpress"))
+		c.Assert(err, gc.IsNil)
+	}
+	err := store.Publish(MustParseResolvedURL("~charmers/trusty/wordpress-1"), "stable")
+	c.Assert(err, gc.IsNil)
+	burl := MustParseResolvedURL("~charmers/bundle/wordpress-simple-0")
+	err = store.AddBundleWithArchive(burl, storetesting.Charms.BundleDir("wordpress-simple"))
+	c.Assert(err, gc.IsNil)
+	err = store.DB.Entities().UpdateId(&burl.URL, bson.D{{"$set", bson.D{{"bundlecharms", []*charm.Reference{
+		charm.MustParseReference("~charmers/trusty/wordpress-0"),
+	}}}}})
+	c.Assert(err, gc.IsNil)
+
+	// With no policy anywhere, nothing is pruned.
+	pruned, err := store.PruneRevisions(time.Now())
+	c.Assert(err, gc.IsNil)
+	c.Assert(pruned, gc.HasLen, 0)
+
+	// Recent revisions are kept by a duration policy.
+	setRetention := func(policy *mongodoc.RetentionPolicy) {
+		err := store.DB.BaseEntities().UpdateId(charm.MustParseReference("~charmers/wordpress"), bson.D{{"$set", bson.D{{"retention", policy}}}})
+		c.Assert(err, gc.IsNil)
+	}
+	setRetention(&mongodoc.RetentionPolicy{
+		KeepDuration: time.Hour,
+	})
+	pruned, err = store.PruneRevisions(time.Now())
+	c.Assert(err, gc.IsNil)
+	c.Assert(pruned, gc.HasLen, 0)
+
+	// The last revisions in each series are kept, as are revisions
+	// published to a channel or referenced by a bundle.
+	setRetention(&mongodoc.RetentionPolicy{
+		KeepRevisions: 2,
+	})
+	pruned, err = store.PruneRevisions(time.Now())
+	c.Assert(err, gc.IsNil)
+	c.Assert(pruned, gc.DeepEquals, []*router.ResolvedURL{
+		MustParseResolvedURL("~charmers/trusty/wordpress-2"),
+	})
+
+	// The pruned revision is in the trash.
+	_, err = store.FindEntity(MustParseResolvedURL("~charmers/trusty/wordpress-2"))
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+	n, err := store.DB.Entities().Find(bson.D{{"trashtime", bson.D{{"$exists", true}}}}).Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 1)
+
+	// The store-wide policy applies to base entities without
+	// a policy of their own. Promulgated revisions are kept.
+	store.pool.config.RetentionKeepRevisions = 1
+	pruned, err = store.PruneRevisions(time.Now())
+	c.Assert(err, gc.IsNil)
+	c.Assert(pruned, gc.DeepEquals, []*router.ResolvedURL{
+		MustParseResolvedURL("~charmers/trusty/mysql-1"),
+	})
+
+	// Pruning again finds nothing more to do.
+	pruned, err = store.PruneRevisions(time.Now())
+	c.Assert(err, gc.IsNil)
+	c.Assert(pruned, gc.HasLen, 0)
+}
@@ -0,0 +1,190 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"strconv"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+// Quotas returns the Mongo collection where the
+// per-user upload quota overrides are stored.
+func (s StoreDatabase) Quotas() *mgo.Collection {
+	return s.C("quotas")
+}
+
+// UserQuota returns the upload quota overrides for the given user.
+// If the user has no overrides, a quota with all limits set
+// to zero is returned.
+func (s *Store) UserQuota(user string) (*mongodoc.Quota, error) {
+	var q mongodoc.Quota
+	err := s.DB.Quotas().FindId(user).One(&q)
+	if err == mgo.ErrNotFound {
+		return &mongodoc.Quota{User: user}, nil
+	}
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get quota for %q", user)
+	}
+	return &q, nil
+}
+
+// SetUserQuota sets the upload quota overrides for q.User.
+// If all the limits in q are zero, the overrides are removed
+// and the store-wide limits apply.
+func (s *Store) SetUserQuota(q *mongodoc.Quota) error {
+	if *q == (mongodoc.Quota{User: q.User}) {
+		_, err := s.DB.Quotas().RemoveAll(bson.D{{"_id", q.User}})
+		if err != nil {
+			return errgo.Notef(err, "cannot remove quota for %q", q.User)
+		}
+		return nil
+	}
+	if _, err := s.DB.Quotas().UpsertId(q.User, q); err != nil {
+		return errgo.Notef(err, "cannot set quota for %q", q.User)
+	}
+	return nil
+}
+
+// effectiveQuota returns the limits that apply to the given user,
+// taking into account both the store-wide limits and the user's
+// overrides. In the returned quota, a zero limit means that there
+// is no limit.
+func (s *Store) effectiveQuota(user string) (*mongodoc.Quota, error) {
+	q, err := s.UserQuota(user)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	config := s.pool.config
+	if q.MaxBytes == 0 {
+		q.MaxBytes = config.QuotaMaxBytes
+	}
+	if q.MaxArchiveSize == 0 {
+		q.MaxArchiveSize = config.QuotaMaxArchiveSize
+	}
+	if q.MaxRevisions == 0 {
+		q.MaxRevisions = config.QuotaMaxRevisions
+	}
+	return q, nil
+}
+
+// CheckUploadQuota checks that uploading an archive of the given
+// size as a new revision of the given id would not exceed the
+// quota of the owner of the id. Entities in the trash count against
+// the quota until they are purged. It returns an error with a
+// router.ErrQuotaExceeded cause if the quota would be exceeded.
+func (s *Store) CheckUploadQuota(id *charm.Reference, size int64) error {
+	q, err := s.effectiveQuota(id.User)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if q.MaxArchiveSize > 0 && size > q.MaxArchiveSize {
+		return errgo.WithCausef(nil, router.ErrQuotaExceeded, "archive size %d exceeds maximum archive size of %d bytes", size, q.MaxArchiveSize)
+	}
+	if q.MaxRevisions > 0 {
+		n, err := s.DB.Entities().Find(bson.D{{"baseurl", baseURL(id)}}).Count()
+		if err != nil {
+			return errgo.Notef(err, "cannot count revisions")
+		}
+		if n >= q.MaxRevisions {
+			return errgo.WithCausef(nil, router.ErrQuotaExceeded, "%s has reached its maximum of %d revisions", baseURL(id), q.MaxRevisions)
+		}
+	}
+	if q.MaxBytes > 0 {
+		used, err := s.userArchiveBytes(id.User)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		if used+size > q.MaxBytes {
+			return errgo.WithCausef(nil, router.ErrQuotaExceeded, "upload would exceed quota of %d bytes for %q (%d bytes used)", q.MaxBytes, id.User, used)
+		}
+	}
+	return nil
+}
+
+// checkChunkQuota checks that storing a chunk of the given size at
+// the given offset in the given upload session would not exceed the
+// quota of the owner of the session. The end of the chunk must fit
+// within the maximum archive size, and the chunks staged in all the
+// user's upload sessions count against the user's byte quota along
+// with their archives. Sessions created by admins are not limited.
+// It returns an error with a router.ErrQuotaExceeded cause if the
+// quota would be exceeded.
+func (s *Store) checkChunkQuota(session *mongodoc.UploadSession, offset, size int64) error {
+	if session.User == "" {
+		return nil
+	}
+	q, err := s.effectiveQuota(session.User)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if q.MaxArchiveSize > 0 && offset+size > q.MaxArchiveSize {
+		return errgo.WithCausef(nil, router.ErrQuotaExceeded, "chunk ending at offset %d exceeds maximum archive size of %d bytes", offset+size, q.MaxArchiveSize)
+	}
+	if q.MaxBytes > 0 {
+		used, err := s.userArchiveBytes(session.User)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		staged, err := s.userStagedBytes(session.User)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		// A chunk uploaded again at the same offset
+		// replaces the existing one.
+		replaced := session.Chunks[strconv.FormatInt(offset, 10)].Size
+		used += staged - replaced
+		if used+size > q.MaxBytes {
+			return errgo.WithCausef(nil, router.ErrQuotaExceeded, "upload would exceed quota of %d bytes for %q (%d bytes used)", q.MaxBytes, session.User, used)
+		}
+	}
+	return nil
+}
+
+// userStagedBytes returns the total size of the chunks
+// staged in the unexpired upload sessions of the given user.
+func (s *Store) userStagedBytes(user string) (int64, error) {
+	var size int64
+	iter := s.DB.UploadSessions().Find(bson.D{
+		{"user", user},
+		{"expires", bson.D{{"$gt", time.Now()}}},
+	}).Select(bson.D{{"chunks", 1}}).Iter()
+	var session mongodoc.UploadSession
+	for iter.Next(&session) {
+		for _, chunk := range session.Chunks {
+			size += chunk.Size
+		}
+		session = mongodoc.UploadSession{}
+	}
+	if err := iter.Close(); err != nil {
+		return 0, errgo.Notef(err, "cannot compute staged upload sizes for %q", user)
+	}
+	return size, nil
+}
+
+// userArchiveBytes returns the total size of
+// the archives owned by the given user.
+func (s *Store) userArchiveBytes(user string) (int64, error) {
+	var results []struct {
+		Size int64
+	}
+	err := s.DB.Entities().Pipe([]bson.D{
+		{{"$match", bson.D{{"user", user}}}},
+		{{"$group", bson.D{{"_id", nil}, {"size", bson.D{{"$sum", "$size"}}}}}},
+	}).All(&results)
+	if err != nil {
+		return 0, errgo.Notef(err, "cannot compute archive sizes for %q", user)
+	}
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Size, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+func (s *StoreSuite) TestUserQuota(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	q, err := store.UserQuota("bob")
+	c.Assert(err, gc.IsNil)
+	c.Assert(q, jc.DeepEquals, &mongodoc.Quota{User: "bob"})
+
+	err = store.SetUserQuota(&mongodoc.Quota{
+		User:         "bob",
+		MaxBytes:     1000,
+		MaxRevisions: -1,
+	})
+	c.Assert(err, gc.IsNil)
+	q, err = store.UserQuota("bob")
+	c.Assert(err, gc.IsNil)
+	c.Assert(q, jc.DeepEquals, &mongodoc.Quota{
+		User:         "bob",
+		MaxBytes:     1000,
+		MaxRevisions: -1,
+	})
+
+	// Setting all the limits to zero removes the overrides.
+	err = store.SetUserQuota(&mongodoc.Quota{User: "bob"})
+	c.Assert(err, gc.IsNil)
+	n, err := store.DB.Quotas().Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
+
+func (s *StoreSuite) TestCheckUploadQuota(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	for _, id := range []string{"~bob/trusty/wordpress-0", "~bob/trusty/wordpress-1"} {
+		err := store.AddCharmWithArchive(MustParseResolvedURL(id), storetesting.Charms.CharmDir("wordpress"))
+		c.Assert(err, gc.IsNil)
+	}
+	entity, err := store.FindEntity(MustParseResolvedURL("~bob/trusty/wordpress-0"), "size")
+	c.Assert(err, gc.IsNil)
+	size := entity.Size
+	wordpress := charm.MustParseReference("~bob/trusty/wordpress")
+	mysql := charm.MustParseReference("~bob/trusty/mysql")
+
+	// With no quotas, anything goes.
+	err = store.CheckUploadQuota(wordpress, 1<<40)
+	c.Assert(err, gc.IsNil)
+
+	store.pool.config.QuotaMaxArchiveSize = 100
+	store.pool.config.QuotaMaxRevisions = 2
+	store.pool.config.QuotaMaxBytes = 2*size + 100
+	err = store.CheckUploadQuota(mysql, 100)
+	c.Assert(err, gc.IsNil)
+	err = store.CheckUploadQuota(mysql, 101)
+	c.Assert(err, gc.ErrorMatches, `archive size 101 exceeds maximum archive size of 100 bytes`)
+	c.Assert(errgo.Cause(err), gc.Equals, router.ErrQuotaExceeded)
+	err = store.CheckUploadQuota(wordpress, 10)
+	c.Assert(err, gc.ErrorMatches, `cs:~bob/trusty/wordpress has reached its maximum of 2 revisions`)
+	c.Assert(errgo.Cause(err), gc.Equals, router.ErrQuotaExceeded)
+
+	// Other users are not affected by bob's usage.
+	err = store.CheckUploadQuota(charm.MustParseReference("~alice/trusty/mysql"), 100)
+	c.Assert(err, gc.IsNil)
+
+	store.pool.config.QuotaMaxArchiveSize = 0
+	err = store.CheckUploadQuota(mysql, 101)
+	c.Assert(err, gc.ErrorMatches, `upload would exceed quota of [0-9]+ bytes for "bob" \([0-9]+ bytes used\)`)
+	c.Assert(errgo.Cause(err), gc.Equals, router.ErrQuotaExceeded)
+
+	// User overrides take precedence over the store-wide limits.
+	err = store.SetUserQuota(&mongodoc.Quota{
+		User:         "bob",
+		MaxBytes:     -1,
+		MaxRevisions: 3,
+	})
+	c.Assert(err, gc.IsNil)
+	err = store.CheckUploadQuota(wordpress, 1000)
+	c.Assert(err, gc.IsNil)
+	err = store.CheckUploadQuota(charm.MustParseReference("~alice/trusty/mysql"), 2*size+101)
+	c.Assert(err, gc.ErrorMatches, `upload would exceed quota of [0-9]+ bytes for "alice" \(0 bytes used\)`)
+}
@@ -0,0 +1,497 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"encoding/json"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/elasticsearch"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+// defaultReindexBatchSize holds the number of search documents
+// sent in each bulk request when ReindexParams.BatchSize is zero.
+const defaultReindexBatchSize = 500
+
+// reindexType holds the elasticsearch type of the documents, stored in
+// versionIndex, that record the state of a reindex in progress.
+const reindexType = "reindex"
+
+// ReindexParams holds the parameters for Store.ReindexElasticsearch.
+type ReindexParams struct {
+	// BatchSize holds the maximum number of search documents
+	// sent to elasticsearch in each bulk request. If it is zero,
+	// a default batch size is used.
+	BatchSize int
+
+	// Resume specifies that a previously interrupted reindex
+	// should be continued from where it stopped. If there is
+	// no such reindex, a new one is started.
+	Resume bool
+
+	// Progress, if non-nil, is called after each batch
+	// of search documents has been indexed.
+	Progress func(ReindexProgress)
+}
+
+// ReindexProgress reports the progress of a reindex.
+type ReindexProgress struct {
+	// Index holds the name of the index being populated.
+	Index string
+
+	// BaseEntities holds the number of base entities
+	// processed so far, including any processed before
+	// the reindex was resumed.
+	BaseEntities int
+
+	// TotalBaseEntities holds the total number of base
+	// entities to process.
+	TotalBaseEntities int
+
+	// Documents holds the number of search documents
+	// indexed so far.
+	Documents int
+}
+
+// reindexState records the progress of a reindex so that it can be
+// resumed if interrupted.
+type reindexState struct {
+	// Index holds the name of the index being populated.
+	Index string
+
+	// Version holds the elasticsearch settings version
+	// used to create the index.
+	Version int64
+
+	// StartTime holds the time the reindex was started.
+	StartTime time.Time
+
+	// LastBaseURL holds the base URL of the last base entity
+	// indexed. Base entities are indexed in order of their
+	// base URL.
+	LastBaseURL string
+
+	// BaseEntities and Documents hold the number of base entities
+	// processed and the number of search documents indexed.
+	BaseEntities int
+	Documents    int
+}
+
+// ReindexElasticsearch rebuilds the elasticsearch search index without
+// interrupting searches. A new index is created with the current
+// settings and populated in bulk with the latest revision of every
+// entity in each series. Once the number of documents in the new index
+// has been checked against the contents of MongoDB, the alias used for
+// searching is moved to the new index in a single operation and the
+// old index is deleted.
+//
+// Entities uploaded or moved to the trash while the new index is being
+// populated are taken into account before the alias is moved, and those
+// uploaded or trashed while the alias is being moved are taken into
+// account afterwards. Other changes to existing entities, such as
+// changes of permissions, made during the reindex may not be reflected
+// in the new index.
+func (s *Store) ReindexElasticsearch(p ReindexParams) error {
+	si := s.ES
+	if si == nil || si.Database == nil {
+		return errgo.New("elasticsearch not configured")
+	}
+	if p.BatchSize <= 0 {
+		p.BatchSize = defaultReindexBatchSize
+	}
+	state, err := si.startReindex(p.Resume)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	newIndex := &SearchIndex{
+		Database: si.Database,
+		Index:    state.Index,
+	}
+	if err := s.populateIndex(newIndex, state, p); err != nil {
+		return errgo.Notef(err, "cannot populate index %s", state.Index)
+	}
+	// Index anything uploaded since the reindex was started. The
+	// time is recorded first so that nothing uploaded while this is
+	// done can be missed after the alias has been moved.
+	catchUpTime := time.Now()
+	if err := s.indexChangedSince(newIndex, state.StartTime); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := si.RefreshIndex(state.Index); err != nil {
+		return errgo.Notef(err, "cannot refresh index %s", state.Index)
+	}
+	if err := s.verifyIndex(newIndex, catchUpTime); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := si.swapIndex(state.Index); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := s.indexChangedSince(si, catchUpTime); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := si.DeleteDocument(versionIndex, reindexType, si.Index); err != nil && err != elasticsearch.ErrNotFound {
+		return errgo.Notef(err, "cannot remove reindex state")
+	}
+	return nil
+}
+
+// startReindex returns the state of the reindex to perform. If resume
+// is true and there is an interrupted reindex using the current
+// settings, its state is returned; otherwise any partially populated
+// index is deleted and a new index is created.
+func (si *SearchIndex) startReindex(resume bool) (*reindexState, error) {
+	var state reindexState
+	d, err := si.GetESDocument(versionIndex, reindexType, si.Index)
+	if err != nil && err != elasticsearch.ErrNotFound {
+		return nil, errgo.Notef(err, "cannot get reindex state")
+	}
+	if d.Found {
+		if err := json.Unmarshal(d.Source, &state); err != nil {
+			return nil, errgo.Notef(err, "invalid reindex state")
+		}
+		if resume && state.Version == esSettingsVersion {
+			_, err := si.Count(state.Index, "")
+			if err == nil {
+				logger.Infof("resuming reindex into %s after %s", state.Index, state.LastBaseURL)
+				return &state, nil
+			}
+			if err != elasticsearch.ErrNotFound {
+				return nil, errgo.Notef(err, "cannot check index %s", state.Index)
+			}
+		}
+		logger.Infof("discarding previous reindex into %s", state.Index)
+		if err := si.DeleteIndex(state.Index); err != nil && err != elasticsearch.ErrNotFound {
+			return nil, errgo.Notef(err, "cannot delete index %s", state.Index)
+		}
+	}
+	index, err := si.newIndex()
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot create index")
+	}
+	state = reindexState{
+		Index:     index,
+		Version:   esSettingsVersion,
+		StartTime: time.Now(),
+	}
+	if err := si.saveReindexState(&state); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &state, nil
+}
+
+// saveReindexState stores the given reindex state.
+func (si *SearchIndex) saveReindexState(state *reindexState) error {
+	if err := si.PutDocument(versionIndex, reindexType, si.Index, state); err != nil {
+		return errgo.Notef(err, "cannot save reindex state")
+	}
+	return nil
+}
+
+// populateIndex adds the search documents for all the base entities
+// after state.LastBaseURL to the given index, updating the stored state
+// after each batch.
+func (s *Store) populateIndex(si *SearchIndex, state *reindexState, p ReindexParams) error {
+	total, err := s.DB.BaseEntities().Count()
+	if err != nil {
+		return errgo.Notef(err, "cannot count base entities")
+	}
+	progress := func() {
+		if p.Progress != nil {
+			p.Progress(ReindexProgress{
+				Index:             state.Index,
+				BaseEntities:      state.BaseEntities,
+				TotalBaseEntities: total,
+				Documents:         state.Documents,
+			})
+		}
+	}
+	var query bson.D
+	if state.LastBaseURL != "" {
+		query = bson.D{{"_id", bson.D{{"$gt", state.LastBaseURL}}}}
+	}
+	iter := s.DB.BaseEntities().Find(query).Sort("_id").Iter()
+	defer iter.Close()
+	var docs []*SearchDoc
+	var baseEntities int
+	var lastBaseURL *charm.Reference
+	flush := func() error {
+		if err := si.bulkUpdate(docs); err != nil {
+			return errgo.Mask(err)
+		}
+		state.LastBaseURL = lastBaseURL.String()
+		state.BaseEntities += baseEntities
+		state.Documents += len(docs)
+		docs, baseEntities = docs[:0], 0
+		if err := s.ES.saveReindexState(state); err != nil {
+			return errgo.Mask(err)
+		}
+		progress()
+		return nil
+	}
+	for {
+		var baseEntity mongodoc.BaseEntity
+		if !iter.Next(&baseEntity) {
+			break
+		}
+		baseDocs, err := s.searchDocsForBaseEntity(&baseEntity)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		docs = append(docs, baseDocs...)
+		baseEntities++
+		lastBaseURL = baseEntity.URL
+		if len(docs) >= p.BatchSize {
+			if err := flush(); err != nil {
+				return errgo.Mask(err)
+			}
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return errgo.Notef(err, "cannot iterate base entities")
+	}
+	if baseEntities > 0 {
+		if err := flush(); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+// indexChangedSince updates the given index to take into account
+// the entities uploaded, moved to the trash or restored from the
+// trash since the given time.
+func (s *Store) indexChangedSince(si *SearchIndex, since time.Time) error {
+	if err := s.indexUploadedSince(si, since); err != nil {
+		return errgo.Notef(err, "cannot index recent uploads")
+	}
+	if err := s.indexTrashedSince(si, since); err != nil {
+		return errgo.Notef(err, "cannot index recently trashed entities")
+	}
+	return nil
+}
+
+// indexTrashedSince updates the search documents in the given index
+// for the entities moved into or out of the trash since the given
+// time, so that their series are either removed or refer to the
+// latest revision that is not in the trash.
+func (s *Store) indexTrashedSince(si *SearchIndex, since time.Time) error {
+	iter := s.DB.Entities().Find(bson.D{{
+		"$or", []bson.D{
+			{{"trashtime", bson.D{{"$gte", since}}}},
+			{{"restoretime", bson.D{{"$gte", since}}}},
+		},
+	}}).Select(bson.D{{"_id", 1}}).Iter()
+	defer iter.Close()
+	done := make(map[string]bool)
+	for {
+		var entity mongodoc.Entity
+		if !iter.Next(&entity) {
+			break
+		}
+		id := si.getID(entity.URL)
+		if done[id] {
+			continue
+		}
+		done[id] = true
+		if err := s.updateBackendAfterTrash(si, entity.URL); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return errgo.Notef(err, "cannot get recently trashed entities")
+	}
+	return nil
+}
+
+// indexUploadedSince adds the search documents for all entities
+// uploaded since the given time to the given index.
+func (s *Store) indexUploadedSince(si *SearchIndex, since time.Time) error {
+	var baseURLs []*charm.Reference
+	err := s.DB.Entities().Find(bson.D{
+		{"uploadtime", bson.D{{"$gte", since}}},
+	}).Distinct("baseurl", &baseURLs)
+	if err != nil {
+		return errgo.Notef(err, "cannot get recently uploaded entities")
+	}
+	var docs []*SearchDoc
+	for _, baseURL := range baseURLs {
+		baseEntity, err := s.FindBaseEntity(baseURL)
+		if err != nil {
+			return errgo.Notef(err, "cannot get %s", baseURL)
+		}
+		baseDocs, err := s.searchDocsForBaseEntity(baseEntity)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		docs = append(docs, baseDocs...)
+	}
+	if err := si.bulkUpdate(docs); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// searchDocsForBaseEntity returns the search documents for the latest
+// revision in each series of the entities with the given base entity.
+func (s *Store) searchDocsForBaseEntity(baseEntity *mongodoc.BaseEntity) ([]*SearchDoc, error) {
+	urls, err := s.latestSeriesURLs(baseEntity.URL)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get latest revisions of %s", baseEntity.URL)
+	}
+	docs := make([]*SearchDoc, 0, len(urls))
+	for _, url := range urls {
+		var entity mongodoc.Entity
+		if err := s.DB.Entities().FindId(url).One(&entity); err != nil {
+			return nil, errgo.Notef(err, "cannot get %s", url)
+		}
+		doc, err := s.searchDocFromEntity(&entity, baseEntity)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot create search document for %s", url)
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// bulkUpdate stores all the given documents in the index, unless the
+// index holds a later revision of the same entity.
+func (si *SearchIndex) bulkUpdate(docs []*SearchDoc) error {
+	bulkDocs := make([]elasticsearch.BulkDocument, len(docs))
+	for i, doc := range docs {
+		bulkDocs[i] = elasticsearch.BulkDocument{
+			Index:       si.Index,
+			Type:        typeName,
+			ID:          si.getID(doc.URL),
+			Version:     int64(doc.URL.Revision),
+			VersionType: elasticsearch.ExternalGTE,
+			Doc:         doc,
+		}
+	}
+	if err := si.Bulk(bulkDocs); err != nil {
+		return errgo.Notef(err, "cannot index search documents")
+	}
+	return nil
+}
+
+// verifyIndex checks that the given index holds a document for every
+// series of every entity uploaded before the given time that should
+// be searchable. Entities uploaded since then may not have been
+// indexed yet, so they are not counted.
+func (s *Store) verifyIndex(si *SearchIndex, before time.Time) error {
+	var series []string
+	for name := range deprecatedSeries {
+		series = append(series, name)
+	}
+	var result struct {
+		Count int
+	}
+	err := s.DB.Entities().Pipe([]bson.D{
+		{{"$match", bson.D{
+			{"uploadtime", bson.D{{"$lt", before}}},
+			{"trashtime", bson.D{{"$exists", false}}},
+			{"series", bson.D{{"$nin", series}}},
+		}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{{"baseurl", "$baseurl"}, {"series", "$series"}}},
+		}}},
+		{{"$group", bson.D{
+			{"_id", nil},
+			{"count", bson.D{{"$sum", 1}}},
+		}}},
+	}).One(&result)
+	if err != nil && err != mgo.ErrNotFound {
+		return errgo.Notef(err, "cannot count searchable entities")
+	}
+	n, err := si.Count(si.Index, typeName)
+	if err != nil {
+		return errgo.Notef(err, "cannot count search documents")
+	}
+	if n != result.Count {
+		return errgo.Newf("index %s holds %d search documents, expected %d", si.Index, n, result.Count)
+	}
+	return nil
+}
+
+// swapIndex makes the given index the current search index, moving
+// the alias in a single operation and deleting the indexes previously
+// in use.
+func (si *SearchIndex) swapIndex(index string) error {
+	old, dv, err := si.getCurrentVersion()
+	if err != nil {
+		return errgo.Notef(err, "cannot get current version")
+	}
+	oldIndexes, err := si.ListIndexesForAlias(si.Index)
+	if err != nil {
+		return errgo.Notef(err, "cannot get current indexes")
+	}
+	updated, err := si.updateVersion(version{
+		Version: esSettingsVersion,
+		Index:   index,
+	}, dv)
+	if err != nil {
+		return errgo.Notef(err, "cannot update version")
+	}
+	if !updated {
+		return errgo.Newf("search index %s changed during reindex", si.Index)
+	}
+	if err := si.Alias(index, si.Index); err != nil {
+		return errgo.Notef(err, "cannot update alias")
+	}
+	if old.Index != "" {
+		oldIndexes = append(oldIndexes, old.Index)
+	}
+	deleted := make(map[string]bool)
+	for _, oldIndex := range oldIndexes {
+		if oldIndex == index || deleted[oldIndex] {
+			continue
+		}
+		deleted[oldIndex] = true
+		if err := si.DeleteIndex(oldIndex); err != nil && err != elasticsearch.ErrNotFound {
+			return errgo.Notef(err, "cannot delete index %s", oldIndex)
+		}
+	}
+	return nil
+}
+
+// latestSeriesURLs returns the URLs of the latest untrashed revision
+// in each series, other than the deprecated series, of the entities
+// with the given base URL.
+func (s *Store) latestSeriesURLs(baseURL *charm.Reference) ([]*charm.Reference, error) {
+	// Note: It is possible to return the complete entity here and save some
+	// database round trips. Unfortunately the version of mongoDB we support
+	// (2.4) would require every field to be enumerated in this query, which
+	// would make it too fragile.
+	iter := s.DB.Entities().Pipe([]bson.D{
+		{{"$match", bson.D{
+			{"baseurl", baseURL},
+			{"trashtime", bson.D{{"$exists", false}}},
+		}}},
+		{{"$sort", bson.D{{"revision", 1}}}},
+		{{"$group", bson.D{
+			{"_id", "$series"},
+			{"url", bson.D{{"$last", "$_id"}}},
+		}}},
+	}).Iter()
+	defer iter.Close()
+	var urls []*charm.Reference
+	var result struct {
+		URL *charm.Reference
+	}
+	for iter.Next(&result) {
+		if deprecatedSeries[result.URL.Series] {
+			continue
+		}
+		urls = append(urls, result.URL)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return urls, nil
+}
@@ -0,0 +1,219 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+
+	"gopkg.in/juju/charmstore.v5-unstable/elasticsearch"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+func (s *StoreSearchSuite) TestReindex(c *gc.C) {
+	indexes, err := s.ES.ListIndexesForAlias(s.TestIndex)
+	c.Assert(err, gc.IsNil)
+	c.Assert(indexes, gc.HasLen, 1)
+	oldIndex := indexes[0]
+
+	// Add an entity at revision zero, which is indexed
+	// with an external version of zero.
+	zero := newResolvedURL("cs:~bob/trusty/mysql-0", -1)
+	err = s.store.AddCharmWithArchive(zero, storetesting.Charms.CharmDir("mysql"))
+	c.Assert(err, gc.IsNil)
+
+	var progress []ReindexProgress
+	err = s.store.ReindexElasticsearch(ReindexParams{
+		BatchSize: 2,
+		Progress: func(p ReindexProgress) {
+			progress = append(progress, p)
+		},
+	})
+	c.Assert(err, gc.IsNil)
+
+	indexes, err = s.ES.ListIndexesForAlias(s.TestIndex)
+	c.Assert(err, gc.IsNil)
+	c.Assert(indexes, gc.HasLen, 1)
+	newIndex := indexes[0]
+	c.Assert(newIndex, gc.Not(gc.Equals), oldIndex)
+	_, err = s.ES.Count(oldIndex, "")
+	c.Assert(err, gc.Equals, elasticsearch.ErrNotFound)
+
+	c.Assert(progress, gc.DeepEquals, []ReindexProgress{{
+		Index:             newIndex,
+		BaseEntities:      2,
+		TotalBaseEntities: 6,
+		Documents:         2,
+	}, {
+		Index:             newIndex,
+		BaseEntities:      4,
+		TotalBaseEntities: 6,
+		Documents:         4,
+	}, {
+		Index:             newIndex,
+		BaseEntities:      6,
+		TotalBaseEntities: 6,
+		Documents:         6,
+	}})
+
+	urls := []*router.ResolvedURL{zero}
+	for _, url := range exportTestCharms {
+		urls = append(urls, url)
+	}
+	for _, url := range urls {
+		present, err := s.ES.HasDocument(s.TestIndex, typeName, s.store.ES.getID(&url.URL))
+		c.Assert(err, gc.IsNil)
+		c.Assert(present, gc.Equals, true, gc.Commentf("%s", url))
+	}
+	v, _, err := s.store.ES.getCurrentVersion()
+	c.Assert(err, gc.IsNil)
+	c.Assert(v, gc.Equals, version{Version: esSettingsVersion, Index: newIndex})
+	d, err := s.ES.GetESDocument(versionIndex, reindexType, s.TestIndex)
+	c.Assert(err, gc.IsNil)
+	c.Assert(d.Found, gc.Equals, false)
+}
+
+func (s *StoreSearchSuite) TestReindexIncludesNewUploads(c *gc.C) {
+	url := newResolvedURL("cs:~bob/trusty/mysql-1", -1)
+	err := s.store.ReindexElasticsearch(ReindexParams{
+		BatchSize: 1,
+		Progress: func(p ReindexProgress) {
+			if p.BaseEntities != 1 {
+				return
+			}
+			// The new base entity sorts before those already
+			// processed, so it can only be indexed by catching
+			// up with recent uploads.
+			err := s.store.AddCharmWithArchive(url, storetesting.Charms.CharmDir("mysql"))
+			c.Assert(err, gc.IsNil)
+		},
+	})
+	c.Assert(err, gc.IsNil)
+	present, err := s.ES.HasDocument(s.TestIndex, typeName, s.store.ES.getID(&url.URL))
+	c.Assert(err, gc.IsNil)
+	c.Assert(present, gc.Equals, true)
+}
+
+func (s *StoreSearchSuite) TestReindexIncludesTrashedEntities(c *gc.C) {
+	riak := exportTestCharms["riak"]
+	err := s.store.ReindexElasticsearch(ReindexParams{
+		BatchSize: 1,
+		Progress: func(p ReindexProgress) {
+			if p.BaseEntities != 1 {
+				return
+			}
+			// The riak base entity sorts first, so it
+			// has already been indexed when it is trashed.
+			err := s.store.TrashEntity(riak)
+			c.Assert(err, gc.IsNil)
+		},
+	})
+	c.Assert(err, gc.IsNil)
+	present, err := s.ES.HasDocument(s.TestIndex, typeName, s.store.ES.getID(&riak.URL))
+	c.Assert(err, gc.IsNil)
+	c.Assert(present, gc.Equals, false)
+}
+
+func (s *StoreSearchSuite) TestReindexIncludesRestoredEntities(c *gc.C) {
+	riak := exportTestCharms["riak"]
+	err := s.store.TrashEntity(riak)
+	c.Assert(err, gc.IsNil)
+	restored := false
+	err = s.store.ReindexElasticsearch(ReindexParams{
+		BatchSize: 1,
+		Progress: func(p ReindexProgress) {
+			if restored {
+				return
+			}
+			// The riak base entity sorts first, so it has
+			// already been indexed when it is restored.
+			_, err := s.store.RestoreEntity(&riak.URL)
+			c.Assert(err, gc.IsNil)
+			restored = true
+		},
+	})
+	c.Assert(err, gc.IsNil)
+	present, err := s.ES.HasDocument(s.TestIndex, typeName, s.store.ES.getID(&riak.URL))
+	c.Assert(err, gc.IsNil)
+	c.Assert(present, gc.Equals, true)
+}
+
+func (s *StoreSearchSuite) TestReindexResume(c *gc.C) {
+	// Simulate a reindex interrupted after the first three
+	// base entities.
+	state, err := s.store.ES.startReindex(false)
+	c.Assert(err, gc.IsNil)
+	var baseEntities []*mongodoc.BaseEntity
+	err = s.store.DB.BaseEntities().Find(nil).Sort("_id").Limit(3).All(&baseEntities)
+	c.Assert(err, gc.IsNil)
+	newIndex := &SearchIndex{s.ES, state.Index}
+	for _, baseEntity := range baseEntities {
+		docs, err := s.store.searchDocsForBaseEntity(baseEntity)
+		c.Assert(err, gc.IsNil)
+		err = newIndex.bulkUpdate(docs)
+		c.Assert(err, gc.IsNil)
+		state.LastBaseURL = baseEntity.URL.String()
+		state.BaseEntities++
+		state.Documents += len(docs)
+	}
+	err = s.store.ES.saveReindexState(state)
+	c.Assert(err, gc.IsNil)
+
+	var progress []ReindexProgress
+	err = s.store.ReindexElasticsearch(ReindexParams{
+		Resume: true,
+		Progress: func(p ReindexProgress) {
+			progress = append(progress, p)
+		},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(progress, gc.DeepEquals, []ReindexProgress{{
+		Index:             state.Index,
+		BaseEntities:      5,
+		TotalBaseEntities: 5,
+		Documents:         5,
+	}})
+	indexes, err := s.ES.ListIndexesForAlias(s.TestIndex)
+	c.Assert(err, gc.IsNil)
+	c.Assert(indexes, gc.DeepEquals, []string{state.Index})
+}
+
+func (s *StoreSearchSuite) TestReindexWithoutResumeDiscardsInterrupted(c *gc.C) {
+	state, err := s.store.ES.startReindex(false)
+	c.Assert(err, gc.IsNil)
+	err = s.store.ReindexElasticsearch(ReindexParams{})
+	c.Assert(err, gc.IsNil)
+	_, err = s.ES.Count(state.Index, "")
+	c.Assert(err, gc.Equals, elasticsearch.ErrNotFound)
+	indexes, err := s.ES.ListIndexesForAlias(s.TestIndex)
+	c.Assert(err, gc.IsNil)
+	c.Assert(indexes, gc.HasLen, 1)
+	c.Assert(indexes[0], gc.Not(gc.Equals), state.Index)
+}
+
+func (s *StoreSearchSuite) TestReindexVerifyFailure(c *gc.C) {
+	// Add an entity directly to MongoDB, so that it is
+	// not found by the reindex but is counted.
+	err := s.store.DB.Entities().Insert(&mongodoc.Entity{
+		URL:      charm.MustParseReference("cs:~bob/trusty/ghost-1"),
+		BaseURL:  charm.MustParseReference("cs:~bob/ghost"),
+		User:     "bob",
+		Name:     "ghost",
+		Revision: 1,
+		Series:   "trusty",
+	})
+	c.Assert(err, gc.IsNil)
+	indexes, err := s.ES.ListIndexesForAlias(s.TestIndex)
+	c.Assert(err, gc.IsNil)
+	defer s.ES.DeleteDocument(versionIndex, reindexType, s.TestIndex)
+	err = s.store.ReindexElasticsearch(ReindexParams{})
+	c.Assert(err, gc.ErrorMatches, `index .* holds 5 search documents, expected 6`)
+
+	// The alias has not been moved.
+	indexes1, err := s.ES.ListIndexesForAlias(s.TestIndex)
+	c.Assert(err, gc.IsNil)
+	c.Assert(indexes1, gc.DeepEquals, indexes)
+}
@@ -0,0 +1,220 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+// Resources returns the Mongo collection where charm resources are stored.
+func (s StoreDatabase) Resources() *mgo.Collection {
+	return s.C("resources")
+}
+
+var (
+	validResourceStream = regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]*[a-z][a-z0-9]*)*(\.[a-z][a-z0-9]*(-[a-z0-9]*[a-z][a-z0-9]*)*)?$`)
+	validResourceArch   = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+)
+
+// IsValidResourceStream reports whether the given name
+// is a valid name for a resource stream.
+func IsValidResourceStream(stream string) bool {
+	return validResourceStream.MatchString(stream)
+}
+
+// IsValidResourceArch reports whether the given name
+// is a valid architecture for a resource.
+func IsValidResourceArch(arch string) bool {
+	return validResourceArch.MatchString(arch)
+}
+
+// maxResourceRevisionAttempts holds the maximum number of times
+// AddResourceRevision will try to allocate a new revision number
+// when racing against concurrent callers.
+const maxResourceRevisionAttempts = 10
+
+// AddResourceRevision creates a new revision of the given resource
+// stream for the base entity of the given charm URL and returns the
+// new revision number. The new revision has no data associated with
+// it until PutResource is called.
+func (s *Store) AddResourceRevision(url *charm.Reference, stream string) (int, error) {
+	if !IsValidResourceStream(stream) {
+		return 0, errgo.WithCausef(nil, params.ErrBadRequest, "invalid resource stream %q", stream)
+	}
+	base := baseURL(url)
+	for i := 0; i < maxResourceRevisionAttempts; i++ {
+		rev := 0
+		latest, err := s.FindResource(base, stream, -1, "")
+		switch {
+		case err == nil:
+			rev = latest.Revision + 1
+		case errgo.Cause(err) != params.ErrNotFound:
+			return 0, errgo.Mask(err)
+		}
+		err = s.DB.Resources().Insert(&mongodoc.Resource{
+			BaseURL:    base,
+			Stream:     stream,
+			Revision:   rev,
+			CreateTime: time.Now(),
+		})
+		if err == nil {
+			return rev, nil
+		}
+		if !mgo.IsDup(err) {
+			return 0, errgo.Notef(err, "cannot insert resource")
+		}
+		// Another revision was created concurrently: try again.
+	}
+	return 0, errgo.Newf("cannot allocate new revision for resource %q of %v", stream, base)
+}
+
+// FindResource returns the given revision of the resource stream
+// associated with the base entity of the given charm URL. If revision
+// is -1, the latest revision is returned. If arch is not empty, only
+// revisions with data uploaded for that architecture are considered.
+// It returns a params.ErrNotFound error if no matching resource is
+// found.
+func (s *Store) FindResource(url *charm.Reference, stream string, revision int, arch string) (*mongodoc.Resource, error) {
+	q := bson.D{{"baseurl", baseURL(url)}, {"stream", stream}}
+	if revision != -1 {
+		q = append(q, bson.DocElem{"revision", revision})
+	}
+	if arch != "" {
+		if !IsValidResourceArch(arch) {
+			return nil, errgo.WithCausef(nil, params.ErrNotFound, "resource not found")
+		}
+		q = append(q, bson.DocElem{"blobs." + arch, bson.D{{"$exists", true}}})
+	}
+	var res mongodoc.Resource
+	if err := s.DB.Resources().Find(q).Sort("-revision").One(&res); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, errgo.WithCausef(nil, params.ErrNotFound, "resource not found")
+		}
+		return nil, errgo.Notef(err, "cannot find resource %q of %v", stream, url)
+	}
+	return &res, nil
+}
+
+// Resources returns all the revisions of all the resource streams
+// associated with the base entity of the given charm URL, ordered by
+// stream name and revision.
+func (s *Store) Resources(url *charm.Reference) ([]*mongodoc.Resource, error) {
+	var docs []*mongodoc.Resource
+	if err := s.DB.Resources().Find(bson.D{{"baseurl", baseURL(url)}}).Sort("stream", "revision").All(&docs); err != nil {
+		return nil, errgo.Notef(err, "cannot retrieve resources of %v", url)
+	}
+	return docs, nil
+}
+
+// PutResource uploads the data read from r as the data for the given
+// architecture of the given resource revision. The data must have the
+// given SHA256 hash. If data has already been uploaded for the
+// architecture, PutResource succeeds only when the hash matches the
+// existing data, in which case r is not read at all.
+func (s *Store) PutResource(url *charm.Reference, stream string, revision int, arch string, r io.Reader, hash256 string) error {
+	if !IsValidResourceArch(arch) {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "invalid resource architecture %q", arch)
+	}
+	res, err := s.FindResource(url, stream, revision, "")
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if blob, ok := res.Blobs[arch]; ok {
+		return checkResourceHash(res, arch, blob, hash256)
+	}
+
+	// The blob store requires the SHA384 hash to be known before
+	// the data is uploaded, so stage the data in a temporary file
+	// while calculating the hashes.
+	f, err := ioutil.TempFile("", "charmstore-resource")
+	if err != nil {
+		return errgo.Notef(err, "cannot create temporary file")
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	hash := blobstore.NewHash()
+	sha := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash, sha), r)
+	if err != nil {
+		return errgo.Notef(err, "cannot read resource data")
+	}
+	if got := fmt.Sprintf("%x", sha.Sum(nil)); got != hash256 {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "resource hash mismatch; got %s want %s", got, hash256)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return errgo.Notef(err, "cannot seek in temporary file")
+	}
+	blob := mongodoc.ResourceBlob{
+		BlobName:    bson.NewObjectId().Hex(),
+		BlobHash:    fmt.Sprintf("%x", hash.Sum(nil)),
+		BlobHash256: hash256,
+		Size:        size,
+		UploadTime:  time.Now(),
+	}
+	if err := s.BlobStore.PutUnchallenged(f, blob.BlobName, size, blob.BlobHash); err != nil {
+		return errgo.Notef(err, "cannot put resource into blob store")
+	}
+	field := "blobs." + arch
+	err = s.DB.Resources().Update(bson.D{
+		{"baseurl", res.BaseURL},
+		{"stream", stream},
+		{"revision", revision},
+		{field, bson.D{{"$exists", false}}},
+	}, bson.D{{"$set", bson.D{{field, blob}}}})
+	if err == nil {
+		return nil
+	}
+	if err := s.BlobStore.Remove(blob.BlobName); err != nil {
+		logger.Errorf("cannot remove resource blob %s: %v", blob.BlobName, err)
+	}
+	if err != mgo.ErrNotFound {
+		return errgo.Notef(err, "cannot update resource")
+	}
+	// The data was uploaded concurrently by someone else.
+	res, err = s.FindResource(url, stream, revision, arch)
+	if err != nil {
+		return errgo.Notef(err, "cannot find concurrently uploaded resource")
+	}
+	return checkResourceHash(res, arch, res.Blobs[arch], hash256)
+}
+
+// checkResourceHash checks that the given blob, which has already been
+// uploaded for the given architecture of res, has the given SHA256 hash.
+func checkResourceHash(res *mongodoc.Resource, arch string, blob mongodoc.ResourceBlob, hash256 string) error {
+	if blob.BlobHash256 != hash256 {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "resource %s-%d/%s already uploaded with a different hash", res.Stream, res.Revision, arch)
+	}
+	return nil
+}
+
+// OpenResource opens the data uploaded for the given architecture of
+// the given resource; it returns the data source, its size and its
+// SHA256 hash. It returns a params.ErrNotFound error if no data has been
+// uploaded for the architecture.
+func (s *Store) OpenResource(res *mongodoc.Resource, arch string) (r blobstore.ReadSeekCloser, size int64, hash256 string, err error) {
+	blob, ok := res.Blobs[arch]
+	if !ok {
+		return nil, 0, "", errgo.WithCausef(nil, params.ErrNotFound, "resource not found")
+	}
+	r, size, err = s.BlobStore.Open(blob.BlobName)
+	if err != nil {
+		return nil, 0, "", errgo.Notef(err, "cannot open resource data for %s-%d/%s", res.Stream, res.Revision, arch)
+	}
+	return r, size, blob.BlobHash256, nil
+}
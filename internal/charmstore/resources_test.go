@@ -0,0 +1,112 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+)
+
+func (s *StoreSuite) TestAddResourceRevision(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	url := charm.MustParseReference("cs:~charmers/precise/wordpress-3")
+	for i := 0; i < 3; i++ {
+		rev, err := store.AddResourceRevision(url, "data.default")
+		c.Assert(err, gc.IsNil)
+		c.Assert(rev, gc.Equals, i)
+	}
+	// Revisions are allocated independently for each stream.
+	rev, err := store.AddResourceRevision(url, "other")
+	c.Assert(err, gc.IsNil)
+	c.Assert(rev, gc.Equals, 0)
+
+	// Resources are shared between all series and revisions
+	// of the charm.
+	rev, err = store.AddResourceRevision(charm.MustParseReference("cs:~charmers/trusty/wordpress-0"), "data.default")
+	c.Assert(err, gc.IsNil)
+	c.Assert(rev, gc.Equals, 3)
+
+	_, err = store.AddResourceRevision(url, "Bad-")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+}
+
+func (s *StoreSuite) TestPutAndOpenResource(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	url := charm.MustParseReference("cs:~charmers/precise/wordpress-3")
+	_, err := store.AddResourceRevision(url, "data.default")
+	c.Assert(err, gc.IsNil)
+
+	err = store.PutResource(url, "data.default", 0, "amd64", strings.NewReader("hello"), hashOf256("hello"))
+	c.Assert(err, gc.IsNil)
+
+	res, err := store.FindResource(url, "data.default", -1, "amd64")
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Revision, gc.Equals, 0)
+	c.Assert(res.Blobs, gc.HasLen, 1)
+
+	r, size, hash, err := store.OpenResource(res, "amd64")
+	c.Assert(err, gc.IsNil)
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "hello")
+	c.Assert(size, gc.Equals, int64(5))
+	c.Assert(hash, gc.Equals, hashOf256("hello"))
+
+	// No data has been uploaded for other architectures.
+	_, err = store.FindResource(url, "data.default", -1, "i386")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+	_, _, _, err = store.OpenResource(res, "i386")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+
+	// Uploading different data to the same architecture fails.
+	err = store.PutResource(url, "data.default", 0, "amd64", strings.NewReader("bye"), hashOf256("bye"))
+	c.Assert(err, gc.ErrorMatches, `resource data.default-0/amd64 already uploaded with a different hash`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+
+	// Uploading data with the wrong hash fails.
+	err = store.PutResource(url, "data.default", 0, "i386", strings.NewReader("bye"), hashOf256("hello"))
+	c.Assert(err, gc.ErrorMatches, `resource hash mismatch; got [0-9a-f]+ want [0-9a-f]+`)
+
+	// Uploading to a revision that does not exist fails.
+	err = store.PutResource(url, "data.default", 1, "amd64", strings.NewReader("bye"), hashOf256("bye"))
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
+
+func (s *StoreSuite) TestResources(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	url := charm.MustParseReference("cs:~charmers/precise/wordpress-3")
+	for _, stream := range []string{"b", "a", "b"} {
+		_, err := store.AddResourceRevision(url, stream)
+		c.Assert(err, gc.IsNil)
+	}
+	_, err := store.AddResourceRevision(charm.MustParseReference("cs:~bob/precise/wordpress-3"), "a")
+	c.Assert(err, gc.IsNil)
+
+	docs, err := store.Resources(url)
+	c.Assert(err, gc.IsNil)
+	var got []string
+	for _, doc := range docs {
+		got = append(got, fmt.Sprintf("%s-%d", doc.Stream, doc.Revision))
+	}
+	c.Assert(got, gc.DeepEquals, []string{"a-0", "b-0", "b-1"})
+}
+
+// hashOf256 returns the hex-encoded SHA256 hash of s.
+func hashOf256(s string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+}
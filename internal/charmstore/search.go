@@ -7,6 +7,9 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,13 +25,68 @@ import (
 	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
 )
 
+// SearchIndex holds an elasticsearch index used to search
+// for charms and bundles.
 type SearchIndex struct {
 	*elasticsearch.Database
 	Index string
 }
 
+var _ searchBackend = (*SearchIndex)(nil)
+
+// searchBackend is implemented by the indexes that the store can use
+// to search for charms and bundles. The index holds a SearchDoc for
+// the latest revision of each entity in each series. It is
+// implemented by SearchIndex, which uses elasticsearch, and by
+// mongoSearchIndex, which uses MongoDB.
+type searchBackend interface {
+	// search returns the entities matching sp.
+	search(sp SearchParams) (SearchResult, error)
+
+	// update stores doc in the index, unless the index
+	// holds a later revision of the same entity.
+	update(doc *SearchDoc) error
+
+	// put stores doc in the index, replacing the document
+	// for any other revision of the same entity.
+	put(doc *SearchDoc) error
+
+	// remove removes the document for any revision of the
+	// entity with the given id. It succeeds if there is
+	// no such document.
+	remove(id *charm.Reference) error
+
+	// recentDownloads returns the recent download count held
+	// in the document for any revision of the entity with the
+	// given id. It returns an error with a params.ErrNotFound
+	// cause if there is no such document.
+	recentDownloads(id *charm.Reference) (int64, error)
+
+	// ensureIndexes makes sure that the index is ready for
+	// use. If force is true, the index is created afresh.
+	ensureIndexes(force bool) error
+}
+
+// searchBackend returns the index used to search for entities,
+// or nil if search is not configured. Elasticsearch is used if it
+// is configured; otherwise MongoDB is used if enabled by the
+// MongoSearch server parameter.
+func (s *Store) searchBackend() searchBackend {
+	if s.ES != nil && s.ES.Database != nil {
+		return s.ES
+	}
+	if s.pool.config.MongoSearch {
+		return &mongoSearchIndex{s.DB}
+	}
+	return nil
+}
+
 const typeName = "entity"
 
+// defaultSearchLimit holds the number of results returned
+// when no limit is specified, which is the same as elasticsearch.
+const defaultSearchLimit = 10
+
 // seriesBoost defines how much the results for each
 // series will be boosted. Series are currently ranked in
 // reverse order of LTS releases, followed by the latest
@@ -63,7 +121,21 @@ var deprecatedSeries = map[string]bool{
 type SearchDoc struct {
 	*mongodoc.Entity
 	TotalDownloads int64
-	ReadACLs       []string
+	// RecentDownloads holds the number of downloads of all
+	// revisions of the entity in the last week. It is used to
+	// rank trending entities.
+	RecentDownloads int64
+	ReadACLs        []string
+	// Channels holds the names of the channels that a revision
+	// of the entity with the same series has been published to.
+	Channels []string
+	// Tags holds the categories and tags of a charm, or the
+	// tags of a bundle, so that they can be counted together.
+	Tags []string
+	// ConfigOptions and ActionNames hold the sorted names
+	// of the config options and actions of a charm.
+	ConfigOptions []string
+	ActionNames   []string
 }
 
 // UpdateSearchAsync will update the search record for the entity
@@ -80,41 +152,56 @@ func (s *Store) UpdateSearchAsync(r *router.ResolvedURL) {
 // The search index only includes the latest revision of each entity so
 // the latest revision of the charm specified by r will be indexed.
 func (s *Store) UpdateSearch(r *router.ResolvedURL) error {
-	if s.ES == nil || s.ES.Database == nil {
+	if s.searchBackend() == nil {
 		return nil
 	}
 	if deprecatedSeries[r.URL.Series] {
 		return nil
 	}
 
+	doc, err := s.latestSearchDoc(r)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if err := s.searchBackend().update(doc); err != nil {
+		return errgo.Notef(err, "cannot update search record for %q", doc.URL)
+	}
+	return nil
+}
+
+// latestSearchDoc returns the search document for the latest
+// revision of the entity with the same user, name and series as r.
+func (s *Store) latestSearchDoc(r *router.ResolvedURL) (*SearchDoc, error) {
 	var query *mgo.Query
 	query = s.DB.Entities().Find(bson.D{
 		{"user", r.URL.User},
 		{"name", r.URL.Name},
 		{"series", r.URL.Series},
+		{"trashtime", bson.D{{"$exists", false}}},
 	}).Sort("-revision")
 	var entity mongodoc.Entity
 	if err := query.One(&entity); err != nil {
 		if err == mgo.ErrNotFound {
-			return errgo.WithCausef(nil, params.ErrNotFound, "entity not found %s", r)
+			return nil, errgo.WithCausef(nil, params.ErrNotFound, "entity not found %s", r)
 		}
-		return errgo.Notef(err, "cannot get %s", r)
+		return nil, errgo.Notef(err, "cannot get %s", r)
 	}
 	baseEntity, err := s.FindBaseEntity(entity.BaseURL)
 	if err != nil {
-		return errgo.Notef(err, "cannot get %s", entity.BaseURL)
+		return nil, errgo.Notef(err, "cannot get %s", entity.BaseURL)
 	}
-	if err := s.updateSearchEntity(&entity, baseEntity); err != nil {
-		return errgo.Notef(err, "cannot update search record for %q", entity.URL)
+	doc, err := s.searchDocFromEntity(&entity, baseEntity)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot create search document for %q", entity.URL)
 	}
-	return nil
+	return doc, nil
 }
 
 // UpdateSearchBaseURL updates the search record for all entities with
 // the specified base URL. It must be called whenever the entry for the
 // given URL in the BaseEntitites collection has changed.
 func (s *Store) UpdateSearchBaseURL(baseURL *charm.Reference) error {
-	if s.ES == nil || s.ES.Database == nil {
+	if s.searchBackend() == nil {
 		return nil
 	}
 	if baseURL.Series != "" {
@@ -125,44 +212,65 @@ func (s *Store) UpdateSearchBaseURL(baseURL *charm.Reference) error {
 	}
 	// From the entities with the specified base URL find the latest revision in
 	// each of the available series.
-	//
-	// Note: It is possible to return the complete entity here and save some
-	// database round trips. Unfortunately the version of mongoDB we support
-	// (2.4) would require every field to be enumerated in this query, which
-	// would make it too fragile.
-	iter := s.DB.Entities().Pipe([]bson.D{
-		{{"$match", bson.D{{"baseurl", baseURL}}}},
-		{{"$sort", bson.D{{"revision", 1}}}},
-		{{"$group", bson.D{
-			{"_id", "$series"},
-			{"url", bson.D{{"$last", "$_id"}}},
-		}}},
-	}).Iter()
-	defer iter.Close()
-	var result struct {
-		URL *charm.Reference
+	urls, err := s.latestSeriesURLs(baseURL)
+	if err != nil {
+		return errgo.Mask(err)
 	}
-	for iter.Next(&result) {
-		if deprecatedSeries[result.URL.Series] {
-			continue
-		}
-		if err := s.UpdateSearch(&router.ResolvedURL{URL: *result.URL, PromulgatedRevision: -1}); err != nil {
-			return errgo.Notef(err, "cannot update search record for %q", result.URL)
+	for _, url := range urls {
+		if err := s.UpdateSearch(&router.ResolvedURL{URL: *url, PromulgatedRevision: -1}); err != nil {
+			return errgo.Notef(err, "cannot update search record for %q", url)
 		}
 	}
-	if err := iter.Close(); err != nil {
-		return errgo.Mask(err)
-	}
 	return nil
 }
 
-func (s *Store) updateSearchEntity(entity *mongodoc.Entity, baseEntity *mongodoc.BaseEntity) error {
-	doc, err := s.searchDocFromEntity(entity, baseEntity)
+// updateSearchAfterTrash updates the search record for the entity
+// with the given URL after it, or another revision with the same
+// series, has been moved into or out of the trash. If no untrashed
+// revisions remain, the search record is removed.
+func (s *Store) updateSearchAfterTrash(url *charm.Reference) error {
+	backend := s.searchBackend()
+	if backend == nil {
+		return nil
+	}
+	return s.updateBackendAfterTrash(backend, url)
+}
+
+// updateBackendAfterTrash is like updateSearchAfterTrash
+// but updates the given search backend.
+func (s *Store) updateBackendAfterTrash(backend searchBackend, url *charm.Reference) error {
+	if deprecatedSeries[url.Series] {
+		return nil
+	}
+	var entity mongodoc.Entity
+	err := s.DB.Entities().Find(bson.D{
+		{"user", url.User},
+		{"name", url.Name},
+		{"series", url.Series},
+		{"trashtime", bson.D{{"$exists", false}}},
+	}).Sort("-revision").One(&entity)
+	if err == mgo.ErrNotFound {
+		if err := backend.remove(url); err != nil {
+			return errgo.Notef(err, "cannot remove search record for %q", url)
+		}
+		return nil
+	}
+	if err != nil {
+		return errgo.Notef(err, "cannot get %s", url)
+	}
+	baseEntity, err := s.FindBaseEntity(entity.BaseURL)
+	if err != nil {
+		return errgo.Notef(err, "cannot get %s", entity.BaseURL)
+	}
+	doc, err := s.searchDocFromEntity(&entity, baseEntity)
 	if err != nil {
 		return errgo.Mask(err)
 	}
-	if err := s.ES.update(doc); err != nil {
-		return errgo.Notef(err, "cannot update search index")
+	// The indexed revision may be newer than the one that is now the
+	// latest, so replace it rather than relying on the revision
+	// to order the updates.
+	if err := backend.put(doc); err != nil {
+		return errgo.Notef(err, "cannot update search record for %q", entity.URL)
 	}
 	return nil
 }
@@ -170,7 +278,7 @@ func (s *Store) updateSearchEntity(entity *mongodoc.Entity, baseEntity *mongodoc
 // UpdateSearchFields updates the search record for the entity reference r
 // with the updated values in fields.
 func (s *Store) UpdateSearchFields(r *router.ResolvedURL, fields map[string]interface{}) error {
-	if s.ES == nil || s.ES.Database == nil {
+	if s.searchBackend() == nil {
 		return nil
 	}
 	var needUpdate bool
@@ -195,6 +303,11 @@ func (s *Store) UpdateSearchFields(r *router.ResolvedURL, fields map[string]inte
 func (s *Store) searchDocFromEntity(e *mongodoc.Entity, be *mongodoc.BaseEntity) (*SearchDoc, error) {
 	doc := SearchDoc{Entity: e}
 	doc.ReadACLs = be.ACLs.Read
+	doc.Channels = entityChannels(e, be)
+	doc.Tags = entityTags(e)
+	doc.ConfigOptions = entityConfigOptions(e)
+	doc.ActionNames = entityActionNames(e)
+	s.populateCharmHooks(e)
 	// There should only be one record for the promulgated entity, which
 	// should be the latest promulgated revision. In the case that the base
 	// entity is not promulgated assume that there is a later promulgated
@@ -208,9 +321,89 @@ func (s *Store) searchDocFromEntity(e *mongodoc.Entity, be *mongodoc.BaseEntity)
 		return nil, errgo.Mask(err)
 	}
 	doc.TotalDownloads = allRevisions.Total
+	doc.RecentDownloads = allRevisions.LastWeek
 	return &doc, nil
 }
 
+// populateCharmHooks fills in the hooks of the given charm entity
+// if they have never been recorded, which is the case for charms
+// uploaded before hooks were stored. The hooks are read from the
+// charm archive and saved in the entity, so this happens at most
+// once for each charm. Entities whose archive cannot be read are
+// logged and left unchanged.
+func (s *Store) populateCharmHooks(e *mongodoc.Entity) {
+	if e.CharmMeta == nil || e.CharmHooks != nil || e.BlobName == "" {
+		return
+	}
+	hooks, err := s.blobHooks(e.BlobName)
+	if err != nil {
+		logger.Errorf("cannot read hooks for entity %s: %v", e.URL, err)
+		return
+	}
+	if hooks == nil {
+		hooks = []string{}
+	}
+	if err := s.DB.Entities().UpdateId(e.URL, bson.D{{
+		"$set", bson.D{{"charmhooks", hooks}},
+	}}); err != nil {
+		logger.Errorf("cannot save hooks for entity %s: %v", e.URL, err)
+	}
+	e.CharmHooks = hooks
+}
+
+// blobHooks returns the names of the hooks in
+// the charm archive with the given blob name.
+func (s *Store) blobHooks(name string) ([]string, error) {
+	r, size, err := s.BlobStore.Open(name)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer r.Close()
+	return ArchiveHooks(ReaderAtSeeker(r), size)
+}
+
+// entityTags returns the categories and tags of the given
+// charm, or the tags of the given bundle.
+func entityTags(e *mongodoc.Entity) []string {
+	var tags []string
+	if e.CharmMeta != nil {
+		tags = append(tags, e.CharmMeta.Categories...)
+		tags = append(tags, e.CharmMeta.Tags...)
+	}
+	if e.BundleData != nil {
+		tags = append(tags, e.BundleData.Tags...)
+	}
+	return tags
+}
+
+// entityConfigOptions returns the sorted names
+// of the config options of the given charm.
+func entityConfigOptions(e *mongodoc.Entity) []string {
+	if e.CharmConfig == nil {
+		return nil
+	}
+	names := make([]string, 0, len(e.CharmConfig.Options))
+	for name := range e.CharmConfig.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// entityActionNames returns the sorted names
+// of the actions of the given charm.
+func entityActionNames(e *mongodoc.Entity) []string {
+	if e.CharmActions == nil {
+		return nil
+	}
+	names := make([]string, 0, len(e.CharmActions.ActionSpecs))
+	for name := range e.CharmActions.ActionSpecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // update inserts an entity into elasticsearch if elasticsearch
 // is configured. The entity with id r is extracted from mongodb
 // and written into elasticsearch.
@@ -231,6 +424,42 @@ func (si *SearchIndex) update(doc *SearchDoc) error {
 	return nil
 }
 
+// put implements searchBackend.put.
+func (si *SearchIndex) put(doc *SearchDoc) error {
+	err := si.PutDocumentVersionWithType(
+		si.Index,
+		typeName,
+		si.getID(doc.URL),
+		int64(doc.URL.Revision),
+		elasticsearch.Force,
+		doc)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// remove implements searchBackend.remove.
+func (si *SearchIndex) remove(id *charm.Reference) error {
+	err := si.DeleteDocument(si.Index, typeName, si.getID(id))
+	if err != nil && err != elasticsearch.ErrNotFound {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// recentDownloads implements searchBackend.recentDownloads.
+func (si *SearchIndex) recentDownloads(id *charm.Reference) (int64, error) {
+	doc, err := si.GetSearchDocument(id)
+	if errgo.Cause(err) == elasticsearch.ErrNotFound {
+		return 0, errgo.WithCausef(nil, params.ErrNotFound, "no search document for %s", id)
+	}
+	if err != nil {
+		return 0, errgo.Mask(err)
+	}
+	return doc.RecentDownloads, nil
+}
+
 // getID returns an ID for the elasticsearch document based on the contents of the
 // mongoDB document. This is to allow elasticsearch documents to be replaced with
 // updated versions when charm data is changed.
@@ -261,6 +490,12 @@ func (si *SearchIndex) search(sp SearchParams) (SearchResult, error) {
 		Total:      esr.Hits.Total,
 		Results:    make([]*router.ResolvedURL, 0, len(esr.Hits.Hits)),
 	}
+	for _, name := range sp.Facets {
+		if r.Facets == nil {
+			r.Facets = make(map[string][]FacetCount)
+		}
+		r.Facets[name] = facets[name].counts(esr.Hits.Total, facetAggregationResults(name, esr.Aggregations))
+	}
 	for _, h := range esr.Hits.Hits {
 		urlStr := h.Fields.GetString("URL")
 		url, err := charm.ParseReference(urlStr)
@@ -281,12 +516,121 @@ func (si *SearchIndex) search(sp SearchParams) (SearchResult, error) {
 			id.PromulgatedRevision = -1
 		}
 		r.Results = append(r.Results, id)
+		if q.Highlight != nil {
+			r.Highlights = append(r.Highlights, searchHighlights(h.Highlight))
+		}
+	}
+	if r.Total < fewResults {
+		r.Suggestions, err = si.suggestions(sp, esr.Suggest)
+		if err != nil {
+			return SearchResult{}, errgo.Mask(err)
+		}
 	}
 	return r, nil
 }
 
+// suggestions returns the suggested search text for a search with the
+// given parameters, built from the results of its term suggesters.
+// Suggesters consider every document in the index, so suggested terms
+// that are not held by any entity the user is allowed to read are
+// discarded.
+func (si *SearchIndex) suggestions(sp SearchParams, results map[string][]elasticsearch.SuggestResult) ([]string, error) {
+	var candidates []suggestion
+	for _, field := range suggestFields {
+		for _, sr := range results[field] {
+			start, end, ok := utf16Span(sp.Text, sr.Offset, sr.Length)
+			if !ok {
+				continue
+			}
+			for _, o := range sr.Options {
+				candidates = append(candidates, suggestion{
+					text:  sp.Text[:start] + o.Text + sp.Text[end:],
+					field: field,
+					term:  o.Text,
+					score: o.Score,
+				})
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	filter := createFilters(nil, sp.Admin, sp.Groups)
+	if sp.Channel != "" {
+		filter = elasticsearch.AndFilter{filter, elasticsearch.TermFilter{
+			Field: "Channels",
+			Value: sp.Channel,
+		}}
+	}
+	q := elasticsearch.QueryDSL{
+		Fields: []string{"URL"},
+		Query: elasticsearch.FilteredQuery{
+			Query:  elasticsearch.MatchAllQuery{},
+			Filter: filter,
+		},
+		Aggregations: make(map[string]elasticsearch.Aggregation),
+	}
+	for i, c := range candidates {
+		q.Aggregations[fmt.Sprint(i)] = elasticsearch.FilterAggregation{
+			Filter: elasticsearch.TermFilter{
+				Field: c.field,
+				Value: c.term,
+			},
+		}
+	}
+	esr, err := si.Search(si.Index, typeName, q)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot check suggestions")
+	}
+	readable := candidates[:0]
+	for i, c := range candidates {
+		if esr.Aggregations[fmt.Sprint(i)].DocCount > 0 {
+			readable = append(readable, c)
+		}
+	}
+	return sortedSuggestions(readable), nil
+}
+
+// utf16Span returns the byte indexes in s of the span starting at the
+// given offset with the given length, both counted in UTF-16 code
+// units as elasticsearch reports them. It returns false if the span
+// is out of range or does not fall on character boundaries.
+func utf16Span(s string, offset, length int) (start, end int, ok bool) {
+	if offset < 0 || length < 0 {
+		return 0, 0, false
+	}
+	start, end = -1, -1
+	units := 0
+	for i, r := range s {
+		if units == offset {
+			start = i
+		}
+		if units == offset+length {
+			end = i
+			break
+		}
+		if r >= 0x10000 {
+			// The character is encoded as a surrogate pair.
+			units += 2
+		} else {
+			units++
+		}
+	}
+	if units == offset && start == -1 {
+		start = len(s)
+	}
+	if units == offset+length && end == -1 {
+		end = len(s)
+	}
+	if start == -1 || end == -1 {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
 // GetSearchDocument retrieves the current search record for the charm
-// reference id.
+// reference id. If there is no such record, an error with an
+// elasticsearch.ErrNotFound cause is returned.
 func (si *SearchIndex) GetSearchDocument(id *charm.Reference) (*SearchDoc, error) {
 	if si == nil || si.Database == nil {
 		return &SearchDoc{}, nil
@@ -294,7 +638,7 @@ func (si *SearchIndex) GetSearchDocument(id *charm.Reference) (*SearchDoc, error
 	var s SearchDoc
 	err := si.GetDocument(si.Index, "entity", si.getID(id), &s)
 	if err != nil {
-		return nil, errgo.Notef(err, "cannot retrieve search document for %v", id)
+		return nil, errgo.NoteMask(err, fmt.Sprintf("cannot retrieve search document for %v", id), errgo.Is(elasticsearch.ErrNotFound))
 	}
 	return &s, nil
 }
@@ -408,18 +752,21 @@ func (si *SearchIndex) updateVersion(v version, dv int64) (bool, error) {
 	return true, nil
 }
 
-// syncSearch populates the SearchIndex with all the data currently stored in
-// mongodb. If the SearchIndex is not configured then this method returns a nil error.
+// syncSearch populates the search index with all the data currently stored in
+// mongodb. If search is not configured then this method returns a nil error.
 func (s *Store) syncSearch() error {
-	if s.ES == nil || s.ES.Database == nil {
+	if s.searchBackend() == nil {
 		return nil
 	}
 	var result mongodoc.Entity
 	// Only get the IDs here, UpdateSearch will get the full document
 	// if it is in a series that is indexed.
-	iter := s.DB.Entities().Find(nil).Select(bson.M{"_id": 1, "promulgated-url": 1}).Iter()
+	iter := s.DB.Entities().Find(nil).Select(bson.M{"_id": 1, "promulgated-url": 1, "trashtime": 1}).Iter()
 	defer iter.Close() // Make sure we always close on error.
 	for iter.Next(&result) {
+		if result.TrashTime != nil {
+			continue
+		}
 		rurl := EntityResolvedURL(&result)
 		if err := s.UpdateSearch(rurl); err != nil {
 			return errgo.Notef(err, "cannot index %s", rurl)
@@ -439,6 +786,8 @@ type SearchParams struct {
 	// bundles with a name that has text as a prefix.
 	AutoComplete bool
 	// Limit the search to items with attributes that match the specified filter value.
+	// A filter name prefixed with a hyphen, such as "-series", excludes the items
+	// that match any of its values instead.
 	Filters map[string][]string
 	// Limit the number of returned items to the specified count.
 	Limit int
@@ -452,6 +801,15 @@ type SearchParams struct {
 	// Admin searches will not filter on the ACL and will show results for all matching
 	// charms.
 	Admin bool
+	// Channel, if not empty, restricts the search to entities
+	// published to the given channel.
+	Channel string
+	// Facets holds the names of the facets to count over all
+	// the matching items. See ParseFacets.
+	Facets []string
+	// Highlight specifies that snippets of the fields that
+	// matched the text are returned for each result.
+	Highlight bool
 	// Sort the returned items.
 	sort []sortParam
 }
@@ -475,6 +833,18 @@ func (sp *SearchParams) ParseSortFields(f ...string) error {
 	return nil
 }
 
+// ParseFacets adds the given facet names to sp.Facets.
+// The available facets are "owner", "series", "tags" and "type".
+func (sp *SearchParams) ParseFacets(f ...string) error {
+	for _, name := range f {
+		if facets[name] == nil {
+			return errgo.Newf("%s", name)
+		}
+		sp.Facets = append(sp.Facets, name)
+	}
+	return nil
+}
+
 // sortOrder defines the order in which a field should be sorted.
 type sortOrder int
 
@@ -495,6 +865,7 @@ var sortFields = map[string]string{
 	"owner":     "User",
 	"series":    "Series",
 	"downloads": "TotalDownloads",
+	"trending":  "RecentDownloads",
 }
 
 // SearchResult represents the result of performing a search.
@@ -502,6 +873,25 @@ type SearchResult struct {
 	SearchTime time.Duration
 	Total      int
 	Results    []*router.ResolvedURL
+	// Facets holds the counts for each facet requested
+	// in SearchParams.Facets, keyed by facet name.
+	Facets map[string][]FacetCount
+	// Suggestions holds alternative search text, most likely
+	// first, when a text search matches few entities.
+	Suggestions []string
+	// Highlights holds, when SearchParams.Highlight is set for
+	// a text search, the highlighted snippets for each result,
+	// keyed by field name (see highlightFields). Highlights[i]
+	// holds the snippets for Results[i] and is nil when there
+	// are none.
+	Highlights []map[string][]string
+}
+
+// FacetCount holds the number of items
+// matching a search with a given facet value.
+type FacetCount struct {
+	Value string
+	Count int
 }
 
 // queryFields provides a map of fields to weighting to use with the
@@ -515,6 +905,9 @@ func queryFields(sp SearchParams) map[string]float64 {
 		"Series.ngrams":           5,
 		"CharmProvidedInterfaces": 3,
 		"CharmRequiredInterfaces": 3,
+		"ConfigOptions":           2,
+		"ActionNames":             2,
+		"CharmHooks":              1,
 		"CharmMeta.Description":   1,
 		"BundleReadMe":            1,
 	}
@@ -564,6 +957,13 @@ func createSearchDSL(sp SearchParams) elasticsearch.QueryDSL {
 			Factor:   0.000001,
 			Modifier: "ln2p",
 		},
+		// Entities that have been downloaded a lot recently get a
+		// further boost so that newly popular ones can surface.
+		elasticsearch.FieldValueFactorFunction{
+			Field:    "RecentDownloads",
+			Factor:   0.0001,
+			Modifier: "ln2p",
+		},
 		elasticsearch.BoostFactorFunction{
 			Filter:      promulgatedFilter("1"),
 			BoostFactor: 1.25,
@@ -581,9 +981,16 @@ func createSearchDSL(sp SearchParams) elasticsearch.QueryDSL {
 	}
 
 	// Filters
+	filter := createFilters(sp.Filters, sp.Admin, sp.Groups)
+	if sp.Channel != "" {
+		filter = elasticsearch.AndFilter{filter, elasticsearch.TermFilter{
+			Field: "Channels",
+			Value: sp.Channel,
+		}}
+	}
 	qdsl.Query = elasticsearch.FilteredQuery{
 		Query:  q,
-		Filter: createFilters(sp.Filters, sp.Admin, sp.Groups),
+		Filter: filter,
 	}
 
 	// Sorting
@@ -591,6 +998,44 @@ func createSearchDSL(sp SearchParams) elasticsearch.QueryDSL {
 		qdsl.Sort = append(qdsl.Sort, createSort(s))
 	}
 
+	// Facets
+	for _, name := range sp.Facets {
+		f := facets[name]
+		if f == nil {
+			continue
+		}
+		if qdsl.Aggregations == nil {
+			qdsl.Aggregations = make(map[string]elasticsearch.Aggregation)
+		}
+		for k, agg := range f.aggregations() {
+			qdsl.Aggregations[name+"-"+k] = agg
+		}
+	}
+
+	// Suggestions
+	if sp.Text != "" {
+		qdsl.Suggest = make(map[string]elasticsearch.Suggester)
+		for _, field := range suggestFields {
+			qdsl.Suggest[field] = elasticsearch.TermSuggester{
+				Text:     sp.Text,
+				Field:    field,
+				Analyzer: "standard",
+				Size:     maxSuggestions,
+			}
+		}
+	}
+
+	// Highlighting
+	if sp.Highlight && sp.Text != "" {
+		qdsl.Highlight = &elasticsearch.Highlight{
+			FragmentSize:      highlightFragmentSize,
+			NumberOfFragments: maxHighlightFragments,
+		}
+		for _, f := range highlightFields {
+			qdsl.Highlight.Fields = append(qdsl.Highlight.Fields, f.field)
+		}
+	}
+
 	return qdsl
 }
 
@@ -600,12 +1045,14 @@ func createSearchDSL(sp SearchParams) elasticsearch.QueryDSL {
 // for details of how filters are specified in the API. For each key in f a
 // filter is created that matches any one of the set of values specified for
 // that key. The created filter will only match when at least one of the
-// requested values matches for all of the requested keys. Any filter names
+// requested values matches for all of the requested keys, and none of the
+// values matches for any key prefixed with a hyphen. Any filter names
 // that are not defined in the filters map will be silently skipped.
 func createFilters(f map[string][]string, admin bool, groups []string) elasticsearch.Filter {
 	af := make(elasticsearch.AndFilter, 0, len(f)+1)
 	for k, vals := range f {
-		filter, ok := filters[k]
+		name := strings.TrimPrefix(k, "-")
+		filter, ok := filters[name]
 		if !ok {
 			continue
 		}
@@ -613,6 +1060,10 @@ func createFilters(f map[string][]string, admin bool, groups []string) elasticse
 		for _, v := range vals {
 			of = append(of, filter(v))
 		}
+		if name != k {
+			af = append(af, elasticsearch.NotFilter{of})
+			continue
+		}
 		af = append(af, of)
 	}
 	if admin {
@@ -637,16 +1088,29 @@ func createFilters(f map[string][]string, admin bool, groups []string) elasticse
 // function that will generate an elasticsearch query DSL filter for the
 // given value.
 var filters = map[string]func(string) elasticsearch.Filter{
-	"description": descriptionFilter,
-	"name":        nameFilter,
-	"owner":       ownerFilter,
-	"promulgated": promulgatedFilter,
-	"provides":    termFilter("CharmProvidedInterfaces"),
-	"requires":    termFilter("CharmRequiredInterfaces"),
-	"series":      seriesFilter,
-	"summary":     summaryFilter,
-	"tags":        tagsFilter,
-	"type":        typeFilter,
+	"action":         termFilter("ActionNames"),
+	"config-option":  termFilter("ConfigOptions"),
+	"description":    descriptionFilter,
+	"downloads-min":  minFilter("downloads-min", "TotalDownloads"),
+	"hook":           termFilter("CharmHooks"),
+	"machines-max":   maxFilter("machines-max", "BundleMachineCount"),
+	"name":           nameFilter,
+	"owner":          ownerFilter,
+	"promulgated":    promulgatedFilter,
+	"provides":       termFilter("CharmProvidedInterfaces"),
+	"requires":       termFilter("CharmRequiredInterfaces"),
+	"series":         seriesFilter,
+	"summary":        summaryFilter,
+	"tags":           tagsFilter,
+	"type":           typeFilter,
+	"units-max":      maxFilter("units-max", "BundleUnitCount"),
+	"uploaded-since": minFilter("uploaded-since", "UploadTime"),
+}
+
+// IsFilter reports whether name is the name of a search filter,
+// such as "series" or "-series".
+func IsFilter(name string) bool {
+	return filters[strings.TrimPrefix(name, "-")] != nil
 }
 
 // descriptionFilter generates a filter that will match against the
@@ -699,13 +1163,20 @@ func promulgatedFilter(value string) elasticsearch.Filter {
 }
 
 // seriesFilter generates a filter that will match against the
-// series taken from the URL.
+// series taken from the URL, or against any of the supported
+// series of a multi-series charm.
 func seriesFilter(value string) elasticsearch.Filter {
-	return elasticsearch.QueryFilter{
-		Query: elasticsearch.MatchQuery{
-			Field: "Series",
-			Query: value,
-			Type:  "phrase",
+	return elasticsearch.OrFilter{
+		elasticsearch.QueryFilter{
+			Query: elasticsearch.MatchQuery{
+				Field: "Series",
+				Query: value,
+				Type:  "phrase",
+			},
+		},
+		elasticsearch.TermFilter{
+			Field: "SupportedSeries",
+			Value: value,
 		},
 	}
 }
@@ -769,6 +1240,96 @@ func termFilter(field string) func(string) elasticsearch.Filter {
 	}
 }
 
+// minFilter creates a function that generates a filter requiring
+// the specified document field to be at least the value of the
+// named range filter.
+func minFilter(name, field string) func(string) elasticsearch.Filter {
+	return func(value string) elasticsearch.Filter {
+		return elasticsearch.RangeFilter{
+			Field: field,
+			GTE:   rangeFilterValue(name, value),
+		}
+	}
+}
+
+// maxFilter creates a function that generates a filter requiring
+// the specified document field to be at most the value of the
+// named range filter.
+func maxFilter(name, field string) func(string) elasticsearch.Filter {
+	return func(value string) elasticsearch.Filter {
+		return elasticsearch.RangeFilter{
+			Field: field,
+			LTE:   rangeFilterValue(name, value),
+		}
+	}
+}
+
+// rangeFilterParsers holds the functions that parse the values of
+// the filters that restrict a field to a range, keyed by filter name.
+var rangeFilterParsers = map[string]func(string) (interface{}, error){
+	"downloads-min":  parseRangeCount,
+	"machines-max":   parseRangeCount,
+	"units-max":      parseRangeCount,
+	"uploaded-since": parseRangeTime,
+}
+
+// ParseRangeFilter checks the given values of the range filter with
+// the given name, for example "units-max", and adds them to
+// sp.Filters. As for other filters, an entity matches if it matches
+// any of the values, and the name may be prefixed with a hyphen to
+// exclude the matching entities.
+func (sp *SearchParams) ParseRangeFilter(name string, values ...string) error {
+	parse := rangeFilterParsers[strings.TrimPrefix(name, "-")]
+	if parse == nil {
+		return errgo.Newf("%s is not a range filter", name)
+	}
+	for _, v := range values {
+		if _, err := parse(v); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	if sp.Filters == nil {
+		sp.Filters = make(map[string][]string)
+	}
+	sp.Filters[name] = append(sp.Filters[name], values...)
+	return nil
+}
+
+// rangeFilterValue returns the bound of the named range filter for
+// the given value. Values are checked by ParseRangeFilter, so an
+// invalid value can only come from within the charm store; it is
+// passed on unparsed for the search backend to reject.
+func rangeFilterValue(name, value string) interface{} {
+	v, err := rangeFilterParsers[name](value)
+	if err != nil {
+		logger.Errorf("invalid value for %s filter: %v", name, err)
+		return value
+	}
+	return v
+}
+
+// parseRangeCount parses a non-negative integer range filter value.
+func parseRangeCount(value string) (interface{}, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return nil, errgo.Newf("expected non-negative integer, got %q", value)
+	}
+	return n, nil
+}
+
+// parseRangeTime parses a range filter value holding either a date,
+// such as "2015-06-01", or a time in RFC 3339 format.
+func parseRangeTime(value string) (interface{}, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errgo.Newf("expected date or RFC 3339 time, got %q", value)
+	}
+	return t.UTC(), nil
+}
+
 // bundleFilter is a filter that matches against bundles, based on
 // the URL.
 var bundleFilter = seriesFilter("bundle")
@@ -782,6 +1343,216 @@ func typeFilter(value string) elasticsearch.Filter {
 	return elasticsearch.NotFilter{bundleFilter}
 }
 
+// maxFacetValues holds the maximum number of values
+// counted for a facet.
+const maxFacetValues = 100
+
+// facet represents a facet that may be requested in SearchParams.Facets.
+// The aggregations for a facet are computed on the same filtered query
+// as the search itself, so the counts honour the filters and ACLs of
+// the search.
+type facet interface {
+	// aggregations returns the elasticsearch aggregations
+	// needed to count the facet, keyed by name.
+	aggregations() map[string]elasticsearch.Aggregation
+
+	// counts returns the facet counts given the total number of
+	// matching documents and the results of the aggregations
+	// returned by aggregations.
+	counts(total int, results map[string]elasticsearch.AggregationResult) []FacetCount
+}
+
+// facets contains a mapping from a facet name in the API
+// to the facet that counts it.
+var facets = map[string]facet{
+	"owner": termsFacet{"User"},
+	// A document never holds both a series and supported series,
+	// so the counts for the two fields can be added together.
+	"series": termsFacet{"Series", "SupportedSeries"},
+	"tags":   termsFacet{"Tags"},
+	"type":   typeFacet{},
+}
+
+// facetAggregationResults returns the results of the aggregations
+// for the facet with the given name, keyed as returned by the
+// facet's aggregations method.
+func facetAggregationResults(name string, results map[string]elasticsearch.AggregationResult) map[string]elasticsearch.AggregationResult {
+	facetResults := make(map[string]elasticsearch.AggregationResult)
+	for k, r := range results {
+		if strings.HasPrefix(k, name+"-") {
+			facetResults[strings.TrimPrefix(k, name+"-")] = r
+		}
+	}
+	return facetResults
+}
+
+// termsFacet is a facet that counts the values
+// of the document fields it holds.
+type termsFacet []string
+
+func (f termsFacet) aggregations() map[string]elasticsearch.Aggregation {
+	aggs := make(map[string]elasticsearch.Aggregation)
+	for _, field := range f {
+		aggs[field] = elasticsearch.TermsAggregation{
+			Field: field,
+			Size:  maxFacetValues,
+		}
+	}
+	return aggs
+}
+
+func (f termsFacet) counts(total int, results map[string]elasticsearch.AggregationResult) []FacetCount {
+	counts := make(map[string]int)
+	for _, field := range f {
+		for _, b := range results[field].Buckets {
+			if b.Key != "" {
+				counts[b.Key] += b.DocCount
+			}
+		}
+	}
+	return sortedFacetCounts(counts)
+}
+
+// typeFacet is a facet that counts charms and bundles.
+type typeFacet struct{}
+
+func (typeFacet) aggregations() map[string]elasticsearch.Aggregation {
+	return map[string]elasticsearch.Aggregation{
+		"bundle": elasticsearch.FilterAggregation{
+			Filter: bundleFilter,
+		},
+	}
+}
+
+func (typeFacet) counts(total int, results map[string]elasticsearch.AggregationResult) []FacetCount {
+	bundles := results["bundle"].DocCount
+	return sortedFacetCounts(map[string]int{
+		"bundle": bundles,
+		"charm":  total - bundles,
+	})
+}
+
+// sortedFacetCounts returns the given non-zero counts, keyed
+// by value, ordered by descending count and then by value.
+func sortedFacetCounts(counts map[string]int) []FacetCount {
+	fcs := make([]FacetCount, 0, len(counts))
+	for v, n := range counts {
+		if n > 0 {
+			fcs = append(fcs, FacetCount{
+				Value: v,
+				Count: n,
+			})
+		}
+	}
+	sort.Sort(facetCountsByCount(fcs))
+	return fcs
+}
+
+type facetCountsByCount []FacetCount
+
+func (s facetCountsByCount) Len() int      { return len(s) }
+func (s facetCountsByCount) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s facetCountsByCount) Less(i, j int) bool {
+	if s[i].Count != s[j].Count {
+		return s[i].Count > s[j].Count
+	}
+	return s[i].Value < s[j].Value
+}
+
+// fewResults holds the number of matching entities below
+// which a text search returns suggestions.
+const fewResults = 3
+
+// maxSuggestions holds the maximum number of
+// suggestions returned for a search.
+const maxSuggestions = 5
+
+// suggestFields holds the fields from which
+// the terms of suggestions are taken.
+var suggestFields = []string{"Name", "Tags"}
+
+// suggestion represents a possible suggestion for a search.
+type suggestion struct {
+	// text holds the suggested search text.
+	text string
+	// field and term hold the field and the suggested
+	// term that replaces a term of the original text.
+	field string
+	term  string
+	// score holds how close the term is to the
+	// one it replaces, between 0 and 1.
+	score float64
+}
+
+// sortedSuggestions returns the distinct text of the given suggestions,
+// ordered by descending score and then by text. At most
+// maxSuggestions are returned.
+func sortedSuggestions(ss []suggestion) []string {
+	sort.Sort(suggestionsByScore(ss))
+	var texts []string
+	seen := make(map[string]bool)
+	for _, s := range ss {
+		if seen[s.text] {
+			continue
+		}
+		seen[s.text] = true
+		texts = append(texts, s.text)
+		if len(texts) == maxSuggestions {
+			break
+		}
+	}
+	return texts
+}
+
+type suggestionsByScore []suggestion
+
+func (s suggestionsByScore) Len() int      { return len(s) }
+func (s suggestionsByScore) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s suggestionsByScore) Less(i, j int) bool {
+	if s[i].score != s[j].score {
+		return s[i].score > s[j].score
+	}
+	return s[i].text < s[j].text
+}
+
+// highlightFragmentSize and maxHighlightFragments hold the
+// approximate length in characters of a highlighted snippet and
+// the maximum number of snippets returned for each field.
+const (
+	highlightFragmentSize = 100
+	maxHighlightFragments = 3
+)
+
+// highlightFields holds the fields that are highlighted when
+// SearchParams.Highlight is set, and the names under which their
+// snippets are returned in SearchResult.Highlights.
+var highlightFields = []struct {
+	field string
+	name  string
+}{
+	{"CharmMeta.Summary", "summary"},
+	{"CharmMeta.Description", "description"},
+	{"CharmMeta.Categories", "tags"},
+	{"CharmMeta.Tags", "tags"},
+	{"BundleData.Tags", "tags"},
+}
+
+// searchHighlights returns the highlighted snippets returned by
+// elasticsearch for a hit, keyed by the names in highlightFields.
+func searchHighlights(h map[string][]string) map[string][]string {
+	var highlights map[string][]string
+	for _, f := range highlightFields {
+		if len(h[f.field]) == 0 {
+			continue
+		}
+		if highlights == nil {
+			highlights = make(map[string][]string)
+		}
+		highlights[f.name] = append(highlights[f.name], h[f.field]...)
+	}
+	return highlights
+}
+
 // createSort creates an elasticsearch.Sort query parameter out of a Sort parameter.
 func createSort(s sortParam) elasticsearch.Sort {
 	sort := elasticsearch.Sort{
@@ -5,13 +5,18 @@ package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/char
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	jc "github.com/juju/testing/checkers"
 	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
 	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2/bson"
 
 	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
@@ -89,9 +94,13 @@ func (s *StoreSearchSuite) TestSuccessfulExport(c *gc.C) {
 			readACLs = []string{ref.URL.User}
 		}
 		doc := SearchDoc{
-			Entity:         entity,
-			TotalDownloads: int64(charmDownloadCounts[name]),
-			ReadACLs:       readACLs,
+			Entity:          entity,
+			TotalDownloads:  int64(charmDownloadCounts[name]),
+			RecentDownloads: int64(charmDownloadCounts[name]),
+			ReadACLs:        readACLs,
+			Tags:            entityTags(entity),
+			ConfigOptions:   entityConfigOptions(entity),
+			ActionNames:     entityActionNames(entity),
 		}
 		c.Assert(string(actual), jc.JSONEquals, doc)
 	}
@@ -130,8 +139,85 @@ func (s *StoreSearchSuite) TestExportOnlyLatest(c *gc.C) {
 	c.Assert(err, gc.IsNil)
 	err = s.store.ES.GetDocument(s.TestIndex, typeName, s.store.ES.getID(old.URL), &actual)
 	c.Assert(err, gc.IsNil)
-	doc := SearchDoc{Entity: expected, ReadACLs: []string{"charmers", params.Everyone}}
+	doc := SearchDoc{
+		Entity:        expected,
+		ReadACLs:      []string{"charmers", params.Everyone},
+		Tags:          entityTags(expected),
+		ConfigOptions: entityConfigOptions(expected),
+		ActionNames:   entityActionNames(expected),
+	}
+	c.Assert(string(actual), jc.JSONEquals, doc)
+}
+
+func (s *StoreSearchSuite) TestExportAfterTrash(c *gc.C) {
+	charmArchive := storetesting.Charms.CharmDir("wordpress")
+	url := newResolvedURL("cs:~charmers/precise/wordpress-24", -1)
+	err := s.store.AddCharmWithArchive(url, charmArchive)
+	c.Assert(err, gc.IsNil)
+
+	// Trashing the latest revision exports the previous one.
+	err = s.store.TrashEntity(url)
+	c.Assert(err, gc.IsNil)
+	var expected *mongodoc.Entity
+	err = s.store.DB.Entities().FindId("cs:~charmers/precise/wordpress-23").One(&expected)
+	c.Assert(err, gc.IsNil)
+	var actual json.RawMessage
+	err = s.store.ES.GetDocument(s.TestIndex, typeName, s.store.ES.getID(&url.URL), &actual)
+	c.Assert(err, gc.IsNil)
+	doc := SearchDoc{
+		Entity:        expected,
+		ReadACLs:      []string{"charmers", params.Everyone},
+		Tags:          entityTags(expected),
+		ConfigOptions: entityConfigOptions(expected),
+		ActionNames:   entityActionNames(expected),
+	}
 	c.Assert(string(actual), jc.JSONEquals, doc)
+
+	// Trashing all revisions removes the document.
+	err = s.store.TrashEntity(exportTestCharms["wordpress"])
+	c.Assert(err, gc.IsNil)
+	present, err := s.store.ES.HasDocument(s.TestIndex, typeName, s.store.ES.getID(&url.URL))
+	c.Assert(err, gc.IsNil)
+	c.Assert(present, gc.Equals, false)
+
+	// Restoring a revision exports it again.
+	_, err = s.store.RestoreEntity(&url.URL)
+	c.Assert(err, gc.IsNil)
+	present, err = s.store.ES.HasDocument(s.TestIndex, typeName, s.store.ES.getID(&url.URL))
+	c.Assert(err, gc.IsNil)
+	c.Assert(present, gc.Equals, true)
+}
+
+func (s *StoreSearchSuite) TestSearchMultiSeries(c *gc.C) {
+	url := newResolvedURL("cs:~charmers/multi-series-0", -1)
+	err := s.store.AddCharmWithArchive(url, storetesting.Charms.CharmDir("multi-series"))
+	c.Assert(err, gc.IsNil)
+	err = s.store.SetPerms(&url.URL, "read", params.Everyone, url.URL.User)
+	c.Assert(err, gc.IsNil)
+	err = s.store.UpdateSearch(url)
+	c.Assert(err, gc.IsNil)
+	s.store.ES.Database.RefreshIndex(s.TestIndex)
+
+	// The charm is found under each of its supported series.
+	for _, series := range []string{"precise", "trusty", "utopic"} {
+		c.Logf("series %s", series)
+		res, err := s.store.Search(SearchParams{
+			Filters: map[string][]string{
+				"name":   {"multi-series"},
+				"series": {series},
+			},
+		})
+		c.Assert(err, gc.IsNil)
+		c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{url})
+	}
+	res, err := s.store.Search(SearchParams{
+		Filters: map[string][]string{
+			"name":   {"multi-series"},
+			"series": {"quantal"},
+		},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, gc.HasLen, 0)
 }
 
 func (s *StoreSearchSuite) TestExportSearchDocument(c *gc.C) {
@@ -148,6 +234,12 @@ func (s *StoreSearchSuite) TestExportSearchDocument(c *gc.C) {
 }
 
 func (s *StoreSearchSuite) addCharmsToStore(c *gc.C) {
+	addSearchTestEntities(c, s.store)
+}
+
+// addSearchTestEntities adds the entities used by the search
+// tests to the given store and populates its search index.
+func addSearchTestEntities(c *gc.C, store *Store) {
 	for name, url := range exportTestCharms {
 		charmArchive := storetesting.Charms.CharmDir(name)
 		cats := strings.Split(name, "-")
@@ -157,38 +249,38 @@ func (s *StoreSearchSuite) addCharmsToStore(c *gc.C) {
 			tags[i] = s + "TAG"
 		}
 		charmArchive.Meta().Tags = tags
-		err := s.store.AddCharmWithArchive(url, charmArchive)
+		err := store.AddCharmWithArchive(url, charmArchive)
 		c.Assert(err, gc.IsNil)
 		for i := 0; i < charmDownloadCounts[name]; i++ {
-			err := s.store.IncrementDownloadCounts(url)
+			err := store.IncrementDownloadCounts(url)
 			c.Assert(err, gc.IsNil)
 		}
 		if url.URL.Name == "riak" {
 			continue
 		}
 		bURL := baseURL(&url.URL)
-		baseEntity, err := s.store.FindBaseEntity(bURL)
+		baseEntity, err := store.FindBaseEntity(bURL)
 		baseEntity.ACLs.Read = append(baseEntity.ACLs.Read, params.Everyone)
-		err = s.store.DB.BaseEntities().UpdateId(baseEntity.URL, baseEntity)
+		err = store.DB.BaseEntities().UpdateId(baseEntity.URL, baseEntity)
 		c.Assert(err, gc.IsNil)
 	}
 	for name, url := range exportTestBundles {
 		bundleArchive := storetesting.Charms.BundleDir(name)
 		bundleArchive.Data().Tags = strings.Split(name, "-")
-		err := s.store.AddBundleWithArchive(url, bundleArchive)
+		err := store.AddBundleWithArchive(url, bundleArchive)
 		c.Assert(err, gc.IsNil)
 		for i := 0; i < charmDownloadCounts[name]; i++ {
-			err := s.store.IncrementDownloadCounts(url)
+			err := store.IncrementDownloadCounts(url)
 			c.Assert(err, gc.IsNil)
 		}
 		bURL := baseURL(&url.URL)
-		baseEntity, err := s.store.FindBaseEntity(bURL)
+		baseEntity, err := store.FindBaseEntity(bURL)
 		baseEntity.ACLs.Read = append(baseEntity.ACLs.Read, params.Everyone)
-		err = s.store.DB.BaseEntities().UpdateId(baseEntity.URL, baseEntity)
+		err = store.DB.BaseEntities().UpdateId(baseEntity.URL, baseEntity)
 		c.Assert(err, gc.IsNil)
 	}
-	s.store.pool.statsCache.EvictAll()
-	err := s.store.syncSearch()
+	store.pool.statsCache.EvictAll()
+	err := store.syncSearch()
 	c.Assert(err, gc.IsNil)
 }
 
@@ -478,6 +570,133 @@ var searchTests = []struct {
 		results: []*router.ResolvedURL{
 			exportTestCharms["mysql"],
 		},
+	}, {
+		about: "units-max filter search",
+		sp: SearchParams{
+			Filters: map[string][]string{
+				"units-max": {"2"},
+			},
+		},
+		results: []*router.ResolvedURL{
+			exportTestBundles["wordpress-simple"],
+		},
+	}, {
+		about: "units-max filter search with too few units",
+		sp: SearchParams{
+			Filters: map[string][]string{
+				"units-max": {"1"},
+			},
+		},
+	}, {
+		about: "machines-max filter search",
+		sp: SearchParams{
+			Filters: map[string][]string{
+				"machines-max": {"5"},
+			},
+		},
+		results: []*router.ResolvedURL{
+			exportTestBundles["wordpress-simple"],
+		},
+	}, {
+		about: "downloads-min filter search",
+		sp: SearchParams{
+			Filters: map[string][]string{
+				"downloads-min": {"3"},
+			},
+		},
+		results: []*router.ResolvedURL{
+			exportTestCharms["mysql"],
+			exportTestCharms["varnish"],
+		},
+	}, {
+		about: "downloads-min filter search with several values",
+		sp: SearchParams{
+			Filters: map[string][]string{
+				"downloads-min": {"5", "1"},
+			},
+		},
+		results: []*router.ResolvedURL{
+			exportTestCharms["mysql"],
+			exportTestCharms["varnish"],
+			exportTestBundles["wordpress-simple"],
+		},
+	}, {
+		about: "uploaded-since filter search",
+		sp: SearchParams{
+			Filters: map[string][]string{
+				"uploaded-since": {"2015-01-01"},
+				"type":           {"charm"},
+			},
+		},
+		results: []*router.ResolvedURL{
+			exportTestCharms["wordpress"],
+			exportTestCharms["mysql"],
+			exportTestCharms["varnish"],
+		},
+	}, {
+		about: "excluded series filter search",
+		sp: SearchParams{
+			Filters: map[string][]string{
+				"-series": {"precise"},
+			},
+		},
+		results: []*router.ResolvedURL{
+			exportTestCharms["mysql"],
+			exportTestCharms["varnish"],
+			exportTestBundles["wordpress-simple"],
+		},
+	}, {
+		about: "excluded tags filter search",
+		sp: SearchParams{
+			Filters: map[string][]string{
+				"-tags": {"wordpress"},
+			},
+		},
+		results: []*router.ResolvedURL{
+			exportTestCharms["mysql"],
+			exportTestCharms["varnish"],
+		},
+	}, {
+		about: "excluded filter search with several values",
+		sp: SearchParams{
+			Filters: map[string][]string{
+				"-name": {"mysql", "varnish"},
+			},
+		},
+		results: []*router.ResolvedURL{
+			exportTestCharms["wordpress"],
+			exportTestBundles["wordpress-simple"],
+		},
+	}, {
+		about: "included and excluded filter search",
+		sp: SearchParams{
+			Filters: map[string][]string{
+				"type":   {"charm"},
+				"-owner": {"charmers"},
+			},
+		},
+		results: []*router.ResolvedURL{
+			exportTestCharms["mysql"],
+			exportTestCharms["varnish"],
+		},
+	}, {
+		about: "excluded range filter search",
+		sp: SearchParams{
+			Filters: map[string][]string{
+				"-downloads-min": {"3"},
+			},
+		},
+		results: []*router.ResolvedURL{
+			exportTestCharms["wordpress"],
+			exportTestBundles["wordpress-simple"],
+		},
+	}, {
+		about: "uploaded-since filter search in the future",
+		sp: SearchParams{
+			Filters: map[string][]string{
+				"uploaded-since": {"2100-01-01T00:00:00Z"},
+			},
+		},
 	},
 }
 
@@ -509,6 +728,520 @@ func (r resolvedURLsByString) Len() int {
 	return len(r)
 }
 
+var searchFacetsTests = []struct {
+	about        string
+	sp           SearchParams
+	expectFacets map[string][]FacetCount
+}{{
+	about: "all facets",
+	sp: SearchParams{
+		Facets: []string{"owner", "series", "tags", "type"},
+	},
+	expectFacets: map[string][]FacetCount{
+		"owner": {
+			{"charmers", 2},
+			{"foo", 1},
+			{"openstack-charmers", 1},
+		},
+		"series": {
+			{"trusty", 2},
+			{"bundle", 1},
+			{"precise", 1},
+		},
+		"tags": {
+			{"wordpress", 2},
+			{"mysql", 1},
+			{"mysqlTAG", 1},
+			{"simple", 1},
+			{"varnish", 1},
+			{"varnishTAG", 1},
+			{"wordpressTAG", 1},
+		},
+		"type": {
+			{"charm", 3},
+			{"bundle", 1},
+		},
+	},
+}, {
+	about: "facets honour filters",
+	sp: SearchParams{
+		Filters: map[string][]string{
+			"series": {"trusty"},
+		},
+		Facets: []string{"owner", "type"},
+	},
+	expectFacets: map[string][]FacetCount{
+		"owner": {
+			{"foo", 1},
+			{"openstack-charmers", 1},
+		},
+		"type": {
+			{"charm", 2},
+		},
+	},
+}, {
+	about: "facets honour ACLs",
+	sp: SearchParams{
+		Filters: map[string][]string{
+			"series": {"trusty"},
+		},
+		Facets: []string{"owner"},
+		Admin:  true,
+	},
+	expectFacets: map[string][]FacetCount{
+		"owner": {
+			{"charmers", 1},
+			{"foo", 1},
+			{"openstack-charmers", 1},
+		},
+	},
+}, {
+	about: "facets are counted over all results",
+	sp: SearchParams{
+		Facets: []string{"type"},
+		Limit:  1,
+	},
+	expectFacets: map[string][]FacetCount{
+		"type": {
+			{"charm", 3},
+			{"bundle", 1},
+		},
+	},
+}, {
+	about: "no facets",
+	sp:    SearchParams{},
+}}
+
+func (s *StoreSearchSuite) TestSearchFacets(c *gc.C) {
+	s.store.ES.Database.RefreshIndex(s.TestIndex)
+	for i, test := range searchFacetsTests {
+		c.Logf("test %d: %s", i, test.about)
+		res, err := s.store.Search(test.sp)
+		c.Assert(err, gc.IsNil)
+		c.Assert(res.Facets, jc.DeepEquals, test.expectFacets)
+	}
+}
+
+func (s *StoreSearchSuite) TestSearchFacetsMultiSeries(c *gc.C) {
+	url := newResolvedURL("cs:~charmers/multi-series-0", -1)
+	err := s.store.AddCharmWithArchive(url, storetesting.Charms.CharmDir("multi-series"))
+	c.Assert(err, gc.IsNil)
+	err = s.store.SetPerms(&url.URL, "read", params.Everyone, url.URL.User)
+	c.Assert(err, gc.IsNil)
+	err = s.store.UpdateSearch(url)
+	c.Assert(err, gc.IsNil)
+	s.store.ES.Database.RefreshIndex(s.TestIndex)
+
+	// A multi-series charm is counted in each of its supported series.
+	res, err := s.store.Search(SearchParams{
+		Facets: []string{"series"},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Facets, jc.DeepEquals, map[string][]FacetCount{
+		"series": {
+			{"trusty", 3},
+			{"precise", 2},
+			{"bundle", 1},
+			{"utopic", 1},
+		},
+	})
+}
+
+// addCharmWithConfigAndActions adds a public charm to the given
+// store with the ssl_cert config option, the backup action and the
+// leader-elected hook, and returns its id.
+func addCharmWithConfigAndActions(c *gc.C, store *Store) *router.ResolvedURL {
+	dir := storetesting.Charms.ClonedDir(c.MkDir(), "wordpress")
+	for name, content := range map[string]string{
+		"config.yaml":          "options:\n  ssl_cert:\n    type: string\n    default: \"\"\n    description: The SSL certificate.\n",
+		"actions.yaml":         "backup:\n  description: Back up the blog.\n",
+		"hooks/leader-elected": "#!/bin/sh\n",
+	} {
+		err := ioutil.WriteFile(filepath.Join(dir.Path, name), []byte(content), 0755)
+		c.Assert(err, gc.IsNil)
+	}
+	ch, err := charm.ReadCharmDir(dir.Path)
+	c.Assert(err, gc.IsNil)
+	url := newResolvedURL("cs:~bob/trusty/wordpress-0", -1)
+	err = store.AddCharmWithArchive(url, ch)
+	c.Assert(err, gc.IsNil)
+	err = store.SetPerms(&url.URL, "read", params.Everyone, url.URL.User)
+	c.Assert(err, gc.IsNil)
+	err = store.UpdateSearch(url)
+	c.Assert(err, gc.IsNil)
+	return url
+}
+
+var searchConfigAndActionsTests = []struct {
+	about         string
+	sp            SearchParams
+	expectResults bool
+}{{
+	about: "config option filter",
+	sp: SearchParams{
+		Filters: map[string][]string{
+			"config-option": {"ssl_cert"},
+		},
+	},
+	expectResults: true,
+}, {
+	about: "action filter",
+	sp: SearchParams{
+		Filters: map[string][]string{
+			"action": {"backup"},
+		},
+	},
+	expectResults: true,
+}, {
+	about: "hook filter",
+	sp: SearchParams{
+		Filters: map[string][]string{
+			"hook": {"leader-elected"},
+		},
+	},
+	expectResults: true,
+}, {
+	about: "config option text",
+	sp: SearchParams{
+		Text: "ssl_cert",
+	},
+	expectResults: true,
+}, {
+	about: "all filters must match",
+	sp: SearchParams{
+		Filters: map[string][]string{
+			"config-option": {"ssl_cert"},
+			"action":        {"restore"},
+		},
+	},
+}, {
+	about: "unknown config option",
+	sp: SearchParams{
+		Filters: map[string][]string{
+			"config-option": {"ssl_key"},
+		},
+	},
+}}
+
+func (s *StoreSearchSuite) TestSearchConfigAndActions(c *gc.C) {
+	url := addCharmWithConfigAndActions(c, s.store)
+	s.store.ES.Database.RefreshIndex(s.TestIndex)
+	for i, test := range searchConfigAndActionsTests {
+		c.Logf("test %d: %s", i, test.about)
+		res, err := s.store.Search(test.sp)
+		c.Assert(err, gc.IsNil)
+		if test.expectResults {
+			c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{url})
+		} else {
+			c.Assert(res.Results, gc.HasLen, 0)
+		}
+	}
+}
+
+func (s *StoreSearchSuite) TestUpdateSearchPopulatesCharmHooks(c *gc.C) {
+	// Remove the hooks, as if the charm had been uploaded
+	// before they were recorded.
+	url := addCharmWithConfigAndActions(c, s.store)
+	entity, err := s.store.FindEntity(url, "charmhooks")
+	c.Assert(err, gc.IsNil)
+	expectHooks := entity.CharmHooks
+	c.Assert(expectHooks, jc.DeepContains, []string{"leader-elected"})
+	err = s.store.DB.Entities().UpdateId(&url.URL, bson.D{{
+		"$unset", bson.D{{"charmhooks", true}},
+	}})
+	c.Assert(err, gc.IsNil)
+
+	err = s.store.UpdateSearch(url)
+	c.Assert(err, gc.IsNil)
+	s.store.ES.Database.RefreshIndex(s.TestIndex)
+
+	entity, err = s.store.FindEntity(url, "charmhooks")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.CharmHooks, jc.DeepEquals, expectHooks)
+	res, err := s.store.Search(SearchParams{
+		Filters: map[string][]string{
+			"hook": {"leader-elected"},
+		},
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{url})
+}
+
+var searchSuggestionsTests = []struct {
+	about             string
+	sp                SearchParams
+	expectSuggestions []string
+}{{
+	about: "misspelt name",
+	sp: SearchParams{
+		Text: "mysq",
+	},
+	expectSuggestions: []string{"mysql"},
+}, {
+	about: "misspelt word within text",
+	sp: SearchParams{
+		Text: "wordpres blog",
+	},
+	expectSuggestions: []string{"wordpress blog"},
+}, {
+	about: "correct name",
+	sp: SearchParams{
+		Text: "wordpress",
+	},
+}, {
+	about: "unreadable names are not suggested",
+	sp: SearchParams{
+		Text: "riek",
+	},
+}, {
+	about: "admin",
+	sp: SearchParams{
+		Text:  "riek",
+		Admin: true,
+	},
+	expectSuggestions: []string{"riak"},
+}, {
+	about: "additional groups",
+	sp: SearchParams{
+		Text:   "riek",
+		Groups: []string{"charmers"},
+	},
+	expectSuggestions: []string{"riak"},
+}, {
+	about: "no text",
+	sp:    SearchParams{},
+}}
+
+func (s *StoreSearchSuite) TestSearchSuggestions(c *gc.C) {
+	s.store.ES.Database.RefreshIndex(s.TestIndex)
+	for i, test := range searchSuggestionsTests {
+		c.Logf("test %d: %s", i, test.about)
+		res, err := s.store.Search(test.sp)
+		c.Assert(err, gc.IsNil)
+		c.Assert(res.Suggestions, jc.DeepEquals, test.expectSuggestions)
+	}
+}
+
+var searchHighlightTests = []struct {
+	about            string
+	sp               SearchParams
+	expectHighlights map[string]map[string][]string
+}{{
+	about: "summary and description",
+	sp: SearchParams{
+		Text:      "blog",
+		Highlight: true,
+	},
+	expectHighlights: map[string]map[string][]string{
+		"cs:~charmers/precise/wordpress-23": {
+			"summary":     {"<em>Blog</em> engine"},
+			"description": {"A pretty popular <em>blog</em> engine"},
+		},
+	},
+}, {
+	about: "tags",
+	sp: SearchParams{
+		Text:      "wordpress",
+		Highlight: true,
+	},
+	expectHighlights: map[string]map[string][]string{
+		"cs:~charmers/precise/wordpress-23": {
+			"tags": {"<em>wordpress</em>"},
+		},
+		"cs:~charmers/bundle/wordpress-simple-4": {
+			"tags": {"<em>wordpress</em>"},
+		},
+	},
+}, {
+	about: "highlight not requested",
+	sp: SearchParams{
+		Text: "blog",
+	},
+}, {
+	about: "no text",
+	sp: SearchParams{
+		Highlight: true,
+	},
+}}
+
+// resultHighlights returns the highlights of the given search
+// result keyed by result URL, omitting results without any.
+func resultHighlights(c *gc.C, res SearchResult) map[string]map[string][]string {
+	if res.Highlights == nil {
+		return nil
+	}
+	c.Assert(res.Highlights, gc.HasLen, len(res.Results))
+	highlights := make(map[string]map[string][]string)
+	for i, h := range res.Highlights {
+		if h != nil {
+			highlights[res.Results[i].URL.String()] = h
+		}
+	}
+	return highlights
+}
+
+func (s *StoreSearchSuite) TestSearchHighlights(c *gc.C) {
+	s.store.ES.Database.RefreshIndex(s.TestIndex)
+	for i, test := range searchHighlightTests {
+		c.Logf("test %d: %s", i, test.about)
+		res, err := s.store.Search(test.sp)
+		c.Assert(err, gc.IsNil)
+		c.Assert(resultHighlights(c, res), jc.DeepEquals, test.expectHighlights)
+	}
+}
+
+var parseRangeFilterTests = []struct {
+	about       string
+	name        string
+	values      []string
+	expectError string
+}{{
+	about:  "count",
+	name:   "units-max",
+	values: []string{"0", "20"},
+}, {
+	about:  "date",
+	name:   "uploaded-since",
+	values: []string{"2015-06-01"},
+}, {
+	about:  "time",
+	name:   "uploaded-since",
+	values: []string{"2015-06-01T12:30:00+02:00"},
+}, {
+	about:       "invalid count",
+	name:        "downloads-min",
+	values:      []string{"lots"},
+	expectError: `expected non-negative integer, got "lots"`,
+}, {
+	about:       "negative count",
+	name:        "machines-max",
+	values:      []string{"3", "-1"},
+	expectError: `expected non-negative integer, got "-1"`,
+}, {
+	about:       "invalid time",
+	name:        "uploaded-since",
+	values:      []string{"last month"},
+	expectError: `expected date or RFC 3339 time, got "last month"`,
+}, {
+	about:  "excluded range filter",
+	name:   "-units-max",
+	values: []string{"3"},
+}, {
+	about:       "not a range filter",
+	name:        "name",
+	values:      []string{"1"},
+	expectError: `name is not a range filter`,
+}}
+
+func (s *StoreSearchSuite) TestParseRangeFilter(c *gc.C) {
+	for i, test := range parseRangeFilterTests {
+		c.Logf("test %d: %s", i, test.about)
+		var sp SearchParams
+		err := sp.ParseRangeFilter(test.name, test.values...)
+		if test.expectError != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectError)
+			c.Assert(sp.Filters, gc.IsNil)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(sp.Filters, jc.DeepEquals, map[string][]string{
+			test.name: test.values,
+		})
+	}
+}
+
+func (s *StoreSearchSuite) TestSortedSuggestions(c *gc.C) {
+	texts := sortedSuggestions([]suggestion{
+		{text: "b", score: 0.5},
+		{text: "c", score: 0.75},
+		{text: "a", score: 0.5},
+		{text: "c", score: 0.5},
+		{text: "d", score: 0.25},
+		{text: "e", score: 0.25},
+		{text: "f", score: 0.25},
+	})
+	c.Assert(texts, jc.DeepEquals, []string{"c", "a", "b", "d", "e"})
+}
+
+var utf16SpanTests = []struct {
+	about       string
+	text        string
+	offset      int
+	length      int
+	expectSpan  string
+	expectError bool
+}{{
+	about:      "ascii",
+	text:       "wordpress mysql",
+	offset:     10,
+	length:     5,
+	expectSpan: "mysql",
+}, {
+	about:      "after multi-byte characters",
+	text:       "café mysql",
+	offset:     5,
+	length:     5,
+	expectSpan: "mysql",
+}, {
+	about:      "multi-byte characters",
+	text:       "crème brûlée",
+	offset:     6,
+	length:     6,
+	expectSpan: "brûlée",
+}, {
+	about:      "after a surrogate pair",
+	text:       "\U0001F600 mysql",
+	offset:     3,
+	length:     5,
+	expectSpan: "mysql",
+}, {
+	about:      "empty span at the end",
+	text:       "café",
+	offset:     4,
+	length:     0,
+	expectSpan: "",
+}, {
+	about:       "inside a surrogate pair",
+	text:        "\U0001F600 mysql",
+	offset:      1,
+	length:      2,
+	expectError: true,
+}, {
+	about:       "out of range",
+	text:        "café",
+	offset:      2,
+	length:      3,
+	expectError: true,
+}, {
+	about:       "negative offset",
+	text:        "café",
+	offset:      -1,
+	length:      2,
+	expectError: true,
+}}
+
+func (s *StoreSearchSuite) TestUTF16Span(c *gc.C) {
+	for i, test := range utf16SpanTests {
+		c.Logf("test %d: %s", i, test.about)
+		start, end, ok := utf16Span(test.text, test.offset, test.length)
+		if test.expectError {
+			c.Assert(ok, gc.Equals, false)
+			continue
+		}
+		c.Assert(ok, gc.Equals, true)
+		c.Assert(test.text[start:end], gc.Equals, test.expectSpan)
+	}
+}
+
+func (s *StoreSearchSuite) TestParseFacets(c *gc.C) {
+	var sp SearchParams
+	err := sp.ParseFacets("series", "owner")
+	c.Assert(err, gc.IsNil)
+	c.Assert(sp.Facets, jc.DeepEquals, []string{"series", "owner"})
+	err = sp.ParseFacets("name")
+	c.Assert(err, gc.ErrorMatches, "name")
+}
+
 func (s *StoreSearchSuite) TestPaginatedSearch(c *gc.C) {
 	err := s.store.ES.Database.RefreshIndex(s.TestIndex)
 	c.Assert(err, gc.IsNil)
@@ -651,6 +1384,55 @@ func (s *StoreSearchSuite) TestSorting(c *gc.C) {
 	}
 }
 
+func (s *StoreSearchSuite) TestTrendingSort(c *gc.C) {
+	addOldDownloads(c, s.store, exportTestCharms["wordpress"], 10)
+	s.store.ES.Database.RefreshIndex(s.TestIndex)
+	checkTrendingSort(c, s.store)
+}
+
+// addOldDownloads records n downloads of the given entity made two
+// months ago, outside the trending window, and updates its search
+// document.
+func addOldDownloads(c *gc.C, store *Store, url *router.ResolvedURL, n int) {
+	t := time.Now().AddDate(0, -2, 0)
+	for i := 0; i < n; i++ {
+		err := store.IncrementDownloadCountsAtTime(url, t)
+		c.Assert(err, gc.IsNil)
+	}
+	store.pool.statsCache.EvictAll()
+	err := store.UpdateSearch(url)
+	c.Assert(err, gc.IsNil)
+}
+
+// checkTrendingSort checks that, after addOldDownloads has been
+// called for the wordpress charm, it is the most downloaded entity
+// but the least trending one.
+func checkTrendingSort(c *gc.C, store *Store) {
+	var sp SearchParams
+	err := sp.ParseSortFields("-downloads")
+	c.Assert(err, gc.IsNil)
+	res, err := store.Search(sp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{
+		exportTestCharms["wordpress"],
+		exportTestCharms["varnish"],
+		exportTestCharms["mysql"],
+		exportTestBundles["wordpress-simple"],
+	})
+
+	sp = SearchParams{}
+	err = sp.ParseSortFields("-trending")
+	c.Assert(err, gc.IsNil)
+	res, err = store.Search(sp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Results, jc.DeepEquals, []*router.ResolvedURL{
+		exportTestCharms["varnish"],
+		exportTestCharms["mysql"],
+		exportTestBundles["wordpress-simple"],
+		exportTestCharms["wordpress"],
+	})
+}
+
 func (s *StoreSearchSuite) TestBoosting(c *gc.C) {
 	s.store.ES.Database.RefreshIndex(s.TestIndex)
 	var sp SearchParams
@@ -0,0 +1,371 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+
+	"gopkg.in/juju/charmstore.v5-unstable/elasticsearch"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+// searchCheckBatchSize holds the maximum number of search
+// documents retrieved from elasticsearch in each request.
+const searchCheckBatchSize = 500
+
+// searchCheckScrollKeepAlive holds how long elasticsearch keeps
+// the scrolled search used to find extra documents between
+// requests.
+const searchCheckScrollKeepAlive = 5 * time.Minute
+
+// SearchCheckResult holds the result of Store.CheckSearch.
+type SearchCheckResult struct {
+	// Checked holds the number of search documents that
+	// should be in the index.
+	Checked int
+
+	// Missing holds the URLs of the entities that have
+	// no search document.
+	Missing []*charm.Reference
+
+	// Stale holds the search documents that do not match
+	// the current state of their entity.
+	Stale []StaleSearchDoc
+
+	// Extra holds the URLs of the search documents that do
+	// not correspond to the latest revision of any entity.
+	Extra []*charm.Reference
+
+	// Repaired holds whether the problems found have been repaired.
+	Repaired bool
+}
+
+// StaleSearchDoc describes a search document that does
+// not match the current state of its entity.
+type StaleSearchDoc struct {
+	// URL holds the URL of the latest revision of the entity.
+	URL *charm.Reference
+
+	// IndexedURL holds the URL in the search document.
+	IndexedURL *charm.Reference
+
+	// Fields holds the names of the differing fields, any of
+	// "revision", "acls", "promulgation" and "downloads".
+	Fields []string
+}
+
+// OK reports whether no problems were found.
+func (r *SearchCheckResult) OK() bool {
+	return len(r.Missing) == 0 && len(r.Stale) == 0 && len(r.Extra) == 0
+}
+
+// CheckSearch compares the elasticsearch index with the latest revision
+// in each series of the entities stored in MongoDB, reporting entities
+// with no search document, search documents that are out of date and
+// search documents that do not correspond to any entity. If repair is
+// true, the problems found are also fixed.
+//
+// The check reads every entity and search document, so it can take
+// a long time on a large store: StartSearchCheck can be used to run
+// it in the background. Entities changed while the check is running
+// may be reported incorrectly.
+func (s *Store) CheckSearch(repair bool) (*SearchCheckResult, error) {
+	si := s.ES
+	if si == nil || si.Database == nil {
+		return nil, errgo.New("elasticsearch not configured")
+	}
+	var result SearchCheckResult
+	var repairDocs []*SearchDoc
+	expected := make(map[string]bool)
+	var batch []*SearchDoc
+	checkBatch := func() error {
+		problems, err := si.checkSearchDocs(batch, &result)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		repairDocs = append(repairDocs, problems...)
+		batch = batch[:0]
+		return nil
+	}
+	iter := s.DB.BaseEntities().Find(nil).Sort("_id").Iter()
+	defer iter.Close()
+	for {
+		var baseEntity mongodoc.BaseEntity
+		if !iter.Next(&baseEntity) {
+			break
+		}
+		docs, err := s.searchDocsForBaseEntity(&baseEntity)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		for _, doc := range docs {
+			result.Checked++
+			expected[si.getID(doc.URL)] = true
+		}
+		batch = append(batch, docs...)
+		if len(batch) >= searchCheckBatchSize {
+			if err := checkBatch(); err != nil {
+				return nil, errgo.Mask(err)
+			}
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, errgo.Notef(err, "cannot iterate base entities")
+	}
+	if len(batch) > 0 {
+		if err := checkBatch(); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	extra, err := si.extraDocuments(expected)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	result.Extra = extra
+	if !repair || result.OK() {
+		return &result, nil
+	}
+	for _, doc := range repairDocs {
+		// The indexed revision may be newer than the latest
+		// one, so replace the document regardless of revision.
+		if err := si.put(doc); err != nil {
+			return nil, errgo.Notef(err, "cannot update search record for %q", doc.URL)
+		}
+	}
+	for _, url := range result.Extra {
+		if err := si.remove(url); err != nil {
+			return nil, errgo.Notef(err, "cannot remove search record for %q", url)
+		}
+	}
+	result.Repaired = true
+	return &result, nil
+}
+
+// checkSearchDocs retrieves the indexed search documents corresponding
+// to the given expected documents in a single request, and records any
+// that are missing or stale in result. It returns the expected documents
+// for which problems were found.
+func (si *SearchIndex) checkSearchDocs(docs []*SearchDoc, result *SearchCheckResult) ([]*SearchDoc, error) {
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = si.getID(doc.URL)
+	}
+	esDocs, err := si.MultiGet(si.Index, typeName, ids)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get search documents")
+	}
+	var problems []*SearchDoc
+	for i, esDoc := range esDocs {
+		doc := docs[i]
+		if !esDoc.Found {
+			result.Missing = append(result.Missing, doc.URL)
+			problems = append(problems, doc)
+			continue
+		}
+		var actual SearchDoc
+		if err := json.Unmarshal(esDoc.Source, &actual); err != nil {
+			return nil, errgo.Notef(err, "cannot unmarshal search document for %q", doc.URL)
+		}
+		if fields := staleSearchFields(doc, &actual); len(fields) > 0 {
+			stale := StaleSearchDoc{
+				URL:    doc.URL,
+				Fields: fields,
+			}
+			if actual.Entity != nil {
+				stale.IndexedURL = actual.URL
+			}
+			result.Stale = append(result.Stale, stale)
+			problems = append(problems, doc)
+		}
+	}
+	return problems, nil
+}
+
+// staleSearchFields returns the names of the fields in which the
+// indexed search document differs from the expected one.
+func staleSearchFields(expected, actual *SearchDoc) []string {
+	var fields []string
+	if actual.Entity == nil || urlString(actual.URL) != urlString(expected.URL) {
+		fields = append(fields, "revision")
+	}
+	if !stringsEqual(actual.ReadACLs, expected.ReadACLs) {
+		fields = append(fields, "acls")
+	}
+	if actual.Entity == nil || urlString(actual.PromulgatedURL) != urlString(expected.PromulgatedURL) {
+		fields = append(fields, "promulgation")
+	}
+	if actual.TotalDownloads != expected.TotalDownloads {
+		fields = append(fields, "downloads")
+	}
+	return fields
+}
+
+// extraDocuments returns the URLs of all the documents in the index
+// whose ids are not in expected, ordered by URL. The index is read
+// with a scrolled search so that its size is not limited by the
+// maximum result window of paginated searches.
+func (si *SearchIndex) extraDocuments(expected map[string]bool) ([]*charm.Reference, error) {
+	esr, err := si.Scroll(si.Index, typeName, elasticsearch.QueryDSL{
+		Fields: []string{"URL"},
+		Size:   searchCheckBatchSize,
+		Query:  elasticsearch.MatchAllQuery{},
+	}, searchCheckScrollKeepAlive)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get search documents")
+	}
+	defer func() {
+		if err := si.ClearScroll(esr.ScrollID); err != nil {
+			logger.Warningf("cannot clear search document scroll: %v", err)
+		}
+	}()
+	var extra []*charm.Reference
+	for len(esr.Hits.Hits) > 0 {
+		for _, h := range esr.Hits.Hits {
+			if expected[h.ID] {
+				continue
+			}
+			urlStr := h.Fields.GetString("URL")
+			url, err := charm.ParseReference(urlStr)
+			if err != nil {
+				return nil, errgo.Notef(err, "invalid URL in search document %q", urlStr)
+			}
+			extra = append(extra, url)
+		}
+		esr, err = si.ScrollNext(esr.ScrollID, searchCheckScrollKeepAlive)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot get search documents")
+		}
+	}
+	sort.Sort(referencesByString(extra))
+	return extra, nil
+}
+
+// SearchCheckStatus holds the status of a search
+// check started with Store.StartSearchCheck.
+type SearchCheckStatus struct {
+	// Running holds whether the check is still running.
+	Running bool
+
+	// Repair holds whether the problems found are to be repaired.
+	Repair bool
+
+	// StartTime holds the time the check was started.
+	StartTime time.Time
+
+	// EndTime holds the time the check finished.
+	// It is zero while the check is running.
+	EndTime time.Time
+
+	// Result holds the result of the check once it
+	// has finished successfully.
+	Result *SearchCheckResult `json:",omitempty"`
+
+	// Error holds the reason the check failed, if it did.
+	Error string `json:",omitempty"`
+}
+
+// searchCheckJob records the status of the search
+// check most recently started in a pool.
+type searchCheckJob struct {
+	// mu guards status.
+	mu     sync.Mutex
+	status *SearchCheckStatus
+}
+
+// StartSearchCheck starts checking the search index in the background,
+// as with CheckSearch, and returns the status of the check. Only one
+// check runs at a time in each pool: if a check is already running,
+// its status is returned and no new check is started.
+func (s *Store) StartSearchCheck(repair bool) (*SearchCheckStatus, error) {
+	if s.ES == nil || s.ES.Database == nil {
+		return nil, errgo.New("elasticsearch not configured")
+	}
+	j := &s.pool.searchCheck
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status != nil && j.status.Running {
+		status := *j.status
+		return &status, nil
+	}
+	j.status = &SearchCheckStatus{
+		Running:   true,
+		Repair:    repair,
+		StartTime: time.Now(),
+	}
+	status := *j.status
+	s.Go(func(s *Store) {
+		result, err := s.CheckSearch(repair)
+		if err != nil {
+			logger.Errorf("cannot check search index: %v", err)
+		}
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.status.Running = false
+		j.status.EndTime = time.Now()
+		if err != nil {
+			j.status.Error = err.Error()
+			return
+		}
+		j.status.Result = result
+	})
+	return &status, nil
+}
+
+// SearchCheckStatus returns the status of the search check most
+// recently started with StartSearchCheck, or nil if none has been
+// started.
+func (s *Store) SearchCheckStatus() *SearchCheckStatus {
+	j := &s.pool.searchCheck
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status == nil {
+		return nil
+	}
+	status := *j.status
+	return &status
+}
+
+// urlString returns the string form of the given URL,
+// or the empty string if it is nil.
+func urlString(url *charm.Reference) string {
+	if url == nil {
+		return ""
+	}
+	return url.String()
+}
+
+// stringsEqual reports whether the given slices
+// hold the same strings in the same order.
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// referencesByString sorts URLs by their string form.
+type referencesByString []*charm.Reference
+
+func (r referencesByString) Len() int {
+	return len(r)
+}
+
+func (r referencesByString) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+
+func (r referencesByString) Less(i, j int) bool {
+	return r[i].String() < r[j].String()
+}
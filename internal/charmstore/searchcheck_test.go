@@ -0,0 +1,168 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"time"
+
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+func (s *StoreSearchSuite) TestCheckSearch(c *gc.C) {
+	result, err := s.store.CheckSearch(false)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result, jc.DeepEquals, &SearchCheckResult{
+		Checked: 5,
+	})
+	c.Assert(result.OK(), gc.Equals, true)
+}
+
+func (s *StoreSearchSuite) TestCheckSearchProblems(c *gc.C) {
+	// Remove the search document for mysql.
+	err := s.store.ES.remove(&exportTestCharms["mysql"].URL)
+	c.Assert(err, gc.IsNil)
+
+	// Change the read ACLs of varnish without updating search.
+	err = s.store.DB.BaseEntities().UpdateId(
+		charm.MustParseReference("cs:~foo/varnish"),
+		bson.D{{"$set", bson.D{{"acls.read", []string{"foo"}}}}},
+	)
+	c.Assert(err, gc.IsNil)
+
+	// Download wordpress without updating search.
+	err = s.store.IncCounter(EntityStatsKey(exportTestCharms["wordpress"].PreferredURL(), params.StatsArchiveDownload))
+	c.Assert(err, gc.IsNil)
+	s.store.pool.statsCache.EvictAll()
+
+	// Add a search document for an entity that does not exist.
+	ghost := charm.MustParseReference("cs:~bob/trusty/ghost-1")
+	err = s.store.ES.put(&SearchDoc{
+		Entity: &mongodoc.Entity{
+			URL:                 ghost,
+			PromulgatedRevision: -1,
+		},
+	})
+	c.Assert(err, gc.IsNil)
+	err = s.ES.RefreshIndex(s.TestIndex)
+	c.Assert(err, gc.IsNil)
+
+	expect := &SearchCheckResult{
+		Checked: 5,
+		Missing: []*charm.Reference{&exportTestCharms["mysql"].URL},
+		Stale: []StaleSearchDoc{{
+			URL:        &exportTestCharms["wordpress"].URL,
+			IndexedURL: &exportTestCharms["wordpress"].URL,
+			Fields:     []string{"downloads"},
+		}, {
+			URL:        &exportTestCharms["varnish"].URL,
+			IndexedURL: &exportTestCharms["varnish"].URL,
+			Fields:     []string{"acls"},
+		}},
+		Extra: []*charm.Reference{ghost},
+	}
+	result, err := s.store.CheckSearch(false)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result, jc.DeepEquals, expect)
+	c.Assert(result.OK(), gc.Equals, false)
+
+	// Repair the problems.
+	result, err = s.store.CheckSearch(true)
+	c.Assert(err, gc.IsNil)
+	expect.Repaired = true
+	c.Assert(result, jc.DeepEquals, expect)
+
+	err = s.ES.RefreshIndex(s.TestIndex)
+	c.Assert(err, gc.IsNil)
+	result, err = s.store.CheckSearch(false)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result, jc.DeepEquals, &SearchCheckResult{
+		Checked: 5,
+	})
+}
+
+func (s *StoreSearchSuite) TestCheckSearchStaleRevision(c *gc.C) {
+	// Add a new revision of wordpress without updating search.
+	err := s.store.DB.Entities().Insert(&mongodoc.Entity{
+		URL:                 charm.MustParseReference("cs:~charmers/precise/wordpress-24"),
+		BaseURL:             charm.MustParseReference("cs:~charmers/wordpress"),
+		User:                "charmers",
+		Name:                "wordpress",
+		Revision:            24,
+		Series:              "precise",
+		PromulgatedRevision: -1,
+	})
+	c.Assert(err, gc.IsNil)
+	result, err := s.store.CheckSearch(false)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result, jc.DeepEquals, &SearchCheckResult{
+		Checked: 5,
+		Stale: []StaleSearchDoc{{
+			URL:        charm.MustParseReference("cs:~charmers/precise/wordpress-24"),
+			IndexedURL: &exportTestCharms["wordpress"].URL,
+			Fields:     []string{"revision", "promulgation"},
+		}},
+	})
+}
+
+func (s *StoreSearchSuite) TestStartSearchCheck(c *gc.C) {
+	c.Assert(s.store.SearchCheckStatus(), gc.IsNil)
+
+	err := s.store.ES.remove(&exportTestCharms["mysql"].URL)
+	c.Assert(err, gc.IsNil)
+	status, err := s.store.StartSearchCheck(true)
+	c.Assert(err, gc.IsNil)
+	c.Assert(status.Repair, gc.Equals, true)
+	c.Assert(status.StartTime.IsZero(), gc.Equals, false)
+
+	status = waitSearchCheck(c, s.store)
+	c.Assert(status.Error, gc.Equals, "")
+	c.Assert(status.EndTime.IsZero(), gc.Equals, false)
+	c.Assert(status.Result, jc.DeepEquals, &SearchCheckResult{
+		Checked:  5,
+		Missing:  []*charm.Reference{&exportTestCharms["mysql"].URL},
+		Repaired: true,
+	})
+
+	err = s.ES.RefreshIndex(s.TestIndex)
+	c.Assert(err, gc.IsNil)
+	_, err = s.store.StartSearchCheck(false)
+	c.Assert(err, gc.IsNil)
+	status = waitSearchCheck(c, s.store)
+	c.Assert(status.Repair, gc.Equals, false)
+	c.Assert(status.Result, jc.DeepEquals, &SearchCheckResult{
+		Checked: 5,
+	})
+}
+
+func (s *StoreSuite) TestStartSearchCheckNotConfigured(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	_, err := store.StartSearchCheck(false)
+	c.Assert(err, gc.ErrorMatches, "elasticsearch not configured")
+	c.Assert(store.SearchCheckStatus(), gc.IsNil)
+}
+
+// waitSearchCheck waits for the search check started
+// in the given store to finish and returns its status.
+func waitSearchCheck(c *gc.C, store *Store) *SearchCheckStatus {
+	timeout := time.After(10 * time.Second)
+	for {
+		status := store.SearchCheckStatus()
+		c.Assert(status, gc.NotNil)
+		if !status.Running {
+			return status
+		}
+		select {
+		case <-timeout:
+			c.Fatalf("search check did not finish")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -77,6 +77,49 @@ type ServerParams struct {
 	// AuditLogger optionally holds the logger which will be used to
 	// write audit log entries.
 	AuditLogger *lumberjack.Logger
+
+	// TrashGracePeriod holds the length of time that deleted
+	// entities are kept in the trash, where they can be restored,
+	// before being purged. If it is zero, DefaultTrashGracePeriod
+	// is used.
+	TrashGracePeriod time.Duration
+
+	// BlobGCInterval holds the interval between runs of the
+	// garbage collector that removes archive blobs no longer
+	// referenced by any entity or resource. If it is zero, the
+	// garbage collector is not run.
+	BlobGCInterval time.Duration
+
+	// RetentionKeepRevisions and RetentionKeepDuration hold the
+	// store-wide revision retention policy, which applies to base
+	// entities that have no policy of their own. See
+	// mongodoc.RetentionPolicy for details. If both are zero,
+	// revisions are only pruned according to per-entity policies.
+	RetentionKeepRevisions int
+	RetentionKeepDuration  time.Duration
+
+	// PruneInterval holds the interval between runs of the job
+	// that moves revisions not kept by the retention policy to
+	// the trash. If it is zero, revisions are never pruned.
+	PruneInterval time.Duration
+
+	// QuotaMaxBytes, QuotaMaxArchiveSize and QuotaMaxRevisions
+	// hold the store-wide upload quotas: the maximum total size
+	// of the archives owned by a user, the maximum size of a
+	// single archive and the maximum number of revisions of a
+	// base entity. A zero value means that there is no limit.
+	// The limits can be overridden for each user (see
+	// Store.SetUserQuota).
+	QuotaMaxBytes       int64
+	QuotaMaxArchiveSize int64
+	QuotaMaxRevisions   int
+
+	// MongoSearch specifies that search is served from MongoDB
+	// when no elasticsearch index is configured. This is
+	// intended for small deployments: filters and ACLs are
+	// applied by MongoDB but the matching entities are scored
+	// and sorted in process.
+	MongoSearch bool
 }
 
 // NewServer returns a handler that serves the given charm store API
@@ -126,6 +169,16 @@ func NewServer(db *mgo.Database, si *SearchIndex, config ServerParams, versions
 		pool: pool,
 		mux:  router.NewServeMux(),
 	}
+	srv.jobs = append(srv.jobs, startPeriodicJob(pool, trashReapInterval, reapTrash(pool.config.TrashGracePeriod)))
+	if si != nil || config.MongoSearch {
+		srv.jobs = append(srv.jobs, startPeriodicJob(pool, trendingRefreshInterval, refreshTrending))
+	}
+	if config.BlobGCInterval > 0 {
+		srv.jobs = append(srv.jobs, startPeriodicJob(pool, config.BlobGCInterval, collectBlobs))
+	}
+	if config.PruneInterval > 0 {
+		srv.jobs = append(srv.jobs, startPeriodicJob(pool, config.PruneInterval, pruneRevisions))
+	}
 	// Version independent API.
 	handle(srv.mux, "/debug", newServiceDebugHandler(pool, config, srv.mux))
 	for vers, newAPI := range versions {
@@ -141,6 +194,7 @@ type Server struct {
 	pool     *Pool
 	mux      *router.ServeMux
 	handlers []HTTPCloseHandler
+	jobs     []*periodicJob
 }
 
 // ServeHTTP implements http.Handler.ServeHTTP.
@@ -151,6 +205,10 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 // Close closes the server. It must be called when the server
 // is finished with.
 func (s *Server) Close() {
+	for _, j := range s.jobs {
+		j.Close()
+	}
+	s.jobs = nil
 	s.pool.Close()
 	for _, h := range s.handlers {
 		h.Close()
@@ -0,0 +1,154 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/agl/ed25519"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+// PublisherKeys returns the Mongo collection where the public keys
+// used to sign entity archives are stored.
+func (s StoreDatabase) PublisherKeys() *mgo.Collection {
+	return s.C("publisher_keys")
+}
+
+// signatureAlgorithm holds the sizes of the keys and
+// signatures used by a signature algorithm.
+type signatureAlgorithm struct {
+	keySize       int
+	signatureSize int
+
+	// verify reports whether sig is a valid signature
+	// of message made with the given public key.
+	// The key and signature are known to be of the
+	// correct size.
+	verify func(key, message, sig []byte) bool
+}
+
+// signatureAlgorithms holds the supported signature algorithms.
+var signatureAlgorithms = map[string]signatureAlgorithm{
+	"ed25519": {
+		keySize:       ed25519.PublicKeySize,
+		signatureSize: ed25519.SignatureSize,
+		verify:        verifyEd25519,
+	},
+}
+
+func verifyEd25519(key, message, sig []byte) bool {
+	var k [ed25519.PublicKeySize]byte
+	var s [ed25519.SignatureSize]byte
+	copy(k[:], key)
+	copy(s[:], sig)
+	return ed25519.Verify(&k, message, &s)
+}
+
+// AddPublisherKey registers the given public key for the given user
+// and returns the registered key. Registering a key that is already
+// registered has no effect.
+func (s *Store) AddPublisherKey(user, algorithm string, key []byte) (*mongodoc.PublisherKey, error) {
+	alg, ok := signatureAlgorithms[algorithm]
+	if !ok {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "unsupported signature algorithm %q", algorithm)
+	}
+	if len(key) != alg.keySize {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "invalid %s public key size %d", algorithm, len(key))
+	}
+	keyId := publisherKeyId(key)
+	_, err := s.DB.PublisherKeys().Upsert(
+		bson.D{{"user", user}, {"keyid", keyId}},
+		bson.D{{"$setOnInsert", &mongodoc.PublisherKey{
+			User:       user,
+			KeyId:      keyId,
+			Algorithm:  algorithm,
+			PublicKey:  key,
+			CreateTime: time.Now(),
+		}}},
+	)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot add publisher key")
+	}
+	var pk mongodoc.PublisherKey
+	if err := s.DB.PublisherKeys().Find(bson.D{{"user", user}, {"keyid", keyId}}).One(&pk); err != nil {
+		return nil, errgo.Notef(err, "cannot get publisher key")
+	}
+	return &pk, nil
+}
+
+// publisherKeyId returns the id of the given public key.
+func publisherKeyId(key []byte) string {
+	sum := sha256.Sum256(key)
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// PublisherKeys returns the public keys registered
+// by the given user, ordered by key id.
+func (s *Store) PublisherKeys(user string) ([]*mongodoc.PublisherKey, error) {
+	var keys []*mongodoc.PublisherKey
+	if err := s.DB.PublisherKeys().Find(bson.D{{"user", user}}).Sort("keyid").All(&keys); err != nil {
+		return nil, errgo.Notef(err, "cannot get publisher keys for %q", user)
+	}
+	return keys, nil
+}
+
+// RemovePublisherKey removes the public key with the given id
+// registered by the given user, along with all the signatures
+// made with it on entities owned by the user. It returns an error
+// with a params.ErrNotFound cause if there is no such key.
+func (s *Store) RemovePublisherKey(user, keyId string) error {
+	err := s.DB.PublisherKeys().Remove(bson.D{{"user", user}, {"keyid", keyId}})
+	if err == mgo.ErrNotFound {
+		return errgo.WithCausef(nil, params.ErrNotFound, "publisher key %q not found", keyId)
+	}
+	if err != nil {
+		return errgo.Notef(err, "cannot remove publisher key")
+	}
+	_, err = s.DB.Entities().UpdateAll(
+		bson.D{{"user", user}, {"signatures.keyid", keyId}},
+		bson.D{{"$pull", bson.D{{"signatures", bson.D{{"keyid", keyId}}}}}},
+	)
+	if err != nil {
+		return errgo.Notef(err, "cannot remove signatures made with publisher key %q", keyId)
+	}
+	return nil
+}
+
+// CheckSignature checks that the given signature is a valid signature
+// of the given entity's archive made with a key registered by the owner
+// of the entity. The signed message is the hexadecimal SHA384 hash of
+// the archive, as held in entity.BlobHash. It returns an error with a
+// params.ErrBadRequest cause if the signature is not valid.
+//
+// The entity must have at least the URL and BlobHash fields populated.
+func (s *Store) CheckSignature(entity *mongodoc.Entity, sig *mongodoc.Signature) error {
+	alg, ok := signatureAlgorithms[sig.Algorithm]
+	if !ok {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "unsupported signature algorithm %q", sig.Algorithm)
+	}
+	if len(sig.Signature) != alg.signatureSize {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "invalid %s signature size %d", sig.Algorithm, len(sig.Signature))
+	}
+	user := entity.URL.User
+	var key mongodoc.PublisherKey
+	err := s.DB.PublisherKeys().Find(bson.D{{"user", user}, {"keyid", sig.KeyId}, {"algorithm", sig.Algorithm}}).One(&key)
+	if err == mgo.ErrNotFound {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "%s key %q not registered by %q", sig.Algorithm, sig.KeyId, user)
+	}
+	if err != nil {
+		return errgo.Notef(err, "cannot get publisher key")
+	}
+	if !alg.verify(key.PublicKey, []byte(entity.BlobHash), sig.Signature) {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "invalid %s signature for key %q", sig.Algorithm, sig.KeyId)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"bytes"
+	"crypto/rand"
+
+	"github.com/agl/ed25519"
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+func (s *StoreSuite) TestAddPublisherKey(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	key, err := store.AddPublisherKey("bob", "ed25519", bytes.Repeat([]byte{1}, 32))
+	c.Assert(err, gc.IsNil)
+	c.Assert(key.User, gc.Equals, "bob")
+	c.Assert(key.KeyId, gc.HasLen, 16)
+	c.Assert(key.Algorithm, gc.Equals, "ed25519")
+
+	// Adding the same key again returns the existing key.
+	key1, err := store.AddPublisherKey("bob", "ed25519", bytes.Repeat([]byte{1}, 32))
+	c.Assert(err, gc.IsNil)
+	c.Assert(key1, jc.DeepEquals, key)
+
+	key2, err := store.AddPublisherKey("bob", "ed25519", bytes.Repeat([]byte{2}, 32))
+	c.Assert(err, gc.IsNil)
+	keys, err := store.PublisherKeys("bob")
+	c.Assert(err, gc.IsNil)
+	c.Assert(keys, gc.HasLen, 2)
+	expectKeys := []*mongodoc.PublisherKey{key, key2}
+	if key2.KeyId < key.KeyId {
+		expectKeys = []*mongodoc.PublisherKey{key2, key}
+	}
+	c.Assert(keys, jc.DeepEquals, expectKeys)
+
+	keys, err = store.PublisherKeys("alice")
+	c.Assert(err, gc.IsNil)
+	c.Assert(keys, gc.HasLen, 0)
+
+	_, err = store.AddPublisherKey("bob", "rot13", bytes.Repeat([]byte{1}, 32))
+	c.Assert(err, gc.ErrorMatches, `unsupported signature algorithm "rot13"`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+	_, err = store.AddPublisherKey("bob", "ed25519", []byte("foo"))
+	c.Assert(err, gc.ErrorMatches, `invalid ed25519 public key size 3`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+}
+
+func (s *StoreSuite) TestCheckSignature(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	id := MustParseResolvedURL("~bob/trusty/wordpress-0")
+	err := store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	entity, err := store.FindEntity(id, "_id", "blobhash")
+	c.Assert(err, gc.IsNil)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	c.Assert(err, gc.IsNil)
+	key, err := store.AddPublisherKey("bob", "ed25519", publicKey[:])
+	c.Assert(err, gc.IsNil)
+	signature := ed25519.Sign(privateKey, []byte(entity.BlobHash))[:]
+	err = store.CheckSignature(entity, &mongodoc.Signature{
+		KeyId:     key.KeyId,
+		Algorithm: "ed25519",
+		Signature: signature,
+	})
+	c.Assert(err, gc.IsNil)
+
+	// A key registered by another user cannot be used
+	// to sign bob's entities.
+	aliceKey, err := store.AddPublisherKey("alice", "ed25519", bytes.Repeat([]byte{1}, 32))
+	c.Assert(err, gc.IsNil)
+
+	otherSignature := ed25519.Sign(privateKey, []byte("something else"))[:]
+	for i, test := range []struct {
+		sig         mongodoc.Signature
+		expectError string
+	}{{
+		sig: mongodoc.Signature{
+			KeyId:     key.KeyId,
+			Algorithm: "rot13",
+			Signature: signature,
+		},
+		expectError: `unsupported signature algorithm "rot13"`,
+	}, {
+		sig: mongodoc.Signature{
+			KeyId:     key.KeyId,
+			Algorithm: "ed25519",
+			Signature: signature[0:63],
+		},
+		expectError: `invalid ed25519 signature size 63`,
+	}, {
+		sig: mongodoc.Signature{
+			KeyId:     aliceKey.KeyId,
+			Algorithm: "ed25519",
+			Signature: signature,
+		},
+		expectError: `ed25519 key "` + aliceKey.KeyId + `" not registered by "bob"`,
+	}, {
+		sig: mongodoc.Signature{
+			KeyId:     key.KeyId,
+			Algorithm: "ed25519",
+			Signature: otherSignature,
+		},
+		expectError: `invalid ed25519 signature for key "` + key.KeyId + `"`,
+	}} {
+		c.Logf("test %d: %s", i, test.expectError)
+		err := store.CheckSignature(entity, &test.sig)
+		c.Assert(err, gc.ErrorMatches, test.expectError)
+		c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+	}
+}
+
+func (s *StoreSuite) TestRemovePublisherKey(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	id := MustParseResolvedURL("~bob/trusty/wordpress-0")
+	err := store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	key1, err := store.AddPublisherKey("bob", "ed25519", bytes.Repeat([]byte{1}, 32))
+	c.Assert(err, gc.IsNil)
+	key2, err := store.AddPublisherKey("bob", "ed25519", bytes.Repeat([]byte{2}, 32))
+	c.Assert(err, gc.IsNil)
+	sigs := []mongodoc.Signature{{
+		KeyId:     key1.KeyId,
+		Algorithm: "ed25519",
+		Signature: bytes.Repeat([]byte{3}, 64),
+	}, {
+		KeyId:     key2.KeyId,
+		Algorithm: "ed25519",
+		Signature: bytes.Repeat([]byte{4}, 64),
+	}}
+	err = store.UpdateEntity(id, bson.D{{"$set", bson.D{{"signatures", sigs}}}})
+	c.Assert(err, gc.IsNil)
+
+	err = store.RemovePublisherKey("bob", key1.KeyId)
+	c.Assert(err, gc.IsNil)
+	keys, err := store.PublisherKeys("bob")
+	c.Assert(err, gc.IsNil)
+	c.Assert(keys, jc.DeepEquals, []*mongodoc.PublisherKey{key2})
+	entity, err := store.FindEntity(id, "signatures")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.Signatures, jc.DeepEquals, sigs[1:])
+
+	err = store.RemovePublisherKey("bob", key1.KeyId)
+	c.Assert(err, gc.ErrorMatches, `publisher key "`+key1.KeyId+`" not found`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
@@ -102,6 +102,38 @@ func (s *stats) key(db StoreDatabase, key []string, write bool) (string, error)
 	return string(skey), nil
 }
 
+// keyTokens returns the words represented by the compound statistics
+// identifier skey, as returned by key. Any "*" sections of the
+// identifier are ignored.
+func (s *stats) keyTokens(db StoreDatabase, skey string) ([]string, error) {
+	ids := strings.Split(skey, ":")
+	tokens := make([]string, 0, len(ids))
+	for i := 0; i < len(ids)-1; i++ {
+		if ids[i] == "*" {
+			continue
+		}
+		id, err := strconv.ParseInt(ids[i], 32, 32)
+		if err != nil {
+			return nil, errgo.Newf("store: invalid id: %q", ids[i])
+		}
+		token, found := s.idToken(int(id))
+		if !found {
+			var t tokenId
+			err = db.StatTokens().FindId(id).One(&t)
+			if err == mgo.ErrNotFound {
+				return nil, errgo.Newf("store: internal error; token id not found: %d", id)
+			}
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			s.cacheTokenId(t.Token, t.Id)
+			token = t.Token
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
 const statsTokenCacheSize = 1024
 
 type tokenId struct {
@@ -263,7 +295,6 @@ type Counter struct {
 
 // Counters aggregates and returns counter values according to the provided request.
 func (s *Store) Counters(req *CounterRequest) ([]Counter, error) {
-	tokensColl := s.DB.StatTokens()
 	countersColl := s.DB.StatCounters()
 
 	searchKey, err := s.stats.key(s.DB, req.Key, false)
@@ -375,26 +406,9 @@ func (s *Store) Counters(req *CounterRequest) ([]Counter, error) {
 			when = time.Unix(counterEpoch+stamp, 0).In(time.UTC)
 		}
 		ids := strings.Split(key, ":")
-		tokens := make([]string, 0, len(ids))
-		for i := 0; i < len(ids)-1; i++ {
-			if ids[i] == "*" {
-				continue
-			}
-			id, err := strconv.ParseInt(ids[i], 32, 32)
-			if err != nil {
-				return nil, errgo.Newf("store: invalid id: %q", ids[i])
-			}
-			token, found := s.stats.idToken(int(id))
-			if !found {
-				var t tokenId
-				err = tokensColl.FindId(id).One(&t)
-				if err == mgo.ErrNotFound {
-					return nil, errgo.Newf("store: internal error; token id not found: %d", id)
-				}
-				s.stats.cacheTokenId(t.Token, t.Id)
-				token = t.Token
-			}
-			tokens = append(tokens, token)
+		tokens, err := s.stats.keyTokens(s.DB, key)
+		if err != nil {
+			return nil, err
 		}
 		counter := Counter{
 			Key:    tokens,
@@ -64,6 +64,10 @@ type Pool struct {
 	// stores that are not currently in use.
 	reqStoreC chan *Store
 
+	// searchCheck records the search check
+	// started by Store.StartSearchCheck.
+	searchCheck searchCheckJob
+
 	// mu guards the fields following it.
 	mu sync.Mutex
 
@@ -94,6 +98,9 @@ func NewPool(db *mgo.Database, si *SearchIndex, bakeryParams *bakery.NewServiceP
 	if config.StatsCacheMaxAge == 0 {
 		config.StatsCacheMaxAge = time.Hour
 	}
+	if config.TrashGracePeriod == 0 {
+		config.TrashGracePeriod = DefaultTrashGracePeriod
+	}
 
 	p := &Pool{
 		db:          StoreDatabase{db}.copy(),
@@ -141,6 +148,9 @@ func NewPool(db *mgo.Database, si *SearchIndex, bakeryParams *bakery.NewServiceP
 	if err := store.ES.ensureIndexes(false); err != nil {
 		return nil, errgo.Notef(err, "cannot ensure elasticsearch indexes")
 	}
+	if err := store.ensureMongoSearch(); err != nil {
+		return nil, errgo.Notef(err, "cannot populate search documents")
+	}
 	return p, nil
 }
 
@@ -363,6 +373,18 @@ func (s *Store) ensureIndexes() error {
 	}, {
 		s.DB.Logs(),
 		mgo.Index{Key: []string{"urls"}},
+	}, {
+		s.DB.SearchDocs(),
+		mgo.Index{Key: []string{"readacls"}},
+	}, {
+		s.DB.SearchDocs(),
+		mgo.Index{Key: []string{"channels"}},
+	}, {
+		s.DB.SearchDocs(),
+		mgo.Index{Key: []string{"series"}},
+	}, {
+		s.DB.SearchDocs(),
+		mgo.Index{Key: []string{"supportedseries"}},
 	}, {
 		s.DB.Entities(),
 		mgo.Index{Key: []string{"user"}},
@@ -375,6 +397,9 @@ func (s *Store) ensureIndexes() error {
 	}, {
 		s.DB.Entities(),
 		mgo.Index{Key: []string{"series"}},
+	}, {
+		s.DB.Entities(),
+		mgo.Index{Key: []string{"supportedseries"}},
 	}, {
 		s.DB.Entities(),
 		mgo.Index{Key: []string{"blobhash256"}},
@@ -393,6 +418,18 @@ func (s *Store) ensureIndexes() error {
 	}, {
 		s.DB.BaseEntities(),
 		mgo.Index{Key: []string{"name"}},
+	}, {
+		s.DB.Resources(),
+		mgo.Index{Key: []string{"baseurl", "stream", "revision"}, Unique: true},
+	}, {
+		s.DB.Redirects(),
+		mgo.Index{Key: []string{"to"}},
+	}, {
+		s.DB.PublisherKeys(),
+		mgo.Index{Key: []string{"user", "keyid"}, Unique: true},
+	}, {
+		s.DB.UploadSessions(),
+		mgo.Index{Key: []string{"expires"}},
 	}}
 	for _, idx := range indexes {
 		err := idx.c.EnsureIndex(idx.i)
@@ -433,12 +470,22 @@ func (s *Store) AddCharmWithArchive(url *router.ResolvedURL, ch charm.Charm) err
 	if err != nil {
 		return errgo.Notef(err, "cannot upload charm")
 	}
+	r, _, err := s.BlobStore.Open(blobName)
+	if err != nil {
+		return errgo.Notef(err, "cannot open charm archive")
+	}
+	defer r.Close()
+	hooks, err := ArchiveHooks(ReaderAtSeeker(r), blobSize)
+	if err != nil {
+		return errgo.Mask(err)
+	}
 	return s.AddCharm(ch, AddParams{
 		URL:         url,
 		BlobName:    blobName,
 		BlobHash:    blobHash,
 		BlobHash256: blobHash256,
 		BlobSize:    blobSize,
+		CharmHooks:  hooks,
 	})
 }
 
@@ -514,6 +561,14 @@ type AddParams struct {
 	// Contents holds references to files inside the
 	// entity's archive blob.
 	Contents map[mongodoc.FileId]mongodoc.ZipFile
+
+	// LintWarnings holds the warnings found when
+	// linting the entity's archive.
+	LintWarnings []mongodoc.LintProblem
+
+	// CharmHooks holds the names of the hooks
+	// in a charm's archive.
+	CharmHooks []string
 }
 
 // AddCharm adds a charm entities collection with the given
@@ -523,9 +578,19 @@ func (s *Store) AddCharm(c charm.Charm, p AddParams) (err error) {
 	// always be canonical, but check just in case anyway, as this is
 	// final gateway before a potentially invalid url might be stored
 	// in the database.
-	if p.URL.URL.Series == "bundle" || p.URL.URL.User == "" || p.URL.URL.Revision == -1 || p.URL.URL.Series == "" {
+	if p.URL.URL.Series == "bundle" || p.URL.URL.User == "" || p.URL.URL.Revision == -1 {
 		return errgo.Newf("charm added with invalid id %v", &p.URL.URL)
 	}
+	// A charm without a series in its id is a multi-series
+	// charm, which supports all the series declared in its
+	// metadata.
+	var supportedSeries []string
+	if p.URL.URL.Series == "" {
+		supportedSeries = c.Meta().Series
+		if len(supportedSeries) == 0 {
+			return errgo.Newf("multi-series charm %v declares no series", &p.URL.URL)
+		}
+	}
 	logger.Infof("add charm url %s; prev %d", &p.URL.URL, p.URL.PromulgatedRevision)
 	entity := &mongodoc.Entity{
 		URL:                     &p.URL.URL,
@@ -534,6 +599,7 @@ func (s *Store) AddCharm(c charm.Charm, p AddParams) (err error) {
 		Name:                    p.URL.URL.Name,
 		Revision:                p.URL.URL.Revision,
 		Series:                  p.URL.URL.Series,
+		SupportedSeries:         supportedSeries,
 		BlobHash:                p.BlobHash,
 		BlobHash256:             p.BlobHash256,
 		BlobName:                p.BlobName,
@@ -542,11 +608,13 @@ func (s *Store) AddCharm(c charm.Charm, p AddParams) (err error) {
 		CharmMeta:               c.Meta(),
 		CharmConfig:             c.Config(),
 		CharmActions:            c.Actions(),
+		CharmHooks:              p.CharmHooks,
 		CharmProvidedInterfaces: interfacesForRelations(c.Meta().Provides),
 		CharmRequiredInterfaces: interfacesForRelations(c.Meta().Requires),
 		Contents:                p.Contents,
 		PromulgatedURL:          p.URL.PromulgatedURL(),
 		PromulgatedRevision:     p.URL.PromulgatedRevision,
+		LintWarnings:            p.LintWarnings,
 	}
 
 	// Check that we're not going to create a charm that duplicates
@@ -618,9 +686,17 @@ func (s *Store) insertEntity(entity *mongodoc.Entity) (err error) {
 // If the given URL has no user then it is assumed to be a
 // promulgated entity.
 func (s *Store) FindEntity(url *router.ResolvedURL, fields ...string) (*mongodoc.Entity, error) {
-	entities, err := s.FindEntities(&url.URL, fields...)
-	if err != nil {
-		return nil, errgo.Mask(err)
+	// The id is matched exactly, so a multi-series charm is only
+	// found by its id without a series rather than by the same
+	// revision in any supported series.
+	idField := "_id"
+	if url.URL.User == "" {
+		idField = "promulgated-url"
+	}
+	var entities []*mongodoc.Entity
+	query := s.DB.Entities().Find(bson.D{{idField, &url.URL}, {"trashtime", bson.D{{"$exists", false}}}})
+	if err := selectFields(query, fields).All(&entities); err != nil {
+		return nil, errgo.Notef(err, "cannot find entity %s", &url.URL)
 	}
 	if len(entities) == 0 {
 		return nil, errgo.WithCausef(nil, params.ErrNotFound, "entity not found")
@@ -648,10 +724,17 @@ func (s *Store) FindEntities(url *charm.Reference, fields ...string) ([]*mongodo
 // the given URL. If any fields are specified, only those fields will be
 // populated in the returned entities. If the given URL has no user then
 // only promulgated entities will be queried.
-func (s *Store) FindBestEntity(url *charm.Reference, fields ...string) (*mongodoc.Entity, error) {
+//
+// If channel is not empty and the URL does not specify a revision, only
+// entities published to that channel will be considered.
+func (s *Store) FindBestEntity(url *charm.Reference, channel string, fields ...string) (*mongodoc.Entity, error) {
+	if channel != "" && url.Revision == -1 {
+		entity, err := s.findBestEntityInChannel(url, channel, fields...)
+		return entity, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
 	if len(fields) > 0 {
 		// Make sure we have all the fields we need to make a decision.
-		fields = append(fields, "_id", "promulgated-url", "promulgated-revision", "series", "revision")
+		fields = append(fields, "_id", "promulgated-url", "promulgated-revision", "series", "supportedseries", "revision")
 	}
 	entities, err := s.FindEntities(url, fields...)
 	if err != nil {
@@ -662,11 +745,11 @@ func (s *Store) FindBestEntity(url *charm.Reference, fields ...string) (*mongodo
 	}
 	best := entities[0]
 	for _, e := range entities {
-		if seriesScore[e.Series] > seriesScore[best.Series] {
+		if entitySeriesScore(e, url.Series) > entitySeriesScore(best, url.Series) {
 			best = e
 			continue
 		}
-		if seriesScore[e.Series] < seriesScore[best.Series] {
+		if entitySeriesScore(e, url.Series) < entitySeriesScore(best, url.Series) {
 			continue
 		}
 		if url.User == "" {
@@ -695,20 +778,62 @@ var seriesScore = map[string]int{
 	"utopic":  4,
 }
 
+// entitySeriesScore returns the series score of the given entity
+// when it is being resolved for the given series, which may be empty.
+// A multi-series charm scores as the requested series if there
+// is one, or as the best of its supported series otherwise.
+func entitySeriesScore(e *mongodoc.Entity, series string) int {
+	if e.Series != "" {
+		return seriesScore[e.Series]
+	}
+	if series != "" {
+		return seriesScore[series]
+	}
+	score := 0
+	for i, s := range e.SupportedSeries {
+		if i == 0 || seriesScore[s] > score {
+			score = seriesScore[s]
+		}
+	}
+	return score
+}
+
 // EntitiesQuery creates a mgo.Query object that can be used to find
 // entities matching the given URL. If the given URL has no user then
-// the produced query will only match promulgated entities.
+// the produced query will only match promulgated entities. Entities
+// that have been moved to the trash are never matched.
 func (s *Store) EntitiesQuery(url *charm.Reference) *mgo.Query {
-	if url.User != "" && url.Series != "" && url.Revision != -1 {
-		// Find a specific owned entity, for instance ~who/utopic/django-42.
-		return s.DB.Entities().FindId(url)
-	}
+	q := entitiesQuery(url)
+	q = append(q, bson.DocElem{"trashtime", bson.D{{"$exists", false}}})
+	return s.DB.Entities().Find(q)
+}
+
+// EntitiesQueryWithTrash is like EntitiesQuery except that
+// entities that have been moved to the trash are also matched.
+func (s *Store) EntitiesQueryWithTrash(url *charm.Reference) *mgo.Query {
+	return s.DB.Entities().Find(entitiesQuery(url))
+}
+
+// entitiesQuery returns the query document used by EntitiesQuery.
+func entitiesQuery(url *charm.Reference) bson.D {
 	if url.Series != "" && url.Revision != -1 {
-		// Find a specific promulgated entity, for instance utopic/django-42.
-		return s.DB.Entities().Find(bson.D{{"promulgated-url", url}})
+		// Find a specific owned entity, for instance ~who/utopic/django-42,
+		// or a specific promulgated entity, for instance utopic/django-42.
+		// A multi-series charm without the series in its id matches
+		// too if it supports the series.
+		idField := "_id"
+		if url.User == "" {
+			idField = "promulgated-url"
+		}
+		multiURL := *url
+		multiURL.Series = ""
+		return bson.D{{"$or", []bson.D{
+			{{idField, url}},
+			{{idField, &multiURL}, {"supportedseries", url.Series}},
+		}}}
 	}
 	// Find all entities matching the URL.
-	q := make(bson.D, 0, 3)
+	q := make(bson.D, 0, 4)
 	q = append(q, bson.DocElem{"name", url.Name})
 	if url.User != "" {
 		q = append(q, bson.DocElem{"user", url.User})
@@ -717,7 +842,10 @@ func (s *Store) EntitiesQuery(url *charm.Reference) *mgo.Query {
 		q = append(q, bson.DocElem{"promulgated-url", bson.D{{"$exists", true}}})
 	}
 	if url.Series != "" {
-		q = append(q, bson.DocElem{"series", url.Series})
+		q = append(q, bson.DocElem{"$or", []bson.D{
+			{{"series", url.Series}},
+			{{"supportedseries", url.Series}},
+		}})
 	}
 	if url.Revision != -1 {
 		if url.User != "" {
@@ -726,7 +854,7 @@ func (s *Store) EntitiesQuery(url *charm.Reference) *mgo.Query {
 			q = append(q, bson.DocElem{"promulgated-revision", url.Revision})
 		}
 	}
-	return s.DB.Entities().Find(q)
+	return q
 }
 
 // FindBaseEntity finds the base entity in the store using the given URL,
@@ -988,6 +1116,7 @@ func (s *Store) AddBundle(b charm.Bundle, p AddParams) error {
 		Contents:            p.Contents,
 		PromulgatedURL:      p.URL.PromulgatedURL(),
 		PromulgatedRevision: p.URL.PromulgatedRevision,
+		LintWarnings:        p.LintWarnings,
 	}
 
 	// Check that we're not going to create a bundle that duplicates
@@ -1011,15 +1140,30 @@ func (s *Store) AddBundle(b charm.Bundle, p AddParams) error {
 // data source, its size and its hash. It returns a params.ErrNotFound
 // error if the entity does not exist.
 func (s *Store) OpenBlob(id *router.ResolvedURL) (r blobstore.ReadSeekCloser, size int64, hash string, err error) {
-	blobName, hash, err := s.BlobNameAndHash(id)
+	r, size, entity, err := s.OpenBlobEntity(id)
 	if err != nil {
 		return nil, 0, "", errgo.Mask(err, errgo.Is(params.ErrNotFound))
 	}
-	r, size, err = s.BlobStore.Open(blobName)
+	return r, size, entity.BlobHash, nil
+}
+
+// OpenBlobEntity is like OpenBlob except that it returns the entity
+// instead of the blob's hash. The returned entity holds the BlobName
+// and BlobHash fields as well as any of the given fields, so that
+// callers needing more of the entity do not have to look it up again.
+func (s *Store) OpenBlobEntity(id *router.ResolvedURL, fields ...string) (r blobstore.ReadSeekCloser, size int64, entity *mongodoc.Entity, err error) {
+	entity, err = s.FindEntity(id, append([]string{"blobname", "blobhash"}, fields...)...)
 	if err != nil {
-		return nil, 0, "", errgo.Notef(err, "cannot open archive data for %s", id)
+		if errgo.Cause(err) == params.ErrNotFound {
+			return nil, 0, nil, errgo.WithCausef(nil, params.ErrNotFound, "entity not found")
+		}
+		return nil, 0, nil, errgo.Notef(err, "cannot get %s", id)
 	}
-	return r, size, hash, nil
+	r, size, err = s.BlobStore.Open(entity.BlobName)
+	if err != nil {
+		return nil, 0, nil, errgo.Notef(err, "cannot open archive data for %s", id)
+	}
+	return r, size, entity, nil
 }
 
 // BlobNameAndHash returns the name that is used to store the blob
@@ -1307,6 +1451,13 @@ var allCollections = []func(StoreDatabase) *mgo.Collection{
 	StoreDatabase.BaseEntities,
 	StoreDatabase.Logs,
 	StoreDatabase.Migrations,
+	StoreDatabase.Resources,
+	StoreDatabase.Redirects,
+	StoreDatabase.PublisherKeys,
+	StoreDatabase.Quotas,
+	StoreDatabase.UploadSessions,
+	StoreDatabase.Featured,
+	StoreDatabase.SearchDocs,
 }
 
 // Collections returns a slice of all the collections used
@@ -1339,18 +1490,61 @@ func ReaderAtSeeker(r io.ReadSeeker) io.ReaderAt {
 
 // Search searches the store for the given SearchParams.
 // It returns a SearchResult containing the results of the search.
+//
+// If sp.Channel is set, each result refers to the entity
+// published to that channel, rather than to the latest
+// revision. Results that are no longer published to the channel,
+// because the search index is out of date, are omitted, so the
+// page of results may hold fewer than sp.Limit results even when
+// more follow. No other results are fetched in their place, so
+// that the next page can still be requested by adding sp.Limit to
+// sp.Skip. Total is reduced by the number of results omitted, but
+// it and any facet counts may still include out of date entries
+// in other pages.
 func (store *Store) Search(sp SearchParams) (SearchResult, error) {
-	result, err := store.ES.search(sp)
+	backend := store.searchBackend()
+	if backend == nil {
+		return SearchResult{}, nil
+	}
+	result, err := backend.search(sp)
 	if err != nil {
 		return SearchResult{}, errgo.Mask(err)
 	}
+	if sp.Channel == "" {
+		return result, nil
+	}
+	results := make([]*router.ResolvedURL, 0, len(result.Results))
+	var highlights []map[string][]string
+	for i, r := range result.Results {
+		entity, err := store.findBestEntityInChannel(&r.URL, sp.Channel, "_id", "promulgated-url")
+		if errgo.Cause(err) == params.ErrNotFound {
+			// The search index is out of date, so omit the result.
+			result.Total--
+			continue
+		}
+		if err != nil {
+			return SearchResult{}, errgo.Mask(err)
+		}
+		results = append(results, EntityResolvedURL(entity))
+		// Keep the highlights in step with the results.
+		if result.Highlights != nil {
+			highlights = append(highlights, result.Highlights[i])
+		}
+	}
+	result.Results = results
+	result.Highlights = highlights
 	return result, nil
 }
 
-// SynchroniseElasticsearch creates new indexes in elasticsearch
-// and populates them with the current data from the mongodb database.
+// SynchroniseElasticsearch creates new search indexes, in elasticsearch
+// or in MongoDB if elasticsearch is not configured, and populates them
+// with the current data from the mongodb database.
 func (s *Store) SynchroniseElasticsearch() error {
-	if err := s.ES.ensureIndexes(true); err != nil {
+	backend := s.searchBackend()
+	if backend == nil {
+		return nil
+	}
+	if err := backend.ensureIndexes(true); err != nil {
 		return errgo.Notef(err, "cannot create indexes")
 	}
 	if err := s.syncSearch(); err != nil {
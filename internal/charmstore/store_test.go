@@ -90,6 +90,10 @@ func (s *StoreSuite) checkAddCharm(c *gc.C, ch charm.Charm, addToES bool, url *r
 	c.Assert(blobName, gc.Matches, "[0-9a-z]+")
 	doc.BlobName = ""
 
+	// The hooks are checked against the archive below.
+	hooks := doc.CharmHooks
+	doc.CharmHooks = nil
+
 	c.Assert(doc, jc.DeepEquals, mongodoc.Entity{
 		URL:                     &url.URL,
 		BaseURL:                 baseURL(&url.URL),
@@ -123,6 +127,19 @@ func (s *StoreSuite) checkAddCharm(c *gc.C, ch charm.Charm, addToES bool, url *r
 	c.Assert(charmArchive.Actions(), jc.DeepEquals, ch.Actions())
 	c.Assert(charmArchive.Revision(), jc.DeepEquals, ch.Revision())
 
+	// The names of the hooks in the archive have been stored.
+	zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	c.Assert(err, gc.IsNil)
+	var expectHooks []string
+	for _, f := range zipReader.File {
+		if strings.HasPrefix(f.Name, "hooks/") && !f.Mode().IsDir() {
+			expectHooks = append(expectHooks, path.Base(f.Name))
+		}
+	}
+	sort.Strings(expectHooks)
+	c.Assert(expectHooks, gc.Not(gc.HasLen), 0)
+	c.Assert(hooks, jc.DeepEquals, expectHooks)
+
 	// Check that the base entity has been properly created.
 	assertBaseEntity(c, store, baseURL(&url.URL), url.PromulgatedRevision != -1)
 
@@ -1274,6 +1291,35 @@ func (s *StoreSuite) TestOpenBlob(c *gc.C) {
 	c.Assert(size, gc.Equals, info.Size())
 }
 
+func (s *StoreSuite) TestOpenBlobEntity(c *gc.C) {
+	charmArchive := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	store := s.newStore(c, false)
+	defer store.Close()
+	url := newResolvedURL("cs:~charmers/precise/wordpress-23", 23)
+	err := store.AddCharmWithArchive(url, charmArchive)
+	c.Assert(err, gc.IsNil)
+
+	f, err := os.Open(charmArchive.Path)
+	c.Assert(err, gc.IsNil)
+	defer f.Close()
+	expectHash := hashOfReader(c, f)
+
+	r, _, entity, err := store.OpenBlobEntity(url, "size")
+	c.Assert(err, gc.IsNil)
+	defer r.Close()
+
+	c.Assert(hashOfReader(c, r), gc.Equals, expectHash)
+	c.Assert(entity.BlobHash, gc.Equals, expectHash)
+	c.Assert(entity.BlobName, gc.Not(gc.Equals), "")
+	info, err := f.Stat()
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.Size, gc.Equals, info.Size())
+	c.Assert(entity.CharmMeta, gc.IsNil)
+
+	_, _, _, err = store.OpenBlobEntity(newResolvedURL("cs:~charmers/precise/wordpress-24", 24))
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
+
 func (s *StoreSuite) TestBlobNameAndHash(c *gc.C) {
 	charmArchive := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
 
@@ -1415,6 +1461,9 @@ func (s *StoreSuite) TestCollections(c *gc.C) {
 	createdOnUse := map[string]bool{
 		"migrations": true,
 		"macaroons":  true,
+		"quotas":     true,
+		"featured":   true,
+		"searchdocs": true,
 	}
 	// Check that all collections mentioned by Collections are actually created.
 	for _, coll := range colls {
@@ -1835,7 +1884,7 @@ func (s *StoreSuite) TestFindBestEntity(c *gc.C) {
 	c.Assert(err, gc.IsNil)
 	for i, test := range findBestEntityTests {
 		c.Logf("test %d: %s", i, test.url)
-		entity, err := store.FindBestEntity(charm.MustParseReference(test.url))
+		entity, err := store.FindBestEntity(charm.MustParseReference(test.url), "")
 		if test.expectErr != "" {
 			c.Assert(err, gc.ErrorMatches, test.expectErr)
 		} else {
@@ -1845,6 +1894,82 @@ func (s *StoreSuite) TestFindBestEntity(c *gc.C) {
 	}
 }
 
+var multiSeriesFindBestEntityTests = []struct {
+	url       string
+	expectURL string
+	expectErr string
+}{{
+	url:       "~charmers/multi-series",
+	expectURL: "~charmers/multi-series-1",
+}, {
+	url:       "~charmers/trusty/multi-series",
+	expectURL: "~charmers/multi-series-1",
+}, {
+	url:       "~charmers/utopic/multi-series",
+	expectURL: "~charmers/multi-series-1",
+}, {
+	url:       "~charmers/utopic/multi-series-1",
+	expectURL: "~charmers/multi-series-1",
+}, {
+	url:       "~charmers/trusty/multi-series-0",
+	expectURL: "~charmers/trusty/multi-series-0",
+}, {
+	url:       "~charmers/multi-series-1",
+	expectURL: "~charmers/multi-series-1",
+}, {
+	url:       "multi-series",
+	expectURL: "~charmers/multi-series-1",
+}, {
+	url:       "precise/multi-series",
+	expectURL: "~charmers/multi-series-1",
+}, {
+	url:       "precise/multi-series-1",
+	expectURL: "~charmers/multi-series-1",
+}, {
+	url:       "trusty/multi-series-0",
+	expectURL: "~charmers/trusty/multi-series-0",
+}, {
+	url:       "~charmers/quantal/multi-series",
+	expectErr: "entity not found",
+}, {
+	url:       "~charmers/utopic/multi-series-0",
+	expectErr: "entity not found",
+}}
+
+func (s *StoreSuite) TestFindBestEntityMultiSeries(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	for _, id := range []string{"0 ~charmers/trusty/multi-series-0", "1 ~charmers/multi-series-1"} {
+		err := store.AddCharmWithArchive(MustParseResolvedURL(id), storetesting.Charms.CharmDir("multi-series"))
+		c.Assert(err, gc.IsNil)
+	}
+	entity, err := store.FindEntity(MustParseResolvedURL("1 ~charmers/multi-series-1"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.Series, gc.Equals, "")
+	c.Assert(entity.SupportedSeries, jc.DeepEquals, []string{"precise", "trusty", "utopic"})
+	c.Assert(entity.PromulgatedURL, jc.DeepEquals, charm.MustParseReference("multi-series-1"))
+
+	for i, test := range multiSeriesFindBestEntityTests {
+		c.Logf("test %d: %s", i, test.url)
+		entity, err := store.FindBestEntity(charm.MustParseReference(test.url), "")
+		if test.expectErr != "" {
+			c.Assert(err, gc.ErrorMatches, test.expectErr)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Assert(entity.URL.String(), gc.Equals, charm.MustParseReference(test.expectURL).String())
+	}
+}
+
+func (s *StoreSuite) TestAddMultiSeriesCharmWithoutSeries(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	err := store.AddCharmWithArchive(MustParseResolvedURL("~charmers/wordpress-0"), storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.ErrorMatches, `multi-series charm cs:~charmers/wordpress-0 declares no series`)
+}
+
 var updateEntityTests = []struct {
 	url       string
 	expectErr string
@@ -0,0 +1,255 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"regexp"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+// Redirects returns the Mongo collection where the redirects
+// left by ownership transfers are stored.
+func (s StoreDatabase) Redirects() *mgo.Collection {
+	return s.C("redirects")
+}
+
+// TransferBaseEntity moves the base entity with the given URL and
+// all its revisions, including those in the trash, to the given
+// user, and returns the new base URL. ACLs, channels, resources and
+// statistics counters are carried over, and bundles that refer to
+// the moved charms are updated to refer to the new URLs.
+//
+// A redirect is left behind so that the old URLs resolve to the
+// new ones (see Redirect) until another entity is uploaded under
+// the old base URL.
+//
+// The transfer is not atomic.
+func (s *Store) TransferBaseEntity(url *charm.Reference, user string) (*charm.Reference, error) {
+	from := baseURL(url)
+	if from.User == "" {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "cannot transfer promulgated URL %q", url)
+	}
+	if user == "" || user == from.User {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "invalid new owner %q", user)
+	}
+	to := *from
+	to.User = user
+	var baseEntity mongodoc.BaseEntity
+	if err := s.DB.BaseEntities().FindId(from).One(&baseEntity); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, errgo.WithCausef(nil, params.ErrNotFound, "base entity %q not found", from)
+		}
+		return nil, errgo.Notef(err, "cannot get %s", from)
+	}
+	n, err := s.DB.BaseEntities().FindId(&to).Count()
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot count base entities")
+	}
+	if n > 0 {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "base entity %q already exists", &to)
+	}
+
+	var entities []*mongodoc.Entity
+	if err := s.DB.Entities().Find(bson.D{{"baseurl", from}}).Select(bson.D{{"_id", 1}, {"series", 1}}).All(&entities); err != nil {
+		return nil, errgo.Notef(err, "cannot find revisions of %s", from)
+	}
+	allSeries := make(map[string]bool)
+	for _, e := range entities {
+		if err := s.transferEntity(e.URL, &to); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		allSeries[e.Series] = true
+	}
+
+	// Move the base entity last, so that a transfer that fails
+	// while moving the entities can be retried.
+	baseEntity.URL = &to
+	baseEntity.User = user
+	for _, urls := range baseEntity.Channels {
+		for series, url := range urls {
+			newURL := *url
+			newURL.User = user
+			urls[series] = &newURL
+		}
+	}
+	if err := s.DB.BaseEntities().Insert(&baseEntity); err != nil {
+		return nil, errgo.Notef(err, "cannot insert base entity %s", &to)
+	}
+	if err := s.DB.BaseEntities().RemoveId(from); err != nil {
+		return nil, errgo.Notef(err, "cannot remove base entity %s", from)
+	}
+
+	if _, err := s.DB.Resources().UpdateAll(bson.D{{"baseurl", from}}, bson.D{{"$set", bson.D{{"baseurl", &to}}}}); err != nil {
+		return nil, errgo.Notef(err, "cannot update resources of %s", from)
+	}
+	if err := s.transferBundleCharms(from, user); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if err := s.transferStats(from, user); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if err := s.addRedirect(from, &to); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	for series := range allSeries {
+		oldURL, newURL := *from, to
+		oldURL.Series, newURL.Series = series, series
+		if err := s.updateSearchAfterTrash(&oldURL); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if err := s.updateSearchAfterTrash(&newURL); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	return &to, nil
+}
+
+// transferEntity moves the entity with the given id
+// under the given base URL.
+func (s *Store) transferEntity(id, to *charm.Reference) error {
+	var entity mongodoc.Entity
+	if err := s.DB.Entities().FindId(id).One(&entity); err != nil {
+		return errgo.Notef(err, "cannot get %s", id)
+	}
+	if entity.PromulgatedURL != nil {
+		// The promulgated URL is unique, so remove it from
+		// the old entity before the new one is inserted.
+		if err := s.DB.Entities().UpdateId(id, bson.D{{"$unset", bson.D{{"promulgated-url", ""}}}}); err != nil {
+			return errgo.Notef(err, "cannot update %s", id)
+		}
+	}
+	newURL := *entity.URL
+	newURL.User = to.User
+	entity.URL = &newURL
+	entity.BaseURL = to
+	entity.User = to.User
+	if err := s.DB.Entities().Insert(&entity); err != nil {
+		return errgo.Notef(err, "cannot insert %s", &newURL)
+	}
+	if err := s.DB.Entities().RemoveId(id); err != nil {
+		return errgo.Notef(err, "cannot remove %s", id)
+	}
+	return nil
+}
+
+// transferBundleCharms updates the charm references of any bundles
+// that refer to entities with the given base URL so that they refer
+// to the entities owned by the given user instead.
+func (s *Store) transferBundleCharms(from *charm.Reference, user string) error {
+	// References are stored as strings, with or without
+	// series and revision.
+	pattern := "^cs:~" + regexp.QuoteMeta(from.User) + "/([^/]+/)?" + regexp.QuoteMeta(from.Name) + "(-[0-9]+)?$"
+	var bundles []*mongodoc.Entity
+	err := s.DB.Entities().
+		Find(bson.D{{"bundlecharms", bson.D{{"$regex", pattern}}}}).
+		Select(bson.D{{"_id", 1}, {"bundlecharms", 1}}).
+		All(&bundles)
+	if err != nil {
+		return errgo.Notef(err, "cannot find bundles referring to %s", from)
+	}
+	for _, b := range bundles {
+		for i, ref := range b.BundleCharms {
+			if ref.User == from.User && ref.Name == from.Name {
+				newRef := *ref
+				newRef.User = user
+				b.BundleCharms[i] = &newRef
+			}
+		}
+		if err := s.DB.Entities().UpdateId(b.URL, bson.D{{"$set", bson.D{{"bundlecharms", b.BundleCharms}}}}); err != nil {
+			return errgo.Notef(err, "cannot update %s", b.URL)
+		}
+	}
+	return nil
+}
+
+// transferStats moves the statistics counters of the entities with
+// the given base URL to the entities owned by the given user.
+func (s *Store) transferStats(from *charm.Reference, user string) error {
+	// See EntityStatsKey for the format of entity keys.
+	nameKey, err := s.stats.key(s.DB, []string{from.Name, from.User}, false)
+	if errgo.Cause(err) == params.ErrNotFound {
+		// No counter can refer to the entity.
+		return nil
+	}
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	userKey, err := s.stats.key(s.DB, []string{user}, true)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	counters := s.DB.StatCounters()
+	var counter struct {
+		Id    bson.ObjectId `bson:"_id"`
+		Key   string        `bson:"k"`
+		Time  int32         `bson:"t"`
+		Count int64         `bson:"c"`
+	}
+	iter := counters.Find(bson.D{{"k", bson.D{{"$regex", "^[^:]*:[^:]*:" + nameKey}}}}).Iter()
+	for iter.Next(&counter) {
+		// The key has the form kind:series:name:user:[revision:].
+		parts := strings.SplitN(counter.Key, ":", 5)
+		parts[3] = strings.TrimSuffix(userKey, ":")
+		_, err := counters.Upsert(
+			bson.D{{"k", strings.Join(parts, ":")}, {"t", counter.Time}},
+			bson.D{{"$inc", bson.D{{"c", counter.Count}}}},
+		)
+		if err != nil {
+			iter.Close()
+			return errgo.Notef(err, "cannot update stats counter")
+		}
+		if err := counters.RemoveId(counter.Id); err != nil {
+			iter.Close()
+			return errgo.Notef(err, "cannot remove stats counter")
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return errgo.Notef(err, "cannot iterate stats counters")
+	}
+	return nil
+}
+
+// addRedirect records that the base entity with the given
+// URL has been moved to the given URL.
+func (s *Store) addRedirect(from, to *charm.Reference) error {
+	// Entities previously redirected to the old URL
+	// now redirect to the new one.
+	if _, err := s.DB.Redirects().UpdateAll(bson.D{{"to", from}}, bson.D{{"$set", bson.D{{"to", to}}}}); err != nil {
+		return errgo.Notef(err, "cannot update redirects to %s", from)
+	}
+	if err := s.DB.Redirects().RemoveId(to); err != nil && err != mgo.ErrNotFound {
+		return errgo.Notef(err, "cannot remove redirect from %s", to)
+	}
+	if _, err := s.DB.Redirects().UpsertId(from, bson.D{{"$set", bson.D{{"to", to}}}}); err != nil {
+		return errgo.Notef(err, "cannot add redirect from %s", from)
+	}
+	return nil
+}
+
+// Redirect returns the URL that the given URL has been redirected
+// to by an ownership transfer. It returns an error with a
+// params.ErrNotFound cause if there is no such redirect.
+func (s *Store) Redirect(url *charm.Reference) (*charm.Reference, error) {
+	if url.User == "" {
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "no redirect for %q", url)
+	}
+	var redirect mongodoc.Redirect
+	if err := s.DB.Redirects().FindId(baseURL(url)).One(&redirect); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, errgo.WithCausef(nil, params.ErrNotFound, "no redirect for %q", url)
+		}
+		return nil, errgo.Notef(err, "cannot get redirect for %q", url)
+	}
+	newURL := *url
+	newURL.User = redirect.To.User
+	return &newURL, nil
+}
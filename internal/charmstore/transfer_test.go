@@ -0,0 +1,139 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	jc "github.com/juju/testing/checkers"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+func (s *StoreSuite) TestTransferBaseEntity(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	for _, id := range []string{"0 ~alice/trusty/wordpress-0", "1 ~alice/trusty/wordpress-1", "~alice/precise/wordpress-2"} {
+		err := store.AddCharmWithArchive(MustParseResolvedURL(id), storetesting.Charms.CharmDir("wordpress"))
+		c.Assert(err, gc.IsNil)
+	}
+	err := store.SetPerms(charm.MustParseReference("~alice/wordpress"), "read", params.Everyone, "alice")
+	c.Assert(err, gc.IsNil)
+	err = store.Publish(MustParseResolvedURL("1 ~alice/trusty/wordpress-1"), "stable")
+	c.Assert(err, gc.IsNil)
+	err = store.TrashEntity(MustParseResolvedURL("~alice/precise/wordpress-2"))
+	c.Assert(err, gc.IsNil)
+	_, err = store.AddResourceRevision(charm.MustParseReference("~alice/wordpress"), "data")
+	c.Assert(err, gc.IsNil)
+	burl := MustParseResolvedURL("~bob/bundle/wordpress-simple-0")
+	err = store.AddBundleWithArchive(burl, storetesting.Charms.BundleDir("wordpress-simple"))
+	c.Assert(err, gc.IsNil)
+	err = store.DB.Entities().UpdateId(&burl.URL, bson.D{{"$set", bson.D{{"bundlecharms", []*charm.Reference{
+		charm.MustParseReference("~alice/trusty/wordpress-1"),
+		charm.MustParseReference("~alice/wordpress"),
+		charm.MustParseReference("~alice/trusty/mysql"),
+	}}}}})
+	c.Assert(err, gc.IsNil)
+	err = store.IncCounter(EntityStatsKey(charm.MustParseReference("~alice/trusty/wordpress-1"), params.StatsArchiveDownload))
+	c.Assert(err, gc.IsNil)
+
+	to, err := store.TransferBaseEntity(charm.MustParseReference("~alice/trusty/wordpress-1"), "team")
+	c.Assert(err, gc.IsNil)
+	c.Assert(to.String(), gc.Equals, "cs:~team/wordpress")
+
+	// All the revisions have moved, including the trashed
+	// one, and the promulgated URLs have been kept.
+	var entities []*mongodoc.Entity
+	err = store.DB.Entities().Find(bson.D{{"name", "wordpress"}}).Sort("_id").All(&entities)
+	c.Assert(err, gc.IsNil)
+	c.Assert(entities, gc.HasLen, 3)
+	for _, e := range entities {
+		c.Assert(e.User, gc.Equals, "team")
+		c.Assert(e.URL.User, gc.Equals, "team")
+		c.Assert(e.BaseURL, jc.DeepEquals, to)
+	}
+	c.Assert(entities[0].TrashTime, gc.NotNil)
+	entity, err := store.FindBestEntity(charm.MustParseReference("trusty/wordpress"), "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.URL.String(), gc.Equals, "cs:~team/trusty/wordpress-1")
+
+	// The base entity keeps its ACLs and channels.
+	_, err = store.FindBaseEntity(charm.MustParseReference("~alice/wordpress"))
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+	baseEntity, err := store.FindBaseEntity(to)
+	c.Assert(err, gc.IsNil)
+	c.Assert(baseEntity.User, gc.Equals, "team")
+	c.Assert(baseEntity.ACLs.Read, jc.DeepEquals, []string{params.Everyone, "alice"})
+	c.Assert(baseEntity.Channels["stable"]["trusty"].String(), gc.Equals, "cs:~team/trusty/wordpress-1")
+
+	// The resources, bundle references and statistics have moved.
+	resources, err := store.Resources(to)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resources, gc.HasLen, 1)
+	var bundle mongodoc.Entity
+	err = store.DB.Entities().FindId(&burl.URL).One(&bundle)
+	c.Assert(err, gc.IsNil)
+	c.Assert(bundle.BundleCharms, jc.DeepEquals, []*charm.Reference{
+		charm.MustParseReference("~team/trusty/wordpress-1"),
+		charm.MustParseReference("~team/wordpress"),
+		charm.MustParseReference("~alice/trusty/mysql"),
+	})
+	for user, count := range map[string]int64{"alice": 0, "team": 1} {
+		counters, err := store.Counters(&CounterRequest{
+			Key: EntityStatsKey(charm.MustParseReference("~"+user+"/trusty/wordpress-1"), params.StatsArchiveDownload),
+		})
+		c.Assert(err, gc.IsNil)
+		c.Assert(counters[0].Count, gc.Equals, count)
+	}
+
+	// The old URLs are redirected.
+	url, err := store.Redirect(charm.MustParseReference("~alice/trusty/wordpress-1"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(url.String(), gc.Equals, "cs:~team/trusty/wordpress-1")
+
+	// Transferring again updates the existing redirect.
+	_, err = store.TransferBaseEntity(to, "carol")
+	c.Assert(err, gc.IsNil)
+	url, err = store.Redirect(charm.MustParseReference("~alice/wordpress"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(url.String(), gc.Equals, "cs:~carol/wordpress")
+	url, err = store.Redirect(charm.MustParseReference("~team/wordpress"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(url.String(), gc.Equals, "cs:~carol/wordpress")
+
+	// Transferring back removes the redirect from the new owner.
+	_, err = store.TransferBaseEntity(charm.MustParseReference("~carol/wordpress"), "alice")
+	c.Assert(err, gc.IsNil)
+	_, err = store.Redirect(charm.MustParseReference("~alice/wordpress"))
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+	url, err = store.Redirect(charm.MustParseReference("~team/wordpress"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(url.String(), gc.Equals, "cs:~alice/wordpress")
+}
+
+func (s *StoreSuite) TestTransferBaseEntityErrors(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	for _, id := range []string{"~alice/trusty/wordpress-0", "~bob/trusty/wordpress-0"} {
+		err := store.AddCharmWithArchive(MustParseResolvedURL(id), storetesting.Charms.CharmDir("wordpress"))
+		c.Assert(err, gc.IsNil)
+	}
+	_, err := store.TransferBaseEntity(charm.MustParseReference("~alice/wordpress"), "bob")
+	c.Assert(err, gc.ErrorMatches, `base entity "cs:~bob/wordpress" already exists`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+
+	_, err = store.TransferBaseEntity(charm.MustParseReference("~alice/wordpress"), "alice")
+	c.Assert(err, gc.ErrorMatches, `invalid new owner "alice"`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+
+	_, err = store.TransferBaseEntity(charm.MustParseReference("~alice/mysql"), "team")
+	c.Assert(err, gc.ErrorMatches, `base entity "cs:~alice/mysql" not found`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
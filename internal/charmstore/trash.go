@@ -0,0 +1,217 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/audit"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+// DefaultTrashGracePeriod holds the length of time that deleted
+// entities are kept in the trash when no grace period
+// is specified in the server configuration.
+const DefaultTrashGracePeriod = 7 * 24 * time.Hour
+
+// trashReapInterval holds the interval between
+// successive runs of the trash reaper.
+var trashReapInterval = time.Hour
+
+// TrashEntity moves the entity with the given id to the trash.
+// Trashed entities are hidden from resolution and search
+// but can be restored with RestoreEntity until they are purged
+// by ReapTrash. It returns an error with a params.ErrNotFound
+// cause if the entity does not exist or is already in the trash.
+func (s *Store) TrashEntity(id *router.ResolvedURL) error {
+	err := s.DB.Entities().Update(
+		bson.D{{"_id", &id.URL}, {"trashtime", bson.D{{"$exists", false}}}},
+		bson.D{{"$set", bson.D{{"trashtime", time.Now()}}}},
+	)
+	if err == mgo.ErrNotFound {
+		return errgo.WithCausef(nil, params.ErrNotFound, "entity not found")
+	}
+	if err != nil {
+		return errgo.Notef(err, "cannot move %s to the trash", id)
+	}
+	if err := s.updateSearchAfterTrash(&id.URL); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// RestoreEntity moves the entity with the given URL out of the
+// trash and returns its resolved URL. The URL must be fully
+// qualified; if it has no user, it is assumed to be a promulgated
+// URL. It returns an error with a params.ErrNotFound cause if
+// there is no such entity in the trash.
+func (s *Store) RestoreEntity(url *charm.Reference) (*router.ResolvedURL, error) {
+	if url.Revision == -1 || url.Series == "" && url.User == "" {
+		return nil, errgo.WithCausef(nil, params.ErrBadRequest, "entity id %q is not fully qualified", url)
+	}
+	q := entitiesQuery(url)
+	if url.Series == "" {
+		// Only a multi-series charm can match a
+		// user-owned URL without a series.
+		q = append(q, bson.DocElem{"series", ""})
+	}
+	q = append(q, bson.DocElem{"trashtime", bson.D{{"$exists", true}}})
+	var entity mongodoc.Entity
+	err := s.DB.Entities().Find(q).Select(bson.D{{"_id", 1}, {"promulgated-url", 1}}).One(&entity)
+	if err == mgo.ErrNotFound {
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "entity %q not found in trash", url)
+	}
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot find %q in trash", url)
+	}
+	id := EntityResolvedURL(&entity)
+	if err := s.UpdateEntity(id, bson.D{
+		{"$unset", bson.D{{"trashtime", ""}}},
+		{"$set", bson.D{{"restoretime", time.Now()}}},
+	}); err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if err := s.updateSearchAfterTrash(&id.URL); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return id, nil
+}
+
+// ReapTrash permanently removes all entities that were moved to the
+// trash before the given time, along with their archive blobs, and
+// returns the ids of the removed entities. Charms that are still
+// referenced by a bundle are left in the trash.
+//
+// When the last entity with a given base URL is removed,
+// the base entity is removed too.
+func (s *Store) ReapTrash(before time.Time) ([]*router.ResolvedURL, error) {
+	var entities []*mongodoc.Entity
+	err := s.DB.Entities().
+		Find(bson.D{{"trashtime", bson.D{{"$lt", before}}}}).
+		Select(bson.D{{"_id", 1}, {"baseurl", 1}, {"promulgated-url", 1}, {"supportedseries", 1}, {"blobname", 1}}).
+		Sort("_id").
+		All(&entities)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot find trashed entities")
+	}
+	var purged []*router.ResolvedURL
+	for _, e := range entities {
+		id := EntityResolvedURL(e)
+		referenced, err := s.isReferencedByBundle(e)
+		if err != nil {
+			return purged, errgo.Mask(err)
+		}
+		if referenced {
+			logger.Infof("not purging %s: still referenced by a bundle", id)
+			continue
+		}
+		err = s.DB.Entities().Remove(bson.D{{"_id", e.URL}, {"trashtime", bson.D{{"$exists", true}}}})
+		if err == mgo.ErrNotFound {
+			// The entity has been restored or purged concurrently.
+			continue
+		}
+		if err != nil {
+			return purged, errgo.Notef(err, "cannot remove %s", id)
+		}
+		s.AddAudit(audit.Entry{
+			Op:     audit.OpPurge,
+			Entity: e.URL,
+		})
+		purged = append(purged, id)
+		if err := s.BlobStore.Remove(e.BlobName); err != nil {
+			logger.Errorf("cannot remove blob %s of %s: %v", e.BlobName, id, err)
+		}
+		if err := s.cleanBaseEntity(e); err != nil {
+			return purged, errgo.Mask(err)
+		}
+	}
+	return purged, nil
+}
+
+// isReferencedByBundle reports whether any bundle refers to the given
+// entity, which must have its URL, promulgated URL and supported series
+// populated. Bundles refer to a multi-series charm with a series, so
+// the charm's URLs are matched with each of its supported series too.
+func (s *Store) isReferencedByBundle(e *mongodoc.Entity) (bool, error) {
+	refs := []*charm.Reference{e.URL}
+	if e.PromulgatedURL != nil {
+		refs = append(refs, e.PromulgatedURL)
+	}
+	if e.URL.Series == "" {
+		for _, url := range refs {
+			for _, series := range e.SupportedSeries {
+				seriesURL := *url
+				seriesURL.Series = series
+				refs = append(refs, &seriesURL)
+			}
+		}
+	}
+	n, err := s.DB.Entities().Find(bson.D{{"bundlecharms", bson.D{{"$in", refs}}}}).Count()
+	if err != nil {
+		return false, errgo.Notef(err, "cannot count bundles referring to %s", e.URL)
+	}
+	return n > 0, nil
+}
+
+// cleanBaseEntity removes any references to the purged entity e
+// from its base entity, removing the base entity entirely if
+// no other entities refer to it.
+func (s *Store) cleanBaseEntity(e *mongodoc.Entity) error {
+	n, err := s.DB.Entities().Find(bson.D{{"baseurl", e.BaseURL}}).Count()
+	if err != nil {
+		return errgo.Notef(err, "cannot count entities with base URL %s", e.BaseURL)
+	}
+	if n == 0 {
+		if err := s.DB.BaseEntities().RemoveId(e.BaseURL); err != nil && err != mgo.ErrNotFound {
+			return errgo.Notef(err, "cannot remove base entity %s", e.BaseURL)
+		}
+		return nil
+	}
+	baseEntity, err := s.FindBaseEntity(e.BaseURL, "channels")
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	var unset bson.D
+	for channel, urls := range baseEntity.Channels {
+		// A multi-series charm may be published under
+		// several series, so check all of them.
+		for series, url := range urls {
+			if *url == *e.URL {
+				unset = append(unset, bson.DocElem{"channels." + channel + "." + series, ""})
+			}
+		}
+	}
+	if len(unset) == 0 {
+		return nil
+	}
+	if err := s.DB.BaseEntities().UpdateId(e.BaseURL, bson.D{{"$unset", unset}}); err != nil {
+		return errgo.Notef(err, "cannot update base entity %s", e.BaseURL)
+	}
+	if err := s.updateSearchAfterTrash(e.URL); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// reapTrash returns a function that purges entities which have been
+// in the trash for longer than the given grace period. It is
+// intended to be run as a periodic job.
+func reapTrash(gracePeriod time.Duration) func(*Store) {
+	return func(store *Store) {
+		purged, err := store.ReapTrash(time.Now().Add(-gracePeriod))
+		for _, id := range purged {
+			logger.Infof("purged %s from the trash", id)
+		}
+		if err != nil {
+			logger.Errorf("cannot purge trash: %v", err)
+		}
+	}
+}
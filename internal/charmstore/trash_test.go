@@ -0,0 +1,175 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"time"
+
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+)
+
+func (s *StoreSuite) TestTrashAndRestoreEntity(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	for _, id := range []string{"0 ~charmers/trusty/wordpress-0", "1 ~charmers/trusty/wordpress-1"} {
+		err := store.AddCharmWithArchive(MustParseResolvedURL(id), storetesting.Charms.CharmDir("wordpress"))
+		c.Assert(err, gc.IsNil)
+	}
+	url := MustParseResolvedURL("1 ~charmers/trusty/wordpress-1")
+	err := store.TrashEntity(url)
+	c.Assert(err, gc.IsNil)
+
+	// The trashed entity is hidden.
+	_, err = store.FindEntity(url)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+	entity, err := store.FindBestEntity(charm.MustParseReference("trusty/wordpress"), "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.URL.String(), gc.Equals, "cs:~charmers/trusty/wordpress-0")
+
+	// Trashing it again fails.
+	err = store.TrashEntity(url)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+
+	// Restore it using its promulgated URL.
+	rurl, err := store.RestoreEntity(charm.MustParseReference("trusty/wordpress-1"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(rurl, gc.DeepEquals, url)
+	entity, err = store.FindBestEntity(charm.MustParseReference("trusty/wordpress"), "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.URL.String(), gc.Equals, "cs:~charmers/trusty/wordpress-1")
+
+	// It is no longer in the trash.
+	_, err = store.RestoreEntity(&url.URL)
+	c.Assert(err, gc.ErrorMatches, `entity "cs:~charmers/trusty/wordpress-1" not found in trash`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+
+	_, err = store.RestoreEntity(charm.MustParseReference("~charmers/trusty/wordpress"))
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+}
+
+func (s *StoreSuite) TestReapTrash(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	for _, id := range []string{"~charmers/trusty/wordpress-0", "~charmers/trusty/wordpress-1", "~charmers/trusty/mysql-0"} {
+		err := store.AddCharmWithArchive(MustParseResolvedURL(id), storetesting.Charms.CharmDir("wordpress"))
+		c.Assert(err, gc.IsNil)
+	}
+	err := store.Publish(MustParseResolvedURL("~charmers/trusty/wordpress-1"), "stable")
+	c.Assert(err, gc.IsNil)
+	for _, id := range []string{"~charmers/trusty/wordpress-1", "~charmers/trusty/mysql-0"} {
+		err := store.TrashEntity(MustParseResolvedURL(id))
+		c.Assert(err, gc.IsNil)
+	}
+	var blobs []string
+	for _, id := range []string{"~charmers/trusty/wordpress-1", "~charmers/trusty/mysql-0"} {
+		var entity mongodoc.Entity
+		err = store.DB.Entities().FindId(charm.MustParseReference(id)).One(&entity)
+		c.Assert(err, gc.IsNil)
+		blobs = append(blobs, entity.BlobName)
+	}
+
+	// Nothing is purged before the grace period has expired.
+	purged, err := store.ReapTrash(time.Now().Add(-time.Hour))
+	c.Assert(err, gc.IsNil)
+	c.Assert(purged, gc.HasLen, 0)
+
+	purged, err = store.ReapTrash(time.Now().Add(time.Second))
+	c.Assert(err, gc.IsNil)
+	c.Assert(purged, gc.DeepEquals, []*router.ResolvedURL{
+		MustParseResolvedURL("~charmers/trusty/mysql-0"),
+		MustParseResolvedURL("~charmers/trusty/wordpress-1"),
+	})
+
+	// The entities and their blobs have been removed.
+	n, err := store.DB.Entities().Find(nil).Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 1)
+	for _, blob := range blobs {
+		_, _, err = store.BlobStore.Open(blob)
+		c.Assert(err, gc.ErrorMatches, "resource.*not found")
+	}
+
+	// The channel no longer refers to the purged entity.
+	baseEntity, err := store.FindBaseEntity(charm.MustParseReference("~charmers/wordpress"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(baseEntity.Channels["stable"], gc.HasLen, 0)
+
+	// The base entity of the last remaining revision has been removed.
+	_, err = store.FindBaseEntity(charm.MustParseReference("~charmers/mysql"))
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
+
+func (s *StoreSuite) TestReapTrashKeepsBundleCharms(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	url := MustParseResolvedURL("~charmers/trusty/wordpress-0")
+	err := store.AddCharmWithArchive(url, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	burl := MustParseResolvedURL("~charmers/bundle/wordpress-simple-0")
+	err = store.AddBundleWithArchive(burl, storetesting.Charms.BundleDir("wordpress-simple"))
+	c.Assert(err, gc.IsNil)
+	err = store.DB.Entities().UpdateId(&burl.URL, bson.D{{"$set", bson.D{{"bundlecharms", []*charm.Reference{&url.URL}}}}})
+	c.Assert(err, gc.IsNil)
+
+	err = store.TrashEntity(url)
+	c.Assert(err, gc.IsNil)
+	purged, err := store.ReapTrash(time.Now().Add(time.Second))
+	c.Assert(err, gc.IsNil)
+	c.Assert(purged, gc.HasLen, 0)
+
+	// Once the bundle has gone, the charm can be purged.
+	err = store.TrashEntity(burl)
+	c.Assert(err, gc.IsNil)
+	purged, err = store.ReapTrash(time.Now().Add(time.Second))
+	c.Assert(err, gc.IsNil)
+	c.Assert(purged, gc.DeepEquals, []*router.ResolvedURL{burl, url})
+}
+
+func (s *StoreSuite) TestReapTrashKeepsMultiSeriesBundleCharms(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	url := MustParseResolvedURL("0 ~charmers/multi-series-0")
+	err := store.AddCharmWithArchive(url, storetesting.Charms.CharmDir("multi-series"))
+	c.Assert(err, gc.IsNil)
+	burl := MustParseResolvedURL("~charmers/bundle/wordpress-simple-0")
+	err = store.AddBundleWithArchive(burl, storetesting.Charms.BundleDir("wordpress-simple"))
+	c.Assert(err, gc.IsNil)
+
+	// Bundles refer to multi-series charms with a series,
+	// using either the promulgated or the user's URL.
+	for _, ref := range []string{"cs:~charmers/trusty/multi-series-0", "cs:utopic/multi-series-0"} {
+		c.Logf("bundle charm %s", ref)
+		err = store.DB.Entities().UpdateId(&burl.URL, bson.D{{"$set", bson.D{{"bundlecharms", []*charm.Reference{charm.MustParseReference(ref)}}}}})
+		c.Assert(err, gc.IsNil)
+		err = store.TrashEntity(url)
+		c.Assert(err, gc.IsNil)
+		purged, err := store.ReapTrash(time.Now().Add(time.Second))
+		c.Assert(err, gc.IsNil)
+		c.Assert(purged, gc.HasLen, 0)
+		_, err = store.RestoreEntity(&url.URL)
+		c.Assert(err, gc.IsNil)
+	}
+
+	// A series that the charm does not support
+	// does not refer to it.
+	err = store.DB.Entities().UpdateId(&burl.URL, bson.D{{"$set", bson.D{{"bundlecharms", []*charm.Reference{charm.MustParseReference("cs:~charmers/quantal/multi-series-0")}}}}})
+	c.Assert(err, gc.IsNil)
+	err = store.TrashEntity(url)
+	c.Assert(err, gc.IsNil)
+	purged, err := store.ReapTrash(time.Now().Add(time.Second))
+	c.Assert(err, gc.IsNil)
+	c.Assert(purged, gc.DeepEquals, []*router.ResolvedURL{url})
+}
@@ -0,0 +1,137 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"strconv"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+// trendingRefreshInterval holds the interval between successive
+// refreshes of the recent download counts in the search documents.
+var trendingRefreshInterval = time.Hour
+
+// trendingWindow holds the period over which recent downloads
+// are counted. It must match the LastWeek aggregated count
+// used for SearchDoc.RecentDownloads.
+const trendingWindow = 7 * 24 * time.Hour
+
+// RefreshTrending updates the search documents of all entities
+// that have been downloaded since the given time, so that their
+// recent download counts reflect the current statistics. Entities
+// whose downloads have moved out of the trending window since the
+// last refresh are updated too, as long as since is early enough
+// to include them. Search documents already holding the current
+// recent download count are left alone.
+//
+// It returns the number of search documents updated.
+func (s *Store) RefreshTrending(since time.Time) (int, error) {
+	if s.searchBackend() == nil {
+		return 0, nil
+	}
+	kindKey, err := s.stats.key(s.DB, []string{params.StatsArchiveDownload}, false)
+	if errgo.Cause(err) == params.ErrNotFound {
+		// Nothing has ever been downloaded.
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errgo.Mask(err)
+	}
+	var skeys []string
+	err = s.DB.StatCounters().Find(bson.D{
+		{"k", bson.D{{"$regex", "^" + kindKey}}},
+		{"t", bson.D{{"$gte", timeToStamp(since)}}},
+	}).Distinct("k", &skeys)
+	if err != nil {
+		return 0, errgo.Notef(err, "cannot get recent stats counters")
+	}
+	// Evict all the cached counts before updating any search
+	// document, because both the user owned and the promulgated
+	// counters of an entity contribute to its counts.
+	var urls []*charm.Reference
+	seen := make(map[string]bool)
+	for _, skey := range skeys {
+		key, err := s.stats.keyTokens(s.DB, skey)
+		if err != nil {
+			return 0, errgo.Mask(err)
+		}
+		// Only per-revision keys of the form
+		// kind:series:name:user:revision are considered.
+		if len(key) != 5 {
+			continue
+		}
+		rev, err := strconv.Atoi(key[4])
+		if err != nil {
+			continue
+		}
+		url := &charm.Reference{
+			Schema:   "cs",
+			Series:   key[1],
+			Name:     key[2],
+			User:     key[3],
+			Revision: rev,
+		}
+		s.pool.statsCache.Evict(url.String())
+		url.Revision = -1
+		s.pool.statsCache.Evict(url.String())
+		if url.User == "" || seen[url.String()] {
+			continue
+		}
+		seen[url.String()] = true
+		urls = append(urls, url)
+	}
+	backend := s.searchBackend()
+	n := 0
+	for _, url := range urls {
+		if deprecatedSeries[url.Series] {
+			continue
+		}
+		doc, err := s.latestSearchDoc(&router.ResolvedURL{
+			URL:                 *url,
+			PromulgatedRevision: -1,
+		})
+		if errgo.Cause(err) == params.ErrNotFound {
+			// All revisions of the entity have been removed.
+			continue
+		}
+		if err != nil {
+			return n, errgo.Mask(err)
+		}
+		recent, err := backend.recentDownloads(doc.URL)
+		if err == nil && recent == doc.RecentDownloads {
+			continue
+		}
+		if err != nil && errgo.Cause(err) != params.ErrNotFound {
+			return n, errgo.Notef(err, "cannot get search record for %s", url)
+		}
+		if err := backend.update(doc); err != nil {
+			return n, errgo.Notef(err, "cannot update search record for %s", url)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// refreshTrending updates the recent download counts of the search
+// documents. It is intended to be run as a periodic job every
+// trendingRefreshInterval.
+func refreshTrending(store *Store) {
+	// Go back far enough to catch entities whose downloads have
+	// left the window since the previous run, allowing for the
+	// counts being aggregated by day.
+	since := time.Now().Add(-(trendingWindow + trendingRefreshInterval + 24*time.Hour))
+	n, err := store.RefreshTrending(since)
+	if err != nil {
+		logger.Errorf("cannot refresh trending downloads: %v", err)
+		return
+	}
+	logger.Infof("refreshed recent downloads of %d search documents", n)
+}
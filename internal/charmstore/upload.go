@@ -0,0 +1,214 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+// UploadSessionExpiry holds the length of time an upload
+// session is kept after a chunk was last added to it.
+const UploadSessionExpiry = 24 * time.Hour
+
+// UploadSessions returns the Mongo collection where
+// chunked upload sessions are stored.
+func (s StoreDatabase) UploadSessions() *mgo.Collection {
+	return s.C("upload_sessions")
+}
+
+// NewUploadSession creates a new upload session
+// for the given user.
+func (s *Store) NewUploadSession(user string) (*mongodoc.UploadSession, error) {
+	now := time.Now()
+	session := &mongodoc.UploadSession{
+		Id:         bson.NewObjectId().Hex(),
+		User:       user,
+		CreateTime: now,
+		Expires:    now.Add(UploadSessionExpiry),
+	}
+	if err := s.DB.UploadSessions().Insert(session); err != nil {
+		return nil, errgo.Notef(err, "cannot create upload session")
+	}
+	return session, nil
+}
+
+// UploadSession returns the upload session with the given id. It
+// returns an error with a params.ErrNotFound cause if there is no
+// such session or if it has expired.
+func (s *Store) UploadSession(id string) (*mongodoc.UploadSession, error) {
+	var session mongodoc.UploadSession
+	err := s.DB.UploadSessions().Find(bson.D{
+		{"_id", id},
+		{"expires", bson.D{{"$gt", time.Now()}}},
+	}).One(&session)
+	if err == mgo.ErrNotFound {
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "upload session %q not found", id)
+	}
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get upload session %q", id)
+	}
+	return &session, nil
+}
+
+// PutUploadChunk stores the contents of r, which must have the given
+// size and hash, as the chunk of the given upload session at the given
+// offset. A chunk previously uploaded at the same offset is replaced,
+// so that failed chunks can be retried. The expiry time of the session
+// is extended. The chunk counts against the upload quota of the owner
+// of the session: an error with a router.ErrQuotaExceeded cause is
+// returned if the quota would be exceeded.
+func (s *Store) PutUploadChunk(id string, offset int64, r io.Reader, size int64, hash string) error {
+	if offset < 0 {
+		return errgo.WithCausef(nil, params.ErrBadRequest, "invalid offset %d", offset)
+	}
+	session, err := s.UploadSession(id)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if err := s.checkChunkQuota(session, offset, size); err != nil {
+		return errgo.Mask(err, errgo.Is(router.ErrQuotaExceeded))
+	}
+	name := bson.NewObjectId().Hex()
+	if err := s.BlobStore.PutUnchallenged(r, name, size, hash); err != nil {
+		return errgo.Notef(err, "cannot put chunk blob")
+	}
+	key := "chunks." + strconv.FormatInt(offset, 10)
+	var old mongodoc.UploadSession
+	_, err = s.DB.UploadSessions().FindId(id).Select(bson.D{{key, 1}}).Apply(mgo.Change{
+		Update: bson.D{{"$set", bson.D{
+			{key, mongodoc.UploadChunk{
+				Offset:   offset,
+				Size:     size,
+				BlobName: name,
+			}},
+			{"expires", time.Now().Add(UploadSessionExpiry)},
+		}}},
+	}, &old)
+	if err != nil {
+		s.removeChunkBlob(name)
+		if err == mgo.ErrNotFound {
+			return errgo.WithCausef(nil, params.ErrNotFound, "upload session %q not found", id)
+		}
+		return errgo.Notef(err, "cannot update upload session %q", id)
+	}
+	for _, chunk := range old.Chunks {
+		s.removeChunkBlob(chunk.BlobName)
+	}
+	return nil
+}
+
+// OpenUploadSession checks that the chunks of the given upload session
+// hold the whole archive, with no gaps or overlaps, and returns a
+// reader for the archive and its size. The returned reader must be
+// closed after use.
+func (s *Store) OpenUploadSession(id string) (io.ReadCloser, int64, error) {
+	session, err := s.UploadSession(id)
+	if err != nil {
+		return nil, 0, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if len(session.Chunks) == 0 {
+		return nil, 0, errgo.WithCausef(nil, params.ErrBadRequest, "upload session %q has no chunks", id)
+	}
+	chunks := make([]mongodoc.UploadChunk, 0, len(session.Chunks))
+	for _, chunk := range session.Chunks {
+		chunks = append(chunks, chunk)
+	}
+	sort.Sort(chunksByOffset(chunks))
+	var size int64
+	for _, chunk := range chunks {
+		if chunk.Offset > size {
+			return nil, 0, errgo.WithCausef(nil, params.ErrBadRequest, "upload session %q is missing data at offset %d", id, size)
+		}
+		if chunk.Offset < size {
+			return nil, 0, errgo.WithCausef(nil, params.ErrBadRequest, "upload session %q has overlapping chunks at offset %d", id, chunk.Offset)
+		}
+		size += chunk.Size
+	}
+	r := new(multiReadCloser)
+	readers := make([]io.Reader, 0, len(chunks))
+	for _, chunk := range chunks {
+		blob, _, err := s.BlobStore.Open(chunk.BlobName)
+		if err != nil {
+			r.Close()
+			return nil, 0, errgo.Notef(err, "cannot open chunk at offset %d", chunk.Offset)
+		}
+		readers = append(readers, blob)
+		r.closers = append(r.closers, blob)
+	}
+	r.Reader = io.MultiReader(readers...)
+	return r, size, nil
+}
+
+// RemoveUploadSession removes the given upload session and its
+// chunks. It returns an error with a params.ErrNotFound cause if
+// there is no such session.
+func (s *Store) RemoveUploadSession(id string) error {
+	var session mongodoc.UploadSession
+	_, err := s.DB.UploadSessions().FindId(id).Apply(mgo.Change{
+		Remove: true,
+	}, &session)
+	if err == mgo.ErrNotFound {
+		return errgo.WithCausef(nil, params.ErrNotFound, "upload session %q not found", id)
+	}
+	if err != nil {
+		return errgo.Notef(err, "cannot remove upload session %q", id)
+	}
+	for _, chunk := range session.Chunks {
+		s.removeChunkBlob(chunk.BlobName)
+	}
+	return nil
+}
+
+// removeExpiredUploadSessions removes the upload sessions that
+// have expired. Their chunks are left to the blob garbage collector.
+func (s *Store) removeExpiredUploadSessions() error {
+	_, err := s.DB.UploadSessions().RemoveAll(bson.D{{"expires", bson.D{{"$lte", time.Now()}}}})
+	if err != nil {
+		return errgo.Notef(err, "cannot remove expired upload sessions")
+	}
+	return nil
+}
+
+// removeChunkBlob removes the given chunk blob, logging any error.
+// Blobs that cannot be removed are eventually removed by the blob
+// garbage collector.
+func (s *Store) removeChunkBlob(name string) {
+	if err := s.BlobStore.Remove(name); err != nil {
+		logger.Errorf("cannot remove chunk blob %s: %v", name, err)
+	}
+}
+
+type chunksByOffset []mongodoc.UploadChunk
+
+func (c chunksByOffset) Len() int           { return len(c) }
+func (c chunksByOffset) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c chunksByOffset) Less(i, j int) bool { return c[i].Offset < c[j].Offset }
+
+// multiReadCloser reads the concatenation of a
+// sequence of readers and closes them all when closed.
+type multiReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *multiReadCloser) Close() error {
+	var firstErr error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,155 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package charmstore // import "gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	gc "gopkg.in/check.v1"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+	"gopkg.in/mgo.v2/bson"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+func (s *StoreSuite) putUploadChunk(c *gc.C, store *Store, id string, offset int64, data string) {
+	hash := blobstore.NewHash()
+	hash.Write([]byte(data))
+	err := store.PutUploadChunk(id, offset, bytes.NewReader([]byte(data)), int64(len(data)), fmt.Sprintf("%x", hash.Sum(nil)))
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *StoreSuite) TestUploadSession(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	session, err := store.NewUploadSession("bob")
+	c.Assert(err, gc.IsNil)
+	c.Assert(session.User, gc.Equals, "bob")
+
+	// Chunks can be uploaded in any order.
+	s.putUploadChunk(c, store, session.Id, 6, "world")
+	_, _, err = store.OpenUploadSession(session.Id)
+	c.Assert(err, gc.ErrorMatches, `upload session ".*" is missing data at offset 0`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrBadRequest)
+
+	s.putUploadChunk(c, store, session.Id, 0, "hullo ")
+	// A chunk can be replaced by uploading it again.
+	s.putUploadChunk(c, store, session.Id, 0, "hello ")
+	names, err := store.BlobStore.Names()
+	c.Assert(err, gc.IsNil)
+	c.Assert(names, gc.HasLen, 2)
+
+	r, size, err := store.OpenUploadSession(session.Id)
+	c.Assert(err, gc.IsNil)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "hello world")
+	c.Assert(size, gc.Equals, int64(len(data)))
+
+	s.putUploadChunk(c, store, session.Id, 4, "o wo")
+	_, _, err = store.OpenUploadSession(session.Id)
+	c.Assert(err, gc.ErrorMatches, `upload session ".*" has overlapping chunks at offset 4`)
+
+	// The chunks of a live session are not garbage collected.
+	removed, err := store.CollectBlobs(0, true)
+	c.Assert(err, gc.IsNil)
+	c.Assert(removed, gc.HasLen, 0)
+
+	err = store.RemoveUploadSession(session.Id)
+	c.Assert(err, gc.IsNil)
+	names, err = store.BlobStore.Names()
+	c.Assert(err, gc.IsNil)
+	c.Assert(names, gc.HasLen, 0)
+	_, err = store.UploadSession(session.Id)
+	c.Assert(err, gc.ErrorMatches, `upload session ".*" not found`)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+	err = store.RemoveUploadSession(session.Id)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+	err = store.PutUploadChunk(session.Id, 0, bytes.NewReader(nil), 0, "")
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+}
+
+func (s *StoreSuite) TestUploadChunkQuota(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+	store.pool.config.QuotaMaxArchiveSize = 10
+	store.pool.config.QuotaMaxBytes = 15
+
+	putChunk := func(id string, offset int64, data string) error {
+		hash := blobstore.NewHash()
+		hash.Write([]byte(data))
+		return store.PutUploadChunk(id, offset, bytes.NewReader([]byte(data)), int64(len(data)), fmt.Sprintf("%x", hash.Sum(nil)))
+	}
+	session1, err := store.NewUploadSession("bob")
+	c.Assert(err, gc.IsNil)
+	err = putChunk(session1.Id, 0, "hello ")
+	c.Assert(err, gc.IsNil)
+
+	// A chunk cannot extend past the maximum archive size.
+	err = putChunk(session1.Id, 6, "world")
+	c.Assert(err, gc.ErrorMatches, `chunk ending at offset 11 exceeds maximum archive size of 10 bytes`)
+	c.Assert(errgo.Cause(err), gc.Equals, router.ErrQuotaExceeded)
+
+	// Chunks staged in all the user's sessions count against
+	// the user's byte quota.
+	session2, err := store.NewUploadSession("bob")
+	c.Assert(err, gc.IsNil)
+	err = putChunk(session2.Id, 0, "hello")
+	c.Assert(err, gc.IsNil)
+	err = putChunk(session2.Id, 5, "world")
+	c.Assert(err, gc.ErrorMatches, `upload would exceed quota of 15 bytes for "bob" \(11 bytes used\)`)
+	c.Assert(errgo.Cause(err), gc.Equals, router.ErrQuotaExceeded)
+
+	// A replaced chunk does not count against the quota.
+	err = putChunk(session1.Id, 0, "howdy ")
+	c.Assert(err, gc.IsNil)
+
+	// Removing a session frees its staged bytes.
+	err = store.RemoveUploadSession(session1.Id)
+	c.Assert(err, gc.IsNil)
+	err = putChunk(session2.Id, 5, "world")
+	c.Assert(err, gc.IsNil)
+
+	// Sessions created by admins are not limited.
+	session3, err := store.NewUploadSession("")
+	c.Assert(err, gc.IsNil)
+	err = putChunk(session3.Id, 0, "hello world, hello world")
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *StoreSuite) TestExpiredUploadSession(c *gc.C) {
+	store := s.newStore(c, false)
+	defer store.Close()
+
+	session, err := store.NewUploadSession("bob")
+	c.Assert(err, gc.IsNil)
+	s.putUploadChunk(c, store, session.Id, 0, "hello")
+	err = store.DB.UploadSessions().UpdateId(session.Id, bson.D{{"$set", bson.D{{"expires", time.Now().Add(-time.Minute)}}}})
+	c.Assert(err, gc.IsNil)
+
+	_, err = store.UploadSession(session.Id)
+	c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
+
+	// The chunks of expired sessions are garbage collected.
+	removed, err := store.CollectBlobs(0, true)
+	c.Assert(err, gc.IsNil)
+	c.Assert(removed, gc.HasLen, 1)
+	err = store.removeExpiredUploadSessions()
+	c.Assert(err, gc.IsNil)
+	_, err = store.CollectBlobs(0, false)
+	c.Assert(err, gc.IsNil)
+	names, err := store.BlobStore.Names()
+	c.Assert(err, gc.IsNil)
+	c.Assert(names, gc.HasLen, 0)
+	n, err := store.DB.UploadSessions().Count()
+	c.Assert(err, gc.IsNil)
+	c.Assert(n, gc.Equals, 0)
+}
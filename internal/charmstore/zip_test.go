@@ -121,3 +121,19 @@ func (s *zipSuite) TestNewZipFileWithCompressionMethodError(c *gc.C) {
 	_, err := charmstore.NewZipFile(f)
 	c.Assert(err, gc.ErrorMatches, `unknown zip compression method for "foo"`)
 }
+
+func (s *zipSuite) TestArchiveHooks(c *gc.C) {
+	r, _ := s.makeZipReader(c, map[string]string{
+		"hooks/install":        "#!/bin/sh",
+		"hooks/config-changed": "#!/bin/sh",
+		"hooks/.hidden":        "",
+		"hooks/lib/helper.sh":  "",
+		"install":              "",
+		"metadata.yaml":        "metadata contents",
+	})
+	size, err := r.Seek(0, 2)
+	c.Assert(err, gc.IsNil)
+	hooks, err := charmstore.ArchiveHooks(charmstore.ReaderAtSeeker(r), size)
+	c.Assert(err, gc.IsNil)
+	c.Assert(hooks, gc.DeepEquals, []string{"config-changed", "install"})
+}
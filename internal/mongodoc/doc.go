@@ -38,8 +38,14 @@ type Entity struct {
 	Revision int
 
 	// Series holds the entity series (for instance "trusty" or "bundle").
+	// It is empty for multi-series charms.
 	Series string
 
+	// SupportedSeries holds the series supported by a multi-series
+	// charm, as declared in its metadata. It is empty for entities
+	// that have a series in their URL.
+	SupportedSeries []string `json:",omitempty" bson:",omitempty"`
+
 	// BlobHash holds the hash checksum of the blob, in hexadecimal format,
 	// as created by blobstore.NewHash.
 	BlobHash string
@@ -68,6 +74,10 @@ type Entity struct {
 	CharmConfig  *charm.Config
 	CharmActions *charm.Actions
 
+	// CharmHooks holds the names of the hooks
+	// found in the charm's archive.
+	CharmHooks []string `json:",omitempty" bson:",omitempty"`
+
 	// CharmProvidedInterfaces holds all the relation
 	// interfaces provided by the charm
 	CharmProvidedInterfaces []string
@@ -108,6 +118,25 @@ type Entity struct {
 	// PromulgatedRevision holds the revision number from the promulgated URL.
 	// If the entity is not promulgated this should be set to -1.
 	PromulgatedRevision int `bson:"promulgated-revision"`
+
+	// TrashTime holds the time the entity was moved to the trash.
+	// Entities in the trash are hidden from resolution and search
+	// and are purged after a grace period. It is nil if the
+	// entity has not been deleted.
+	TrashTime *time.Time `json:",omitempty" bson:",omitempty"`
+
+	// RestoreTime holds the time the entity was last restored
+	// from the trash. It is nil if the entity has never been
+	// restored.
+	RestoreTime *time.Time `json:",omitempty" bson:",omitempty"`
+
+	// Signatures holds detached signatures of the entity's
+	// archive made by keys registered by the entity's owner.
+	Signatures []Signature `json:",omitempty" bson:",omitempty"`
+
+	// LintWarnings holds the warnings produced by the
+	// checks run on the entity's archive when it was uploaded.
+	LintWarnings []LintProblem `json:",omitempty" bson:",omitempty"`
 }
 
 // PreferredURL returns the preferred way to refer to this entity. If
@@ -120,6 +149,20 @@ func (e *Entity) PreferredURL(usePromulgated bool) *charm.Reference {
 	return e.URL
 }
 
+// SupportsSeries reports whether the entity can be used
+// with the given series.
+func (e *Entity) SupportsSeries(series string) bool {
+	if e.URL.Series != "" {
+		return e.URL.Series == series
+	}
+	for _, s := range e.SupportedSeries {
+		if s == series {
+			return true
+		}
+	}
+	return false
+}
+
 // BaseEntity holds metadata for a charm or bundle
 // independent of any specific uploaded revision or series.
 type BaseEntity struct {
@@ -148,6 +191,31 @@ type BaseEntity struct {
 	// Promulgated specifies whether the charm or bundle should be
 	// promulgated.
 	Promulgated IntBool
+
+	// Channels holds the entities published to each named
+	// channel (for instance "stable"), keyed by channel name and
+	// then by series. Each URL holds the fully qualified,
+	// non-promulgated, URL of the published entity.
+	Channels map[string]map[string]*charm.Reference `json:",omitempty" bson:",omitempty"`
+
+	// Retention holds the policy used when pruning old revisions
+	// of the entity. If it is nil, the store-wide policy applies.
+	Retention *RetentionPolicy `json:",omitempty" bson:",omitempty"`
+}
+
+// RetentionPolicy determines which revisions of an entity are kept
+// when old revisions are pruned. Revisions of each series are
+// considered separately. A revision is kept if it is one of the
+// KeepRevisions most recent revisions in its series, or if it was
+// uploaded less than KeepDuration ago. A zero field imposes no
+// limit of its own; if both fields are zero, no revisions are pruned.
+//
+// Regardless of the policy, promulgated revisions, revisions
+// published to a channel and charms referenced by a bundle
+// are always kept.
+type RetentionPolicy struct {
+	KeepRevisions int           `json:",omitempty" bson:",omitempty"`
+	KeepDuration  time.Duration `json:",omitempty" bson:",omitempty"`
 }
 
 // ACL holds lists of users and groups that are
@@ -189,6 +257,188 @@ func (f ZipFile) IsValid() bool {
 	return f != ZipFile{}
 }
 
+// Signature holds a detached signature of the
+// contents of an entity's archive.
+type Signature struct {
+	// KeyId holds the id of the publisher key
+	// that made the signature.
+	KeyId string
+
+	// Algorithm holds the signature algorithm,
+	// e.g. "ed25519".
+	Algorithm string
+
+	// Signature holds the signature itself.
+	Signature []byte
+}
+
+// LintProblem holds a problem found by a check run
+// on an entity's archive when it is uploaded.
+type LintProblem struct {
+	// Linter holds the name of the check that
+	// found the problem.
+	Linter string
+
+	// Severity holds the severity of the problem,
+	// either "error" or "warning".
+	Severity string
+
+	// Message holds a description of the problem.
+	Message string
+}
+
+// PublisherKey holds a public key registered by a user
+// to sign the archives of the entities they own.
+type PublisherKey struct {
+	// User holds the name of the user or group
+	// that registered the key.
+	User string
+
+	// KeyId holds the id of the key, derived from
+	// the key itself. Key ids are unique per user.
+	KeyId string
+
+	// Algorithm holds the signature algorithm that the
+	// key is used with, e.g. "ed25519".
+	Algorithm string
+
+	// PublicKey holds the public key itself.
+	PublicKey []byte
+
+	// CreateTime holds the time the key was registered.
+	CreateTime time.Time
+}
+
+// Quota holds the upload quota overrides for a user or group.
+// Each limit applies to the entities in the user's namespace.
+// A zero limit means that the store-wide limit applies; a
+// negative limit means that there is no limit.
+type Quota struct {
+	// User holds the name of the user or group.
+	User string `bson:"_id"`
+
+	// MaxBytes holds the maximum total size of
+	// the archives owned by the user.
+	MaxBytes int64
+
+	// MaxArchiveSize holds the maximum size of
+	// a single archive.
+	MaxArchiveSize int64
+
+	// MaxRevisions holds the maximum number of
+	// revisions of each base entity.
+	MaxRevisions int
+}
+
+// UploadSession holds an archive that is being uploaded in chunks.
+// The chunks are staged in the blob store until the upload is
+// finalized, when they are joined into the archive blob.
+type UploadSession struct {
+	// Id holds the id of the session.
+	Id string `bson:"_id"`
+
+	// User holds the name of the user that created the session.
+	// It is empty if the session was created by an admin.
+	User string
+
+	// Chunks holds the chunks uploaded so far,
+	// keyed by their offset in decimal.
+	Chunks map[string]UploadChunk `bson:",omitempty"`
+
+	// CreateTime holds the time the session was created.
+	CreateTime time.Time
+
+	// Expires holds the time after which the session and
+	// its chunks may be removed.
+	Expires time.Time
+}
+
+// UploadChunk holds a chunk of an archive uploaded
+// as part of an UploadSession.
+type UploadChunk struct {
+	// Offset holds the offset of the chunk in the archive.
+	Offset int64
+
+	// Size holds the size of the chunk.
+	Size int64
+
+	// BlobName holds the name of the blob
+	// holding the contents of the chunk.
+	BlobName string
+}
+
+// FeaturedEntity holds an entity curated by an administrator
+// to be shown first in the interesting entities.
+type FeaturedEntity struct {
+	// URL holds the id of the entity. It may be partial, for
+	// instance cs:wordpress, in which case it is resolved
+	// to the best matching entity.
+	URL *charm.Reference `bson:"_id"`
+
+	// Position holds the position of the entity
+	// in the featured list.
+	Position int
+}
+
+// Redirect records that a base entity has been transferred
+// to another user, so that its old URLs keep resolving.
+type Redirect struct {
+	// From holds the base URL of the entity before the
+	// transfer, e.g. cs:~alice/wordpress.
+	From *charm.Reference `bson:"_id"`
+
+	// To holds the base URL of the entity after the
+	// transfer, e.g. cs:~team/wordpress.
+	To *charm.Reference
+}
+
+// Resource holds the in-database representation of a single
+// revision of a charm resource stream. Resources are associated
+// with a base entity, so all the revisions and series of a charm
+// share the same resource streams.
+type Resource struct {
+	// BaseURL holds the reference URL of the charm owning the
+	// resource (this omits the series and revision),
+	// e.g. cs:~user/wordpress.
+	BaseURL *charm.Reference
+
+	// Stream holds the name of the resource stream
+	// (for instance "data.default").
+	Stream string
+
+	// Revision holds the revision of the stream.
+	Revision int
+
+	// CreateTime holds the time the revision was created.
+	CreateTime time.Time
+
+	// Blobs holds the data uploaded for each architecture,
+	// keyed by architecture name.
+	Blobs map[string]ResourceBlob `json:",omitempty" bson:",omitempty"`
+}
+
+// ResourceBlob refers to the data uploaded for a single architecture
+// of a resource revision.
+type ResourceBlob struct {
+	// BlobName holds the name that the resource blob is given
+	// in the blob store.
+	BlobName string
+
+	// BlobHash holds the hash checksum of the blob, in hexadecimal
+	// format, as created by blobstore.NewHash.
+	BlobHash string
+
+	// BlobHash256 holds the SHA256 hash checksum of the blob,
+	// in hexadecimal format.
+	BlobHash256 string
+
+	// Size holds the size of the blob.
+	Size int64
+
+	// UploadTime holds the time the blob was uploaded.
+	UploadTime time.Time
+}
+
 // Log holds the in-database representation of a log message sent to the charm
 // store.
 type Log struct {
@@ -134,16 +134,17 @@ type Handlers struct {
 
 // Router represents a charm store HTTP request router.
 type Router struct {
-	handlers   *Handlers
-	handler    http.Handler
-	resolveURL func(id *charm.Reference) (*ResolvedURL, error)
-	authorize  func(id *ResolvedURL, req *http.Request) error
-	exists     func(id *ResolvedURL, req *http.Request) (bool, error)
+	handlers          *Handlers
+	handler           http.Handler
+	resolveURL        func(id *charm.Reference) (*ResolvedURL, error)
+	resolveMissingURL func(id *charm.Reference) (*ResolvedURL, error)
+	authorize         func(id *ResolvedURL, req *http.Request) error
+	exists            func(id *ResolvedURL, req *http.Request) (bool, error)
 }
 
 // ResolvedURL represents a URL that has been resolved by resolveURL.
-// URL.User and URL.Series should always be non-empty and
-// URL.Revision should never be -1.
+// URL.User should always be non-empty and URL.Revision should never
+// be -1. URL.Series is empty only for multi-series charms.
 //
 // If PromulgatedRevision is not -1, it holds the revision of the
 // promulgated version of the charm.
@@ -158,10 +159,10 @@ type ResolvedURL struct {
 // value.
 //
 // This function panics if urlStr cannot be parsed as a charm.Reference
-// or if it is not fully specified, including user, series and revision.
+// or if it does not specify both user and revision.
 func MustNewResolvedURL(urlStr string, promulgatedRev int) *ResolvedURL {
 	url := charm.MustParseReference(urlStr)
-	if url.User == "" || url.Series == "" || url.Revision == -1 {
+	if url.User == "" || url.Revision == -1 {
 		panic(fmt.Errorf("incomplete url %v", urlStr))
 	}
 	return &ResolvedURL{
@@ -215,6 +216,13 @@ func (u *ResolvedURL) String() string {
 // The Cause of the resolveURL error will be left unchanged,
 // as for the handlers.
 //
+// The resolveMissingURL function, if not nil, will be called to
+// resolve an id again when no entity is found with the id returned
+// by resolveURL, for instance because resolveURL returns fully
+// specified ids without looking them up. If it returns a different
+// id, the request is served again with that id. It should return an
+// error with a params.ErrNotFound cause if there is no such id.
+//
 // The authorize function will be called to authorize the request
 // to any BulkIncludeHandlers. All other handlers are expected
 // to handle their own authorization. The Cause of the authorize
@@ -226,14 +234,16 @@ func (u *ResolvedURL) String() string {
 func New(
 	handlers *Handlers,
 	resolveURL func(id *charm.Reference) (*ResolvedURL, error),
+	resolveMissingURL func(id *charm.Reference) (*ResolvedURL, error),
 	authorize func(id *ResolvedURL, req *http.Request) error,
 	exists func(id *ResolvedURL, req *http.Request) (bool, error),
 ) *Router {
 	r := &Router{
-		handlers:   handlers,
-		resolveURL: resolveURL,
-		authorize:  authorize,
-		exists:     exists,
+		handlers:          handlers,
+		resolveURL:        resolveURL,
+		resolveMissingURL: resolveMissingURL,
+		authorize:         authorize,
+		exists:            exists,
 	}
 	mux := NewServeMux()
 	mux.Handle("/meta/", http.StripPrefix("/meta", HandleErrors(r.serveBulkMeta)))
@@ -321,7 +331,72 @@ func (r *Router) serveIds(w http.ResponseWriter, req *http.Request) error {
 		return errgo.Mask(err, errgo.Any)
 	}
 	req.URL.Path = path
-	return r.serveMeta(rurl, w, req)
+	if req.Method != "GET" && req.Method != "HEAD" && r.resolveMissingURL != nil {
+		// The request body can only be read once, so make
+		// sure that the entity exists before serving the
+		// request rather than serving it again.
+		exists, err := r.exists(rurl, req)
+		if err != nil {
+			return errgo.Notef(err, "cannot determine existence of %q", rurl)
+		}
+		if !exists {
+			missingURL, err := r.resolveMissing(url, rurl)
+			if err != nil {
+				// Note: preserve error cause from resolveMissingURL.
+				return errgo.Mask(err, errgo.Any)
+			}
+			if missingURL != nil {
+				rurl = missingURL
+			}
+		}
+		return r.serveMeta(rurl, w, req)
+	}
+	return r.serveResolved(url, rurl, func(rurl *ResolvedURL) error {
+		return r.serveMeta(rurl, w, req)
+	})
+}
+
+// serveResolved calls serve with the id rurl, which has been resolved
+// from url. If serve fails because an entity was not found and url
+// resolves to another id with r.resolveMissingURL, serve is called
+// again with that id.
+func (r *Router) serveResolved(url *charm.Reference, rurl *ResolvedURL, serve func(rurl *ResolvedURL) error) error {
+	err := serve(rurl)
+	if errgo.Cause(err) != params.ErrNotFound {
+		// Note: preserve error cause from serve.
+		return errgo.Mask(err, errgo.Any)
+	}
+	missingURL, missingErr := r.resolveMissing(url, rurl)
+	if missingErr != nil {
+		// Note: preserve error cause from resolveMissingURL.
+		return errgo.Mask(missingErr, errgo.Any)
+	}
+	if missingURL == nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	// Note: preserve error cause from serve.
+	return errgo.Mask(serve(missingURL), errgo.Any)
+}
+
+// resolveMissing resolves url with r.resolveMissingURL when no entity
+// was found with the id rurl previously resolved from it. It returns
+// nil if url does not resolve to any other id.
+func (r *Router) resolveMissing(url *charm.Reference, rurl *ResolvedURL) (*ResolvedURL, error) {
+	if r.resolveMissingURL == nil {
+		return nil, nil
+	}
+	missingURL, err := r.resolveMissingURL(url)
+	if errgo.Cause(err) == params.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		// Note: preserve error cause from resolveMissingURL.
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	if *missingURL == *rurl {
+		return nil, nil
+	}
+	return missingURL, nil
 }
 
 func idHandlerNeedsResolveURL(req *http.Request) bool {
@@ -569,7 +644,12 @@ func (r *Router) serveBulkMetaGet(req *http.Request) (interface{}, error) {
 			// Note: preserve error cause from resolveURL.
 			return nil, errgo.Mask(err, errgo.Any)
 		}
-		meta, err := r.serveMetaGet(rurl, req)
+		var meta interface{}
+		err = r.serveResolved(url, rurl, func(rurl *ResolvedURL) error {
+			var err error
+			meta, err = r.serveMetaGet(rurl, req)
+			return err
+		})
 		if cause := errgo.Cause(err); cause == params.ErrNotFound || cause == params.ErrMetadataNotFound || (ignoreAuth && isAuthorizationError(cause)) {
 			// The relevant data does not exist, or it is not public and client
 			// asked not to authorize.
@@ -644,13 +724,17 @@ func (r *Router) serveBulkMetaPutOne(req *http.Request, id string, val *json.Raw
 		// Note: preserve error cause from resolveURL.
 		return errgo.Mask(err, errgo.Any)
 	}
-	if err := r.authorize(rurl, req); err != nil {
-		return errgo.Mask(err, errgo.Any)
-	}
-	if err := r.serveMetaPutBody(rurl, req, val); err != nil {
-		return errgo.Mask(err, errgo.Any)
-	}
-	return nil
+	// The body has already been read, so the request can
+	// be served again if the id needs to be resolved again.
+	return r.serveResolved(url, rurl, func(rurl *ResolvedURL) error {
+		if err := r.authorize(rurl, req); err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+		if err := r.serveMetaPutBody(rurl, req, val); err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+		return nil
+	})
 }
 
 // maxMetadataConcurrency specifies the maximum number
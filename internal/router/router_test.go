@@ -37,15 +37,16 @@ var _ = gc.Suite(&RouterSuite{})
 var newResolvedURL = MustNewResolvedURL
 
 var routerGetTests = []struct {
-	about            string
-	handlers         Handlers
-	urlStr           string
-	expectStatus     int
-	expectBody       interface{}
-	expectQueryCount int32
-	resolveURL       func(*charm.Reference) (*ResolvedURL, error)
-	authorize        func(*ResolvedURL, *http.Request) error
-	exists           func(*ResolvedURL, *http.Request) (bool, error)
+	about             string
+	handlers          Handlers
+	urlStr            string
+	expectStatus      int
+	expectBody        interface{}
+	expectQueryCount  int32
+	resolveURL        func(*charm.Reference) (*ResolvedURL, error)
+	resolveMissingURL func(*charm.Reference) (*ResolvedURL, error)
+	authorize         func(*ResolvedURL, *http.Request) error
+	exists            func(*ResolvedURL, *http.Request) (bool, error)
 }{{
 	about: "global handler",
 	handlers: Handlers{
@@ -486,6 +487,44 @@ var routerGetTests = []struct {
 		Code:    params.ErrNotFound,
 		Message: "not found",
 	},
+}, {
+	about:  "meta handler with id resolved again when not found",
+	urlStr: "/~bob/precise/wordpress-42/meta/foo",
+	handlers: Handlers{
+		Meta: map[string]BulkIncludeHandler{
+			"foo": onlyIdMetaHandler("cs:~bob/wordpress-42"),
+		},
+	},
+	resolveMissingURL: resolveMissingWithoutSeries,
+	expectStatus:      http.StatusOK,
+	expectBody:        "cs:~bob/wordpress-42",
+}, {
+	about:  "meta handler with id not found when resolved again",
+	urlStr: "/~bob/precise/wordpress-42/meta/foo",
+	handlers: Handlers{
+		Meta: map[string]BulkIncludeHandler{
+			"foo": onlyIdMetaHandler("cs:~bob/wordpress-42"),
+		},
+	},
+	resolveMissingURL: resolveURLError(params.ErrNotFound),
+	expectStatus:      http.StatusNotFound,
+	expectBody: params.Error{
+		Code:    params.ErrNotFound,
+		Message: "cs:~bob/precise/wordpress-42 not found",
+	},
+}, {
+	about:  "bulk meta handler with id resolved again when not found",
+	urlStr: "/meta/foo?id=~bob/precise/wordpress-42",
+	handlers: Handlers{
+		Meta: map[string]BulkIncludeHandler{
+			"foo": onlyIdMetaHandler("cs:~bob/wordpress-42"),
+		},
+	},
+	resolveMissingURL: resolveMissingWithoutSeries,
+	expectStatus:      http.StatusOK,
+	expectBody: map[string]string{
+		"~bob/precise/wordpress-42": "cs:~bob/wordpress-42",
+	},
 }, {
 	about:  "meta/any, some includes all using same key",
 	urlStr: "/precise/wordpress-42/meta/any?include=field1-1&include=field2&include=field1-2",
@@ -910,6 +949,25 @@ func resolveURLError(err error) func(*charm.Reference) (*ResolvedURL, error) {
 	}
 }
 
+// resolveMissingWithoutSeries resolves the given URL to
+// the same URL without a series.
+func resolveMissingWithoutSeries(u *charm.Reference) (*ResolvedURL, error) {
+	u1 := *u
+	u1.Series = ""
+	return newResolvedURL(u1.String(), -1), nil
+}
+
+// onlyIdMetaHandler returns a meta handler that returns the
+// given id when called for it and a not found error otherwise.
+func onlyIdMetaHandler(id string) BulkIncludeHandler {
+	return SingleIncludeHandler(func(rurl *ResolvedURL, path string, flags url.Values, req *http.Request) (interface{}, error) {
+		if rurl.URL.String() != id {
+			return nil, errgo.WithCausef(nil, params.ErrNotFound, "%s not found", &rurl.URL)
+		}
+		return id, nil
+	})
+}
+
 func alwaysResolveURL(u *charm.Reference) (*ResolvedURL, error) {
 	u1 := *u
 	if u1.Series == "" {
@@ -941,7 +999,7 @@ func (s *RouterSuite) TestRouterGet(c *gc.C) {
 		if test.exists != nil {
 			exists = test.exists
 		}
-		router := New(&test.handlers, resolve, authorize, exists)
+		router := New(&test.handlers, resolve, test.resolveMissingURL, authorize, exists)
 		// Note that fieldSelectHandler increments queryCount each time
 		// a query is made.
 		queryCount = 0
@@ -989,7 +1047,7 @@ func (s *RouterSuite) TestCORSHeaders(c *gc.C) {
 		Global: map[string]http.Handler{
 			"foo": http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}),
 		},
-	}, alwaysResolveURL, alwaysAuthorize, alwaysExists)
+	}, alwaysResolveURL, nil, alwaysAuthorize, alwaysExists)
 	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
 		Handler: h,
 		URL:     "/foo",
@@ -1043,7 +1101,7 @@ func (s *RouterSuite) TestHTTPRequestPassedThroughToMeta(c *gc.C) {
 				Update:    update,
 			}),
 		},
-	}, alwaysResolveURL, alwaysAuthorize, alwaysExists)
+	}, alwaysResolveURL, nil, alwaysAuthorize, alwaysExists)
 	resp := httptest.NewRecorder()
 	h.ServeHTTP(resp, testReq)
 	c.Assert(resp.Code, gc.Equals, http.StatusOK, gc.Commentf("response body: %s", resp.Body))
@@ -1060,7 +1118,7 @@ func (s *RouterSuite) TestHTTPRequestPassedThroughToMeta(c *gc.C) {
 }
 
 func (s *RouterSuite) TestOptionsHTTPMethod(c *gc.C) {
-	h := New(&Handlers{}, alwaysResolveURL, alwaysAuthorize, alwaysExists)
+	h := New(&Handlers{}, alwaysResolveURL, nil, alwaysAuthorize, alwaysExists)
 	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
 		Handler: h,
 		Method:  "OPTIONS",
@@ -1605,7 +1663,7 @@ func (s *RouterSuite) TestRouterPut(c *gc.C) {
 		}
 		bodyVal, err := json.Marshal(test.body)
 		c.Assert(err, gc.IsNil)
-		router := New(&test.handlers, resolve, alwaysAuthorize, alwaysExists)
+		router := New(&test.handlers, resolve, nil, alwaysAuthorize, alwaysExists)
 		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
 			Handler: router,
 			URL:     test.urlStr,
@@ -1674,7 +1732,7 @@ func (s *RouterSuite) TestRouterPutWithInvalidContent(c *gc.C) {
 				"foo": testMetaHandler(0),
 			},
 		}
-		router := New(handlers, alwaysResolveURL, alwaysAuthorize, alwaysExists)
+		router := New(handlers, alwaysResolveURL, nil, alwaysAuthorize, alwaysExists)
 		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
 			Handler: router,
 			URL:     test.urlStr,
@@ -1756,7 +1814,7 @@ func (s *RouterSuite) TestGetMetadata(c *gc.C) {
 				"item2": fieldSelectHandler("handler2", 0, "item2"),
 				"test":  testMetaHandler(0),
 			},
-		}, alwaysResolveURL, alwaysAuthorize, alwaysExists)
+		}, alwaysResolveURL, nil, alwaysAuthorize, alwaysExists)
 		result, err := router.GetMetadata(test.id, test.includes, nil)
 		if test.expectError != "" {
 			c.Assert(err, gc.ErrorMatches, test.expectError)
@@ -20,6 +20,11 @@ import (
 
 var logger = loggo.GetLogger("charmstore.internal.router")
 
+// ErrQuotaExceeded is the error code returned when an upload
+// would exceed the quota of the owner of the namespace it is
+// being uploaded to.
+const ErrQuotaExceeded params.ErrorCode = "quota exceeded"
+
 // WriteError can be used to write an error response.
 var WriteError = errorToResp.WriteError
 
@@ -91,6 +96,8 @@ func errorToResp1(err error) (int, interface{}) {
 		status = http.StatusMethodNotAllowed
 	case params.ErrServiceUnavailable:
 		status = http.StatusServiceUnavailable
+	case ErrQuotaExceeded:
+		status = http.StatusForbidden
 	}
 	return status, errorBody
 }
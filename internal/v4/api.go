@@ -53,8 +53,10 @@ type Handler struct {
 	pool           *charmstore.Pool
 
 	// searchCache is a cache of search results keyed on the query
-	// parameters of the search. It should only be used for searches
-	// from unauthenticated users.
+	// parameters of the search. It should only hold results that
+	// do not depend on the user making the request, such as
+	// searches from unauthenticated users and the candidates for
+	// interesting searches, which are filtered per request.
 	searchCache *cache.Cache
 }
 
@@ -72,6 +74,11 @@ type ReqHandler struct {
 	// auth holds the results of any authorization that
 	// has been done on this request.
 	auth authorization
+
+	// channel holds the channel specified in the request, if any.
+	// When it is set, ids are resolved to the entities published
+	// to the channel.
+	channel string
 }
 
 const (
@@ -126,15 +133,21 @@ func newReqHandler() *ReqHandler {
 			"changes/published":    router.HandleJSON(h.serveChangesPublished),
 			"debug":                http.HandlerFunc(h.serveDebug),
 			"debug/pprof/":         newPprofHandler(&h),
+			"debug/search-check":   router.HandleJSON(h.serveDebugSearchCheck),
 			"debug/status":         router.HandleJSON(h.serveDebugStatus),
 			"log":                  router.HandleErrors(h.serveLog),
 			"search":               router.HandleJSON(h.serveSearch),
-			"search/interesting":   http.HandlerFunc(h.serveSearchInteresting),
+			"search/featured":      router.HandleErrors(h.serveSearchFeatured),
+			"search/interesting":   router.HandleJSON(h.serveSearchInteresting),
 			"set-auth-cookie":      router.HandleErrors(h.serveSetAuthCookie),
 			"stats/":               router.NotFoundHandler(),
 			"stats/counter/":       router.HandleJSON(h.serveStatsCounter),
 			"stats/update":         router.HandleErrors(h.serveStatsUpdate),
 			"macaroon":             router.HandleJSON(h.serveMacaroon),
+			"publisher-keys/":      router.HandleErrors(h.servePublisherKeys),
+			"quota/":               router.HandleErrors(h.serveQuota),
+			"upload":               router.HandleErrors(h.serveNewUpload),
+			"upload/":              router.HandleErrors(h.serveUploadSession),
 			"delegatable-macaroon": router.HandleJSON(h.serveDelegatableMacaroon),
 			"whoami":               router.HandleJSON(h.serveWhoAmI),
 		},
@@ -142,11 +155,16 @@ func newReqHandler() *ReqHandler {
 			"archive":     h.serveArchive,
 			"archive/":    h.resolveId(h.authId(h.serveArchiveFile)),
 			"diagram.svg": h.resolveId(h.authId(h.serveDiagram)),
+			"diff":        h.resolveId(h.authId(h.serveDiff)),
 			"expand-id":   h.resolveId(h.authId(h.serveExpandId)),
 			"icon.svg":    h.resolveId(h.authId(h.serveIcon)),
 			"readme":      h.resolveId(h.authId(h.serveReadMe)),
 			"resources":   h.resolveId(h.authId(h.serveResources)),
+			"resources/":  h.resolveId(h.authId(h.serveResources)),
 			"promulgate":  h.resolveId(h.serveAdminPromulgate),
+			"publish":     h.resolveId(h.servePublish),
+			"restore":     h.serveAdminRestore,
+			"transfer":    h.serveAdminTransfer,
 		},
 		Meta: map[string]router.BulkIncludeHandler{
 			"archive-size":         h.entityHandler(h.metaArchiveSize, "size"),
@@ -169,25 +187,29 @@ func newReqHandler() *ReqHandler {
 				h.putMetaExtraInfoWithKey,
 				"extrainfo",
 			),
-			"hash":          h.entityHandler(h.metaHash, "blobhash"),
-			"hash256":       h.entityHandler(h.metaHash256, "blobhash256"),
-			"id":            h.entityHandler(h.metaId, "_id"),
-			"id-name":       h.entityHandler(h.metaIdName, "_id"),
-			"id-user":       h.entityHandler(h.metaIdUser, "_id"),
-			"id-revision":   h.entityHandler(h.metaIdRevision, "_id"),
-			"id-series":     h.entityHandler(h.metaIdSeries, "_id"),
-			"manifest":      h.entityHandler(h.metaManifest, "blobname"),
-			"perm":          h.puttableBaseEntityHandler(h.metaPerm, h.putMetaPerm, "acls"),
-			"perm/":         h.puttableBaseEntityHandler(h.metaPermWithKey, h.putMetaPermWithKey, "acls"),
-			"promulgated":   h.baseEntityHandler(h.metaPromulgated, "promulgated"),
-			"revision-info": router.SingleIncludeHandler(h.metaRevisionInfo),
-			"stats":         h.entityHandler(h.metaStats),
-			"tags":          h.entityHandler(h.metaTags, "charmmeta", "bundledata"),
+			"hash":             h.entityHandler(h.metaHash, "blobhash"),
+			"hash256":          h.entityHandler(h.metaHash256, "blobhash256"),
+			"id":               h.entityHandler(h.metaId, "_id"),
+			"id-name":          h.entityHandler(h.metaIdName, "_id"),
+			"id-user":          h.entityHandler(h.metaIdUser, "_id"),
+			"id-revision":      h.entityHandler(h.metaIdRevision, "_id"),
+			"id-series":        h.entityHandler(h.metaIdSeries, "_id"),
+			"lint":             h.entityHandler(h.metaLint, "lintwarnings"),
+			"manifest":         h.entityHandler(h.metaManifest, "blobname"),
+			"perm":             h.puttableBaseEntityHandler(h.metaPerm, h.putMetaPerm, "acls"),
+			"perm/":            h.puttableBaseEntityHandler(h.metaPermWithKey, h.putMetaPermWithKey, "acls"),
+			"promulgated":      h.baseEntityHandler(h.metaPromulgated, "promulgated"),
+			"retention":        h.puttableBaseEntityHandler(h.metaRetention, h.putMetaRetention, "retention"),
+			"revision-info":    router.SingleIncludeHandler(h.metaRevisionInfo),
+			"signatures":       h.puttableEntityHandler(h.metaSignatures, h.putMetaSignatures, "signatures"),
+			"stats":            h.entityHandler(h.metaStats),
+			"supported-series": h.entityHandler(h.metaSupportedSeries, "series", "supportedseries"),
+			"tags":             h.entityHandler(h.metaTags, "charmmeta", "bundledata"),
 
 			// endpoints not yet implemented:
 			// "color": router.SingleIncludeHandler(h.metaColor),
 		},
-	}, h.resolveURL, h.AuthorizeEntity, h.entityExists)
+	}, h.resolveURL, h.resolveMissingURL, h.AuthorizeEntity, h.entityExists)
 	return &h
 }
 
@@ -204,6 +226,11 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	defer rh.Close()
+	rh.channel = req.URL.Query().Get("channel")
+	if rh.channel != "" && !charmstore.IsValidChannel(rh.channel) {
+		router.WriteError(w, badRequestf(nil, "invalid channel %q", rh.channel))
+		return
+	}
 	rh.Router.ServeHTTP(w, req)
 }
 
@@ -221,25 +248,34 @@ func (h *ReqHandler) Close() {
 	h.Store = nil
 	h.handler = nil
 	h.auth = authorization{}
+	h.channel = ""
 	reqHandlerPool.Put(h)
 }
 
 // ResolveURL resolves the series and revision of the given URL if either is
 // unspecified by filling them out with information retrieved from the store.
-func ResolveURL(store *charmstore.Store, url *charm.Reference) (*router.ResolvedURL, error) {
-	if url.Series != "" && url.Revision != -1 && url.User != "" {
+// If channel is not empty, the URL is resolved to the entity published to
+// that channel. URLs of base entities that have been transferred to another
+// user are resolved to the entities owned by that user.
+//
+// A fully specified URL is returned unchanged without looking it up, so
+// the returned id may not exist. When there is no entity with that id,
+// ResolveMissingURL can be used to resolve the URL to a multi-series
+// charm or to a transferred entity.
+func ResolveURL(store *charmstore.Store, url *charm.Reference, channel string) (*router.ResolvedURL, error) {
+	if isFullySpecified(url) {
 		// URL is fully specified; no need for a database lookup.
 		return &router.ResolvedURL{
 			URL:                 *url,
 			PromulgatedRevision: -1,
 		}, nil
 	}
-	entity, err := store.FindBestEntity(url, "_id", "promulgated-revision")
+	entity, err := store.FindBestEntity(url, channel, "_id", "promulgated-revision")
 	if err != nil && errgo.Cause(err) != params.ErrNotFound {
 		return nil, errgo.Mask(err)
 	}
 	if errgo.Cause(err) == params.ErrNotFound {
-		return nil, noMatchingURLError(url)
+		return resolveRedirect(store, url, channel)
 	}
 	if url.User == "" {
 		return &router.ResolvedURL{
@@ -253,12 +289,110 @@ func ResolveURL(store *charmstore.Store, url *charm.Reference) (*router.Resolved
 	}, nil
 }
 
+// ResolveMissingURL resolves a fully specified URL for which there is
+// no entity with exactly that id. The URL can match a multi-series
+// charm with the same revision that supports the series, or refer
+// to an entity that has been transferred to another user. It returns
+// an error with a params.ErrNotFound cause if the URL is not fully
+// specified or if neither exists.
+func ResolveMissingURL(store *charmstore.Store, url *charm.Reference, channel string) (*router.ResolvedURL, error) {
+	if !isFullySpecified(url) {
+		return nil, noMatchingURLError(url)
+	}
+	entities, err := store.FindEntities(url, "_id")
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if len(entities) == 0 {
+		return resolveRedirect(store, url, channel)
+	}
+	// An entity with exactly the given id
+	// takes precedence.
+	resolved := entities[0].URL
+	for _, e := range entities {
+		if *e.URL == *url {
+			resolved = url
+			break
+		}
+	}
+	return &router.ResolvedURL{
+		URL:                 *resolved,
+		PromulgatedRevision: -1,
+	}, nil
+}
+
+// isFullySpecified reports whether the given URL
+// specifies the user, series and revision.
+func isFullySpecified(url *charm.Reference) bool {
+	return url.Series != "" && url.Revision != -1 && url.User != ""
+}
+
+// resolveRedirect resolves a URL that refers to an entity that has
+// been transferred to another user. It returns an error with a
+// params.ErrNotFound cause if the URL has not been redirected.
+func resolveRedirect(store *charmstore.Store, url *charm.Reference, channel string) (*router.ResolvedURL, error) {
+	newURL, err := store.Redirect(url)
+	if errgo.Cause(err) == params.ErrNotFound {
+		return nil, noMatchingURLError(url)
+	}
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if !isFullySpecified(newURL) {
+		return ResolveURL(store, newURL, channel)
+	}
+	// The new id may refer to a multi-series charm,
+	// so look it up. If nothing is found, the new id is
+	// returned unchanged and it is left to the handler
+	// to report it.
+	rid, err := ResolveMissingURL(store, newURL, channel)
+	if errgo.Cause(err) == params.ErrNotFound {
+		return &router.ResolvedURL{
+			URL:                 *newURL,
+			PromulgatedRevision: -1,
+		}, nil
+	}
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return rid, nil
+}
+
 func noMatchingURLError(url *charm.Reference) error {
 	return errgo.WithCausef(nil, params.ErrNotFound, "no matching charm or bundle for %q", url)
 }
 
 func (h *ReqHandler) resolveURL(url *charm.Reference) (*router.ResolvedURL, error) {
-	return ResolveURL(h.Store, url)
+	return ResolveURL(h.Store, url, h.channel)
+}
+
+func (h *ReqHandler) resolveMissingURL(url *charm.Reference) (*router.ResolvedURL, error) {
+	return ResolveMissingURL(h.Store, url, h.channel)
+}
+
+// resolveExistingURL is like resolveURL except that when the URL is
+// fully specified and there is no entity with exactly that id, the
+// URL is resolved with resolveMissingURL. It should be used when the
+// request cannot be served again with another id.
+func (h *ReqHandler) resolveExistingURL(url *charm.Reference) (*router.ResolvedURL, error) {
+	rid, err := h.resolveURL(url)
+	if err != nil || !isFullySpecified(url) {
+		return rid, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	_, err = h.Store.FindEntity(rid, "_id")
+	if errgo.Cause(err) != params.ErrNotFound {
+		return rid, errgo.Mask(err)
+	}
+	missingId, err := h.resolveMissingURL(url)
+	if errgo.Cause(err) == params.ErrNotFound {
+		// Leave it to the handler to report
+		// that the entity does not exist.
+		return rid, nil
+	}
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return missingId, nil
 }
 
 type entityHandlerFunc func(entity *mongodoc.Entity, id *router.ResolvedURL, path string, flags url.Values, req *http.Request) (interface{}, error)
@@ -444,18 +578,6 @@ func (h *ReqHandler) serveDebug(w http.ResponseWriter, req *http.Request) {
 	router.WriteError(w, errNotImplemented)
 }
 
-// POST id/resources/name.stream
-// https://github.com/juju/charmstore/blob/v4/docs/API.md#post-idresourcesnamestream
-//
-// GET  id/resources/name.stream[-revision]/arch/filename
-// https://github.com/juju/charmstore/blob/v4/docs/API.md#get-idresourcesnamestream-revisionarchfilename
-//
-// PUT id/resources/[~user/]series/name.stream-revision/arch?sha256=hash
-// https://github.com/juju/charmstore/blob/v4/docs/API.md#put-idresourcesuserseriesnamestream-revisionarchsha256hash
-func (h *ReqHandler) serveResources(id *router.ResolvedURL, w http.ResponseWriter, req *http.Request) error {
-	return errNotImplemented
-}
-
 // GET id/expand-id
 // https://docs.google.com/a/canonical.com/document/d/1TgRA7jW_mmXoKH3JiwBbtPvQu7WiM6XMrz1wSrhTMXw/edit#bookmark=id.4xdnvxphb2si
 func (h *ReqHandler) serveExpandId(id *router.ResolvedURL, w http.ResponseWriter, req *http.Request) error {
@@ -488,9 +610,21 @@ func (h *ReqHandler) serveExpandId(id *router.ResolvedURL, w http.ResponseWriter
 		return noMatchingURLError(id.PreferredURL())
 	}
 
+	var published map[string]*charm.Reference
+	if h.channel != "" {
+		baseEntity, err := h.Store.FindBaseEntity(&id.URL, "channels")
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		published = baseEntity.Channels[h.channel]
+	}
+
 	// Collect all the expanded identifiers for each entity.
 	response := make([]params.ExpandedId, 0, len(docs))
 	for _, doc := range docs {
+		if h.channel != "" && !isPublished(published, doc.URL) {
+			continue
+		}
 		url := doc.PreferredURL(id.PromulgatedRevision != -1)
 		response = append(response, params.ExpandedId{Id: url.String()})
 	}
@@ -499,6 +633,13 @@ func (h *ReqHandler) serveExpandId(id *router.ResolvedURL, w http.ResponseWriter
 	return httprequest.WriteJSON(w, http.StatusOK, response)
 }
 
+// isPublished reports whether the entity with the given
+// URL is in the given set of published entities, keyed by series.
+func isPublished(published map[string]*charm.Reference, url *charm.Reference) bool {
+	p := published[url.Series]
+	return p != nil && *p == *url
+}
+
 func badRequestf(underlying error, f string, a ...interface{}) error {
 	err := errgo.WithCausef(underlying, params.ErrBadRequest, f, a...)
 	err.(*errgo.Err).SetLocation(1)
@@ -685,6 +826,20 @@ func (h *ReqHandler) metaRevisionInfo(id *router.ResolvedURL, path string, flags
 	if len(docs) == 0 {
 		return "", errgo.WithCausef(nil, params.ErrNotFound, "no matching charm or bundle for %s", id)
 	}
+	if h.channel != "" {
+		// Omit any revisions newer than the one published
+		// to the channel.
+		published, err := h.Store.ChannelEntityURL(id, h.channel)
+		if err != nil {
+			return "", errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		for len(docs) > 0 && *docs[0].URL != *published {
+			docs = docs[1:]
+		}
+		if len(docs) == 0 {
+			return "", errgo.WithCausef(nil, params.ErrNotFound, "no matching charm or bundle for %s", id)
+		}
+	}
 	var response params.RevisionInfoResponse
 	for _, doc := range docs {
 		if id.PromulgatedRevision != -1 {
@@ -714,6 +869,28 @@ func (h *ReqHandler) metaIdSeries(entity *mongodoc.Entity, id *router.ResolvedUR
 	}, nil
 }
 
+// SupportedSeriesResponse holds the result of a GET to
+// id/meta/supported-series.
+type SupportedSeriesResponse struct {
+	SupportedSeries []string
+}
+
+// GET id/meta/supported-series
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#get-idmetasupported-series
+func (h *ReqHandler) metaSupportedSeries(entity *mongodoc.Entity, id *router.ResolvedURL, path string, flags url.Values, req *http.Request) (interface{}, error) {
+	if entity.Series == "bundle" {
+		return nil, nil
+	}
+	if entity.Series != "" {
+		return &SupportedSeriesResponse{
+			SupportedSeries: []string{entity.Series},
+		}, nil
+	}
+	return &SupportedSeriesResponse{
+		SupportedSeries: entity.SupportedSeries,
+	}, nil
+}
+
 // GET id/meta/id
 // https://github.com/juju/charmstore/blob/v4/docs/API.md#get-idmetaid
 func (h *ReqHandler) metaId(entity *mongodoc.Entity, id *router.ResolvedURL, path string, flags url.Values, req *http.Request) (interface{}, error) {
@@ -869,6 +1046,80 @@ func (h *ReqHandler) metaPromulgated(entity *mongodoc.BaseEntity, id *router.Res
 	}, nil
 }
 
+// RetentionPolicy holds the result of a GET to id/meta/retention
+// and the body of a PUT to it. KeepDuration is formatted as
+// understood by time.ParseDuration.
+type RetentionPolicy struct {
+	KeepRevisions int    `json:",omitempty"`
+	KeepDuration  string `json:",omitempty"`
+}
+
+// GET id/meta/retention
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#get-idmetaretention
+func (h *ReqHandler) metaRetention(entity *mongodoc.BaseEntity, id *router.ResolvedURL, path string, flags url.Values, req *http.Request) (interface{}, error) {
+	if entity.Retention == nil {
+		return &RetentionPolicy{}, nil
+	}
+	policy := &RetentionPolicy{
+		KeepRevisions: entity.Retention.KeepRevisions,
+	}
+	if entity.Retention.KeepDuration > 0 {
+		policy.KeepDuration = entity.Retention.KeepDuration.String()
+	}
+	return policy, nil
+}
+
+// PUT id/meta/retention
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#put-idmetaretention
+func (h *ReqHandler) putMetaRetention(id *router.ResolvedURL, path string, val *json.RawMessage, updater *router.FieldUpdater, req *http.Request) error {
+	var policy RetentionPolicy
+	if err := json.Unmarshal(*val, &policy); err != nil {
+		return errgo.Mask(err)
+	}
+	if policy.KeepRevisions < 0 {
+		return badRequestf(nil, "invalid KeepRevisions %d", policy.KeepRevisions)
+	}
+	retention := mongodoc.RetentionPolicy{
+		KeepRevisions: policy.KeepRevisions,
+	}
+	if policy.KeepDuration != "" {
+		d, err := time.ParseDuration(policy.KeepDuration)
+		if err != nil || d < 0 {
+			return badRequestf(nil, "invalid KeepDuration %q", policy.KeepDuration)
+		}
+		retention.KeepDuration = d
+	}
+	if retention == (mongodoc.RetentionPolicy{}) {
+		// Fall back to the store-wide policy.
+		updater.UpdateField("retention", nil, nil)
+	} else {
+		updater.UpdateField("retention", &retention, nil)
+	}
+	return nil
+}
+
+// LintProblem holds a warning found when an entity's archive was
+// checked at upload time, as returned by a GET of id/meta/lint.
+type LintProblem struct {
+	Linter   string
+	Severity string
+	Message  string
+}
+
+// GET id/meta/lint
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#get-idmetalint
+func (h *ReqHandler) metaLint(entity *mongodoc.Entity, id *router.ResolvedURL, path string, flags url.Values, req *http.Request) (interface{}, error) {
+	problems := make([]LintProblem, len(entity.LintWarnings))
+	for i, p := range entity.LintWarnings {
+		problems[i] = LintProblem{
+			Linter:   p.Linter,
+			Severity: p.Severity,
+			Message:  p.Message,
+		}
+	}
+	return problems, nil
+}
+
 // GET id/meta/perm/key
 // https://github.com/juju/charmstore/blob/v4/docs/API.md#get-idmetapermkey
 func (h *ReqHandler) metaPermWithKey(entity *mongodoc.BaseEntity, id *router.ResolvedURL, path string, flags url.Values, req *http.Request) (interface{}, error) {
@@ -961,9 +1212,9 @@ func (h *ReqHandler) serveChangesPublished(_ http.Header, r *http.Request) (inte
 			Value: stop,
 		})
 	}
-	var findQuery bson.D
+	findQuery := bson.D{{"trashtime", bson.D{{"$exists", false}}}}
 	if len(tquery) > 0 {
-		findQuery = bson.D{{"uploadtime", tquery}}
+		findQuery = append(findQuery, bson.DocElem{"uploadtime", tquery})
 	}
 	query := h.Store.DB.Entities().
 		Find(findQuery).
@@ -1045,6 +1296,41 @@ func (h *ReqHandler) serveWhoAmI(_ http.Header, req *http.Request) (interface{},
 	}, nil
 }
 
+// PublishRequest holds the body of a PUT to id/publish.
+type PublishRequest struct {
+	Channels []string
+}
+
+// PUT id/publish
+// See https://github.com/juju/charmstore/blob/v4/docs/API.md#put-idpublish
+func (h *ReqHandler) servePublish(id *router.ResolvedURL, w http.ResponseWriter, req *http.Request) error {
+	if req.Method != "PUT" {
+		return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s not allowed", req.Method)
+	}
+	baseEntity, err := h.Store.FindBaseEntity(&id.URL, "acls")
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	// Always authenticate so that the audit log records who
+	// published the entity.
+	if _, err := h.authorize(req, baseEntity.ACLs.Write, true, id); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	var publish PublishRequest
+	if err := json.NewDecoder(req.Body).Decode(&publish); err != nil {
+		return errgo.WithCausef(err, params.ErrBadRequest, "")
+	}
+	if err := h.Store.Publish(id, publish.Channels...); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest), errgo.Is(params.ErrNotFound))
+	}
+	h.addAudit(audit.Entry{
+		Op:       audit.OpPublish,
+		Entity:   &id.URL,
+		Channels: publish.Channels,
+	})
+	return nil
+}
+
 // PUT id/promulgate
 // See https://github.com/juju/charmstore/blob/v4/docs/API.md#put-idpromulgate
 func (h *ReqHandler) serveAdminPromulgate(id *router.ResolvedURL, w http.ResponseWriter, req *http.Request) error {
@@ -1079,6 +1365,41 @@ func (h *ReqHandler) serveAdminPromulgate(id *router.ResolvedURL, w http.Respons
 	return nil
 }
 
+// TransferRequest holds the body of a PUT to id/transfer.
+type TransferRequest struct {
+	// User holds the name of the user or group that
+	// will own the entity after the transfer.
+	User string
+}
+
+// PUT id/transfer
+// See https://github.com/juju/charmstore/blob/v4/docs/API.md#put-idtransfer
+func (h *ReqHandler) serveAdminTransfer(id *charm.Reference, w http.ResponseWriter, req *http.Request) error {
+	if _, err := h.authorize(req, nil, true, nil); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	if req.Method != "PUT" {
+		return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s not allowed", req.Method)
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	var transfer TransferRequest
+	if err := json.Unmarshal(data, &transfer); err != nil {
+		return errgo.WithCausef(err, params.ErrBadRequest, "")
+	}
+	if _, err := h.Store.TransferBaseEntity(id, transfer.User); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrBadRequest))
+	}
+	h.addAudit(audit.Entry{
+		Op:     audit.OpTransfer,
+		Entity: id,
+		Owner:  transfer.User,
+	})
+	return nil
+}
+
 // serveSetAuthCookie sets the provided macaroon slice as a cookie on the
 // client.
 func (h *ReqHandler) serveSetAuthCookie(w http.ResponseWriter, req *http.Request) error {
@@ -1121,13 +1442,35 @@ func (h *ReqHandler) authId(f resolvedIdHandler) resolvedIdHandler {
 
 // resolveId returns an id handler that resolves any non-fully-specified
 // entity ids using h.resolveURL before calling f with the resolved id.
+// If a GET request is not found, the id is resolved again with
+// h.resolveMissingURL and f is called again if that finds another id.
+// Other requests cannot be served twice, so their ids are resolved
+// with h.resolveExistingURL instead.
 func (h *ReqHandler) resolveId(f resolvedIdHandler) router.IdHandler {
 	return func(id *charm.Reference, w http.ResponseWriter, req *http.Request) error {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			rid, err := h.resolveExistingURL(id)
+			if err != nil {
+				return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+			}
+			return f(rid, w, req)
+		}
 		rid, err := h.resolveURL(id)
 		if err != nil {
 			return errgo.Mask(err, errgo.Is(params.ErrNotFound))
 		}
-		return f(rid, w, req)
+		err = f(rid, w, req)
+		if errgo.Cause(err) != params.ErrNotFound {
+			return err
+		}
+		missingId, missingErr := h.resolveMissingURL(id)
+		if errgo.Cause(missingErr) == params.ErrNotFound || (missingErr == nil && *missingId == *rid) {
+			return err
+		}
+		if missingErr != nil {
+			return errgo.Mask(missingErr)
+		}
+		return f(missingId, w, req)
 	}
 }
 
@@ -251,6 +251,47 @@ var metaEndpoints = []metaEndpoint{{
 				charm.MustParseReference("cs:precise/wordpress-99"),
 			}})
 	},
+}, {
+	name: "signatures",
+	get: func(store *charmstore.Store, url *router.ResolvedURL) (interface{}, error) {
+		e, err := store.FindEntity(url, "signatures")
+		if err != nil {
+			return nil, err
+		}
+		if len(e.Signatures) == 0 {
+			return nil, nil
+		}
+		sigs := make([]v4.Signature, len(e.Signatures))
+		for i, sig := range e.Signatures {
+			sigs[i] = v4.Signature{
+				KeyId:     sig.KeyId,
+				Algorithm: sig.Algorithm,
+				Signature: sig.Signature,
+			}
+		}
+		return sigs, nil
+	},
+	checkURL: newResolvedURL("~charmers/precise/wordpress-23", 23),
+	assertCheckData: func(c *gc.C, data interface{}) {
+		c.Assert(data, gc.IsNil)
+	},
+}, {
+	name: "lint",
+	get: entityGetter(func(entity *mongodoc.Entity) interface{} {
+		problems := make([]v4.LintProblem, len(entity.LintWarnings))
+		for i, p := range entity.LintWarnings {
+			problems[i] = v4.LintProblem{
+				Linter:   p.Linter,
+				Severity: p.Severity,
+				Message:  p.Message,
+			}
+		}
+		return problems
+	}),
+	checkURL: newResolvedURL("~charmers/precise/wordpress-23", 23),
+	assertCheckData: func(c *gc.C, data interface{}) {
+		c.Assert(data, gc.DeepEquals, []v4.LintProblem{})
+	},
 }, {
 	name:      "charm-related",
 	exclusive: charmOnly,
@@ -382,6 +423,23 @@ var metaEndpoints = []metaEndpoint{{
 	assertCheckData: func(c *gc.C, data interface{}) {
 		c.Assert(data, gc.Equals, params.IdSeriesResponse{"utopic"})
 	},
+}, {
+	name:      "supported-series",
+	exclusive: charmOnly,
+	get: func(store *charmstore.Store, url *router.ResolvedURL) (interface{}, error) {
+		if url.URL.Series == "bundle" {
+			return nil, nil
+		}
+		return &v4.SupportedSeriesResponse{
+			SupportedSeries: []string{url.URL.Series},
+		}, nil
+	},
+	checkURL: newResolvedURL("~charmers/utopic/category-2", 2),
+	assertCheckData: func(c *gc.C, data interface{}) {
+		c.Assert(data, jc.DeepEquals, &v4.SupportedSeriesResponse{
+			SupportedSeries: []string{"utopic"},
+		})
+	},
 }, {
 	name: "id-name",
 	get: func(store *charmstore.Store, url *router.ResolvedURL) (interface{}, error) {
@@ -437,6 +495,28 @@ var metaEndpoints = []metaEndpoint{{
 	assertCheckData: func(c *gc.C, data interface{}) {
 		c.Assert(data, gc.Equals, params.PromulgatedResponse{Promulgated: false})
 	},
+}, {
+	name: "retention",
+	get: func(store *charmstore.Store, url *router.ResolvedURL) (interface{}, error) {
+		e, err := store.FindBaseEntity(&url.URL)
+		if err != nil {
+			return nil, err
+		}
+		if e.Retention == nil {
+			return &v4.RetentionPolicy{}, nil
+		}
+		policy := &v4.RetentionPolicy{
+			KeepRevisions: e.Retention.KeepRevisions,
+		}
+		if e.Retention.KeepDuration > 0 {
+			policy.KeepDuration = e.Retention.KeepDuration.String()
+		}
+		return policy, nil
+	},
+	checkURL: newResolvedURL("cs:~bob/utopic/wordpress-2", -1),
+	assertCheckData: func(c *gc.C, data interface{}) {
+		c.Assert(data, jc.DeepEquals, &v4.RetentionPolicy{})
+	},
 }}
 
 // TestEndpointGet tries to ensure that the endpoint
@@ -712,6 +792,82 @@ func (s *APISuite) TestMetaPermPutUnauthorized(c *gc.C) {
 	})
 }
 
+func (s *APISuite) TestMetaRetention(c *gc.C) {
+	s.addPublicCharm(c, "wordpress", newResolvedURL("~charmers/precise/wordpress-23", 23))
+	s.addPublicCharm(c, "wordpress", newResolvedURL("~charmers/trusty/wordpress-1", 1))
+	s.assertGet(c, "~charmers/precise/wordpress-23/meta/retention", v4.RetentionPolicy{})
+
+	// The policy is shared by all revisions and series.
+	s.assertPut(c, "~charmers/precise/wordpress-23/meta/retention", v4.RetentionPolicy{
+		KeepRevisions: 5,
+		KeepDuration:  "720h",
+	})
+	s.assertGet(c, "~charmers/trusty/wordpress-1/meta/retention", v4.RetentionPolicy{
+		KeepRevisions: 5,
+		KeepDuration:  "720h0m0s",
+	})
+	e, err := s.store.FindBaseEntity(charm.MustParseReference("~charmers/wordpress"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(e.Retention, jc.DeepEquals, &mongodoc.RetentionPolicy{
+		KeepRevisions: 5,
+		KeepDuration:  720 * time.Hour,
+	})
+
+	// An empty policy removes the base entity's own policy.
+	s.assertPut(c, "~charmers/precise/wordpress-23/meta/retention", v4.RetentionPolicy{})
+	s.assertGet(c, "~charmers/precise/wordpress-23/meta/retention", v4.RetentionPolicy{})
+	e, err = s.store.FindBaseEntity(charm.MustParseReference("~charmers/wordpress"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(e.Retention, gc.IsNil)
+}
+
+var metaRetentionBadPutRequestsTests = []struct {
+	about         string
+	body          interface{}
+	expectMessage string
+}{{
+	about: "negative revision count",
+	body: v4.RetentionPolicy{
+		KeepRevisions: -1,
+	},
+	expectMessage: "invalid KeepRevisions -1",
+}, {
+	about: "invalid duration",
+	body: v4.RetentionPolicy{
+		KeepDuration: "a while",
+	},
+	expectMessage: `invalid KeepDuration "a while"`,
+}, {
+	about: "negative duration",
+	body: v4.RetentionPolicy{
+		KeepDuration: "-1h",
+	},
+	expectMessage: `invalid KeepDuration "-1h"`,
+}}
+
+func (s *APISuite) TestMetaRetentionBadPutRequests(c *gc.C) {
+	s.addPublicCharm(c, "wordpress", newResolvedURL("~charmers/precise/wordpress-23", 23))
+	for i, test := range metaRetentionBadPutRequestsTests {
+		c.Logf("test %d: %s", i, test.about)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler: s.srv,
+			URL:     storeURL("~charmers/precise/wordpress-23/meta/retention"),
+			Method:  "PUT",
+			Header: http.Header{
+				"Content-Type": {"application/json"},
+			},
+			Username:     testUsername,
+			Password:     testPassword,
+			Body:         strings.NewReader(mustMarshalJSON(test.body)),
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody: params.Error{
+				Code:    params.ErrBadRequest,
+				Message: test.expectMessage,
+			},
+		})
+	}
+}
+
 func (s *APISuite) TestExtraInfo(c *gc.C) {
 	id := "precise/wordpress-23"
 	s.addPublicCharm(c, "wordpress", newResolvedURL("~charmers/"+id, 23))
@@ -1287,7 +1443,7 @@ func (s *APISuite) TestResolveURL(c *gc.C) {
 	for i, test := range resolveURLTests {
 		c.Logf("test %d: %s", i, test.url)
 		url := charm.MustParseReference(test.url)
-		rurl, err := v4.ResolveURL(s.store, url)
+		rurl, err := v4.ResolveURL(s.store, url, "")
 		if test.notFound {
 			c.Assert(errgo.Cause(err), gc.Equals, params.ErrNotFound)
 			c.Assert(err, gc.ErrorMatches, `no matching charm or bundle for ".*"`)
@@ -2666,6 +2822,138 @@ func (s *APISuite) TestPromulgate(c *gc.C) {
 	}
 }
 
+func (s *APISuite) TestTransfer(c *gc.C) {
+	s.addPublicCharm(c, "wordpress", newResolvedURL("~alice/trusty/wordpress-1", -1))
+	s.addPublicCharm(c, "wordpress", newResolvedURL("~alice/precise/wordpress-2", -1))
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~alice/wordpress/transfer"),
+		Method:       "PUT",
+		Header:       http.Header{"Content-Type": {"application/json"}},
+		Body:         strings.NewReader(`{"User": "team"}`),
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusOK,
+	})
+	s.assertGet(c, "~team/trusty/wordpress-1/meta/id-user", params.IdUserResponse{"team"})
+
+	// The old URLs resolve to the new entities.
+	for i, id := range []string{"~alice/trusty/wordpress-1", "~alice/trusty/wordpress", "~alice/wordpress"} {
+		c.Logf("%d: %s", i, id)
+		s.assertGet(c, id+"/meta/id-user", params.IdUserResponse{"team"})
+	}
+	s.assertGet(c, "~alice/wordpress/meta/id-revision", params.IdRevisionResponse{Revision: 2})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~alice/trusty/wordpress-2/meta/id"),
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Code:    params.ErrNotFound,
+			Message: "no matching charm or bundle for cs:~team/trusty/wordpress-2",
+		},
+	})
+}
+
+var transferErrorsTests = []struct {
+	about        string
+	method       string
+	url          string
+	body         string
+	username     string
+	password     string
+	expectStatus int
+	expectBody   params.Error
+}{{
+	about:        "not found",
+	method:       "PUT",
+	url:          "~alice/mysql/transfer",
+	body:         `{"User": "team"}`,
+	username:     testUsername,
+	password:     testPassword,
+	expectStatus: http.StatusNotFound,
+	expectBody: params.Error{
+		Message: `base entity "cs:~alice/mysql" not found`,
+		Code:    params.ErrNotFound,
+	},
+}, {
+	about:        "already exists",
+	method:       "PUT",
+	url:          "~alice/wordpress/transfer",
+	body:         `{"User": "bob"}`,
+	username:     testUsername,
+	password:     testPassword,
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Message: `base entity "cs:~bob/wordpress" already exists`,
+		Code:    params.ErrBadRequest,
+	},
+}, {
+	about:        "no user",
+	method:       "PUT",
+	url:          "~alice/wordpress/transfer",
+	body:         `{}`,
+	username:     testUsername,
+	password:     testPassword,
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Message: `invalid new owner ""`,
+		Code:    params.ErrBadRequest,
+	},
+}, {
+	about:        "promulgated URL",
+	method:       "PUT",
+	url:          "wordpress/transfer",
+	body:         `{"User": "team"}`,
+	username:     testUsername,
+	password:     testPassword,
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Message: `cannot transfer promulgated URL "cs:wordpress"`,
+		Code:    params.ErrBadRequest,
+	},
+}, {
+	about:        "method not allowed",
+	method:       "POST",
+	url:          "~alice/wordpress/transfer",
+	body:         `{"User": "team"}`,
+	username:     testUsername,
+	password:     testPassword,
+	expectStatus: http.StatusMethodNotAllowed,
+	expectBody: params.Error{
+		Message: "POST not allowed",
+		Code:    params.ErrMethodNotAllowed,
+	},
+}, {
+	about:        "not admin",
+	method:       "PUT",
+	url:          "~alice/wordpress/transfer",
+	body:         `{"User": "team"}`,
+	expectStatus: http.StatusUnauthorized,
+	expectBody: params.Error{
+		Message: "authentication failed: missing HTTP auth header",
+		Code:    params.ErrUnauthorized,
+	},
+}}
+
+func (s *APISuite) TestTransferErrors(c *gc.C) {
+	s.addPublicCharm(c, "wordpress", newResolvedURL("~alice/trusty/wordpress-1", -1))
+	s.addPublicCharm(c, "wordpress", newResolvedURL("~bob/trusty/wordpress-1", -1))
+	for i, test := range transferErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:      s.srv,
+			URL:          storeURL(test.url),
+			Method:       test.method,
+			Header:       http.Header{"Content-Type": {"application/json"}},
+			Body:         strings.NewReader(test.body),
+			Username:     test.username,
+			Password:     test.password,
+			ExpectStatus: test.expectStatus,
+			ExpectBody:   test.expectBody,
+		})
+	}
+}
+
 func (s *APISuite) TestEndpointRequiringBaseEntityWithPromulgatedId(c *gc.C) {
 	// Add a promulgated charm.
 	url := newResolvedURL("~charmers/precise/wordpress-23", 23)
@@ -26,6 +26,7 @@ import (
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 
+	"gopkg.in/juju/charmstore.v5-unstable/audit"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
@@ -34,7 +35,7 @@ import (
 // GET id/archive
 // https://github.com/juju/charmstore/blob/v4/docs/API.md#get-idarchive
 //
-// POST id/archive?hash=sha384hash
+// POST id/archive?hash=sha384hash[&upload-id=upload-id]
 // https://github.com/juju/charmstore/blob/v4/docs/API.md#post-idarchive
 //
 // DELETE id/archive
@@ -48,19 +49,29 @@ import (
 func (h *ReqHandler) serveArchive(id *charm.Reference, w http.ResponseWriter, req *http.Request) error {
 	switch req.Method {
 	case "DELETE":
-		return h.resolveId(h.authId(h.serveDeleteArchive))(id, w, req)
+		return h.resolveId(h.serveDeleteArchive)(id, w, req)
 	case "GET":
 		return h.resolveId(h.authId(h.serveGetArchive))(id, w, req)
 	case "POST", "PUT":
-		// Make sure we consume the full request body, before responding.
+		// All the checks that do not need the archive itself
+		// (authorization, quotas, revisions and hashes) are made
+		// before the body is read. The Go HTTP server only sends a
+		// 100 Continue status when the body is first read, so
+		// a client that sends an "Expect: 100-continue" header
+		// does not transfer the archive at all if the upload
+		// is refused.
 		//
-		// It seems a shame to require the whole, possibly large, archive
-		// is uploaded if we already know that the request is going to
-		// fail, but it is necessary to prevent some failures.
-		//
-		// TODO: investigate using 100-Continue statuses to prevent
-		// unnecessary uploads.
-		defer io.Copy(ioutil.Discard, req.Body)
+		// Other clients may fail if we respond before they have
+		// sent the whole body, so in that case, or when we have
+		// started reading the body, make sure we consume the full
+		// request body before responding.
+		body := &uploadRequestBody{ReadCloser: req.Body}
+		req.Body = body
+		defer func() {
+			if body.read || !expectsContinue(req) {
+				io.Copy(ioutil.Discard, body)
+			}
+		}()
 		if err := h.authorizeUpload(id, req); err != nil {
 			return errgo.Mask(err, errgo.Any)
 		}
@@ -73,6 +84,24 @@ func (h *ReqHandler) serveArchive(id *charm.Reference, w http.ResponseWriter, re
 	return errgo.Newf("method not allowed")
 }
 
+// uploadRequestBody wraps the body of an upload request,
+// recording whether any of it has been read.
+type uploadRequestBody struct {
+	io.ReadCloser
+	read bool
+}
+
+func (b *uploadRequestBody) Read(buf []byte) (int, error) {
+	b.read = true
+	return b.ReadCloser.Read(buf)
+}
+
+// expectsContinue reports whether the client is waiting
+// for a 100 Continue status before sending the request body.
+func expectsContinue(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Expect"), "100-continue")
+}
+
 func (h *ReqHandler) authorizeUpload(id *charm.Reference, req *http.Request) error {
 	if id.User == "" {
 		return badRequestf(nil, "user not specified in entity upload URL %q", id)
@@ -98,15 +127,18 @@ func (h *ReqHandler) authorizeUpload(id *charm.Reference, req *http.Request) err
 }
 
 func (h *ReqHandler) serveGetArchive(id *router.ResolvedURL, w http.ResponseWriter, req *http.Request) error {
-	r, size, hash, err := h.Store.OpenBlob(id)
+	r, size, entity, err := h.Store.OpenBlobEntity(id, "signatures")
 	if err != nil {
 		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
 	}
 	defer r.Close()
 	header := w.Header()
 	setArchiveCacheControl(w.Header(), h.isPublic(id.URL))
-	header.Set(params.ContentHashHeader, hash)
+	header.Set(params.ContentHashHeader, entity.BlobHash)
 	header.Set(params.EntityIdHeader, id.String())
+	for _, sig := range entity.Signatures {
+		header.Add(SignatureHeader, signatureHeaderValue(sig))
+	}
 
 	if StatsEnabled(req) {
 		h.Store.IncrementDownloadCountsAsync(id)
@@ -118,23 +150,51 @@ func (h *ReqHandler) serveGetArchive(id *router.ResolvedURL, w http.ResponseWrit
 }
 
 func (h *ReqHandler) serveDeleteArchive(id *router.ResolvedURL, w http.ResponseWriter, req *http.Request) error {
-	// Retrieve the entity blob name from the database.
-	blobName, _, err := h.Store.BlobNameAndHash(id)
+	baseEntity, err := h.Store.FindBaseEntity(&id.URL, "acls")
 	if err != nil {
-		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		if errgo.Cause(err) == params.ErrNotFound {
+			return errgo.WithCausef(nil, params.ErrNotFound, "entity %q not found", id)
+		}
+		return errgo.Notef(err, "cannot retrieve entity %q for authorization", id)
 	}
-	// Remove the entity.
-	if err := h.Store.DB.Entities().RemoveId(&id.URL); err != nil {
-		return errgo.Notef(err, "cannot remove %s", id)
+	// Always authenticate so that the audit log records who
+	// deleted the entity.
+	if _, err := h.authorize(req, baseEntity.ACLs.Write, true, id); err != nil {
+		return errgo.Mask(err, errgo.Any)
 	}
-	// Remove the reference to the archive from the blob store.
-	if err := h.Store.BlobStore.Remove(blobName); err != nil {
-		return errgo.Notef(err, "cannot remove blob %s", blobName)
+	// Move the entity to the trash. The archive blob is
+	// removed when the entity is purged from the trash.
+	if err := h.Store.TrashEntity(id); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
 	}
+	h.addAudit(audit.Entry{
+		Op:     audit.OpTrash,
+		Entity: &id.URL,
+	})
 	h.Store.IncCounterAsync(charmstore.EntityStatsKey(&id.URL, params.StatsArchiveDelete))
 	return nil
 }
 
+// PUT id/restore
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#put-idrestore
+func (h *ReqHandler) serveAdminRestore(id *charm.Reference, w http.ResponseWriter, req *http.Request) error {
+	if _, err := h.authorize(req, nil, true, nil); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	if req.Method != "PUT" {
+		return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s not allowed", req.Method)
+	}
+	rid, err := h.Store.RestoreEntity(id)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrBadRequest))
+	}
+	h.addAudit(audit.Entry{
+		Op:     audit.OpRestore,
+		Entity: &rid.URL,
+	})
+	return nil
+}
+
 func (h *ReqHandler) updateStatsArchiveUpload(id *charm.Reference, err *error) {
 	// Upload stats don't include revision: it is assumed that each
 	// entity revision is only uploaded once.
@@ -149,9 +209,6 @@ func (h *ReqHandler) updateStatsArchiveUpload(id *charm.Reference, err *error) {
 func (h *ReqHandler) servePostArchive(id *charm.Reference, w http.ResponseWriter, req *http.Request) (err error) {
 	defer h.updateStatsArchiveUpload(id, &err)
 
-	if id.Series == "" {
-		return badRequestf(nil, "series not specified")
-	}
 	if id.Revision != -1 {
 		return badRequestf(nil, "revision specified, but should not be specified")
 	}
@@ -162,9 +219,11 @@ func (h *ReqHandler) servePostArchive(id *charm.Reference, w http.ResponseWriter
 	if hash == "" {
 		return badRequestf(nil, "hash parameter not specified")
 	}
-	if req.ContentLength == -1 {
-		return badRequestf(nil, "Content-Length not specified")
+	body, size, err := h.archiveUploadBody(req)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest), errgo.Is(params.ErrNotFound), errgo.Is(params.ErrUnauthorized))
 	}
+	defer body.Close()
 
 	oldId, oldHash, err := h.latestRevisionInfo(id)
 	if err != nil && errgo.Cause(err) != params.ErrNotFound {
@@ -173,6 +232,7 @@ func (h *ReqHandler) servePostArchive(id *charm.Reference, w http.ResponseWriter
 	if oldHash == hash {
 		// The hash matches the hash of the latest revision, so
 		// no need to upload anything.
+		h.removeUploadSession(req)
 		return httprequest.WriteJSON(w, http.StatusOK, &params.ArchiveUploadResponse{
 			Id: oldId,
 		})
@@ -191,9 +251,10 @@ func (h *ReqHandler) servePostArchive(id *charm.Reference, w http.ResponseWriter
 		return errgo.Mask(err)
 	}
 
-	if err := h.addBlobAndEntity(rid, req.Body, hash, req.ContentLength); err != nil {
-		return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload))
+	if err := h.addBlobAndEntity(rid, body, hash, size); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload), errgo.Is(params.ErrBadRequest), errgo.Is(router.ErrQuotaExceeded))
 	}
+	h.removeUploadSession(req)
 	return httprequest.WriteJSON(w, http.StatusOK, &params.ArchiveUploadResponse{
 		Id:            &rid.URL,
 		PromulgatedId: rid.PromulgatedURL(),
@@ -202,9 +263,6 @@ func (h *ReqHandler) servePostArchive(id *charm.Reference, w http.ResponseWriter
 
 func (h *ReqHandler) servePutArchive(id *charm.Reference, w http.ResponseWriter, req *http.Request) (err error) {
 	defer h.updateStatsArchiveUpload(id, &err)
-	if id.Series == "" {
-		return badRequestf(nil, "series not specified")
-	}
 	if id.Revision == -1 {
 		return badRequestf(nil, "revision not specified")
 	}
@@ -215,9 +273,6 @@ func (h *ReqHandler) servePutArchive(id *charm.Reference, w http.ResponseWriter,
 	if hash == "" {
 		return badRequestf(nil, "hash parameter not specified")
 	}
-	if req.ContentLength == -1 {
-		return badRequestf(nil, "Content-Length not specified")
-	}
 	rid := &router.ResolvedURL{
 		URL:                 *id,
 		PromulgatedRevision: -1,
@@ -247,9 +302,19 @@ func (h *ReqHandler) servePutArchive(id *charm.Reference, w http.ResponseWriter,
 		}
 		rid.PromulgatedRevision = pid.Revision
 	}
-	if err := h.addBlobAndEntity(rid, req.Body, hash, req.ContentLength); err != nil {
+	// Refuse duplicate uploads before reading the archive.
+	if err := h.checkNotUploaded(rid); err != nil {
 		return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload))
 	}
+	body, size, err := h.archiveUploadBody(req)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest), errgo.Is(params.ErrNotFound), errgo.Is(params.ErrUnauthorized))
+	}
+	defer body.Close()
+	if err := h.addBlobAndEntity(rid, body, hash, size); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload), errgo.Is(params.ErrBadRequest), errgo.Is(router.ErrQuotaExceeded))
+	}
+	h.removeUploadSession(req)
 	return httprequest.WriteJSON(w, http.StatusOK, &params.ArchiveUploadResponse{
 		Id:            id,
 		PromulgatedId: rid.PromulgatedURL(),
@@ -257,11 +322,34 @@ func (h *ReqHandler) servePutArchive(id *charm.Reference, w http.ResponseWriter,
 	return nil
 }
 
+// checkNotUploaded returns a params.ErrDuplicateUpload error if an
+// entity, including one in the trash, already exists with the given id
+// or promulgated id.
+func (h *ReqHandler) checkNotUploaded(id *router.ResolvedURL) error {
+	query := bson.D{{"_id", &id.URL}}
+	if purl := id.PromulgatedURL(); purl != nil {
+		query = bson.D{{"$or", []bson.D{query, {{"promulgated-url", purl}}}}}
+	}
+	n, err := h.Store.DB.Entities().Find(query).Count()
+	if err != nil {
+		return errgo.Notef(err, "cannot check for existing entity")
+	}
+	if n > 0 {
+		return params.ErrDuplicateUpload
+	}
+	return nil
+}
+
 // addBlobAndEntity streams the contents of the given body
 // to the blob store and adds an entity record for it.
 // The hash and contentLength parameters hold
 // the content hash and the content length respectively.
+// The upload quota of the owner of the id is checked
+// before the body is read.
 func (h *ReqHandler) addBlobAndEntity(id *router.ResolvedURL, body io.Reader, hash string, contentLength int64) (err error) {
+	if err := h.Store.CheckUploadQuota(&id.URL, contentLength); err != nil {
+		return errgo.Mask(err, errgo.Is(router.ErrQuotaExceeded))
+	}
 	name := bson.NewObjectId().Hex()
 
 	// Calculate the SHA256 hash while uploading the blob in the blob store.
@@ -281,15 +369,16 @@ func (h *ReqHandler) addBlobAndEntity(id *router.ResolvedURL, body io.Reader, ha
 	defer r.Close()
 	defer func() {
 		if err != nil {
-			h.Store.BlobStore.Remove(name)
-			// TODO(rog) log if remove fails.
+			if err := h.Store.BlobStore.Remove(name); err != nil {
+				logger.Errorf("cannot remove blob %s after error: %v", name, err)
+			}
 		}
 	}()
 
 	// Add the entity entry to the charm store.
 	sum256 := fmt.Sprintf("%x", hash256.Sum(nil))
 	if err := h.addEntity(id, r, name, hash, sum256, contentLength); err != nil {
-		return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload))
+		return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload), errgo.Is(params.ErrBadRequest), errgo.Is(router.ErrQuotaExceeded))
 	}
 	return nil
 }
@@ -319,6 +408,13 @@ func (h *ReqHandler) addEntity(id *router.ResolvedURL, r io.ReadSeeker, blobName
 			// TODO frankban: use multiError (defined in internal/router).
 			return errgo.Notef(verificationError(err), "bundle verification failed")
 		}
+		p.LintWarnings, err = h.lint(&charmstore.LintArchive{
+			URL:    id,
+			Bundle: b,
+		}, readerAt, contentLength)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+		}
 		if err := h.Store.AddBundle(b, p); err != nil {
 			return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload))
 		}
@@ -331,12 +427,55 @@ func (h *ReqHandler) addEntity(id *router.ResolvedURL, r io.ReadSeeker, blobName
 	if err := checkCharmIsValid(ch); err != nil {
 		return errgo.Mask(err)
 	}
+	if err := checkCharmSeries(ch, id.URL.Series); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	p.LintWarnings, err = h.lint(&charmstore.LintArchive{
+		URL:   id,
+		Charm: ch,
+	}, readerAt, contentLength)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	p.CharmHooks, err = charmstore.ArchiveHooks(readerAt, contentLength)
+	if err != nil {
+		return errgo.Mask(err)
+	}
 	if err := h.Store.AddCharm(ch, p); err != nil {
 		return errgo.Mask(err, errgo.Is(params.ErrDuplicateUpload))
 	}
 	return nil
 }
 
+// lint runs the charm store linters on the given archive, whose
+// contents are read from r. It returns an error with a
+// params.ErrBadRequest cause if any errors are found, otherwise
+// it returns the warnings found.
+func (h *ReqHandler) lint(a *charmstore.LintArchive, r io.ReaderAt, size int64) ([]mongodoc.LintProblem, error) {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot read archive")
+	}
+	a.Files = zipReader.File
+	problems, err := h.Store.Lint(a)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var warnings []mongodoc.LintProblem
+	var errors []string
+	for _, p := range problems {
+		if p.Severity == charmstore.LintError {
+			errors = append(errors, p.Linter+": "+p.Message)
+			continue
+		}
+		warnings = append(warnings, p)
+	}
+	if len(errors) > 0 {
+		return nil, badRequestf(nil, "archive failed lint checks: %s", strings.Join(errors, "; "))
+	}
+	return warnings, nil
+}
+
 func checkCharmIsValid(ch charm.Charm) error {
 	m := ch.Meta()
 	for _, rels := range []map[string]charm.Relation{m.Provides, m.Requires, m.Peers} {
@@ -347,6 +486,34 @@ func checkCharmIsValid(ch charm.Charm) error {
 	return nil
 }
 
+// checkCharmSeries checks that a charm uploaded with the given series
+// is compatible with the series declared in its metadata. A charm
+// uploaded without a series is a multi-series charm, so it must
+// declare the series it supports.
+func checkCharmSeries(ch charm.Charm, series string) error {
+	supported := ch.Meta().Series
+	if series == "" {
+		if len(supported) == 0 {
+			return badRequestf(nil, "series not specified in url or charm metadata")
+		}
+		for _, s := range supported {
+			if s == "bundle" {
+				return badRequestf(nil, "charm metadata declares invalid series %q", s)
+			}
+		}
+		return nil
+	}
+	if len(supported) == 0 {
+		return nil
+	}
+	for _, s := range supported {
+		if s == series {
+			return nil
+		}
+	}
+	return badRequestf(nil, "series %q not declared in charm metadata", series)
+}
+
 func checkRelationsAreValid(rels map[string]charm.Relation) error {
 	for _, rel := range rels {
 		if rel.Name == "relation-name" {
@@ -359,8 +526,15 @@ func checkRelationsAreValid(rels map[string]charm.Relation) error {
 	return nil
 }
 
+// latestRevisionInfo returns the id and hash of the latest revision of
+// the given entity. Entities in the trash are taken into account so
+// that their revision numbers are never reused, but the hash of a
+// trashed entity is not returned.
 func (h *ReqHandler) latestRevisionInfo(id *charm.Reference) (*charm.Reference, string, error) {
-	entities, err := h.Store.FindEntities(id, "_id", "blobhash")
+	var entities []*mongodoc.Entity
+	err := h.Store.EntitiesQueryWithTrash(id).
+		Select(bson.D{{"_id", 1}, {"blobhash", 1}, {"trashtime", 1}}).
+		All(&entities)
 	if err != nil {
 		return nil, "", errgo.Mask(err)
 	}
@@ -373,6 +547,9 @@ func (h *ReqHandler) latestRevisionInfo(id *charm.Reference) (*charm.Reference,
 			latest = entity
 		}
 	}
+	if latest.TrashTime != nil {
+		return latest.URL, "", nil
+	}
 	return latest.URL, latest.BlobHash, nil
 }
 
@@ -450,7 +627,7 @@ func (h *ReqHandler) bundleCharms(ids []string) (map[string]charm.Charm, error)
 			// be returned to the user along with other bundle errors.
 			continue
 		}
-		e, err := h.Store.FindBestEntity(url)
+		e, err := h.Store.FindBestEntity(url, "")
 		if err != nil {
 			if errgo.Cause(err) == params.ErrNotFound {
 				// Ignore this error too, for the same reasons
@@ -558,7 +735,9 @@ func (h *ReqHandler) getNewPromulgatedRevision(id *charm.Reference) (int, error)
 	if baseEntity == nil || !baseEntity.Promulgated {
 		return -1, nil
 	}
-	query := h.Store.EntitiesQuery(&charm.Reference{
+	// Include trashed entities so that promulgated
+	// revisions are never reused.
+	query := h.Store.EntitiesQueryWithTrash(&charm.Reference{
 		Series:   id.Series,
 		Name:     id.Name,
 		Revision: -1,
@@ -29,6 +29,7 @@ import (
 	charmtesting "gopkg.in/juju/charmrepo.v1/testing"
 	"gopkg.in/mgo.v2/bson"
 
+	"gopkg.in/juju/charmstore.v5-unstable/audit"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/blobstore"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
@@ -175,12 +176,6 @@ var archivePostErrorsTests = []struct {
 	expectMessage   string
 	expectCode      params.ErrorCode
 }{{
-	about:         "no series",
-	path:          "~charmers/wordpress/archive",
-	expectStatus:  http.StatusBadRequest,
-	expectMessage: "series not specified",
-	expectCode:    params.ErrBadRequest,
-}, {
 	about:         "revision specified",
 	path:          "~charmers/precise/wordpress-23/archive",
 	expectStatus:  http.StatusBadRequest,
@@ -353,6 +348,210 @@ func (s *ArchiveSuite) TestPostCharm(c *gc.C) {
 	s.assertUploadCharm(c, "POST", newResolvedURL("~charmers/precise/wordpress-1", -1), "mysql")
 }
 
+func (s *ArchiveSuite) TestPostCharmLintWarnings(c *gc.C) {
+	s.assertUploadCharm(c, "POST", newResolvedURL("~charmers/precise/mysql-0", -1), "mysql")
+	s.assertUploadCharm(c, "POST", newResolvedURL("~charmers/precise/wordpress-0", -1), "wordpress")
+
+	// The mysql charm was uploaded to an empty store, so
+	// its interface is not used by any other charm.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/precise/mysql-0/meta/lint"),
+		Username: testUsername,
+		Password: testPassword,
+		ExpectBody: []v4.LintProblem{{
+			Linter:   "icon",
+			Severity: "warning",
+			Message:  "icon.svg not found",
+		}, {
+			Linter:   "interfaces",
+			Severity: "warning",
+			Message:  `interface "mysql" is not used by any other charm`,
+		}, {
+			Linter:   "readme",
+			Severity: "warning",
+			Message:  "README not found",
+		}},
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/precise/wordpress-0/meta/lint"),
+		Username: testUsername,
+		Password: testPassword,
+		ExpectBody: []v4.LintProblem{{
+			Linter:   "icon",
+			Severity: "warning",
+			Message:  "icon.svg not found",
+		}, {
+			Linter:   "interfaces",
+			Severity: "warning",
+			Message:  `interface "http" is not used by any other charm`,
+		}, {
+			Linter:   "interfaces",
+			Severity: "warning",
+			Message:  `interface "logging" is not used by any other charm`,
+		}, {
+			Linter:   "interfaces",
+			Severity: "warning",
+			Message:  `interface "monitoring" is not used by any other charm`,
+		}, {
+			Linter:   "interfaces",
+			Severity: "warning",
+			Message:  `interface "varnish" is not used by any other charm`,
+		}, {
+			Linter:   "readme",
+			Severity: "warning",
+			Message:  "README not found",
+		}},
+	})
+}
+
+func (s *ArchiveSuite) TestPostQuotaExceeded(c *gc.C) {
+	err := s.store.SetUserQuota(&mongodoc.Quota{
+		User:         "charmers",
+		MaxRevisions: 1,
+	})
+	c.Assert(err, gc.IsNil)
+	s.assertUploadCharm(c, "POST", newResolvedURL("~charmers/precise/wordpress-0", -1), "wordpress")
+	s.assertUploadCharmError(
+		c,
+		"POST",
+		charm.MustParseReference("~charmers/precise/wordpress-1"),
+		nil,
+		"mysql",
+		http.StatusForbidden,
+		params.Error{
+			Message: "cs:~charmers/precise/wordpress has reached its maximum of 1 revisions",
+			Code:    router.ErrQuotaExceeded,
+		},
+	)
+
+	// Other base entities have their own revision quota.
+	s.assertUploadCharm(c, "POST", newResolvedURL("~charmers/precise/mysql-0", -1), "mysql")
+}
+
+// recordingReader is a request body that
+// records whether it has been read.
+type recordingReader struct {
+	r    io.Reader
+	read bool
+}
+
+func (r *recordingReader) Read(buf []byte) (int, error) {
+	r.read = true
+	return r.r.Read(buf)
+}
+
+var uploadExpectContinueTests = []struct {
+	about        string
+	method       string
+	url          string
+	password     string
+	hash         string
+	expectStatus int
+	expectBody   interface{}
+}{{
+	about:        "unauthorized",
+	method:       "POST",
+	url:          "~charmers/precise/wordpress/archive",
+	password:     "bad-password",
+	expectStatus: http.StatusUnauthorized,
+	expectBody: params.Error{
+		Message: "invalid user name or password",
+		Code:    params.ErrUnauthorized,
+	},
+}, {
+	about:        "same hash as latest revision",
+	method:       "POST",
+	url:          "~charmers/precise/wordpress/archive",
+	expectStatus: http.StatusOK,
+	expectBody: params.ArchiveUploadResponse{
+		Id: charm.MustParseReference("cs:~charmers/precise/wordpress-0"),
+	},
+}, {
+	about:        "revision specified",
+	method:       "POST",
+	url:          "~charmers/precise/wordpress-1/archive",
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Message: "revision specified, but should not be specified",
+		Code:    params.ErrBadRequest,
+	},
+}, {
+	about:        "duplicate upload",
+	method:       "PUT",
+	url:          "~charmers/precise/wordpress-0/archive",
+	expectStatus: http.StatusInternalServerError,
+	expectBody: params.Error{
+		Message: "duplicate upload",
+		Code:    params.ErrDuplicateUpload,
+	},
+}, {
+	about:        "quota exceeded",
+	method:       "POST",
+	url:          "~charmers/precise/wordpress/archive",
+	hash:         hashOfBytes([]byte("other content")),
+	expectStatus: http.StatusForbidden,
+	expectBody: params.Error{
+		Message: "cs:~charmers/precise/wordpress has reached its maximum of 1 revisions",
+		Code:    router.ErrQuotaExceeded,
+	},
+}}
+
+func (s *ArchiveSuite) TestUploadExpectContinue(c *gc.C) {
+	s.assertUploadCharm(c, "POST", newResolvedURL("~charmers/precise/wordpress-0", -1), "wordpress")
+	err := s.store.SetUserQuota(&mongodoc.Quota{
+		User:         "charmers",
+		MaxRevisions: 1,
+	})
+	c.Assert(err, gc.IsNil)
+	ch := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	data, err := ioutil.ReadFile(ch.Path)
+	c.Assert(err, gc.IsNil)
+	for i, test := range uploadExpectContinueTests {
+		c.Logf("test %d: %s", i, test.about)
+		hash := test.hash
+		if hash == "" {
+			hash = hashOfBytes(data)
+		}
+		password := test.password
+		if password == "" {
+			password = testPassword
+		}
+		// A client expecting a 100 Continue status
+		// is refused without the body being read.
+		body := &recordingReader{r: bytes.NewReader(data)}
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:       s.srv,
+			URL:           storeURL(test.url + "?hash=" + hash),
+			Method:        test.method,
+			Header:        http.Header{"Expect": {"100-continue"}},
+			ContentLength: int64(len(data)),
+			Body:          body,
+			Username:      testUsername,
+			Password:      password,
+			ExpectStatus:  test.expectStatus,
+			ExpectBody:    test.expectBody,
+		})
+		c.Assert(body.read, gc.Equals, false)
+
+		// Other clients have the whole body consumed.
+		body = &recordingReader{r: bytes.NewReader(data)}
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:       s.srv,
+			URL:           storeURL(test.url + "?hash=" + hash),
+			Method:        test.method,
+			ContentLength: int64(len(data)),
+			Body:          body,
+			Username:      testUsername,
+			Password:      password,
+			ExpectStatus:  test.expectStatus,
+			ExpectBody:    test.expectBody,
+		})
+		c.Assert(body.read, gc.Equals, true)
+	}
+}
+
 func (s *ArchiveSuite) TestPostCurrentVersion(c *gc.C) {
 	s.assertUploadCharm(c, "POST", newResolvedURL("~charmers/precise/wordpress-0", -1), "wordpress")
 
@@ -903,6 +1102,89 @@ func (s *ArchiveSuite) assertUploadError(c *gc.C, method string, url, purl *char
 	})
 }
 
+func (s *ArchiveSuite) TestPostMultiSeriesCharm(c *gc.C) {
+	// A charm that declares its supported series
+	// can be uploaded without a series.
+	url := newResolvedURL("~charmers/multi-series-0", -1)
+	s.assertUploadCharm(c, "POST", url, "multi-series")
+	err := s.store.SetPerms(&url.URL, "read", params.Everyone)
+	c.Assert(err, gc.IsNil)
+
+	// It can be resolved using any of its supported series.
+	for _, path := range []string{
+		"~charmers/multi-series",
+		"~charmers/precise/multi-series",
+		"~charmers/trusty/multi-series",
+		"~charmers/utopic/multi-series-0",
+	} {
+		c.Logf("path %s", path)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler: s.srv,
+			URL:     storeURL(path + "/meta/id"),
+			ExpectBody: params.IdResponse{
+				Id:       &url.URL,
+				User:     "charmers",
+				Name:     "multi-series",
+				Revision: 0,
+			},
+		})
+	}
+	// Its archive can be downloaded using a fully specified
+	// id with any of its supported series.
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("~charmers/trusty/multi-series-0/archive"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+	c.Assert(rec.Header().Get(params.EntityIdHeader), gc.Equals, url.URL.String())
+
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		URL:     storeURL("~charmers/trusty/multi-series/meta/supported-series"),
+		ExpectBody: &v4.SupportedSeriesResponse{
+			SupportedSeries: []string{"precise", "trusty", "utopic"},
+		},
+	})
+
+	// It cannot be resolved for other series.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/quantal/multi-series/meta/id"),
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Message: `no matching charm or bundle for "cs:~charmers/quantal/multi-series"`,
+			Code:    params.ErrNotFound,
+		},
+	})
+}
+
+func (s *ArchiveSuite) TestPostMultiSeriesCharmErrors(c *gc.C) {
+	s.assertUploadCharmError(
+		c,
+		"POST",
+		charm.MustParseReference("~charmers/wordpress"),
+		nil,
+		"wordpress",
+		http.StatusBadRequest,
+		params.Error{
+			Message: "series not specified in url or charm metadata",
+			Code:    params.ErrBadRequest,
+		},
+	)
+	s.assertUploadCharmError(
+		c,
+		"POST",
+		charm.MustParseReference("~charmers/quantal/multi-series"),
+		nil,
+		"multi-series",
+		http.StatusBadRequest,
+		params.Error{
+			Message: `series "quantal" not declared in charm metadata`,
+			Code:    params.ErrBadRequest,
+		},
+	)
+}
+
 var archiveFileErrorsTests = []struct {
 	about         string
 	path          string
@@ -1128,14 +1410,195 @@ func (s *ArchiveSuite) TestDelete(c *gc.C) {
 		ExpectStatus: http.StatusOK,
 	})
 
-	// The entity has been deleted.
-	count, err := s.store.DB.Entities().FindId(url).Count()
+	// The entity has been moved to the trash.
+	err = s.store.DB.Entities().FindId(&url.URL).Select(bson.D{{"trashtime", 1}}).One(&entity)
+	c.Assert(err, gc.IsNil)
+	c.Assert(entity.TrashTime, gc.NotNil)
+
+	// The blob is kept until the entity is purged.
+	r, _, err := s.store.BlobStore.Open(entity.BlobName)
+	c.Assert(err, gc.IsNil)
+	r.Close()
+
+	// The entity can no longer be retrieved.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL(id + "/meta/id"),
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Message: "no matching charm or bundle for cs:~charmers/utopic/mysql-42",
+			Code:    params.ErrNotFound,
+		},
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/utopic/mysql/meta/id"),
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Message: `no matching charm or bundle for "cs:~charmers/utopic/mysql"`,
+			Code:    params.ErrNotFound,
+		},
+	})
+}
+
+func (s *ArchiveSuite) TestDeleteAudit(c *gc.C) {
+	var calledEntities []audit.Entry
+	s.PatchValue(v4.TestAddAuditCallback, func(e audit.Entry) {
+		calledEntities = append(calledEntities, e)
+	})
+	id := "~charmers/utopic/mysql-42"
+	err := s.store.AddCharmWithArchive(newResolvedURL(id, -1), storetesting.Charms.CharmArchive(c.MkDir(), "mysql"))
+	c.Assert(err, gc.IsNil)
+
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		Method:   "DELETE",
+		URL:      storeURL(id + "/archive"),
+		Username: testUsername,
+		Password: testPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+	rec = httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		Method:   "PUT",
+		URL:      storeURL(id + "/restore"),
+		Username: testUsername,
+		Password: testPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+	c.Assert(calledEntities, jc.DeepEquals, []audit.Entry{{
+		User:   "admin",
+		Op:     audit.OpTrash,
+		Entity: charm.MustParseReference(id),
+	}, {
+		User:   "admin",
+		Op:     audit.OpRestore,
+		Entity: charm.MustParseReference(id),
+	}})
+}
+
+func (s *ArchiveSuite) TestRestore(c *gc.C) {
+	for i, id := range []string{"~charmers/utopic/mysql-42", "~charmers/utopic/mysql-43"} {
+		err := s.store.AddCharmWithArchive(newResolvedURL(id, i), storetesting.Charms.CharmArchive(c.MkDir(), "mysql"))
+		c.Assert(err, gc.IsNil)
+	}
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		Method:   "DELETE",
+		URL:      storeURL("~charmers/utopic/mysql-43/archive"),
+		Username: testUsername,
+		Password: testPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+
+	// The previous revision is now the latest.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:    s.srv,
+		URL:        storeURL("~charmers/utopic/mysql/meta/id-revision"),
+		ExpectBody: params.IdRevisionResponse{Revision: 42},
+	})
+
+	// Restore the entity using its promulgated URL.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("utopic/mysql-1/restore"),
+		Method:       "PUT",
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusOK,
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:    s.srv,
+		URL:        storeURL("~charmers/utopic/mysql/meta/id-revision"),
+		ExpectBody: params.IdRevisionResponse{Revision: 43},
+	})
+}
+
+var restoreErrorsTests = []struct {
+	about        string
+	method       string
+	url          string
+	username     string
+	password     string
+	expectStatus int
+	expectBody   params.Error
+}{{
+	about:        "not trashed",
+	method:       "PUT",
+	url:          "~charmers/utopic/mysql-42/restore",
+	username:     testUsername,
+	password:     testPassword,
+	expectStatus: http.StatusNotFound,
+	expectBody: params.Error{
+		Message: `entity "cs:~charmers/utopic/mysql-42" not found in trash`,
+		Code:    params.ErrNotFound,
+	},
+}, {
+	about:        "not fully qualified",
+	method:       "PUT",
+	url:          "~charmers/utopic/mysql/restore",
+	username:     testUsername,
+	password:     testPassword,
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Message: `entity id "cs:~charmers/utopic/mysql" is not fully qualified`,
+		Code:    params.ErrBadRequest,
+	},
+}, {
+	about:        "method not allowed",
+	method:       "POST",
+	url:          "~charmers/utopic/mysql-42/restore",
+	username:     testUsername,
+	password:     testPassword,
+	expectStatus: http.StatusMethodNotAllowed,
+	expectBody: params.Error{
+		Message: "POST not allowed",
+		Code:    params.ErrMethodNotAllowed,
+	},
+}, {
+	about:        "not admin",
+	method:       "PUT",
+	url:          "~charmers/utopic/mysql-42/restore",
+	expectStatus: http.StatusUnauthorized,
+	expectBody: params.Error{
+		Message: "authentication failed: missing HTTP auth header",
+		Code:    params.ErrUnauthorized,
+	},
+}}
+
+func (s *ArchiveSuite) TestRestoreErrors(c *gc.C) {
+	err := s.store.AddCharmWithArchive(newResolvedURL("~charmers/utopic/mysql-42", -1), storetesting.Charms.CharmArchive(c.MkDir(), "mysql"))
 	c.Assert(err, gc.IsNil)
-	c.Assert(count, gc.Equals, 0)
+	for i, test := range restoreErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:      s.srv,
+			URL:          storeURL(test.url),
+			Method:       test.method,
+			Username:     test.username,
+			Password:     test.password,
+			ExpectStatus: test.expectStatus,
+			ExpectBody:   test.expectBody,
+		})
+	}
+}
 
-	// The blob has been deleted.
-	_, _, err = s.store.BlobStore.Open(entity.BlobName)
-	c.Assert(err, gc.ErrorMatches, "resource.*not found")
+func (s *ArchiveSuite) TestUploadAfterDelete(c *gc.C) {
+	id := "~charmers/utopic/mysql-0"
+	err := s.store.AddCharmWithArchive(newResolvedURL(id, -1), storetesting.Charms.CharmArchive(c.MkDir(), "mysql"))
+	c.Assert(err, gc.IsNil)
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		Method:   "DELETE",
+		URL:      storeURL(id + "/archive"),
+		Username: testUsername,
+		Password: testPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+
+	// Uploading the same archive again creates a new revision
+	// rather than reusing the trashed one.
+	s.assertUploadCharm(c, "POST", newResolvedURL("~charmers/utopic/mysql-1", -1), "mysql")
 }
 
 func (s *ArchiveSuite) TestDeleteSpecificCharm(c *gc.C) {
@@ -1185,29 +1648,24 @@ func (s *ArchiveSuite) TestDeleteNotFound(c *gc.C) {
 	})
 }
 
-func (s *ArchiveSuite) TestDeleteError(c *gc.C) {
-	// Add a charm to the database (not including the archive).
+func (s *ArchiveSuite) TestDeleteTrashed(c *gc.C) {
 	id := "~charmers/utopic/mysql-42"
-	url := newResolvedURL(id, -1)
-	err := s.store.AddCharm(storetesting.Charms.CharmArchive(c.MkDir(), "mysql"),
-		charmstore.AddParams{
-			URL:      url,
-			BlobName: "no-such-name",
-			BlobHash: fakeBlobHash,
-			BlobSize: fakeBlobSize,
-		})
+	err := s.store.AddCharmWithArchive(newResolvedURL(id, -1), storetesting.Charms.CharmArchive(c.MkDir(), "mysql"))
+	c.Assert(err, gc.IsNil)
+	err = s.store.TrashEntity(newResolvedURL(id, -1))
 	c.Assert(err, gc.IsNil)
 
-	// Try to delete the charm using the API.
+	// Try to delete the charm again using the API.
 	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
 		Handler:      s.srv,
 		URL:          storeURL(id + "/archive"),
 		Method:       "DELETE",
 		Username:     testUsername,
 		Password:     testPassword,
-		ExpectStatus: http.StatusInternalServerError,
+		ExpectStatus: http.StatusNotFound,
 		ExpectBody: params.Error{
-			Message: `cannot remove blob no-such-name: resource at path "global/no-such-name" not found`,
+			Message: "entity not found",
+			Code:    params.ErrNotFound,
 		},
 	})
 }
@@ -0,0 +1,360 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4 // import "gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/juju/httprequest"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+// MaxDiffFileSize holds the maximum size of a file for which
+// a text diff is returned by GET id/diff.
+const MaxDiffFileSize = 64 * 1024
+
+// maxDiffCost holds the maximum number of line comparisons
+// made when computing the diff of a single file.
+const maxDiffCost = 4 * 1024 * 1024
+
+// diffContext holds the number of unchanged lines shown
+// around each change in a unified diff.
+const diffContext = 3
+
+// ArchiveDiff holds the differences between the archives of
+// two entities, as returned by GET id/diff.
+type ArchiveDiff struct {
+	From    *charm.Reference
+	To      *charm.Reference
+	Added   []params.ManifestFile `json:",omitempty"`
+	Removed []params.ManifestFile `json:",omitempty"`
+	Changed []ChangedFile         `json:",omitempty"`
+}
+
+// ChangedFile holds information on a file whose
+// contents differ between two archives. Diff holds
+// a unified diff of the file contents; it is empty if
+// either version of the file is not text or is larger
+// than MaxDiffFileSize.
+type ChangedFile struct {
+	Name     string
+	FromSize int64
+	ToSize   int64
+	Diff     string `json:",omitempty"`
+}
+
+// GET id/diff?to=otherid
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#get-iddifftootherid
+func (h *ReqHandler) serveDiff(id *router.ResolvedURL, w http.ResponseWriter, req *http.Request) error {
+	if req.Method != "GET" {
+		return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s not allowed", req.Method)
+	}
+	to := req.Form.Get("to")
+	if to == "" {
+		return badRequestf(nil, "to parameter not specified")
+	}
+	toURL, err := charm.ParseReference(to)
+	if err != nil {
+		return badRequestf(err, "invalid to parameter")
+	}
+	toId, err := h.resolveExistingURL(toURL)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if err := h.AuthorizeEntity(toId, req); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	fromFiles, fromCloser, err := h.archiveFiles(id)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	defer fromCloser.Close()
+	toFiles, toCloser, err := h.archiveFiles(toId)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	defer toCloser.Close()
+	diff, err := diffArchives(fromFiles, toFiles)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	diff.From = id.PreferredURL()
+	diff.To = toId.PreferredURL()
+	return httprequest.WriteJSON(w, http.StatusOK, diff)
+}
+
+// archiveFiles returns the files in the archive of the entity with
+// the given id, indexed by their cleaned path. Directories are omitted.
+// The returned closer must be closed after the files have been read.
+func (h *ReqHandler) archiveFiles(id *router.ResolvedURL) (map[string]*zip.File, io.Closer, error) {
+	r, size, _, err := h.Store.OpenBlob(id)
+	if err != nil {
+		return nil, nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	zipReader, err := zip.NewReader(charmstore.ReaderAtSeeker(r), size)
+	if err != nil {
+		r.Close()
+		return nil, nil, errgo.Notef(err, "cannot read archive data for %s", id)
+	}
+	files := make(map[string]*zip.File)
+	for _, file := range zipReader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		files[path.Clean(file.Name)] = file
+	}
+	return files, r, nil
+}
+
+// diffArchives compares the given archive files and returns
+// the files that have been added, removed or changed, sorted
+// by name.
+func diffArchives(from, to map[string]*zip.File) (*ArchiveDiff, error) {
+	var diff ArchiveDiff
+	for _, name := range sortedFileNames(from) {
+		fromFile := from[name]
+		toFile, ok := to[name]
+		if !ok {
+			diff.Removed = append(diff.Removed, params.ManifestFile{
+				Name: name,
+				Size: fromFile.FileInfo().Size(),
+			})
+			continue
+		}
+		if fromFile.CRC32 == toFile.CRC32 && fromFile.UncompressedSize64 == toFile.UncompressedSize64 {
+			continue
+		}
+		changed := ChangedFile{
+			Name:     name,
+			FromSize: fromFile.FileInfo().Size(),
+			ToSize:   toFile.FileInfo().Size(),
+		}
+		if changed.FromSize <= MaxDiffFileSize && changed.ToSize <= MaxDiffFileSize {
+			var err error
+			changed.Diff, err = diffFiles(name, fromFile, toFile)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+		}
+		diff.Changed = append(diff.Changed, changed)
+	}
+	for _, name := range sortedFileNames(to) {
+		if _, ok := from[name]; ok {
+			continue
+		}
+		diff.Added = append(diff.Added, params.ManifestFile{
+			Name: name,
+			Size: to[name].FileInfo().Size(),
+		})
+	}
+	return &diff, nil
+}
+
+// diffFiles returns a unified diff between the contents of the
+// given zip files. It returns an empty string if either
+// file does not hold text.
+func diffFiles(name string, from, to *zip.File) (string, error) {
+	fromData, err := readZipFile(from)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	toData, err := readZipFile(to)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	if !isText(fromData) || !isText(toData) {
+		return "", nil
+	}
+	return unifiedDiff("a/"+name, "b/"+name, string(fromData), string(toData)), nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, errgo.Notef(err, "unable to read file %q", f.Name)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errgo.Notef(err, "unable to read file %q", f.Name)
+	}
+	return data, nil
+}
+
+// isText reports whether the given data looks like text.
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) == -1
+}
+
+func sortedFileNames(files map[string]*zip.File) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// diffOp holds a single line of an edit script.
+type diffOp struct {
+	// kind holds ' ' for an unchanged line, '-' for
+	// a removed line and '+' for an added line.
+	kind byte
+	line string
+}
+
+// unifiedDiff returns a unified diff between the from and to texts,
+// labelled with the given names. It returns an empty string if the
+// texts have the same lines. If the texts have too many lines to
+// be compared, a short note is returned instead.
+func unifiedDiff(fromName, toName, from, to string) string {
+	a, b := splitLines(from), splitLines(to)
+	if len(a)*len(b) > maxDiffCost {
+		return fmt.Sprintf("%s and %s have too many lines to compare\n", fromName, toName)
+	}
+	ops := diffLines(a, b)
+	var buf bytes.Buffer
+	for i := 0; i < len(ops); {
+		// Find the next change.
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		// Extend the hunk while changes are close enough
+		// for their contexts to overlap.
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+				continue
+			}
+			if j-end > 2*diffContext {
+				break
+			}
+		}
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		if end += diffContext; end > len(ops) {
+			end = len(ops)
+		}
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		writeHunk(&buf, ops, start, end)
+		i = end
+	}
+	return buf.String()
+}
+
+// writeHunk writes the hunk holding ops[start:end] to buf.
+func writeHunk(buf *bytes.Buffer, ops []diffOp, start, end int) {
+	fromLine, toLine := 1, 1
+	for _, op := range ops[:start] {
+		if op.kind != '+' {
+			fromLine++
+		}
+		if op.kind != '-' {
+			toLine++
+		}
+	}
+	fromCount, toCount := 0, 0
+	for _, op := range ops[start:end] {
+		if op.kind != '+' {
+			fromCount++
+		}
+		if op.kind != '-' {
+			toCount++
+		}
+	}
+	// An empty range is identified by the line before it.
+	if fromCount == 0 {
+		fromLine--
+	}
+	if toCount == 0 {
+		toLine--
+	}
+	fmt.Fprintf(buf, "@@ -%d,%d +%d,%d @@\n", fromLine, fromCount, toLine, toCount)
+	for _, op := range ops[start:end] {
+		buf.WriteByte(op.kind)
+		buf.WriteString(op.line)
+		if !strings.HasSuffix(op.line, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// splitLines splits the given text into lines,
+// each including its trailing newline if any.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns an edit script that transforms a into b,
+// using the longest common subsequence of their lines.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	// lcs[i*(m+1)+j] holds the length of the longest
+	// common subsequence of a[i:] and b[j:].
+	lcs := make([]int32, (n+1)*(m+1))
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i*(m+1)+j] = lcs[(i+1)*(m+1)+j+1] + 1
+			case lcs[(i+1)*(m+1)+j] >= lcs[i*(m+1)+j+1]:
+				lcs[i*(m+1)+j] = lcs[(i+1)*(m+1)+j]
+			default:
+				lcs[i*(m+1)+j] = lcs[i*(m+1)+j+1]
+			}
+		}
+	}
+	ops := make([]diffOp, 0, n+m)
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[(i+1)*(m+1)+j] >= lcs[i*(m+1)+j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
@@ -0,0 +1,220 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+)
+
+type DiffSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&DiffSuite{})
+
+func (s *DiffSuite) SetUpSuite(c *gc.C) {
+	s.enableIdentity = true
+	s.commonSuite.SetUpSuite(c)
+}
+
+// addCharm adds a revision of the wordpress charm with the given
+// extra files. If public is true, the charm is readable by everyone.
+func (s *DiffSuite) addCharm(c *gc.C, url string, files map[string]string, public bool) {
+	ch := storetesting.Charms.ClonedDir(c.MkDir(), "wordpress")
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(ch.Path, name), []byte(content), 0666)
+		c.Assert(err, gc.IsNil)
+	}
+	rurl := newResolvedURL(url, -1)
+	err := s.store.AddCharmWithArchive(rurl, ch)
+	c.Assert(err, gc.IsNil)
+	if public {
+		err = s.store.SetPerms(&rurl.URL, "read", params.Everyone, rurl.URL.User)
+		c.Assert(err, gc.IsNil)
+	}
+}
+
+func (s *DiffSuite) TestDiff(c *gc.C) {
+	s.addCharm(c, "cs:~charmers/precise/wordpress-0", map[string]string{
+		"README":   "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n",
+		"data.bin": "\x00\x01\x02",
+		"old.txt":  "old\n",
+		"same.txt": "same\n",
+	}, true)
+	s.addCharm(c, "cs:~charmers/precise/wordpress-1", map[string]string{
+		"README":   "one\n2\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven",
+		"data.bin": "\x00\x01\x03\x04",
+		"new.txt":  "new\n",
+		"same.txt": "same\n",
+	}, true)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		URL:     storeURL("~charmers/precise/wordpress-0/diff?to=~charmers/precise/wordpress-1"),
+		ExpectBody: v4.ArchiveDiff{
+			From: charm.MustParseReference("cs:~charmers/precise/wordpress-0"),
+			To:   charm.MustParseReference("cs:~charmers/precise/wordpress-1"),
+			Added: []params.ManifestFile{{
+				Name: "new.txt",
+				Size: 4,
+			}},
+			Removed: []params.ManifestFile{{
+				Name: "old.txt",
+				Size: 4,
+			}},
+			Changed: []v4.ChangedFile{{
+				Name:     "README",
+				FromSize: 49,
+				ToSize:   53,
+				Diff: `--- a/README
++++ b/README
+@@ -1,5 +1,5 @@
+ one
+-two
++2
+ three
+ four
+ five
+@@ -8,3 +8,4 @@
+ eight
+ nine
+ ten
++eleven
+\ No newline at end of file
+`,
+			}, {
+				Name:     "data.bin",
+				FromSize: 3,
+				ToSize:   4,
+			}},
+		},
+	})
+}
+
+func (s *DiffSuite) TestDiffLargeFile(c *gc.C) {
+	large := string(make([]byte, v4.MaxDiffFileSize+1))
+	s.addCharm(c, "cs:~charmers/precise/wordpress-0", map[string]string{
+		"large.txt": large,
+	}, true)
+	s.addCharm(c, "cs:~charmers/precise/wordpress-1", map[string]string{
+		"large.txt": large[1:] + "x",
+	}, true)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		URL:     storeURL("~charmers/precise/wordpress-0/diff?to=~charmers/precise/wordpress-1"),
+		ExpectBody: v4.ArchiveDiff{
+			From: charm.MustParseReference("cs:~charmers/precise/wordpress-0"),
+			To:   charm.MustParseReference("cs:~charmers/precise/wordpress-1"),
+			Changed: []v4.ChangedFile{{
+				Name:     "large.txt",
+				FromSize: v4.MaxDiffFileSize + 1,
+				ToSize:   v4.MaxDiffFileSize + 1,
+			}},
+		},
+	})
+}
+
+var diffErrorsTests = []struct {
+	about        string
+	url          string
+	method       string
+	expectStatus int
+	expectBody   params.Error
+}{{
+	about:        "no to parameter",
+	url:          "~charmers/precise/wordpress-0/diff",
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Code:    params.ErrBadRequest,
+		Message: "to parameter not specified",
+	},
+}, {
+	about:        "invalid to parameter",
+	url:          "~charmers/precise/wordpress-0/diff?to=bad:id",
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Code:    params.ErrBadRequest,
+		Message: `invalid to parameter: charm URL has invalid schema: "bad:id"`,
+	},
+}, {
+	about:        "to entity not found",
+	url:          "~charmers/precise/wordpress-0/diff?to=~charmers/precise/wordpress-42",
+	expectStatus: http.StatusNotFound,
+	expectBody: params.Error{
+		Code:    params.ErrNotFound,
+		Message: `no matching charm or bundle for "cs:~charmers/precise/wordpress-42"`,
+	},
+}, {
+	about:        "method not allowed",
+	url:          "~charmers/precise/wordpress-0/diff?to=~charmers/precise/wordpress-0",
+	method:       "POST",
+	expectStatus: http.StatusMethodNotAllowed,
+	expectBody: params.Error{
+		Code:    params.ErrMethodNotAllowed,
+		Message: "POST not allowed",
+	},
+}}
+
+func (s *DiffSuite) TestDiffErrors(c *gc.C) {
+	s.addCharm(c, "cs:~charmers/precise/wordpress-0", nil, true)
+	for i, test := range diffErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:      s.srv,
+			URL:          storeURL(test.url),
+			Method:       test.method,
+			Username:     testUsername,
+			Password:     testPassword,
+			ExpectStatus: test.expectStatus,
+			ExpectBody:   test.expectBody,
+		})
+	}
+}
+
+func (s *DiffSuite) TestDiffChecksReadACLOfBothEntities(c *gc.C) {
+	s.addCharm(c, "cs:~charmers/precise/wordpress-0", nil, true)
+	s.addCharm(c, "cs:~bob/precise/wordpress-0", nil, false)
+
+	s.discharge = dischargeForUser("alice")
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		Do:           bakeryDo(nil),
+		URL:          storeURL("~charmers/precise/wordpress-0/diff?to=~bob/precise/wordpress-0"),
+		ExpectStatus: http.StatusUnauthorized,
+		ExpectBody: params.Error{
+			Code:    params.ErrUnauthorized,
+			Message: `unauthorized: access denied for user "alice"`,
+		},
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		Do:           bakeryDo(nil),
+		URL:          storeURL("~bob/precise/wordpress-0/diff?to=~charmers/precise/wordpress-0"),
+		ExpectStatus: http.StatusUnauthorized,
+		ExpectBody: params.Error{
+			Code:    params.ErrUnauthorized,
+			Message: `unauthorized: access denied for user "alice"`,
+		},
+	})
+
+	s.discharge = dischargeForUser("bob")
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		Do:      bakeryDo(nil),
+		URL:     storeURL("~bob/precise/wordpress-0/diff?to=~charmers/precise/wordpress-0"),
+		ExpectBody: v4.ArchiveDiff{
+			From: charm.MustParseReference("cs:~bob/precise/wordpress-0"),
+			To:   charm.MustParseReference("cs:~charmers/precise/wordpress-0"),
+		},
+	})
+}
@@ -0,0 +1,234 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+)
+
+type InterestingSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&InterestingSuite{})
+
+func (s *InterestingSuite) SetUpSuite(c *gc.C) {
+	s.enableIdentity = true
+	s.commonSuite.SetUpSuite(c)
+}
+
+func (s *InterestingSuite) SetUpTest(c *gc.C) {
+	s.commonSuite.SetUpTest(c)
+	for _, ch := range []struct {
+		url    string
+		name   string
+		public bool
+	}{
+		{"~charmers/precise/wordpress-0", "wordpress", true},
+		{"~charmers/trusty/mysql-0", "mysql", true},
+		{"~bob/trusty/varnish-0", "varnish", false},
+	} {
+		promulgatedRevision := -1
+		if ch.public {
+			promulgatedRevision = 0
+		}
+		id := newResolvedURL(ch.url, promulgatedRevision)
+		err := s.store.AddCharmWithArchive(id, storetesting.Charms.CharmDir(ch.name))
+		c.Assert(err, gc.IsNil)
+		if ch.public {
+			err = s.store.SetPerms(&id.URL, "read", params.Everyone, id.URL.User)
+			c.Assert(err, gc.IsNil)
+		}
+	}
+}
+
+func (s *InterestingSuite) getInteresting(c *gc.C, query string, do func(*http.Request) (*http.Response, error)) []params.SearchResult {
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		Do:      do,
+		URL:     storeURL("search/interesting" + query),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+	var resp params.SearchResponse
+	err := json.Unmarshal(rec.Body.Bytes(), &resp)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.Total, gc.Equals, len(resp.Results))
+	return resp.Results
+}
+
+func resultIds(results []params.SearchResult) []string {
+	ids := make([]string, len(results))
+	for i, r := range results {
+		ids[i] = r.Id.String()
+	}
+	return ids
+}
+
+func (s *InterestingSuite) TestInteresting(c *gc.C) {
+	// With no downloads or featured entities, only the
+	// new promulgated charms are returned.
+	results := s.getInteresting(c, "", nil)
+	ids := resultIds(results)
+	sort.Strings(ids)
+	c.Assert(ids, jc.DeepEquals, []string{
+		"cs:precise/wordpress-0",
+		"cs:trusty/mysql-0",
+	})
+
+	// Featured entities come first.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("search/featured"),
+		Method:   "PUT",
+		Header:   http.Header{"Content-Type": {"application/json"}},
+		Body:     strings.NewReader(`{"Ids": ["cs:~bob/trusty/varnish", "cs:wordpress"]}`),
+		Username: testUsername,
+		Password: testPassword,
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		URL:     storeURL("search/featured"),
+		ExpectBody: v4.FeaturedEntities{
+			Ids: []*charm.Reference{
+				charm.MustParseReference("cs:~bob/trusty/varnish"),
+				charm.MustParseReference("cs:wordpress"),
+			},
+		},
+	})
+
+	// Entities that cannot be read are omitted.
+	results = s.getInteresting(c, "?include=id-name", nil)
+	c.Assert(resultIds(results), jc.DeepEquals, []string{
+		"cs:precise/wordpress-0",
+		"cs:trusty/mysql-0",
+	})
+	c.Assert(results[0].Meta, jc.DeepEquals, map[string]interface{}{
+		"id-name": map[string]interface{}{"Name": "wordpress"},
+	})
+
+	s.discharge = dischargeForUser("bob")
+	results = s.getInteresting(c, "?limit=2", bakeryDo(nil))
+	c.Assert(resultIds(results), jc.DeepEquals, []string{
+		"cs:~bob/trusty/varnish-0",
+		"cs:precise/wordpress-0",
+	})
+}
+
+func (s *InterestingSuite) TestInterestingCachesCandidates(c *gc.C) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("search/featured"),
+		Method:   "PUT",
+		Header:   http.Header{"Content-Type": {"application/json"}},
+		Body:     strings.NewReader(`{"Ids": ["cs:~bob/trusty/varnish"]}`),
+		Username: testUsername,
+		Password: testPassword,
+	})
+	s.discharge = dischargeForUser("bob")
+	c.Assert(resultIds(s.getInteresting(c, "?limit=1", bakeryDo(nil))), jc.DeepEquals, []string{
+		"cs:~bob/trusty/varnish-0",
+	})
+	ids := resultIds(s.getInteresting(c, "", nil))
+	sort.Strings(ids)
+	c.Assert(ids, jc.DeepEquals, []string{
+		"cs:precise/wordpress-0",
+		"cs:trusty/mysql-0",
+	})
+
+	// The read ACLs are checked on each request, so making
+	// varnish public adds it to the cached results.
+	err := s.store.SetPerms(charm.MustParseReference("cs:~bob/varnish"), "read", params.Everyone, "bob")
+	c.Assert(err, gc.IsNil)
+	ids = resultIds(s.getInteresting(c, "", nil))
+	c.Assert(ids, gc.HasLen, 3)
+	c.Assert(ids[0], gc.Equals, "cs:~bob/trusty/varnish-0")
+
+	// New entities are not considered until the
+	// cached candidates expire.
+	id := newResolvedURL("~charmers/trusty/riak-0", 0)
+	err = s.store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("riak"))
+	c.Assert(err, gc.IsNil)
+	err = s.store.SetPerms(&id.URL, "read", params.Everyone, id.URL.User)
+	c.Assert(err, gc.IsNil)
+	ids = resultIds(s.getInteresting(c, "", nil))
+	c.Assert(ids, gc.HasLen, 3)
+}
+
+var interestingErrorsTests = []struct {
+	about        string
+	query        string
+	expectStatus int
+	expectBody   params.Error
+}{{
+	about:        "invalid parameter",
+	query:        "?text=wordpress",
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Code:    params.ErrBadRequest,
+		Message: "invalid parameter: text",
+	},
+}, {
+	about:        "invalid limit",
+	query:        "?limit=0",
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Code:    params.ErrBadRequest,
+		Message: "invalid limit parameter: expected integer greater than zero",
+	},
+}}
+
+func (s *InterestingSuite) TestInterestingErrors(c *gc.C) {
+	for i, test := range interestingErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:      s.srv,
+			URL:          storeURL("search/interesting" + test.query),
+			ExpectStatus: test.expectStatus,
+			ExpectBody:   test.expectBody,
+		})
+	}
+}
+
+func (s *InterestingSuite) TestPutFeaturedErrors(c *gc.C) {
+	s.discharge = dischargeForUser("bob")
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		Do:           bakeryDo(nil),
+		URL:          storeURL("search/featured"),
+		Method:       "PUT",
+		Header:       http.Header{"Content-Type": {"application/json"}},
+		Body:         strings.NewReader(`{"Ids": ["cs:wordpress"]}`),
+		ExpectStatus: http.StatusUnauthorized,
+		ExpectBody: params.Error{
+			Code:    params.ErrUnauthorized,
+			Message: `unauthorized: access denied for user "bob"`,
+		},
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("search/featured"),
+		Method:       "PUT",
+		Header:       http.Header{"Content-Type": {"application/json"}},
+		Body:         strings.NewReader(`{"Ids": ["cs:wordpress", "cs:wordpress"]}`),
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody: params.Error{
+			Code:    params.ErrBadRequest,
+			Message: `duplicate featured entity "cs:wordpress"`,
+		},
+	})
+}
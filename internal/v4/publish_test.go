@@ -0,0 +1,219 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/audit"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+)
+
+func (s *APISuite) publish(c *gc.C, id string, channels ...string) {
+	body, err := json.Marshal(v4.PublishRequest{Channels: channels})
+	c.Assert(err, gc.IsNil)
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL(id + "/publish"),
+		Method:  "PUT",
+		Header: http.Header{
+			"Content-Type": {"application/json"},
+		},
+		Body:     bytes.NewReader(body),
+		Username: testUsername,
+		Password: testPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+}
+
+func (s *APISuite) addChannelCharms(c *gc.C) {
+	s.addPublicCharm(c, "wordpress", newResolvedURL("cs:~charmers/precise/wordpress-0", 0))
+	s.addPublicCharm(c, "wordpress", newResolvedURL("cs:~charmers/trusty/wordpress-0", 0))
+	s.addPublicCharm(c, "wordpress", newResolvedURL("cs:~charmers/trusty/wordpress-1", 1))
+	s.addPublicCharm(c, "wordpress", newResolvedURL("cs:~charmers/trusty/wordpress-2", 2))
+	s.publish(c, "~charmers/precise/wordpress-0", "stable")
+	s.publish(c, "~charmers/trusty/wordpress-1", "stable")
+	s.publish(c, "~charmers/trusty/wordpress-2", "development")
+}
+
+func (s *APISuite) TestPublishAudit(c *gc.C) {
+	var calledEntities []audit.Entry
+	s.PatchValue(v4.TestAddAuditCallback, func(e audit.Entry) {
+		calledEntities = append(calledEntities, e)
+	})
+	s.addPublicCharm(c, "wordpress", newResolvedURL("cs:~charmers/trusty/wordpress-0", -1))
+	s.publish(c, "~charmers/trusty/wordpress-0", "stable", "development")
+	c.Assert(calledEntities, jc.DeepEquals, []audit.Entry{{
+		User:     "admin",
+		Op:       audit.OpPublish,
+		Entity:   charm.MustParseReference("~charmers/trusty/wordpress-0"),
+		Channels: []string{"stable", "development"},
+	}})
+}
+
+var publishErrorsTests = []struct {
+	about        string
+	method       string
+	body         interface{}
+	expectStatus int
+	expectBody   params.Error
+}{{
+	about:        "get not allowed",
+	method:       "GET",
+	expectStatus: http.StatusMethodNotAllowed,
+	expectBody: params.Error{
+		Code:    params.ErrMethodNotAllowed,
+		Message: "GET not allowed",
+	},
+}, {
+	about:        "no channels",
+	method:       "PUT",
+	body:         v4.PublishRequest{},
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Code:    params.ErrBadRequest,
+		Message: "no channels specified",
+	},
+}, {
+	about:        "invalid channel",
+	method:       "PUT",
+	body:         v4.PublishRequest{Channels: []string{"$bad"}},
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Code:    params.ErrBadRequest,
+		Message: `invalid channel "$bad"`,
+	},
+}}
+
+func (s *APISuite) TestPublishErrors(c *gc.C) {
+	s.addPublicCharm(c, "wordpress", newResolvedURL("cs:~charmers/trusty/wordpress-0", -1))
+	for i, test := range publishErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:      s.srv,
+			URL:          storeURL("~charmers/trusty/wordpress-0/publish"),
+			Method:       test.method,
+			JSONBody:     test.body,
+			Username:     testUsername,
+			Password:     testPassword,
+			ExpectStatus: test.expectStatus,
+			ExpectBody:   test.expectBody,
+		})
+	}
+}
+
+func (s *APISuite) TestPublishUnauthorized(c *gc.C) {
+	s.addPublicCharm(c, "wordpress", newResolvedURL("cs:~charmers/trusty/wordpress-0", -1))
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("~charmers/trusty/wordpress-0/publish"),
+		Method:  "PUT",
+		Header: http.Header{
+			"Content-Type": {"application/json"},
+		},
+		Body: bytes.NewReader([]byte(`{"Channels": ["stable"]}`)),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusUnauthorized)
+}
+
+var resolveChannelTests = []struct {
+	url     string
+	channel string
+	expect  string
+}{{
+	url:     "~charmers/wordpress",
+	channel: "stable",
+	expect:  "cs:~charmers/trusty/wordpress-1",
+}, {
+	url:     "~charmers/wordpress",
+	channel: "development",
+	expect:  "cs:~charmers/trusty/wordpress-2",
+}, {
+	url:     "~charmers/precise/wordpress",
+	channel: "stable",
+	expect:  "cs:~charmers/precise/wordpress-0",
+}, {
+	url:     "trusty/wordpress",
+	channel: "stable",
+	expect:  "cs:trusty/wordpress-1",
+}, {
+	url:    "~charmers/wordpress",
+	expect: "cs:~charmers/trusty/wordpress-2",
+}}
+
+func (s *APISuite) TestResolveWithChannel(c *gc.C) {
+	s.addChannelCharms(c)
+	for i, test := range resolveChannelTests {
+		c.Logf("test %d: %s in %q", i, test.url, test.channel)
+		url := storeURL(test.url + "/meta/id")
+		if test.channel != "" {
+			url += "?channel=" + test.channel
+		}
+		rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+			Handler: s.srv,
+			URL:     url,
+		})
+		c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+		var resp params.IdResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &resp)
+		c.Assert(err, gc.IsNil)
+		c.Assert(resp.Id.String(), gc.Equals, test.expect)
+	}
+}
+
+func (s *APISuite) TestResolveWithUnknownChannel(c *gc.C) {
+	s.addChannelCharms(c)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/wordpress/meta/id?channel=beta"),
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Code:    params.ErrNotFound,
+			Message: `no matching charm or bundle for "cs:~charmers/wordpress"`,
+		},
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/wordpress/meta/id?channel=Bad!"),
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody: params.Error{
+			Code:    params.ErrBadRequest,
+			Message: `invalid channel "Bad!"`,
+		},
+	})
+}
+
+func (s *APISuite) TestExpandIdWithChannel(c *gc.C) {
+	s.addChannelCharms(c)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		URL:     storeURL("~charmers/wordpress/expand-id?channel=stable"),
+		ExpectBody: []params.ExpandedId{
+			{Id: "cs:~charmers/trusty/wordpress-1"},
+			{Id: "cs:~charmers/precise/wordpress-0"},
+		},
+	})
+}
+
+func (s *APISuite) TestRevisionInfoWithChannel(c *gc.C) {
+	s.addChannelCharms(c)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		URL:     storeURL("~charmers/trusty/wordpress/meta/revision-info?channel=stable"),
+		ExpectBody: params.RevisionInfoResponse{
+			Revisions: []*charm.Reference{
+				charm.MustParseReference("cs:~charmers/trusty/wordpress-1"),
+				charm.MustParseReference("cs:~charmers/trusty/wordpress-0"),
+			},
+		},
+	})
+}
@@ -0,0 +1,77 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4 // import "gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/juju/httprequest"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+)
+
+// Quota holds the upload quota overrides for a user, as returned by
+// a GET of quota/user and sent as the body of a PUT to it. A zero
+// limit means that the store-wide limit applies; a negative limit
+// means that there is no limit.
+type Quota struct {
+	MaxBytes       int64
+	MaxArchiveSize int64
+	MaxRevisions   int
+}
+
+// GET quota/user
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#get-quotauser
+//
+// PUT quota/user
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#put-quotauser
+func (h *ReqHandler) serveQuota(w http.ResponseWriter, req *http.Request) error {
+	user := strings.Trim(req.URL.Path, "/")
+	if user == "" || strings.Contains(user, "/") {
+		return errgo.WithCausef(nil, params.ErrNotFound, "")
+	}
+	switch req.Method {
+	case "GET":
+		if _, err := h.authorize(req, []string{user}, true, nil); err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+		q, err := h.Store.UserQuota(user)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		return httprequest.WriteJSON(w, http.StatusOK, Quota{
+			MaxBytes:       q.MaxBytes,
+			MaxArchiveSize: q.MaxArchiveSize,
+			MaxRevisions:   q.MaxRevisions,
+		})
+	case "PUT":
+		if _, err := h.authorize(req, nil, true, nil); err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		var q Quota
+		if err := json.Unmarshal(data, &q); err != nil {
+			return errgo.WithCausef(err, params.ErrBadRequest, "")
+		}
+		err = h.Store.SetUserQuota(&mongodoc.Quota{
+			User:           user,
+			MaxBytes:       q.MaxBytes,
+			MaxArchiveSize: q.MaxArchiveSize,
+			MaxRevisions:   q.MaxRevisions,
+		})
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		return nil
+	}
+	return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s not allowed", req.Method)
+}
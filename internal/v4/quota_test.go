@@ -0,0 +1,109 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+)
+
+type QuotaSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&QuotaSuite{})
+
+func (s *QuotaSuite) SetUpSuite(c *gc.C) {
+	s.enableIdentity = true
+	s.commonSuite.SetUpSuite(c)
+}
+
+func (s *QuotaSuite) TestQuota(c *gc.C) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:    s.srv,
+		URL:        storeURL("quota/bob"),
+		Username:   testUsername,
+		Password:   testPassword,
+		ExpectBody: v4.Quota{},
+	})
+	quota := v4.Quota{
+		MaxBytes:       1 << 30,
+		MaxArchiveSize: -1,
+		MaxRevisions:   10,
+	}
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("quota/bob"),
+		Method:   "PUT",
+		Header:   http.Header{"Content-Type": {"application/json"}},
+		Body:     strings.NewReader(mustMarshalJSON(quota)),
+		Username: testUsername,
+		Password: testPassword,
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:    s.srv,
+		URL:        storeURL("quota/bob"),
+		Username:   testUsername,
+		Password:   testPassword,
+		ExpectBody: quota,
+	})
+	q, err := s.store.UserQuota("bob")
+	c.Assert(err, gc.IsNil)
+	c.Assert(q.MaxRevisions, gc.Equals, 10)
+
+	// Users can see their own quota.
+	s.discharge = dischargeForUser("bob")
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:    s.srv,
+		Do:         bakeryDo(nil),
+		URL:        storeURL("quota/bob"),
+		ExpectBody: quota,
+	})
+}
+
+func (s *QuotaSuite) TestQuotaErrors(c *gc.C) {
+	// Only admins can change quotas.
+	s.discharge = dischargeForUser("bob")
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		Do:           bakeryDo(nil),
+		URL:          storeURL("quota/bob"),
+		Method:       "PUT",
+		Header:       http.Header{"Content-Type": {"application/json"}},
+		Body:         strings.NewReader(mustMarshalJSON(v4.Quota{MaxBytes: -1})),
+		ExpectStatus: http.StatusUnauthorized,
+		ExpectBody: params.Error{
+			Code:    params.ErrUnauthorized,
+			Message: `unauthorized: access denied for user "bob"`,
+		},
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		Do:           bakeryDo(nil),
+		URL:          storeURL("quota/alice"),
+		ExpectStatus: http.StatusUnauthorized,
+		ExpectBody: params.Error{
+			Code:    params.ErrUnauthorized,
+			Message: `unauthorized: access denied for user "bob"`,
+		},
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("quota/bob"),
+		Method:       "DELETE",
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusMethodNotAllowed,
+		ExpectBody: params.Error{
+			Code:    params.ErrMethodNotAllowed,
+			Message: "DELETE not allowed",
+		},
+	})
+}
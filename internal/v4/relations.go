@@ -32,6 +32,7 @@ func (h *ReqHandler) metaCharmRelated(entity *mongodoc.Entity, id *router.Resolv
 
 	// Build the query to retrieve the related entities.
 	query := bson.M{
+		"trashtime": bson.M{"$exists": false},
 		"$or": []bson.M{
 			{"charmrequiredinterfaces": bson.M{
 				"$elemMatch": bson.M{
@@ -182,7 +183,7 @@ func (h *ReqHandler) metaBundlesContaining(entity *mongodoc.Entity, id *router.R
 	// Retrieve the bundles containing the resulting charm id.
 	var entities []*mongodoc.Entity
 	if err := h.Store.DB.Entities().
-		Find(bson.D{{"bundlecharms", &searchId}}).
+		Find(bson.D{{"bundlecharms", &searchId}, {"trashtime", bson.D{{"$exists", false}}}}).
 		Select(bson.D{{"_id", 1}, {"bundlecharms", 1}, {"promulgated-url", 1}}).
 		All(&entities); err != nil {
 		return nil, errgo.Notef(err, "cannot retrieve the related bundles")
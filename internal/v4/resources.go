@@ -0,0 +1,195 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4 // import "gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/httprequest"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+// ResourcesRevision holds the response to a POST to
+// id/resources/name.stream.
+type ResourcesRevision struct {
+	Revision int
+}
+
+// Resource holds information about one revision of a resource
+// stream, as returned by GET id/resources.
+type Resource struct {
+	Stream     string
+	Revision   int
+	CreateTime time.Time
+	Arches     []ResourceArch `json:",omitempty"`
+}
+
+// ResourceArch holds information about the data uploaded
+// for one architecture of a resource revision.
+type ResourceArch struct {
+	Arch       string
+	Size       int64
+	Hash256    string
+	UploadTime time.Time
+}
+
+// ResourceHash256Header holds the name of the HTTP header that
+// holds the SHA256 hash of a downloaded resource.
+const ResourceHash256Header = "Content-Sha256"
+
+// GET id/resources
+// Lists all the resource revisions associated with the charm.
+//
+// POST id/resources/name.stream
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#post-idresourcesnamestream
+//
+// GET  id/resources/name.stream[-revision]/arch/filename
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#get-idresourcesnamestream-revisionarchfilename
+//
+// PUT id/resources/[~user/]series/name.stream-revision/arch?sha256=hash
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#put-idresourcesuserseriesnamestream-revisionarchsha256hash
+func (h *ReqHandler) serveResources(id *router.ResolvedURL, w http.ResponseWriter, req *http.Request) error {
+	if id.URL.Series == "bundle" {
+		return errgo.WithCausef(nil, params.ErrForbidden, "bundles do not have resources")
+	}
+	var parts []string
+	if p := strings.Trim(req.URL.Path, "/"); p != "" {
+		parts = strings.Split(p, "/")
+	}
+	switch req.Method {
+	case "GET", "HEAD":
+		if len(parts) == 0 {
+			return h.serveListResources(id, w, req)
+		}
+		return h.serveGetResource(id, parts, w, req)
+	case "POST":
+		return h.servePostResource(id, parts, w, req)
+	case "PUT":
+		// Make sure we consume the full request body, before responding.
+		defer io.Copy(ioutil.Discard, req.Body)
+		return h.servePutResource(id, parts, w, req)
+	}
+	return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s not allowed", req.Method)
+}
+
+func (h *ReqHandler) serveListResources(id *router.ResolvedURL, w http.ResponseWriter, req *http.Request) error {
+	docs, err := h.Store.Resources(&id.URL)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	resources := make([]Resource, len(docs))
+	for i, doc := range docs {
+		r := Resource{
+			Stream:     doc.Stream,
+			Revision:   doc.Revision,
+			CreateTime: doc.CreateTime.UTC(),
+		}
+		for arch, blob := range doc.Blobs {
+			r.Arches = append(r.Arches, ResourceArch{
+				Arch:       arch,
+				Size:       blob.Size,
+				Hash256:    blob.BlobHash256,
+				UploadTime: blob.UploadTime.UTC(),
+			})
+		}
+		sort.Sort(resourceArchesByName(r.Arches))
+		resources[i] = r
+	}
+	return httprequest.WriteJSON(w, http.StatusOK, resources)
+}
+
+func (h *ReqHandler) serveGetResource(id *router.ResolvedURL, parts []string, w http.ResponseWriter, req *http.Request) error {
+	// The trailing file name is optional and is ignored:
+	// it is only there so that clients can choose the name
+	// of the downloaded file.
+	if len(parts) < 2 || len(parts) > 3 {
+		return errgo.WithCausef(nil, params.ErrNotFound, "")
+	}
+	stream, revision, err := parseResourceStream(parts[0])
+	if err != nil {
+		return errgo.WithCausef(err, params.ErrNotFound, "")
+	}
+	arch := parts[1]
+	res, err := h.Store.FindResource(&id.URL, stream, revision, arch)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	r, size, hash256, err := h.Store.OpenResource(res, arch)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	defer r.Close()
+	header := w.Header()
+	setArchiveCacheControl(header, h.isPublic(id.URL))
+	header.Set(ResourceHash256Header, hash256)
+	header.Set(params.EntityIdHeader, id.String())
+	serveContent(w, req, size, r)
+	return nil
+}
+
+func (h *ReqHandler) servePostResource(id *router.ResolvedURL, parts []string, w http.ResponseWriter, req *http.Request) error {
+	if len(parts) != 1 {
+		return badRequestf(nil, "invalid resource path %q", strings.Join(parts, "/"))
+	}
+	rev, err := h.Store.AddResourceRevision(&id.URL, parts[0])
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+	}
+	return httprequest.WriteJSON(w, http.StatusOK, &ResourcesRevision{
+		Revision: rev,
+	})
+}
+
+func (h *ReqHandler) servePutResource(id *router.ResolvedURL, parts []string, w http.ResponseWriter, req *http.Request) error {
+	if len(parts) != 2 {
+		return badRequestf(nil, "invalid resource path %q", strings.Join(parts, "/"))
+	}
+	stream, revision, err := parseResourceStream(parts[0])
+	if err != nil {
+		return badRequestf(err, "")
+	}
+	if revision == -1 {
+		return badRequestf(nil, "resource revision not specified")
+	}
+	hash256 := req.Form.Get("sha256")
+	if hash256 == "" {
+		return badRequestf(nil, "sha256 parameter not specified")
+	}
+	if err := h.Store.PutResource(&id.URL, stream, revision, parts[1], req.Body, hash256); err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrBadRequest))
+	}
+	return nil
+}
+
+// parseResourceStream parses a path element of the form
+// name.stream[-revision]. If no revision is specified,
+// the returned revision is -1.
+func parseResourceStream(s string) (stream string, revision int, err error) {
+	stream, revision = s, -1
+	if i := strings.LastIndex(s, "-"); i != -1 {
+		if rev, err := strconv.Atoi(s[i+1:]); err == nil {
+			stream, revision = s[0:i], rev
+		}
+	}
+	if !charmstore.IsValidResourceStream(stream) {
+		return "", 0, errgo.Newf("invalid resource stream %q", stream)
+	}
+	return stream, revision, nil
+}
+
+type resourceArchesByName []ResourceArch
+
+func (s resourceArchesByName) Len() int           { return len(s) }
+func (s resourceArchesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s resourceArchesByName) Less(i, j int) bool { return s[i].Arch < s[j].Arch }
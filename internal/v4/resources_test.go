@@ -0,0 +1,319 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+)
+
+type ResourcesSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&ResourcesSuite{})
+
+func (s *ResourcesSuite) addCharm(c *gc.C, public bool) {
+	id := newResolvedURL("cs:~charmers/precise/wordpress-0", -1)
+	err := s.store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	if public {
+		err = s.store.SetPerms(&id.URL, "read", params.Everyone, id.URL.User)
+		c.Assert(err, gc.IsNil)
+	}
+}
+
+func (s *ResourcesSuite) postResource(c *gc.C, path string, expectRevision int) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/precise/wordpress/resources/" + path),
+		Method:   "POST",
+		Username: testUsername,
+		Password: testPassword,
+		ExpectBody: v4.ResourcesRevision{
+			Revision: expectRevision,
+		},
+	})
+}
+
+func (s *ResourcesSuite) putResource(c *gc.C, path, content string) {
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		URL:      storeURL(fmt.Sprintf("~charmers/precise/wordpress/resources/%s?sha256=%s", path, hashOf256(content))),
+		Method:   "PUT",
+		Body:     strings.NewReader(content),
+		Username: testUsername,
+		Password: testPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+}
+
+func (s *ResourcesSuite) TestUploadAndDownload(c *gc.C) {
+	s.addCharm(c, true)
+	s.postResource(c, "data.default", 0)
+	s.putResource(c, "data.default-0/amd64", "amd64 data 0")
+	s.putResource(c, "data.default-0/i386", "i386 data 0")
+	s.postResource(c, "data.default", 1)
+	s.putResource(c, "data.default-1/amd64", "amd64 data 1")
+
+	tests := []struct {
+		path   string
+		expect string
+	}{{
+		path:   "data.default/amd64/data.tgz",
+		expect: "amd64 data 1",
+	}, {
+		path:   "data.default/i386/data.tgz",
+		expect: "i386 data 0",
+	}, {
+		path:   "data.default-0/amd64/data.tgz",
+		expect: "amd64 data 0",
+	}, {
+		path:   "data.default-1/amd64",
+		expect: "amd64 data 1",
+	}}
+	for i, test := range tests {
+		c.Logf("test %d: %s", i, test.path)
+		rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+			Handler: s.srv,
+			URL:     storeURL("~charmers/precise/wordpress/resources/" + test.path),
+		})
+		c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+		c.Assert(rec.Body.String(), gc.Equals, test.expect)
+		c.Assert(rec.Header().Get(v4.ResourceHash256Header), gc.Equals, hashOf256(test.expect))
+		c.Assert(rec.Header().Get(params.EntityIdHeader), gc.Equals, "cs:~charmers/precise/wordpress-0")
+	}
+}
+
+func (s *ResourcesSuite) TestList(c *gc.C) {
+	s.addCharm(c, true)
+	s.postResource(c, "data.default", 0)
+	s.putResource(c, "data.default-0/i386", "i386 data")
+	s.putResource(c, "data.default-0/amd64", "amd64 data")
+	s.postResource(c, "data.default", 1)
+
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("~charmers/precise/wordpress/resources"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+	var resources []v4.Resource
+	err := json.Unmarshal(rec.Body.Bytes(), &resources)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resources, gc.HasLen, 2)
+	c.Assert(resources[0].Stream, gc.Equals, "data.default")
+	c.Assert(resources[0].Revision, gc.Equals, 0)
+	c.Assert(resources[0].Arches, gc.HasLen, 2)
+	c.Assert(resources[0].Arches[0].Arch, gc.Equals, "amd64")
+	c.Assert(resources[0].Arches[0].Size, gc.Equals, int64(len("amd64 data")))
+	c.Assert(resources[0].Arches[0].Hash256, gc.Equals, hashOf256("amd64 data"))
+	c.Assert(resources[0].Arches[1].Arch, gc.Equals, "i386")
+	c.Assert(resources[1].Revision, gc.Equals, 1)
+	c.Assert(resources[1].Arches, gc.HasLen, 0)
+}
+
+func (s *ResourcesSuite) TestPutDifferentHash(c *gc.C) {
+	s.addCharm(c, true)
+	s.postResource(c, "data.default", 0)
+	s.putResource(c, "data.default-0/amd64", "some data")
+
+	// Putting the same content again succeeds.
+	s.putResource(c, "data.default-0/amd64", "some data")
+
+	content := "other data"
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/precise/wordpress/resources/data.default-0/amd64?sha256=" + hashOf256(content)),
+		Method:       "PUT",
+		Body:         strings.NewReader(content),
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody: params.Error{
+			Code:    params.ErrBadRequest,
+			Message: "resource data.default-0/amd64 already uploaded with a different hash",
+		},
+	})
+}
+
+func (s *ResourcesSuite) TestPutHashMismatch(c *gc.C) {
+	s.addCharm(c, true)
+	s.postResource(c, "data.default", 0)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/precise/wordpress/resources/data.default-0/amd64?sha256=" + hashOf256("foo")),
+		Method:       "PUT",
+		Body:         strings.NewReader("bar"),
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody: params.Error{
+			Code:    params.ErrBadRequest,
+			Message: fmt.Sprintf("resource hash mismatch; got %s want %s", hashOf256("bar"), hashOf256("foo")),
+		},
+	})
+}
+
+func (s *ResourcesSuite) TestPutUnknownRevision(c *gc.C) {
+	s.addCharm(c, true)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/precise/wordpress/resources/data.default-3/amd64?sha256=" + hashOf256("foo")),
+		Method:       "PUT",
+		Body:         strings.NewReader("foo"),
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Code:    params.ErrNotFound,
+			Message: "resource not found",
+		},
+	})
+}
+
+var resourcesBadRequestTests = []struct {
+	about        string
+	method       string
+	path         string
+	expectStatus int
+	expectBody   params.Error
+}{{
+	about:        "post with invalid stream",
+	method:       "POST",
+	path:         "Data",
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Code:    params.ErrBadRequest,
+		Message: `invalid resource stream "Data"`,
+	},
+}, {
+	about:        "post with extra path elements",
+	method:       "POST",
+	path:         "data.default/amd64",
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Code:    params.ErrBadRequest,
+		Message: `invalid resource path "data.default/amd64"`,
+	},
+}, {
+	about:        "put without revision",
+	method:       "PUT",
+	path:         "data.default/amd64?sha256=1234",
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Code:    params.ErrBadRequest,
+		Message: "resource revision not specified",
+	},
+}, {
+	about:        "put without hash",
+	method:       "PUT",
+	path:         "data.default-0/amd64",
+	expectStatus: http.StatusBadRequest,
+	expectBody: params.Error{
+		Code:    params.ErrBadRequest,
+		Message: "sha256 parameter not specified",
+	},
+}, {
+	about:        "get unknown resource",
+	method:       "GET",
+	path:         "data.default/amd64/data.tgz",
+	expectStatus: http.StatusNotFound,
+	expectBody: params.Error{
+		Code:    params.ErrNotFound,
+		Message: "resource not found",
+	},
+}, {
+	about:        "delete not allowed",
+	method:       "DELETE",
+	path:         "data.default",
+	expectStatus: http.StatusMethodNotAllowed,
+	expectBody: params.Error{
+		Code:    params.ErrMethodNotAllowed,
+		Message: "DELETE not allowed",
+	},
+}}
+
+func (s *ResourcesSuite) TestBadRequests(c *gc.C) {
+	s.addCharm(c, true)
+	for i, test := range resourcesBadRequestTests {
+		c.Logf("test %d: %s", i, test.about)
+		httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+			Handler:      s.srv,
+			URL:          storeURL("~charmers/precise/wordpress/resources/" + test.path),
+			Method:       test.method,
+			Username:     testUsername,
+			Password:     testPassword,
+			ExpectStatus: test.expectStatus,
+			ExpectBody:   test.expectBody,
+		})
+	}
+}
+
+func (s *ResourcesSuite) TestBundleHasNoResources(c *gc.C) {
+	id := newResolvedURL("cs:~charmers/bundle/wordpress-simple-0", -1)
+	err := s.store.AddBundleWithArchive(id, storetesting.Charms.BundleDir("wordpress-simple"))
+	c.Assert(err, gc.IsNil)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/bundle/wordpress-simple/resources/data.default"),
+		Method:       "POST",
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusForbidden,
+		ExpectBody: params.Error{
+			Code:    params.ErrForbidden,
+			Message: "bundles do not have resources",
+		},
+	})
+}
+
+func (s *ResourcesSuite) TestAccessFollowsBaseEntityACLs(c *gc.C) {
+	s.addCharm(c, false)
+	s.postResource(c, "data.default", 0)
+	s.putResource(c, "data.default-0/amd64", "some data")
+
+	// The charm is not public, so anonymous users cannot
+	// list or download its resources.
+	for _, path := range []string{"resources", "resources/data.default/amd64/data.tgz"} {
+		rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+			Handler: s.srv,
+			URL:     storeURL("~charmers/precise/wordpress/" + path),
+		})
+		c.Assert(rec.Code, gc.Equals, http.StatusUnauthorized, gc.Commentf("path %s", path))
+	}
+
+	// Making the charm public makes the resources available too.
+	err := s.store.SetPerms(charm.MustParseReference("cs:~charmers/precise/wordpress-0"), "read", params.Everyone)
+	c.Assert(err, gc.IsNil)
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("~charmers/precise/wordpress/resources/data.default/amd64/data.tgz"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), gc.Equals, "some data")
+
+	// Only users with write access can upload.
+	rec = httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("~charmers/precise/wordpress/resources/data.default"),
+		Method:  "POST",
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusUnauthorized)
+}
+
+// hashOf256 returns the hex-encoded SHA256 hash of s.
+func hashOf256(s string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+}
@@ -4,27 +4,41 @@
 package v4 // import "gopkg.in/juju/charmstore.v5-unstable/internal/v4"
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
+	"github.com/juju/httprequest"
 	"github.com/juju/utils/parallel"
 	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charm.v6-unstable"
 	"gopkg.in/juju/charmrepo.v1/csclient/params"
 
 	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
 )
 
 const maxConcurrency = 20
 
-// GET search[?text=text][&autocomplete=1][&filter=value…][&limit=limit][&include=meta][&skip=count][&sort=field[+dir]]
+// GET search[?text=text][&autocomplete=1][&filter=value…][&limit=limit][&include=meta][&skip=count][&sort=field[+dir]][&facet=name…][&highlight=1]
 // https://github.com/juju/charmstore/blob/v4/docs/API.md#get-search
 func (h *ReqHandler) serveSearch(_ http.Header, req *http.Request) (interface{}, error) {
 	sp, err := parseSearchParams(req)
 	if err != nil {
 		return "", err
 	}
+	h.setSearchAuth(&sp, req)
+	return h.doSearch(sp, req)
+}
+
+// setSearchAuth sets the Admin and Groups fields of sp
+// according to the credentials in the request, if any.
+func (h *ReqHandler) setSearchAuth(sp *charmstore.SearchParams, req *http.Request) {
 	auth, err := h.checkRequest(req, nil)
 	if err != nil {
 		logger.Infof("authorization failed on search request, granting no privileges: %v", err)
@@ -38,7 +52,6 @@ func (h *ReqHandler) serveSearch(_ http.Header, req *http.Request) (interface{},
 		}
 		sp.Groups = append(sp.Groups, groups...)
 	}
-	return h.doSearch(sp, req)
 }
 
 // doSearch performs the search specified by SearchParams. If sp
@@ -50,6 +63,50 @@ func (h *ReqHandler) doSearch(sp charmstore.SearchParams, req *http.Request) (in
 	if err != nil {
 		return nil, errgo.Notef(err, "error performing search")
 	}
+	resp := SearchResponse{
+		SearchResponse: h.searchResponse(results, sp.Include, req),
+		Facets:         results.Facets,
+		Suggestions:    results.Suggestions,
+	}
+	if results.Highlights != nil {
+		// Results whose metadata could not be retrieved are
+		// omitted from the response, so their highlights are
+		// omitted too.
+		returned := make(map[string]bool)
+		for _, r := range resp.Results {
+			returned[r.Id.String()] = true
+		}
+		for i, highlights := range results.Highlights {
+			id := results.Results[i].PreferredURL().String()
+			if highlights == nil || !returned[id] {
+				continue
+			}
+			if resp.Highlights == nil {
+				resp.Highlights = make(map[string]map[string][]string)
+			}
+			resp.Highlights[id] = highlights
+		}
+	}
+	return resp, nil
+}
+
+// SearchResponse holds the response from GET search.
+// It holds the facet counts requested with the facet
+// parameter, any suggested search text and the snippets
+// requested with the highlight parameter in addition
+// to the fields of params.SearchResponse.
+type SearchResponse struct {
+	params.SearchResponse
+	Facets      map[string][]charmstore.FacetCount `json:",omitempty"`
+	Suggestions []string                           `json:",omitempty"`
+	// Highlights holds the highlighted snippets of each
+	// result, keyed by result id and then by field name.
+	Highlights map[string]map[string][]string `json:",omitempty"`
+}
+
+// searchResponse returns the response for the given search results,
+// including the metadata specified by include in each result.
+func (h *ReqHandler) searchResponse(results charmstore.SearchResult, include []string, req *http.Request) params.SearchResponse {
 	response := params.SearchResponse{
 		SearchTime: results.SearchTime,
 		Total:      results.Total,
@@ -60,7 +117,7 @@ func (h *ReqHandler) doSearch(sp charmstore.SearchParams, req *http.Request) (in
 	for i, ref := range results.Results {
 		i, ref := i, ref
 		run.Do(func() error {
-			meta, err := h.Router.GetMetadata(ref, sp.Include, req)
+			meta, err := h.Router.GetMetadata(ref, include, req)
 			if err != nil {
 				// Unfortunately it is possible to get errors here due to
 				// internal inconsistency, so rather than throwing away
@@ -80,7 +137,7 @@ func (h *ReqHandler) doSearch(sp charmstore.SearchParams, req *http.Request) (in
 	// check the error here.
 	run.Wait()
 	if missing == 0 {
-		return response, nil
+		return response
 	}
 	// We're missing some results - shuffle all the results down to
 	// fill the gaps.
@@ -92,13 +149,102 @@ func (h *ReqHandler) doSearch(sp charmstore.SearchParams, req *http.Request) (in
 		}
 	}
 	response.Results = response.Results[0:j]
-	return response, nil
+	return response
 }
 
+// interestingCandidatesKey holds the search cache key of
+// the candidates for GET search/interesting.
+const interestingCandidatesKey = "interesting"
+
 // GET search/interesting[?limit=limit][&include=meta]
 // https://github.com/juju/charmstore/blob/v4/docs/API.md#get-searchinteresting
-func (h *ReqHandler) serveSearchInteresting(w http.ResponseWriter, req *http.Request) {
-	router.WriteError(w, errNotImplemented)
+func (h *ReqHandler) serveSearchInteresting(_ http.Header, req *http.Request) (interface{}, error) {
+	for k := range req.Form {
+		if k != "limit" && k != "include" {
+			return nil, badRequestf(nil, "invalid parameter: %s", k)
+		}
+	}
+	sp, err := parseSearchParams(req)
+	if err != nil {
+		return nil, err
+	}
+	h.setSearchAuth(&sp, req)
+	start := time.Now()
+	// The candidates are the same for all users, so they are
+	// cached, and only the ACLs are checked for each request.
+	candidates, err := h.handler.searchCache.Get(interestingCandidatesKey, func() (interface{}, error) {
+		candidates, err := h.Store.InterestingCandidates()
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return candidates, nil
+	})
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get interesting entities")
+	}
+	ids, err := h.Store.SelectInteresting(candidates.([]*mongodoc.Entity), charmstore.InterestingParams{
+		Limit:  sp.Limit,
+		Groups: sp.Groups,
+		Admin:  sp.Admin,
+	})
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get interesting entities")
+	}
+	return h.searchResponse(charmstore.SearchResult{
+		SearchTime: time.Since(start),
+		Total:      len(ids),
+		Results:    ids,
+	}, sp.Include, req), nil
+}
+
+// FeaturedEntities holds the ids of the entities curated by
+// administrators to be returned first by GET search/interesting,
+// as returned by GET search/featured and sent as the body
+// of a PUT to it.
+type FeaturedEntities struct {
+	Ids []*charm.Reference
+}
+
+// GET search/featured
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#get-searchfeatured
+//
+// PUT search/featured
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#put-searchfeatured
+func (h *ReqHandler) serveSearchFeatured(w http.ResponseWriter, req *http.Request) error {
+	switch req.Method {
+	case "GET":
+		ids, err := h.Store.FeaturedEntities()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		return httprequest.WriteJSON(w, http.StatusOK, FeaturedEntities{
+			Ids: ids,
+		})
+	case "PUT":
+		if _, err := h.authorize(req, nil, true, nil); err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		var featured FeaturedEntities
+		if err := json.Unmarshal(data, &featured); err != nil {
+			return errgo.WithCausef(err, params.ErrBadRequest, "")
+		}
+		for _, id := range featured.Ids {
+			if id == nil {
+				return badRequestf(nil, "empty featured entity id")
+			}
+		}
+		if err := h.Store.SetFeaturedEntities(featured.Ids); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+		}
+		// Cached interesting entities are now out of date.
+		h.handler.searchCache.EvictAll()
+		return nil
+	}
+	return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s not allowed", req.Method)
 }
 
 // parseSearchParms extracts the search paramaters from the request
@@ -106,7 +252,18 @@ func parseSearchParams(req *http.Request) (charmstore.SearchParams, error) {
 	sp := charmstore.SearchParams{}
 	var err error
 	for k, v := range req.Form {
-		switch k {
+		// A filter can be negated to exclude the matching
+		// items, as either "-name=value" or "name!=value".
+		name, negate := k, ""
+		if strings.HasSuffix(k, "!") {
+			name, negate = strings.TrimSuffix(k, "!"), "-"
+		} else if strings.HasPrefix(k, "-") {
+			name, negate = strings.TrimPrefix(k, "-"), "-"
+		}
+		if negate != "" && !charmstore.IsFilter(name) {
+			return charmstore.SearchParams{}, badRequestf(nil, "invalid parameter: %s", k)
+		}
+		switch name {
 		case "text":
 			sp.Text = v[0]
 		case "autocomplete":
@@ -114,6 +271,11 @@ func parseSearchParams(req *http.Request) (charmstore.SearchParams, error) {
 			if err != nil {
 				return charmstore.SearchParams{}, badRequestf(err, "invalid autocomplete parameter")
 			}
+		case "highlight":
+			sp.Highlight, err = router.ParseBool(v[0])
+			if err != nil {
+				return charmstore.SearchParams{}, badRequestf(err, "invalid highlight parameter")
+			}
 		case "limit":
 			sp.Limit, err = strconv.Atoi(v[0])
 			if err != nil {
@@ -128,11 +290,11 @@ func parseSearchParams(req *http.Request) (charmstore.SearchParams, error) {
 					sp.Include = append(sp.Include, s)
 				}
 			}
-		case "description", "name", "owner", "provides", "requires", "series", "summary", "tags", "type":
+		case "action", "config-option", "description", "hook", "name", "owner", "provides", "requires", "series", "summary", "tags", "type":
 			if sp.Filters == nil {
 				sp.Filters = make(map[string][]string)
 			}
-			sp.Filters[k] = v
+			sp.Filters[negate+name] = append(sp.Filters[negate+name], v...)
 		case "promulgated":
 			promulgated, err := router.ParseBool(v[0])
 			if err != nil {
@@ -142,10 +304,16 @@ func parseSearchParams(req *http.Request) (charmstore.SearchParams, error) {
 				sp.Filters = make(map[string][]string)
 			}
 			if promulgated {
-				sp.Filters[k] = []string{"1"}
+				sp.Filters[negate+name] = []string{"1"}
 			} else {
-				sp.Filters[k] = []string{"0"}
+				sp.Filters[negate+name] = []string{"0"}
 			}
+		case "downloads-min", "machines-max", "units-max", "uploaded-since":
+			if err := sp.ParseRangeFilter(negate+name, v...); err != nil {
+				return charmstore.SearchParams{}, badRequestf(err, "invalid %s filter parameter", k)
+			}
+		case "channel":
+			sp.Channel = v[0]
 		case "skip":
 			sp.Skip, err = strconv.Atoi(v[0])
 			if err != nil {
@@ -159,6 +327,11 @@ func parseSearchParams(req *http.Request) (charmstore.SearchParams, error) {
 			if err != nil {
 				return charmstore.SearchParams{}, badRequestf(err, "invalid sort field")
 			}
+		case "facet":
+			err = sp.ParseFacets(v...)
+			if err != nil {
+				return charmstore.SearchParams{}, badRequestf(err, "invalid facet")
+			}
 		default:
 			return charmstore.SearchParams{}, badRequestf(nil, "invalid parameter: %s", k)
 		}
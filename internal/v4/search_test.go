@@ -127,6 +127,76 @@ func (s *SearchSuite) TestParseSearchParams(c *gc.C) {
 		about:       "invalid autocomplete",
 		query:       "autocomplete=true",
 		expectError: `invalid autocomplete parameter: unexpected bool value "true" (must be "0" or "1")`,
+	}, {
+		about: "excluded filter",
+		query: "series!=precise",
+		expectParams: charmstore.SearchParams{
+			Filters: map[string][]string{
+				"-series": {"precise"},
+			},
+		},
+	}, {
+		about: "excluded filter with hyphen",
+		query: "-tags=databases&-tags=mysql&tags=web",
+		expectParams: charmstore.SearchParams{
+			Filters: map[string][]string{
+				"-tags": {"databases", "mysql"},
+				"tags":  {"web"},
+			},
+		},
+	}, {
+		about: "excluded promulgated filter",
+		query: "promulgated!=1",
+		expectParams: charmstore.SearchParams{
+			Filters: map[string][]string{
+				"-promulgated": {"1"},
+			},
+		},
+	}, {
+		about: "excluded range filter",
+		query: "-units-max=3",
+		expectParams: charmstore.SearchParams{
+			Filters: map[string][]string{
+				"-units-max": {"3"},
+			},
+		},
+	}, {
+		about:       "excluded non-filter parameter",
+		query:       "-text=foo",
+		expectError: `invalid parameter: -text`,
+	}, {
+		about:       "excluded unknown parameter",
+		query:       "limit!=2",
+		expectError: `invalid parameter: limit!`,
+	}, {
+		about: "range filters",
+		query: "units-max=10&machines-max=5&downloads-min=100&uploaded-since=2015-06-01",
+		expectParams: charmstore.SearchParams{
+			Filters: map[string][]string{
+				"units-max":      {"10"},
+				"machines-max":   {"5"},
+				"downloads-min":  {"100"},
+				"uploaded-since": {"2015-06-01"},
+			},
+		},
+	}, {
+		about:       "invalid range filter",
+		query:       "units-max=many",
+		expectError: `invalid units-max filter parameter: expected non-negative integer, got "many"`,
+	}, {
+		about:       "invalid time range filter",
+		query:       "uploaded-since=yesterday",
+		expectError: `invalid uploaded-since filter parameter: expected date or RFC 3339 time, got "yesterday"`,
+	}, {
+		about: "highlight",
+		query: "highlight=1",
+		expectParams: charmstore.SearchParams{
+			Highlight: true,
+		},
+	}, {
+		about:       "invalid highlight",
+		query:       "highlight=yes",
+		expectError: `invalid highlight parameter: unexpected bool value "yes" (must be "0" or "1")`,
 	}, {
 		about: "limit",
 		query: "limit=20",
@@ -223,6 +293,30 @@ func (s *SearchSuite) TestParseSearchParams(c *gc.C) {
 				"type": {"text"},
 			},
 		},
+	}, {
+		about: "config option filter",
+		query: "config-option=ssl_cert",
+		expectParams: charmstore.SearchParams{
+			Filters: map[string][]string{
+				"config-option": {"ssl_cert"},
+			},
+		},
+	}, {
+		about: "action filter",
+		query: "action=backup",
+		expectParams: charmstore.SearchParams{
+			Filters: map[string][]string{
+				"action": {"backup"},
+			},
+		},
+	}, {
+		about: "hook filter",
+		query: "hook=install",
+		expectParams: charmstore.SearchParams{
+			Filters: map[string][]string{
+				"hook": {"install"},
+			},
+		},
 	}, {
 		about: "many filters",
 		query: "name=name&owner=owner&series=series1&series=series2",
@@ -263,6 +357,16 @@ func (s *SearchSuite) TestParseSearchParams(c *gc.C) {
 		about:       "promulgated filter - bad",
 		query:       "promulgated=bad",
 		expectError: `invalid promulgated filter parameter: unexpected bool value "bad" (must be "0" or "1")`,
+	}, {
+		about: "facets",
+		query: "facet=series&facet=owner",
+		expectParams: charmstore.SearchParams{
+			Facets: []string{"series", "owner"},
+		},
+	}, {
+		about:       "invalid facet",
+		query:       "facet=series&facet=name",
+		expectError: "invalid facet: name",
 	}}
 	for i, test := range tests {
 		c.Logf("test %d. %s", i, test.about)
@@ -707,6 +811,101 @@ func (s *SearchSuite) TestSortUnsupportedField(c *gc.C) {
 	c.Assert(e.Message, gc.Equals, "invalid sort field: foo")
 }
 
+func (s *SearchSuite) TestSearchFacets(c *gc.C) {
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("search?facet=owner&facet=type&type=charm"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	var sr v4.SearchResponse
+	err := json.Unmarshal(rec.Body.Bytes(), &sr)
+	c.Assert(err, gc.IsNil)
+	assertResultSet(c, sr.SearchResponse, []*router.ResolvedURL{
+		exportTestCharms["wordpress"],
+		exportTestCharms["mysql"],
+		exportTestCharms["varnish"],
+	})
+	// The riak charm is not readable by everyone
+	// so it is not counted.
+	c.Assert(sr.Facets, jc.DeepEquals, map[string][]charmstore.FacetCount{
+		"owner": {
+			{Value: "charmers", Count: 1},
+			{Value: "foo", Count: 1},
+			{Value: "openstack-charmers", Count: 1},
+		},
+		"type": {
+			{Value: "charm", Count: 3},
+		},
+	})
+}
+
+func (s *SearchSuite) TestSearchFacetsNotRequested(c *gc.C) {
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("search"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	var resp map[string]interface{}
+	err := json.Unmarshal(rec.Body.Bytes(), &resp)
+	c.Assert(err, gc.IsNil)
+	_, ok := resp["Facets"]
+	c.Assert(ok, gc.Equals, false)
+}
+
+func (s *SearchSuite) TestSearchSuggestions(c *gc.C) {
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("search?text=mysq"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	var sr v4.SearchResponse
+	err := json.Unmarshal(rec.Body.Bytes(), &sr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(sr.Suggestions, jc.DeepEquals, []string{"mysql"})
+
+	// No suggestions are returned for search text that is
+	// spelt correctly.
+	rec = httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("search?text=mysql"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	var resp map[string]interface{}
+	err = json.Unmarshal(rec.Body.Bytes(), &resp)
+	c.Assert(err, gc.IsNil)
+	_, ok := resp["Suggestions"]
+	c.Assert(ok, gc.Equals, false)
+}
+
+func (s *SearchSuite) TestSearchHighlights(c *gc.C) {
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("search?text=blog&highlight=1"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	var sr v4.SearchResponse
+	err := json.Unmarshal(rec.Body.Bytes(), &sr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(sr.Highlights, jc.DeepEquals, map[string]map[string][]string{
+		"cs:precise/wordpress-23": {
+			"summary":     {"<em>Blog</em> engine"},
+			"description": {"A pretty popular <em>blog</em> engine"},
+		},
+	})
+
+	// No highlights are returned unless requested.
+	rec = httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("search?text=blog"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	var resp map[string]interface{}
+	err = json.Unmarshal(rec.Body.Bytes(), &resp)
+	c.Assert(err, gc.IsNil)
+	_, ok := resp["Highlights"]
+	c.Assert(ok, gc.Equals, false)
+}
+
 func (s *SearchSuite) TestDownloadsBoost(c *gc.C) {
 	// TODO (frankban): remove this call when removing the legacy counts logic.
 	patchLegacyDownloadCountsEnabled(s.AddCleanup, false)
@@ -0,0 +1,174 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4 // import "gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/juju/httprequest"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+// SignatureHeader holds the name of the HTTP header that holds
+// a signature of a downloaded archive. There is one header for
+// each signature, of the form "algorithm key-id signature", where
+// the signature is base64 encoded.
+const SignatureHeader = "Entity-Signature"
+
+// Signature holds a detached signature of the contents of an
+// entity's archive, as returned by a GET of id/meta/signatures.
+type Signature struct {
+	KeyId     string
+	Algorithm string
+	Signature []byte
+}
+
+// PublisherKey holds a public key registered by a user to sign
+// their archives, as returned by a GET of publisher-keys/user.
+type PublisherKey struct {
+	KeyId      string `json:",omitempty"`
+	Algorithm  string
+	PublicKey  []byte
+	CreateTime time.Time
+}
+
+// GET id/meta/signatures
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#get-idmetasignatures
+func (h *ReqHandler) metaSignatures(entity *mongodoc.Entity, id *router.ResolvedURL, path string, flags url.Values, req *http.Request) (interface{}, error) {
+	if len(entity.Signatures) == 0 {
+		return nil, nil
+	}
+	sigs := make([]Signature, len(entity.Signatures))
+	for i, sig := range entity.Signatures {
+		sigs[i] = Signature{
+			KeyId:     sig.KeyId,
+			Algorithm: sig.Algorithm,
+			Signature: sig.Signature,
+		}
+	}
+	return sigs, nil
+}
+
+// PUT id/meta/signatures
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#put-idmetasignatures
+func (h *ReqHandler) putMetaSignatures(id *router.ResolvedURL, path string, val *json.RawMessage, updater *router.FieldUpdater, req *http.Request) error {
+	var sigs []Signature
+	if err := json.Unmarshal(*val, &sigs); err != nil {
+		return errgo.Mask(err)
+	}
+	if len(sigs) == 0 {
+		updater.UpdateField("signatures", nil, nil)
+		return nil
+	}
+	entity, err := h.Store.FindEntity(id, "_id", "blobhash")
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	docs := make([]mongodoc.Signature, len(sigs))
+	keyIds := make(map[string]bool)
+	for i, sig := range sigs {
+		if keyIds[sig.KeyId] {
+			return badRequestf(nil, "duplicate signature for key %q", sig.KeyId)
+		}
+		keyIds[sig.KeyId] = true
+		docs[i] = mongodoc.Signature{
+			KeyId:     sig.KeyId,
+			Algorithm: sig.Algorithm,
+			Signature: sig.Signature,
+		}
+		if err := h.Store.CheckSignature(entity, &docs[i]); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+		}
+	}
+	updater.UpdateField("signatures", docs, nil)
+	return nil
+}
+
+// signatureHeaderValue returns the value of the
+// SignatureHeader header for the given signature.
+func signatureHeaderValue(sig mongodoc.Signature) string {
+	return sig.Algorithm + " " + sig.KeyId + " " + base64.StdEncoding.EncodeToString(sig.Signature)
+}
+
+// GET publisher-keys/user
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#get-publisher-keysuser
+//
+// POST publisher-keys/user
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#post-publisher-keysuser
+//
+// DELETE publisher-keys/user/key-id
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#delete-publisher-keysuserkey-id
+func (h *ReqHandler) servePublisherKeys(w http.ResponseWriter, req *http.Request) error {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if parts[0] == "" || len(parts) > 2 {
+		return errgo.WithCausef(nil, params.ErrNotFound, "")
+	}
+	user := parts[0]
+	switch req.Method {
+	case "GET":
+		if len(parts) != 1 {
+			return errgo.WithCausef(nil, params.ErrNotFound, "")
+		}
+		docs, err := h.Store.PublisherKeys(user)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		keys := make([]PublisherKey, len(docs))
+		for i, doc := range docs {
+			keys[i] = publisherKeyFromDoc(doc)
+		}
+		return httprequest.WriteJSON(w, http.StatusOK, keys)
+	case "POST":
+		if len(parts) != 1 {
+			return errgo.WithCausef(nil, params.ErrNotFound, "")
+		}
+		if _, err := h.authorize(req, []string{user}, true, nil); err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		var key PublisherKey
+		if err := json.Unmarshal(data, &key); err != nil {
+			return errgo.WithCausef(err, params.ErrBadRequest, "")
+		}
+		doc, err := h.Store.AddPublisherKey(user, key.Algorithm, key.PublicKey)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrBadRequest))
+		}
+		return httprequest.WriteJSON(w, http.StatusOK, publisherKeyFromDoc(doc))
+	case "DELETE":
+		if len(parts) != 2 {
+			return errgo.WithCausef(nil, params.ErrNotFound, "")
+		}
+		if _, err := h.authorize(req, []string{user}, true, nil); err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+		if err := h.Store.RemovePublisherKey(user, parts[1]); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		return nil
+	}
+	return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s not allowed", req.Method)
+}
+
+func publisherKeyFromDoc(doc *mongodoc.PublisherKey) PublisherKey {
+	return PublisherKey{
+		KeyId:      doc.KeyId,
+		Algorithm:  doc.Algorithm,
+		PublicKey:  doc.PublicKey,
+		CreateTime: doc.CreateTime.UTC(),
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/agl/ed25519"
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+)
+
+type SignaturesSuite struct {
+	commonSuite
+
+	// signature holds a valid signature of the
+	// test entity's archive made with testPrivateKey.
+	signature []byte
+}
+
+var _ = gc.Suite(&SignaturesSuite{})
+
+func (s *SignaturesSuite) SetUpSuite(c *gc.C) {
+	s.enableIdentity = true
+	s.commonSuite.SetUpSuite(c)
+}
+
+var testPublicKey, testPrivateKey = generateTestKey()
+
+func generateTestKey() ([]byte, *[ed25519.PrivateKeySize]byte) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return publicKey[:], privateKey
+}
+
+func (s *SignaturesSuite) SetUpTest(c *gc.C) {
+	s.commonSuite.SetUpTest(c)
+	id := newResolvedURL("cs:~charmers/precise/wordpress-0", -1)
+	err := s.store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("wordpress"))
+	c.Assert(err, gc.IsNil)
+	err = s.store.SetPerms(&id.URL, "read", params.Everyone, id.URL.User)
+	c.Assert(err, gc.IsNil)
+	entity, err := s.store.FindEntity(id, "blobhash")
+	c.Assert(err, gc.IsNil)
+	s.signature = ed25519.Sign(testPrivateKey, []byte(entity.BlobHash))[:]
+}
+
+func (s *SignaturesSuite) addKey(c *gc.C, user string, publicKey []byte) v4.PublisherKey {
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("publisher-keys/" + user),
+		Method:  "POST",
+		Header:  http.Header{"Content-Type": {"application/json"}},
+		Body: strings.NewReader(mustMarshalJSON(v4.PublisherKey{
+			Algorithm: "ed25519",
+			PublicKey: publicKey,
+		})),
+		Username: testUsername,
+		Password: testPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+	var key v4.PublisherKey
+	err := json.Unmarshal(rec.Body.Bytes(), &key)
+	c.Assert(err, gc.IsNil)
+	return key
+}
+
+func (s *SignaturesSuite) putSignatures(c *gc.C, sigs []v4.Signature, expectStatus int, expectBody interface{}) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/precise/wordpress-0/meta/signatures"),
+		Method:       "PUT",
+		Header:       http.Header{"Content-Type": {"application/json"}},
+		Body:         strings.NewReader(mustMarshalJSON(sigs)),
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: expectStatus,
+		ExpectBody:   expectBody,
+	})
+}
+
+func (s *SignaturesSuite) TestSignatures(c *gc.C) {
+	key := s.addKey(c, "charmers", testPublicKey)
+	c.Assert(key.KeyId, gc.HasLen, 16)
+	c.Assert(key.Algorithm, gc.Equals, "ed25519")
+	c.Assert(key.PublicKey, jc.DeepEquals, testPublicKey)
+
+	// Registering the same key again has no effect.
+	key1 := s.addKey(c, "charmers", testPublicKey)
+	c.Assert(key1, jc.DeepEquals, key)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:    s.srv,
+		URL:        storeURL("publisher-keys/charmers"),
+		ExpectBody: []v4.PublisherKey{key},
+	})
+
+	// Sign the archive.
+	sig := v4.Signature{
+		KeyId:     key.KeyId,
+		Algorithm: "ed25519",
+		Signature: s.signature,
+	}
+	s.putSignatures(c, []v4.Signature{sig}, http.StatusOK, nil)
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:    s.srv,
+		URL:        storeURL("~charmers/precise/wordpress-0/meta/signatures"),
+		ExpectBody: []v4.Signature{sig},
+	})
+
+	// The signature is returned with the archive.
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("~charmers/precise/wordpress-0/archive"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.HeaderMap[v4.SignatureHeader], jc.DeepEquals, []string{
+		"ed25519 " + key.KeyId + " " + base64.StdEncoding.EncodeToString(s.signature),
+	})
+
+	// Revoking the key removes its signatures.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("publisher-keys/charmers/" + key.KeyId),
+		Method:       "DELETE",
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusOK,
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/precise/wordpress-0/meta/signatures"),
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Code:    params.ErrMetadataNotFound,
+			Message: params.ErrMetadataNotFound.Error(),
+		},
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:    s.srv,
+		URL:        storeURL("publisher-keys/charmers"),
+		ExpectBody: []v4.PublisherKey{},
+	})
+	rec = httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler: s.srv,
+		URL:     storeURL("~charmers/precise/wordpress-0/archive"),
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.HeaderMap[v4.SignatureHeader], gc.HasLen, 0)
+}
+
+// putSignaturesErrorsTests holds tests of invalid signatures.
+// In each signature, "%s" in the key id is replaced with the
+// id of the test key and a nil signature is replaced with a
+// valid signature of the archive.
+var putSignaturesErrorsTests = []struct {
+	about         string
+	sigs          []v4.Signature
+	expectMessage string
+}{{
+	about: "unsupported algorithm",
+	sigs: []v4.Signature{{
+		KeyId:     "%s",
+		Algorithm: "rot13",
+	}},
+	expectMessage: `unsupported signature algorithm "rot13"`,
+}, {
+	about: "invalid signature size",
+	sigs: []v4.Signature{{
+		KeyId:     "%s",
+		Algorithm: "ed25519",
+		Signature: bytes.Repeat([]byte{2}, 10),
+	}},
+	expectMessage: `invalid ed25519 signature size 10`,
+}, {
+	about: "key not registered",
+	sigs: []v4.Signature{{
+		KeyId:     "0123456789abcdef",
+		Algorithm: "ed25519",
+	}},
+	expectMessage: `ed25519 key "0123456789abcdef" not registered by "charmers"`,
+}, {
+	about: "invalid signature",
+	sigs: []v4.Signature{{
+		KeyId:     "%s",
+		Algorithm: "ed25519",
+		Signature: bytes.Repeat([]byte{2}, 64),
+	}},
+	expectMessage: `invalid ed25519 signature for key "%s"`,
+}, {
+	about: "duplicate signature",
+	sigs: []v4.Signature{{
+		KeyId:     "%s",
+		Algorithm: "ed25519",
+	}, {
+		KeyId:     "%s",
+		Algorithm: "ed25519",
+	}},
+	expectMessage: `duplicate signature for key "%s"`,
+}}
+
+func (s *SignaturesSuite) TestPutSignaturesErrors(c *gc.C) {
+	key := s.addKey(c, "charmers", testPublicKey)
+	// A key registered by another user cannot be
+	// used to sign charmers' entities.
+	s.addKey(c, "bob", bytes.Repeat([]byte{3}, 32))
+	for i, test := range putSignaturesErrorsTests {
+		c.Logf("test %d: %s", i, test.about)
+		sigs := make([]v4.Signature, len(test.sigs))
+		for j, sig := range test.sigs {
+			sig.KeyId = strings.Replace(sig.KeyId, "%s", key.KeyId, 1)
+			if sig.Signature == nil {
+				sig.Signature = s.signature
+			}
+			sigs[j] = sig
+		}
+		s.putSignatures(c, sigs, http.StatusBadRequest, params.Error{
+			Code:    params.ErrBadRequest,
+			Message: strings.Replace(test.expectMessage, "%s", key.KeyId, 1),
+		})
+	}
+}
+
+func (s *SignaturesSuite) TestAddPublisherKeyErrors(c *gc.C) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		URL:     storeURL("publisher-keys/charmers"),
+		Method:  "POST",
+		Header:  http.Header{"Content-Type": {"application/json"}},
+		Body: strings.NewReader(mustMarshalJSON(v4.PublisherKey{
+			Algorithm: "ed25519",
+			PublicKey: testPublicKey[0:8],
+		})),
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody: params.Error{
+			Code:    params.ErrBadRequest,
+			Message: "invalid ed25519 public key size 8",
+		},
+	})
+
+	// Only the user can register their keys.
+	s.discharge = dischargeForUser("bob")
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler: s.srv,
+		Do:      bakeryDo(nil),
+		URL:     storeURL("publisher-keys/charmers"),
+		Method:  "POST",
+		Header:  http.Header{"Content-Type": {"application/json"}},
+		Body: strings.NewReader(mustMarshalJSON(v4.PublisherKey{
+			Algorithm: "ed25519",
+			PublicKey: testPublicKey,
+		})),
+		ExpectStatus: http.StatusUnauthorized,
+		ExpectBody: params.Error{
+			Code:    params.ErrUnauthorized,
+			Message: `unauthorized: access denied for user "bob"`,
+		},
+	})
+
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("publisher-keys/charmers/0123456789abcdef"),
+		Method:       "DELETE",
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Code:    params.ErrNotFound,
+			Message: `publisher key "0123456789abcdef" not found`,
+		},
+	})
+}
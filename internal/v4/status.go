@@ -16,6 +16,7 @@ import (
 	"gopkg.in/mgo.v2/bson"
 
 	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
 )
 
 // GET /debug/status
@@ -42,6 +43,39 @@ func (h *ReqHandler) serveDebugStatus(_ http.Header, req *http.Request) (interfa
 	), nil
 }
 
+// GET /debug/search-check
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#get-debugsearch-check
+//
+// POST /debug/search-check[?repair=1]
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#post-debugsearch-check
+func (h *ReqHandler) serveDebugSearchCheck(_ http.Header, req *http.Request) (interface{}, error) {
+	if _, err := h.authorize(req, nil, true, nil); err != nil {
+		return nil, err
+	}
+	if req.Method != "GET" && req.Method != "POST" {
+		return nil, errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s not allowed", req.Method)
+	}
+	if h.Store.ES == nil || h.Store.ES.Database == nil {
+		return nil, errgo.WithCausef(nil, params.ErrNotFound, "elasticsearch not configured")
+	}
+	if req.Method == "GET" {
+		status := h.Store.SearchCheckStatus()
+		if status == nil {
+			return nil, errgo.WithCausef(nil, params.ErrNotFound, "no search check has been started")
+		}
+		return status, nil
+	}
+	repair, err := router.ParseBool(req.Form.Get("repair"))
+	if err != nil {
+		return nil, badRequestf(err, "invalid repair parameter")
+	}
+	status, err := h.Store.StartSearchCheck(repair)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot check search index")
+	}
+	return status, nil
+}
+
 func (h *ReqHandler) checkElasticSearch() (key string, result debugstatus.CheckResult) {
 	key = "elasticsearch"
 	result.Name = "Elastic search is running"
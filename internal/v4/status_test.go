@@ -15,8 +15,10 @@ import (
 	"gopkg.in/juju/charm.v6-unstable"
 	"gopkg.in/juju/charmrepo.v1/csclient/params"
 
+	"gopkg.in/juju/charmstore.v5-unstable/internal/charmstore"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
 	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
 )
 
 var zeroTimeStr = time.Time{}.Format(time.RFC3339)
@@ -279,3 +281,139 @@ func (s *statusWithElasticSearchSuite) TestStatusWithElasticSearch(c *gc.C) {
 	c.Assert(results["elasticsearch"].Name, gc.Equals, "Elastic search is running")
 	c.Assert(results["elasticsearch"].Value, jc.Contains, "cluster_name:")
 }
+
+func (s *statusWithElasticSearchSuite) TestDebugSearchCheck(c *gc.C) {
+	// Add a charm without indexing it.
+	si := s.store.ES
+	s.store.ES = nil
+	id := newResolvedURL("~charmers/precise/wordpress-23", 23)
+	err := s.store.AddCharmWithArchive(id, storetesting.Charms.CharmDir("wordpress"))
+	s.store.ES = si
+	c.Assert(err, gc.IsNil)
+
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("debug/search-check"),
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: &params.Error{
+			Message: "no search check has been started",
+			Code:    params.ErrNotFound,
+		},
+	})
+
+	expect := &charmstore.SearchCheckResult{
+		Checked: 1,
+		Missing: []*charm.Reference{&id.URL},
+	}
+	status := s.startSearchCheck(c, "")
+	c.Assert(status.Repair, gc.Equals, false)
+	c.Assert(status.Result, jc.DeepEquals, expect)
+
+	expect.Repaired = true
+	status = s.startSearchCheck(c, "?repair=1")
+	c.Assert(status.Repair, gc.Equals, true)
+	c.Assert(status.Result, jc.DeepEquals, expect)
+
+	status = s.startSearchCheck(c, "")
+	c.Assert(status.Result, jc.DeepEquals, &charmstore.SearchCheckResult{
+		Checked: 1,
+	})
+}
+
+// startSearchCheck starts a search check with a POST to
+// debug/search-check with the given query, waits for it
+// to finish and returns the status reported by a GET.
+func (s *statusWithElasticSearchSuite) startSearchCheck(c *gc.C, query string) charmstore.SearchCheckStatus {
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		URL:      storeURL("debug/search-check" + query),
+		Method:   "POST",
+		Username: testUsername,
+		Password: testPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+	var status charmstore.SearchCheckStatus
+	err := json.Unmarshal(rec.Body.Bytes(), &status)
+	c.Assert(err, gc.IsNil)
+	c.Assert(status.Running, gc.Equals, true)
+
+	timeout := time.After(10 * time.Second)
+	for s.store.SearchCheckStatus().Running {
+		select {
+		case <-timeout:
+			c.Fatalf("search check did not finish")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	rec = httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		URL:      storeURL("debug/search-check"),
+		Username: testUsername,
+		Password: testPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+	status = charmstore.SearchCheckStatus{}
+	err = json.Unmarshal(rec.Body.Bytes(), &status)
+	c.Assert(err, gc.IsNil)
+	c.Assert(status.Running, gc.Equals, false)
+	c.Assert(status.Error, gc.Equals, "")
+	return status
+}
+
+func (s *statusWithElasticSearchSuite) TestDebugSearchCheckInvalidRepair(c *gc.C) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("debug/search-check?repair=yes"),
+		Method:       "POST",
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody: &params.Error{
+			Message: `invalid repair parameter: unexpected bool value "yes" (must be "0" or "1")`,
+			Code:    params.ErrBadRequest,
+		},
+	})
+}
+
+func (s *statusWithElasticSearchSuite) TestDebugSearchCheckNonAdmin(c *gc.C) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("debug/search-check"),
+		ExpectStatus: http.StatusUnauthorized,
+		ExpectBody: &params.Error{
+			Message: "authentication failed: missing HTTP auth header",
+			Code:    params.ErrUnauthorized,
+		},
+	})
+}
+
+func (s *statusWithElasticSearchSuite) TestDebugSearchCheckMethodNotAllowed(c *gc.C) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("debug/search-check"),
+		Method:       "PUT",
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusMethodNotAllowed,
+		ExpectBody: &params.Error{
+			Message: "PUT not allowed",
+			Code:    params.ErrMethodNotAllowed,
+		},
+	})
+}
+
+func (s *APISuite) TestDebugSearchCheckNotConfigured(c *gc.C) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("debug/search-check"),
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: &params.Error{
+			Message: "elasticsearch not configured",
+			Code:    params.ErrNotFound,
+		},
+	})
+}
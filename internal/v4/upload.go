@@ -0,0 +1,172 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4 // import "gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/httprequest"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/mongodoc"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/router"
+)
+
+// UploadSession holds information on a chunked upload session,
+// as returned by POST upload and GET upload/upload-id.
+type UploadSession struct {
+	UploadId string
+	Expires  time.Time
+	Chunks   []UploadChunk `json:",omitempty"`
+}
+
+// UploadChunk holds information on a chunk
+// uploaded as part of an upload session.
+type UploadChunk struct {
+	Offset int64
+	Size   int64
+}
+
+// POST upload
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#post-upload
+func (h *ReqHandler) serveNewUpload(w http.ResponseWriter, req *http.Request) error {
+	if req.Method != "POST" {
+		return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s not allowed", req.Method)
+	}
+	auth, err := h.authorize(req, []string{params.Everyone}, true, nil)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	session, err := h.Store.NewUploadSession(auth.Username)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return httprequest.WriteJSON(w, http.StatusOK, uploadSessionFromDoc(session))
+}
+
+// GET upload/upload-id
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#get-uploadupload-id
+//
+// PUT upload/upload-id?offset=offset&hash=sha384hash
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#put-uploadupload-idoffsetoffsethashsha384hash
+//
+// DELETE upload/upload-id
+// https://github.com/juju/charmstore/blob/v4/docs/API.md#delete-uploadupload-id
+func (h *ReqHandler) serveUploadSession(w http.ResponseWriter, req *http.Request) error {
+	// Make sure we consume the full request body, before responding.
+	defer io.Copy(ioutil.Discard, req.Body)
+	id := strings.Trim(req.URL.Path, "/")
+	if id == "" || strings.Contains(id, "/") {
+		return errgo.WithCausef(nil, params.ErrNotFound, "")
+	}
+	if _, err := h.authorize(req, []string{params.Everyone}, true, nil); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	session, err := h.uploadSession(id)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrUnauthorized))
+	}
+	switch req.Method {
+	case "GET":
+		return httprequest.WriteJSON(w, http.StatusOK, uploadSessionFromDoc(session))
+	case "PUT":
+		offset, err := strconv.ParseInt(req.Form.Get("offset"), 10, 64)
+		if err != nil {
+			return badRequestf(nil, "invalid offset %q", req.Form.Get("offset"))
+		}
+		hash := req.Form.Get("hash")
+		if hash == "" {
+			return badRequestf(nil, "hash parameter not specified")
+		}
+		if req.ContentLength == -1 {
+			return badRequestf(nil, "Content-Length not specified")
+		}
+		if err := h.Store.PutUploadChunk(id, offset, req.Body, req.ContentLength, hash); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrBadRequest), errgo.Is(router.ErrQuotaExceeded))
+		}
+		return nil
+	case "DELETE":
+		if err := h.Store.RemoveUploadSession(id); err != nil {
+			return errgo.Mask(err, errgo.Is(params.ErrNotFound))
+		}
+		return nil
+	}
+	return errgo.WithCausef(nil, params.ErrMethodNotAllowed, "%s not allowed", req.Method)
+}
+
+// uploadSession returns the upload session with the given id,
+// checking that it belongs to the authenticated user.
+func (h *ReqHandler) uploadSession(id string) (*mongodoc.UploadSession, error) {
+	session, err := h.Store.UploadSession(id)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(params.ErrNotFound))
+	}
+	if !h.auth.Admin && h.auth.Username != session.User {
+		return nil, errgo.WithCausef(nil, params.ErrUnauthorized, "upload session %q belongs to another user", id)
+	}
+	return session, nil
+}
+
+// archiveUploadBody returns the archive sent in an upload request and
+// its size. If the upload-id parameter is set, the archive is read
+// from the chunks of that upload session, which must belong to the
+// authenticated user, and the request body is ignored. The returned
+// reader must be closed after use.
+func (h *ReqHandler) archiveUploadBody(req *http.Request) (io.ReadCloser, int64, error) {
+	uploadId := req.Form.Get("upload-id")
+	if uploadId == "" {
+		if req.ContentLength == -1 {
+			return nil, 0, badRequestf(nil, "Content-Length not specified")
+		}
+		return ioutil.NopCloser(req.Body), req.ContentLength, nil
+	}
+	if _, err := h.uploadSession(uploadId); err != nil {
+		return nil, 0, errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrUnauthorized))
+	}
+	r, size, err := h.Store.OpenUploadSession(uploadId)
+	if err != nil {
+		return nil, 0, errgo.Mask(err, errgo.Is(params.ErrNotFound), errgo.Is(params.ErrBadRequest))
+	}
+	return r, size, nil
+}
+
+// removeUploadSession removes the upload session used by
+// a successful upload request, if any.
+func (h *ReqHandler) removeUploadSession(req *http.Request) {
+	uploadId := req.Form.Get("upload-id")
+	if uploadId == "" {
+		return
+	}
+	if err := h.Store.RemoveUploadSession(uploadId); err != nil {
+		logger.Errorf("cannot remove upload session %q: %v", uploadId, err)
+	}
+}
+
+func uploadSessionFromDoc(session *mongodoc.UploadSession) *UploadSession {
+	result := &UploadSession{
+		UploadId: session.Id,
+		Expires:  session.Expires.UTC(),
+	}
+	for _, chunk := range session.Chunks {
+		result.Chunks = append(result.Chunks, UploadChunk{
+			Offset: chunk.Offset,
+			Size:   chunk.Size,
+		})
+	}
+	sort.Sort(uploadChunksByOffset(result.Chunks))
+	return result
+}
+
+type uploadChunksByOffset []UploadChunk
+
+func (c uploadChunksByOffset) Len() int           { return len(c) }
+func (c uploadChunksByOffset) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c uploadChunksByOffset) Less(i, j int) bool { return c[i].Offset < c[j].Offset }
@@ -0,0 +1,191 @@
+// Copyright 2015 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+package v4_test // import "gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	jc "github.com/juju/testing/checkers"
+	"github.com/juju/testing/httptesting"
+	gc "gopkg.in/check.v1"
+	"gopkg.in/juju/charm.v6-unstable"
+	"gopkg.in/juju/charmrepo.v1/csclient/params"
+
+	"gopkg.in/juju/charmstore.v5-unstable/internal/storetesting"
+	"gopkg.in/juju/charmstore.v5-unstable/internal/v4"
+)
+
+type UploadSuite struct {
+	commonSuite
+}
+
+var _ = gc.Suite(&UploadSuite{})
+
+func (s *UploadSuite) SetUpSuite(c *gc.C) {
+	s.enableIdentity = true
+	s.commonSuite.SetUpSuite(c)
+}
+
+func (s *UploadSuite) newUploadSession(c *gc.C) v4.UploadSession {
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		URL:      storeURL("upload"),
+		Method:   "POST",
+		Username: testUsername,
+		Password: testPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+	var session v4.UploadSession
+	err := json.Unmarshal(rec.Body.Bytes(), &session)
+	c.Assert(err, gc.IsNil)
+	c.Assert(session.UploadId, gc.Not(gc.Equals), "")
+	return session
+}
+
+func (s *UploadSuite) putChunk(c *gc.C, uploadId string, offset int, data []byte) {
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:       s.srv,
+		URL:           storeURL(fmt.Sprintf("upload/%s?offset=%d&hash=%s", uploadId, offset, hashOfBytes(data))),
+		Method:        "PUT",
+		ContentLength: int64(len(data)),
+		Body:          bytes.NewReader(data),
+		Username:      testUsername,
+		Password:      testPassword,
+	})
+}
+
+func (s *UploadSuite) TestChunkedUpload(c *gc.C) {
+	ch := storetesting.Charms.CharmArchive(c.MkDir(), "wordpress")
+	data, err := ioutil.ReadFile(ch.Path)
+	c.Assert(err, gc.IsNil)
+	half := len(data) / 2
+
+	session := s.newUploadSession(c)
+	s.putChunk(c, session.UploadId, half, data[half:])
+	s.putChunk(c, session.UploadId, 0, data[:half])
+	rec := httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		URL:      storeURL("upload/" + session.UploadId),
+		Username: testUsername,
+		Password: testPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK, gc.Commentf("body: %s", rec.Body.String()))
+	var info v4.UploadSession
+	err = json.Unmarshal(rec.Body.Bytes(), &info)
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.UploadId, gc.Equals, session.UploadId)
+	// Uploading a chunk extends the session expiry time.
+	c.Assert(info.Expires.Before(session.Expires), gc.Equals, false)
+	c.Assert(info.Chunks, jc.DeepEquals, []v4.UploadChunk{{
+		Offset: 0,
+		Size:   int64(half),
+	}, {
+		Offset: int64(half),
+		Size:   int64(len(data) - half),
+	}})
+
+	// Finalize the upload.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/precise/wordpress/archive?hash=" + hashOfBytes(data) + "&upload-id=" + session.UploadId),
+		Method:   "POST",
+		Username: testUsername,
+		Password: testPassword,
+		ExpectBody: params.ArchiveUploadResponse{
+			Id: charm.MustParseReference("cs:~charmers/precise/wordpress-0"),
+		},
+	})
+	rec = httptesting.DoRequest(c, httptesting.DoRequestParams{
+		Handler:  s.srv,
+		URL:      storeURL("~charmers/precise/wordpress-0/archive"),
+		Username: testUsername,
+		Password: testPassword,
+	})
+	c.Assert(rec.Code, gc.Equals, http.StatusOK)
+	c.Assert(rec.Body.Bytes(), gc.DeepEquals, data)
+
+	// The session is removed once the upload is complete.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("upload/" + session.UploadId),
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Code:    params.ErrNotFound,
+			Message: fmt.Sprintf("upload session %q not found", session.UploadId),
+		},
+	})
+}
+
+func (s *UploadSuite) TestChunkedUploadErrors(c *gc.C) {
+	data := []byte("some content")
+	session := s.newUploadSession(c)
+	s.putChunk(c, session.UploadId, 4, data[4:])
+
+	// The upload cannot be finalized while data is missing.
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("~charmers/precise/wordpress/archive?hash=" + hashOfBytes(data) + "&upload-id=" + session.UploadId),
+		Method:       "POST",
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody: params.Error{
+			Code:    params.ErrBadRequest,
+			Message: fmt.Sprintf("upload session %q is missing data at offset 0", session.UploadId),
+		},
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("upload/" + session.UploadId + "?offset=foo&hash=" + hashOfBytes(data)),
+		Method:       "PUT",
+		Body:         bytes.NewReader(data),
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusBadRequest,
+		ExpectBody: params.Error{
+			Code:    params.ErrBadRequest,
+			Message: `invalid offset "foo"`,
+		},
+	})
+
+	// Other users cannot use the session.
+	s.discharge = dischargeForUser("bob")
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		Do:           bakeryDo(nil),
+		URL:          storeURL("upload/" + session.UploadId),
+		Method:       "DELETE",
+		ExpectStatus: http.StatusUnauthorized,
+		ExpectBody: params.Error{
+			Code:    params.ErrUnauthorized,
+			Message: fmt.Sprintf("upload session %q belongs to another user", session.UploadId),
+		},
+	})
+
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:  s.srv,
+		URL:      storeURL("upload/" + session.UploadId),
+		Method:   "DELETE",
+		Username: testUsername,
+		Password: testPassword,
+	})
+	httptesting.AssertJSONCall(c, httptesting.JSONCallParams{
+		Handler:      s.srv,
+		URL:          storeURL("upload/" + session.UploadId),
+		Method:       "DELETE",
+		Username:     testUsername,
+		Password:     testPassword,
+		ExpectStatus: http.StatusNotFound,
+		ExpectBody: params.Error{
+			Code:    params.ErrNotFound,
+			Message: fmt.Sprintf("upload session %q not found", session.UploadId),
+		},
+	})
+}
@@ -94,6 +94,46 @@ type ServerParams struct {
 	// AuditLogger optionally holds the logger which will be used to
 	// write audit log entries.
 	AuditLogger *lumberjack.Logger
+
+	// TrashGracePeriod holds the length of time that deleted
+	// entities are kept in the trash, where they can be restored,
+	// before being purged. If it is zero, a default of one week
+	// is used.
+	TrashGracePeriod time.Duration
+
+	// BlobGCInterval holds the interval between runs of the
+	// garbage collector that removes archive blobs no longer
+	// referenced by any entity or resource. If it is zero, the
+	// garbage collector is not run.
+	BlobGCInterval time.Duration
+
+	// RetentionKeepRevisions and RetentionKeepDuration hold the
+	// store-wide revision retention policy, which applies to base
+	// entities that have no policy of their own. If both are zero,
+	// revisions are only pruned according to per-entity policies.
+	RetentionKeepRevisions int
+	RetentionKeepDuration  time.Duration
+
+	// PruneInterval holds the interval between runs of the job
+	// that moves revisions not kept by the retention policy to
+	// the trash. If it is zero, revisions are never pruned.
+	PruneInterval time.Duration
+
+	// QuotaMaxBytes, QuotaMaxArchiveSize and QuotaMaxRevisions
+	// hold the store-wide upload quotas: the maximum total size
+	// of the archives owned by a user, the maximum size of a
+	// single archive and the maximum number of revisions of a
+	// base entity. A zero value means that there is no limit.
+	QuotaMaxBytes       int64
+	QuotaMaxArchiveSize int64
+	QuotaMaxRevisions   int
+
+	// MongoSearch specifies that search is served from MongoDB
+	// when no elasticsearch index is configured. This is
+	// intended for small deployments: filters and ACLs are
+	// applied by MongoDB but the matching entities are scored
+	// and sorted in process.
+	MongoSearch bool
 }
 
 // NewServer returns a new handler that handles charm store requests and stores